@@ -0,0 +1,37 @@
+package main
+
+import (
+	"github.com/openshift/windows-machine-config-operator/pkg/bootstrapper"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// loadConfig returns the configuration file at path, or an empty configuration if path is empty. The install
+// directory is set to installDir if --install-dir was given or the file does not set it.
+func loadConfig(cmd *cobra.Command, path, installDir string) (*bootstrapper.Config, error) {
+	config := &bootstrapper.Config{APIVersion: bootstrapper.ConfigAPIVersion, Kind: bootstrapper.ConfigKind}
+	if path != "" {
+		var err error
+		if config, err = bootstrapper.LoadConfig(path); err != nil {
+			return nil, err
+		}
+	}
+	overrideString(cmd.Flags(), "install-dir", &config.InstallDir, installDir)
+	return config, nil
+}
+
+// overrideString sets the configuration field to the value of the flag with the given name, if the flag was given or
+// the field is not set
+func overrideString(flags *pflag.FlagSet, name string, field *string, value string) {
+	if flags.Changed(name) || *field == "" {
+		*field = value
+	}
+}
+
+// overrideStrings sets the configuration field to the value of the flag with the given name, if the flag was given or
+// the field is not set
+func overrideStrings(flags *pflag.FlagSet, name string, field *[]string, value []string) {
+	if flags.Changed(name) || *field == nil {
+		*field = value
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/bootstrapper"
+	"github.com/spf13/cobra"
+)
+
+var (
+	daemonCmd = &cobra.Command{
+		Use:   "daemon",
+		Short: "Keeps the Windows node reconciled with its machine config",
+		Long: "Bootstraps the node described by the configuration file, then reads the ignition file again every " +
+			"--interval, so that changes to the machine config reach the node. The node is applied again if the " +
+			"ignition file or kubelet changed, or the node no longer matches what was applied, changing only the " +
+			"files and services which differ. Failed reconciles are retried with a backoff. The result of the last " +
+			"reconcile is reported by status",
+		Run: runDaemonCmd,
+		PreRunE: func(cmd *cobra.Command, _ []string) error {
+			if daemonOpts.configFile == "" {
+				return fmt.Errorf("--config must be given")
+			}
+			var err error
+			if daemonConfig, err = loadConfig(cmd, daemonOpts.configFile, daemonOpts.installDir); err != nil {
+				return err
+			}
+			flags := cmd.Flags()
+			if flags.Changed("interval") {
+				daemonConfig.Daemon.Interval.Duration = daemonOpts.interval
+			}
+			if flags.Changed("retry-interval") {
+				daemonConfig.Daemon.RetryInterval.Duration = daemonOpts.retryInterval
+			}
+			if flags.Changed("lock-wait") {
+				daemonConfig.Timeouts.LockWait.Duration = daemonOpts.lockWait
+			}
+			if daemonConfig.Kubelet.Path == "" {
+				return fmt.Errorf("kubelet.path must be set in the configuration file")
+			}
+			if daemonConfig.Ignition.File == "" && daemonConfig.Ignition.URL == "" {
+				return fmt.Errorf("ignition.file or ignition.url must be set in the configuration file")
+			}
+			return daemonConfig.Validate()
+		},
+	}
+
+	// daemonConfig is the configuration file, with the values of the flags which were given applied over it
+	daemonConfig *bootstrapper.Config
+
+	daemonOpts struct {
+		// The location of the configuration file describing the node
+		configFile string
+		// The directory to install the kubelet and related files
+		installDir string
+		// The time between reconciles of a node which is up to date
+		interval time.Duration
+		// The time to wait before retrying a failed reconcile
+		retryInterval time.Duration
+		// The time each reconcile waits for another run of wmcb to release the install directory
+		lockWait time.Duration
+		// Run as a Windows service
+		windowsService bool
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(daemonCmd)
+	daemonCmd.PersistentFlags().StringVar(&daemonOpts.configFile, "config", "",
+		"Configuration file, in YAML or JSON, describing the node, including the ignition file and kubelet")
+	daemonCmd.PersistentFlags().StringVar(&daemonOpts.installDir, "install-dir", bootstrapper.DefaultInstallDir,
+		"Kubelet file location to bootstrap the windows node. Defaults to C:\\k")
+	daemonCmd.PersistentFlags().DurationVar(&daemonOpts.interval, "interval", 0,
+		"Time between reconciles of a node which is up to date. Defaults to daemon.interval, or 5m")
+	daemonCmd.PersistentFlags().DurationVar(&daemonOpts.retryInterval, "retry-interval", 0,
+		"Time to wait before retrying a failed reconcile, doubled after each consecutive failure up to --interval. "+
+			"Defaults to daemon.retryInterval, or 10s")
+	daemonCmd.PersistentFlags().DurationVar(&daemonOpts.lockWait, "lock-wait", 0,
+		"Time each reconcile waits for another run of wmcb to release the install directory. If 0, the reconcile "+
+			"is retried later if it is locked")
+	daemonCmd.PersistentFlags().BoolVar(&daemonOpts.windowsService, "windows-service", false,
+		"Run as the "+bootstrapper.DaemonServiceName+" Windows service")
+}
+
+// runDaemonCmd reconciles the node until it is interrupted or the service is stopped
+func runDaemonCmd(cmd *cobra.Command, args []string) {
+	flag.Parse()
+
+	if daemonOpts.windowsService {
+		err := bootstrapper.RunAsService(bootstrapper.DaemonServiceName, func(stop <-chan struct{}) error {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go func() {
+				select {
+				case <-stop:
+					cancel()
+				case <-ctx.Done():
+				}
+			}()
+			return runDaemon(ctx)
+		})
+		if err != nil {
+			log.Error(err, "could not run reconcile daemon service")
+			os.Exit(exitFailure)
+		}
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Info("interrupted, stopping", "signal", sig.String())
+		cancel()
+	}()
+	if err := runDaemon(ctx); err != nil {
+		log.Error(err, "could not run reconcile daemon")
+		os.Exit(exitFailure)
+	}
+}
+
+// runDaemon reconciles the node until ctx is cancelled. It only returns an error if the daemon cannot be started,
+// failed reconciles are retried.
+func runDaemon(ctx context.Context) error {
+	b, err := bootstrapper.New(bootstrapper.WithConfig(daemonConfig))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := b.Close(); err != nil {
+			log.Error(err, "can't clean up bootstrapper")
+		}
+	}()
+	daemon := bootstrapper.NewDaemon(b, bootstrapper.DaemonOptions{
+		Interval:      daemonConfig.Daemon.Interval.Duration,
+		RetryInterval: daemonConfig.Daemon.RetryInterval.Duration,
+		LockWait:      daemonConfig.Timeouts.LockWait.Duration,
+	})
+	log.Info("reconciling node until stopped", "installDir", daemonConfig.InstallDir)
+	daemon.Run(ctx)
+	return nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/bootstrapper"
+	"github.com/spf13/cobra"
+)
+
+var (
+	lintKubeletConfigCmd = &cobra.Command{
+		Use:   "lint-kubelet-config",
+		Short: "Reports the kubelet configuration fields which are not supported on Windows",
+		Long: "Translates the kubelet configuration in the worker ignition file for Windows, as run does, and reports " +
+			"each field which is not supported on Windows as a warning, for fields which have no effect, or an " +
+			"error, for fields which can prevent the kubelet from running. Exits with an error if there are errors " +
+			"which are not fixed",
+		Run: runLintKubeletConfigCmd,
+		PreRunE: func(cmd *cobra.Command, _ []string) error {
+			if lintOpts.ignitionFile == "" {
+				return fmt.Errorf("--ignition-file must be given")
+			}
+			if lintOpts.output != outputText && lintOpts.output != outputJSON {
+				return fmt.Errorf("--output must be %s or %s", outputText, outputJSON)
+			}
+			return nil
+		},
+	}
+
+	lintOpts struct {
+		// The location of the ignition file holding the kubelet configuration
+		ignitionFile string
+		// The location of the partial kubelet configuration merged into the one from the ignition file
+		kubeletConfigOverrides string
+		// The directory the kubelet and related files are installed to
+		installDir string
+		// Fix the fields which can be fixed safely, and print the fixed configuration
+		fix bool
+		// The output format
+		output string
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(lintKubeletConfigCmd)
+	lintKubeletConfigCmd.PersistentFlags().StringVar(&lintOpts.ignitionFile, "ignition-file", "",
+		"Ignition file location holding the kubelet configuration")
+	lintKubeletConfigCmd.PersistentFlags().StringVar(&lintOpts.kubeletConfigOverrides, "kubelet-config-overrides", "",
+		"Partial KubeletConfiguration, in YAML or JSON, merged into the kubelet configuration before it is linted")
+	lintKubeletConfigCmd.PersistentFlags().StringVar(&lintOpts.installDir, "install-dir",
+		bootstrapper.DefaultInstallDir, "Kubelet file location the node is bootstrapped into. Defaults to C:\\k")
+	lintKubeletConfigCmd.PersistentFlags().BoolVar(&lintOpts.fix, "fix", false,
+		"Fix the fields which can be fixed safely, as run does, and print the fixed kubelet configuration")
+	lintKubeletConfigCmd.PersistentFlags().StringVarP(&lintOpts.output, "output", "o", outputText,
+		"Output format, "+outputText+" or "+outputJSON)
+}
+
+// runLintKubeletConfigCmd reports the kubelet configuration fields which are not supported on Windows
+func runLintKubeletConfigCmd(cmd *cobra.Command, args []string) {
+	flag.Parse()
+
+	lint, err := bootstrapper.LintKubeletConfig(lintOpts.ignitionFile, lintOpts.installDir,
+		lintOpts.kubeletConfigOverrides, lintOpts.fix)
+	if err != nil {
+		log.Error(err, "could not lint kubelet configuration")
+		os.Exit(exitFailure)
+	}
+	if lintOpts.output == outputJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(lint)
+	} else {
+		err = printLint(os.Stdout, lint, lintOpts.fix)
+	}
+	if err != nil {
+		log.Error(err, "could not print findings")
+		os.Exit(exitFailure)
+	}
+	if len(lint.Errors()) > 0 {
+		os.Exit(exitFailure)
+	}
+}
+
+// printLint writes the findings in a human readable format, followed by the fixed configuration if printConfig is true
+func printLint(out io.Writer, lint *bootstrapper.KubeletConfigLint, printConfig bool) error {
+	if len(lint.Findings) == 0 {
+		fmt.Fprintln(out, "No unsupported kubelet configuration fields found")
+	} else {
+		w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "FIELD\tSEVERITY\tFIXED\tMESSAGE")
+		for _, finding := range lint.Findings {
+			message := finding.Message
+			if finding.SetByOverride {
+				message += " (set by override)"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%t\t%s\n", finding.Field, finding.Severity, finding.Fixed, message)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+	if printConfig {
+		fmt.Fprintf(out, "\n%s\n", lint.Config)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/bootstrapper"
+)
+
+// runLocked runs run while holding the lock of the install directory, and exits with the exit code run returns
+func runLocked(installDir string, wait time.Duration, run func(ctx context.Context) int) {
+	lock, err := bootstrapper.AcquireInstallLock(installDir, wait)
+	if err != nil {
+		log.Error(err, "could not lock install directory", "path", installDir)
+		if _, ok := err.(*bootstrapper.LockedError); ok {
+			os.Exit(exitLocked)
+		}
+		os.Exit(exitFailure)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Info("interrupted, stopping", "signal", sig.String())
+		cancel()
+		sig = <-signals
+		// The node may be half configured, so the lock is left for the operating system to drop as wmcb exits
+		log.Info("interrupted again, exiting without rolling back", "signal", sig.String())
+		os.Exit(exitFailure)
+	}()
+
+	exitCode := func() int {
+		// The panic continues once the lock is released
+		defer releaseLock(lock)
+		return run(ctx)
+	}()
+	signal.Stop(signals)
+	os.Exit(exitCode)
+}
+
+// releaseLock releases the lock of the install directory, logging if it cannot be released
+func releaseLock(lock *bootstrapper.InstallLock) {
+	if err := lock.Release(); err != nil {
+		log.Error(err, "could not release install directory lock")
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"flag"
+	"os"
 
 	"github.com/spf13/cobra"
 	logger "sigs.k8s.io/controller-runtime/pkg/log"
@@ -30,5 +31,7 @@ func init() {
 func main() {
 	if err := rootCmd.Execute(); err != nil {
 		log.Error(err, "wmcb execution failed")
+		// Errors returned by commands are invalid flags or arguments
+		os.Exit(exitInvalidConfig)
 	}
 }
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/bootstrapper"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+var (
+	rotateLogsCmd = &cobra.Command{
+		Use:   "rotate-logs",
+		Short: "Rotates the logs of the services run by the bootstrapper",
+		Long: "Rotates each of the given logs which has reached the maximum size or age, by copying it to a file " +
+			"named after the time it was rotated and truncating it, then deletes the rotated copies which are no " +
+			"longer retained. Rotates the logs once, or every --interval if it is given. run installs the " +
+			bootstrapper.LogRotatorServiceName + " service, which runs this command, when log rotation is enabled",
+		Run: runRotateLogsCmd,
+		PreRunE: func(cmd *cobra.Command, _ []string) error {
+			if len(rotateLogsOpts.logFiles) == 0 {
+				return fmt.Errorf("--log-file must be given")
+			}
+			if rotateLogsOpts.windowsService && rotateLogsOpts.options.CheckInterval == 0 {
+				return fmt.Errorf("--interval must be given with --windows-service")
+			}
+			if cmd.Flags().Changed("max-size") {
+				size, err := resource.ParseQuantity(rotateLogsOpts.maxSize)
+				if err != nil {
+					return fmt.Errorf("invalid --max-size: %s", err)
+				}
+				rotateLogsOpts.options.MaxSize = size.Value()
+			}
+			if rotateLogsOpts.options.MaxSize <= 0 && rotateLogsOpts.options.MaxAge <= 0 {
+				return fmt.Errorf("--max-size or --max-age must be given")
+			}
+			if options := rotateLogsOpts.options; options.Retention != 0 && options.Retention < options.MaxAge {
+				return fmt.Errorf("--retention must not be shorter than --max-age")
+			}
+			return nil
+		},
+	}
+
+	rotateLogsOpts struct {
+		// The logs to rotate
+		logFiles []string
+		// The size the logs are rotated at, as a quantity
+		maxSize string
+		// How the logs are rotated
+		options bootstrapper.LogRotationOptions
+		// Run as a Windows service
+		windowsService bool
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(rotateLogsCmd)
+	rotateLogsCmd.PersistentFlags().StringSliceVar(&rotateLogsOpts.logFiles, "log-file", nil, "Log to rotate")
+	rotateLogsCmd.PersistentFlags().StringVar(&rotateLogsOpts.maxSize, "max-size", "",
+		"Size, such as 100Mi, the logs are rotated at")
+	rotateLogsCmd.PersistentFlags().DurationVar(&rotateLogsOpts.options.MaxAge, "max-age", 0,
+		"Time after which the logs are rotated, counted from their last rotation")
+	rotateLogsCmd.PersistentFlags().IntVar(&rotateLogsOpts.options.MaxBackups, "max-backups", 0,
+		"Number of rotated copies kept of each log, the oldest are deleted first. All are kept if 0")
+	rotateLogsCmd.PersistentFlags().DurationVar(&rotateLogsOpts.options.Retention, "retention", 0,
+		"Time after which the rotated logs are deleted. They are kept regardless of their age if 0")
+	rotateLogsCmd.PersistentFlags().BoolVar(&rotateLogsOpts.options.Compress, "compress", false,
+		"Gzip compress the rotated logs")
+	rotateLogsCmd.PersistentFlags().DurationVar(&rotateLogsOpts.options.CheckInterval, "interval", 0,
+		"Check the logs every interval until interrupted, instead of once")
+	rotateLogsCmd.PersistentFlags().BoolVar(&rotateLogsOpts.windowsService, "windows-service", false,
+		"Run as the "+bootstrapper.LogRotatorServiceName+" Windows service. Requires --interval")
+}
+
+// runRotateLogsCmd rotates the logs once, or until it is interrupted or the service is stopped
+func runRotateLogsCmd(cmd *cobra.Command, args []string) {
+	flag.Parse()
+
+	paths, options := rotateLogsOpts.logFiles, rotateLogsOpts.options
+	if rotateLogsOpts.windowsService {
+		err := bootstrapper.RunAsService(bootstrapper.LogRotatorServiceName, func(stop <-chan struct{}) error {
+			bootstrapper.RunLogRotator(paths, options, stop)
+			return nil
+		})
+		if err != nil {
+			log.Error(err, "could not run log rotator service")
+			os.Exit(exitFailure)
+		}
+		return
+	}
+	if options.CheckInterval == 0 {
+		if err := bootstrapper.RotateLogs(paths, options); err != nil {
+			log.Error(err, "could not rotate logs")
+			os.Exit(exitFailure)
+		}
+		return
+	}
+	stop := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		close(stop)
+	}()
+	log.Info("rotating logs until interrupted", "interval", options.CheckInterval.String())
+	bootstrapper.RunLogRotator(paths, options, stop)
+}
@@ -1,13 +1,50 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
+	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/openshift/windows-machine-config-operator/pkg/bootstrapper"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/api/resource"
 )
 
+// Exit codes of wmcb, by the class of failure, so that orchestration can tell why a run failed
+const (
+	// exitFailure is any failure which does not have a more specific exit code
+	exitFailure = 1
+	// exitInvalidConfig is an invalid command line or configuration
+	exitInvalidConfig = 2
+	// exitIgnition is an ignition file which could not be fetched, read or parsed
+	exitIgnition = 3
+	// exitFiles is a file which could not be translated or written
+	exitFiles = 4
+	// exitKubelet is a kubelet binary which could not be verified or installed
+	exitKubelet = 5
+	// exitServices is a service which could not be configured or started
+	exitServices = 6
+	// exitRollback is a failed run whose changes could not be rolled back
+	exitRollback = 7
+	// exitLocked is an install directory locked by another run of wmcb, which was not released in time
+	exitLocked = 8
+)
+
+// failureExitCodes are the exit codes of the classes of failure of a run
+var failureExitCodes = map[bootstrapper.FailureCategory]int{
+	bootstrapper.FailureConfig:   exitInvalidConfig,
+	bootstrapper.FailureIgnition: exitIgnition,
+	bootstrapper.FailureFiles:    exitFiles,
+	bootstrapper.FailureKubelet:  exitKubelet,
+	bootstrapper.FailureServices: exitServices,
+	bootstrapper.FailureRollback: exitRollback,
+}
+
 var (
 	runCmd = &cobra.Command{
 		Use:   "run",
@@ -15,59 +52,331 @@ var (
 		Long:  "",
 		Run:   runRunCmd,
 		PreRunE: func(cmd *cobra.Command, _ []string) error {
-			err := cmd.MarkPersistentFlagRequired("ignition-file")
-			if err != nil {
-				return err
+			if cmd.Flags().Changed("ignition-file") && cmd.Flags().Changed("ignition-url") {
+				return fmt.Errorf("only one of --ignition-file or --ignition-url can be given")
 			}
-			err = cmd.MarkPersistentFlagRequired("kubelet-path")
-			if err != nil {
+			var err error
+			if runConfig, err = configFromRunFlags(cmd); err != nil {
 				return err
 			}
+			if runConfig.Kubelet.Path == "" {
+				return fmt.Errorf("--kubelet-path must be given")
+			}
+			if (runConfig.Ignition.File == "") == (runConfig.Ignition.URL == "") {
+				return fmt.Errorf("exactly one of --ignition-file or --ignition-url must be given")
+			}
 			return nil
 		},
 	}
 
+	// runConfig is the configuration file, with the values of the flags which were given applied over it
+	runConfig *bootstrapper.Config
+
 	runOpts struct {
+		// The location of the configuration file
+		configFile string
+		// Print the report of the run to stdout
+		report bool
 		// The location of the ignition file
 		ignitionFile string
+		// The URL of the Machine Config Server to fetch the ignition file from
+		ignitionURL string
+		// The location of the CA bundle used to verify the Machine Config Server
+		ignitionCABundle string
 		// The location where the kubelet.exe has been downloaded to
 		kubeletPath string
+		// The expected hex encoded SHA-256 digest of the kubelet
+		kubeletSHA256 string
+		// The location of a detached signature of the kubelet
+		kubeletSignature string
+		// The location of the PEM encoded public key the kubelet signature is verified with
+		kubeletSigningKey string
+		// The location of the partial kubelet configuration merged into the one from the ignition file
+		kubeletConfigOverrides string
+		// The location of the rules mapping files of the ignition file to where they are written
+		fileMappings string
 		// The directory to install the kubelet and related files
 		installDir string
+		// Labels the node registers with
+		nodeLabels []string
+		// Taints the node registers with
+		nodeTaints []string
+		// The CNI plugin used for pod networking, CNI is not configured if empty
+		cniPlugin string
+		// The directory containing the CNI plugin binaries
+		cniPluginDir string
+		// The subnet pods on this node are given addresses from
+		podCIDR string
+		// The subnet cluster services are given addresses from
+		serviceCIDR string
+		// The location of the hybrid overlay node binary, the hybrid overlay is not run if empty
+		hybridOverlayPath string
+		// The hybrid overlay subnets of the cluster network config
+		hybridOverlayClusterSubnets string
+		// The hybrid overlay VXLAN port of the cluster network config
+		hybridOverlayVXLANPort uint16
+		// The size the service logs are rotated at
+		logMaxSize string
+		// The time after which the service logs are rotated
+		logMaxAge time.Duration
+		// The number of rotated copies kept of each service log
+		logMaxBackups int
+		// The time after which the rotated service logs are deleted
+		logRetention time.Duration
+		// Compress the rotated service logs
+		logCompress bool
+		// The time to wait for another run of wmcb to release the install directory
+		lockWait time.Duration
 	}
 )
 
 func init() {
 	rootCmd.AddCommand(runCmd)
+	runCmd.PersistentFlags().StringVar(&runOpts.configFile, "config", "",
+		"Configuration file, in YAML or JSON, describing the node. Flags which are given override its values")
+	runCmd.PersistentFlags().BoolVar(&runOpts.report, "report", false,
+		"Print the report of the run, which is also recorded in the install directory, to stdout as JSON")
 	runCmd.PersistentFlags().StringVar(&runOpts.ignitionFile, "ignition-file", "",
 		"Ignition file location to bootstrap the windows node")
+	runCmd.PersistentFlags().StringVar(&runOpts.ignitionURL, "ignition-url", "",
+		"Machine Config Server URL to fetch the worker ignition file from, instead of using --ignition-file. "+
+			"e.g. https://api-int.<cluster-domain>:22623/config/worker")
+	runCmd.PersistentFlags().StringVar(&runOpts.ignitionCABundle, "ignition-ca-bundle", "",
+		"CA bundle used to verify the Machine Config Server given by --ignition-url. Defaults to the system roots")
 	runCmd.PersistentFlags().StringVar(&runOpts.kubeletPath, "kubelet-path", "",
 		"Kubelet file location to bootstrap the windows node")
-	runCmd.PersistentFlags().StringVar(&runOpts.installDir, "install-dir", "c:\\k",
+	runCmd.PersistentFlags().StringVar(&runOpts.kubeletSHA256, "kubelet-sha256", "",
+		"Expected hex encoded SHA-256 digest of the kubelet. The kubelet is not installed if it does not match")
+	runCmd.PersistentFlags().StringVar(&runOpts.kubeletSignature, "kubelet-signature", "",
+		"Detached signature of the kubelet, raw or base64 encoded, as created by openssl dgst -sha256 -sign. "+
+			"Requires --kubelet-signing-key")
+	runCmd.PersistentFlags().StringVar(&runOpts.kubeletSigningKey, "kubelet-signing-key", "",
+		"PEM encoded RSA or ECDSA public key the --kubelet-signature is verified with")
+	runCmd.PersistentFlags().StringVar(&runOpts.kubeletConfigOverrides, "kubelet-config-overrides", "",
+		"Partial KubeletConfiguration, in YAML or JSON, merged into the kubelet configuration from the ignition file "+
+			"as a JSON merge patch once it is translated for Windows")
+	runCmd.PersistentFlags().StringVar(&runOpts.fileMappings, "file-mappings", "",
+		"FileMappingRules file, in YAML or JSON, mapping files of the ignition file other than the ones the kubelet "+
+			"needs to where they are written in the install directory, and how they are translated")
+	runCmd.PersistentFlags().StringVar(&runOpts.installDir, "install-dir", bootstrapper.DefaultInstallDir,
 		"Kubelet file location to bootstrap the windows node. Defaults to C:\\k")
+	runCmd.PersistentFlags().StringSliceVar(&runOpts.nodeLabels, "node-labels", nil,
+		"Labels, as key[=value], the node registers with in addition to the ones in the ignition file")
+	runCmd.PersistentFlags().StringSliceVar(&runOpts.nodeTaints, "register-with-taints",
+		[]string{bootstrapper.DefaultNodeTaint}, "Taints, as key[=value]:effect, the node registers with in addition "+
+			"to the ones in the ignition file. Set to an empty string to register without the default taint")
+	runCmd.PersistentFlags().StringVar(&runOpts.cniPlugin, "cni-plugin", "",
+		"CNI plugin used for pod networking, "+bootstrapper.CNIPluginWinBridge+" or "+
+			bootstrapper.CNIPluginWinOverlay+". The kubelet is run without a network plugin if not given")
+	runCmd.PersistentFlags().StringVar(&runOpts.cniPluginDir, "cni-plugin-dir", "",
+		"Directory containing the CNI plugin binaries, required with --cni-plugin")
+	runCmd.PersistentFlags().StringVar(&runOpts.podCIDR, "pod-cidr", "",
+		"Subnet pods on this node are given addresses from, required with --cni-plugin")
+	runCmd.PersistentFlags().StringVar(&runOpts.serviceCIDR, "service-cidr", "",
+		"Subnet cluster services are given addresses from, required with --cni-plugin")
+	runCmd.PersistentFlags().StringVar(&runOpts.hybridOverlayPath, "hybrid-overlay-path", "",
+		"Hybrid overlay node file location. If given, the hybrid overlay node is run as a Windows service")
+	runCmd.PersistentFlags().StringVar(&runOpts.hybridOverlayClusterSubnets, "hybrid-overlay-cluster-subnets", "",
+		"Comma separated hybrid overlay cluster subnets of the cluster network config, required with "+
+			"--hybrid-overlay-path")
+	runCmd.PersistentFlags().Uint16Var(&runOpts.hybridOverlayVXLANPort, "hybrid-overlay-vxlan-port", 0,
+		"Hybrid overlay VXLAN port of the cluster network config. Defaults to the hybrid overlay default")
+	runCmd.PersistentFlags().StringVar(&runOpts.logMaxSize, "log-max-size", "",
+		"Size, such as 100Mi, the kubelet and other service logs are rotated at. If any of the --log flags are given, "+
+			"the logs are rotated by the "+bootstrapper.LogRotatorServiceName+" service")
+	runCmd.PersistentFlags().DurationVar(&runOpts.logMaxAge, "log-max-age", 0,
+		"Time after which the service logs are rotated, counted from their last rotation")
+	runCmd.PersistentFlags().IntVar(&runOpts.logMaxBackups, "log-max-backups", 0,
+		"Number of rotated copies kept of each service log, the oldest are deleted first. All are kept if 0")
+	runCmd.PersistentFlags().DurationVar(&runOpts.logRetention, "log-retention", 0,
+		"Time after which the rotated service logs are deleted. They are kept regardless of their age if 0")
+	runCmd.PersistentFlags().BoolVar(&runOpts.logCompress, "log-compress", false,
+		"Gzip compress the rotated service logs")
+	runCmd.PersistentFlags().DurationVar(&runOpts.lockWait, "lock-wait", 0,
+		"Time to wait for another run of wmcb to release the install directory. If 0, fails at once if it is locked")
+}
+
+// configFromRunFlags loads the configuration file, if one was given, and applies the values of the flags over it. A
+// flag overrides the value in the file if it was given, or if the file does not set the value.
+func configFromRunFlags(cmd *cobra.Command) (*bootstrapper.Config, error) {
+	config, err := loadConfig(cmd, runOpts.configFile, runOpts.installDir)
+	if err != nil {
+		return nil, err
+	}
+	flags := cmd.Flags()
+	// The ignition file and URL are alternatives, so giving either replaces the one set in the file
+	if flags.Changed("ignition-file") {
+		config.Ignition.URL = ""
+	}
+	if flags.Changed("ignition-url") {
+		config.Ignition.File = ""
+	}
+	if config.Ignition.URL == "" {
+		overrideString(flags, "ignition-file", &config.Ignition.File, runOpts.ignitionFile)
+	}
+	if config.Ignition.File == "" {
+		overrideString(flags, "ignition-url", &config.Ignition.URL, runOpts.ignitionURL)
+	}
+	overrideString(flags, "ignition-ca-bundle", &config.Ignition.CABundle, runOpts.ignitionCABundle)
+	overrideString(flags, "kubelet-path", &config.Kubelet.Path, runOpts.kubeletPath)
+	overrideString(flags, "kubelet-sha256", &config.Kubelet.SHA256, runOpts.kubeletSHA256)
+	overrideString(flags, "kubelet-signature", &config.Kubelet.Signature, runOpts.kubeletSignature)
+	overrideString(flags, "kubelet-signing-key", &config.Kubelet.SigningKey, runOpts.kubeletSigningKey)
+	overrideString(flags, "kubelet-config-overrides", &config.Kubelet.ConfigOverrides, runOpts.kubeletConfigOverrides)
+	overrideString(flags, "file-mappings", &config.FileMappings, runOpts.fileMappings)
+	overrideStrings(flags, "node-labels", &config.Kubelet.NodeLabels, runOpts.nodeLabels)
+	overrideStrings(flags, "register-with-taints", &config.Kubelet.NodeTaints, runOpts.nodeTaints)
+	if flags.Changed("lock-wait") {
+		config.Timeouts.LockWait.Duration = runOpts.lockWait
+	}
+
+	if config.CNI == nil && flags.Changed("cni-plugin") {
+		config.CNI = &bootstrapper.CNIConfig{}
+	}
+	if cni := config.CNI; cni != nil {
+		overrideString(flags, "cni-plugin", &cni.Plugin, runOpts.cniPlugin)
+		overrideString(flags, "cni-plugin-dir", &cni.PluginDir, runOpts.cniPluginDir)
+		overrideString(flags, "pod-cidr", &cni.PodCIDR, runOpts.podCIDR)
+		overrideString(flags, "service-cidr", &cni.ServiceCIDR, runOpts.serviceCIDR)
+	}
+
+	if config.HybridOverlay == nil && flags.Changed("hybrid-overlay-path") {
+		config.HybridOverlay = &bootstrapper.HybridOverlayConfig{}
+	}
+	if hybridOverlay := config.HybridOverlay; hybridOverlay != nil {
+		overrideString(flags, "hybrid-overlay-path", &hybridOverlay.Path, runOpts.hybridOverlayPath)
+		var subnets []string
+		if runOpts.hybridOverlayClusterSubnets != "" {
+			subnets = strings.Split(runOpts.hybridOverlayClusterSubnets, ",")
+		}
+		overrideStrings(flags, "hybrid-overlay-cluster-subnets", &hybridOverlay.ClusterSubnets, subnets)
+		if flags.Changed("hybrid-overlay-vxlan-port") || hybridOverlay.VXLANPort == 0 {
+			hybridOverlay.VXLANPort = runOpts.hybridOverlayVXLANPort
+		}
+	}
+	if err = logRotationFromRunFlags(flags, config); err != nil {
+		return nil, err
+	}
+	return config, config.Validate()
+}
+
+// logRotationFromRunFlags applies the log rotation flags which were given over the configuration, enabling log
+// rotation if it is not enabled by the configuration file
+func logRotationFromRunFlags(flags *pflag.FlagSet, config *bootstrapper.Config) error {
+	names := []string{"log-max-size", "log-max-age", "log-max-backups", "log-retention", "log-compress"}
+	for _, name := range names {
+		if flags.Changed(name) && config.LogRotation == nil {
+			config.LogRotation = &bootstrapper.LogRotationConfig{}
+		}
+	}
+	rotation := config.LogRotation
+	if rotation == nil {
+		return nil
+	}
+	if flags.Changed("log-max-size") {
+		size, err := resource.ParseQuantity(runOpts.logMaxSize)
+		if err != nil {
+			return fmt.Errorf("invalid --log-max-size: %s", err)
+		}
+		rotation.MaxSize = size
+	}
+	if flags.Changed("log-max-age") {
+		rotation.MaxAge.Duration = runOpts.logMaxAge
+	}
+	if flags.Changed("log-max-backups") {
+		rotation.MaxBackups = runOpts.logMaxBackups
+	}
+	if flags.Changed("log-retention") {
+		rotation.Retention.Duration = runOpts.logRetention
+	}
+	if flags.Changed("log-compress") {
+		rotation.Compress = runOpts.logCompress
+	}
+	return nil
 }
 
-// runRunCmd starts the windows machine config bootstrapper
+// runRunCmd starts the windows machine config bootstrapper while holding the lock of the install directory. It exits
+// with the exit code of the class of failure if it fails.
 func runRunCmd(cmd *cobra.Command, args []string) {
 	flag.Parse()
-	// TODO: add validation for flags
+	runLocked(runConfig.InstallDir, runConfig.Timeouts.LockWait.Duration, bootstrap)
+}
 
-	wmcb, err := bootstrapper.NewWinNodeBootstrapper(runOpts.installDir, runOpts.ignitionFile, runOpts.kubeletPath)
+// bootstrap runs the windows machine config bootstrapper until ctx is cancelled, and returns the exit code of the class
+// of failure if it fails. A report is recorded even if it fails before the bootstrapper runs.
+func bootstrap(ctx context.Context) int {
+	var source bootstrapper.IgnitionSource
+	if runConfig.Ignition.URL != "" {
+		fetcher, err := bootstrapper.NewIgnitionFetcher(runConfig.Ignition.URL, runConfig.Ignition.CABundle,
+			runConfig.InstallDir)
+		if err != nil {
+			log.Error(err, "could not create ignition fetcher")
+			return failBootstrap(bootstrapper.FailureIgnition, err)
+		}
+		if timeout := runConfig.Timeouts.IgnitionFetch.Duration; timeout != 0 {
+			fetcher.SetTimeout(timeout)
+		}
+		source = fetcher
+	}
+
+	wmcb, err := bootstrapper.NewWinNodeBootstrapper(runConfig.InstallDir, runConfig.Ignition.File,
+		runConfig.Kubelet.Path)
 	if err != nil {
 		log.Error(err, "could not create bootstrapper")
-		os.Exit(1)
+		return failBootstrap(bootstrapper.FailureServices, err)
+	}
+	if source != nil {
+		// The ignition config is fetched by the run, which falls back to the cached copy if it cannot be fetched
+		wmcb.SetIgnitionSource(source)
+	}
+	defer func() {
+		if err := wmcb.Disconnect(); err != nil {
+			log.Error(err, "can't clean up bootstrapper")
+		}
+	}()
+	if err = wmcb.Configure(runConfig); err != nil {
+		log.Error(err, "invalid configuration")
+		return failBootstrap(bootstrapper.FailureConfig, err)
 	}
 
-	err = wmcb.Run()
+	err = wmcb.Run(ctx)
+	printReport(wmcb.Report())
 	if err != nil {
-		log.Error(err, "could not run bootstrapper")
-		os.Exit(1)
+		log.Error(err, "could not run bootstrapper", "category", bootstrapper.FailureCategoryOf(err))
+		return failureExitCode(bootstrapper.FailureCategoryOf(err))
+	} else if changes := wmcb.Changes(); changes.Changed() {
+		log.Info("Bootstrapping completed successfully", "files", changes.Files, "services", changes.Services,
+			"started", changes.Started)
 	} else {
-		log.Info("Bootstrapping completed successfully")
+		log.Info("Bootstrapping completed successfully, the node was already up to date")
 	}
+	return 0
+}
 
-	err = wmcb.Disconnect()
-	if err != nil {
-		log.Error(err, "can't clean up bootstrapper")
+// failBootstrap records the report of a run which failed with err, in the given category, before the bootstrapper
+// could run it, and returns the exit code of the category
+func failBootstrap(category bootstrapper.FailureCategory, err error) int {
+	printReport(bootstrapper.RecordFailedRun(runConfig.InstallDir, category, err))
+	return failureExitCode(category)
+}
+
+// printReport prints the report of the run to stdout as JSON, if --report was given
+func printReport(result *bootstrapper.BootstrapResult) {
+	if !runOpts.report {
+		return
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(result); err != nil {
+		log.Error(err, "could not print report")
+	}
+}
+
+// failureExitCode returns the exit code of the class of failure
+func failureExitCode(category bootstrapper.FailureCategory) int {
+	exitCode, ok := failureExitCodes[category]
+	if !ok {
+		return exitFailure
 	}
+	return exitCode
 }
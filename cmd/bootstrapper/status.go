@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/bootstrapper"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// outputText is the human readable output format
+	outputText = "text"
+	// outputJSON is the JSON output format
+	outputJSON = "json"
+)
+
+var (
+	statusCmd = &cobra.Command{
+		Use:   "status",
+		Short: "Reports the bootstrap state of the Windows node",
+		Long: "Reports the state of the services run by the Windows Machine Config Bootstrapper and of its reconcile " +
+			"daemon, the arguments the kubelet was registered with, the files the kubelet needs, the expiry of the " +
+			"kubelet client certificate, the result of the last bootstrap and the result of the last reconcile of the " +
+			"daemon",
+		Run: runStatusCmd,
+		PreRunE: func(cmd *cobra.Command, _ []string) error {
+			if statusOpts.output != outputText && statusOpts.output != outputJSON {
+				return fmt.Errorf("--output must be %s or %s", outputText, outputJSON)
+			}
+			return nil
+		},
+	}
+
+	statusOpts struct {
+		// The location of the configuration file the node was bootstrapped with
+		configFile string
+		// The directory the kubelet and related files were installed to
+		installDir string
+		// The output format
+		output string
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+	statusCmd.PersistentFlags().StringVar(&statusOpts.configFile, "config", "",
+		"Configuration file the node was bootstrapped with, which gives the install directory and file locations")
+	statusCmd.PersistentFlags().StringVar(&statusOpts.installDir, "install-dir", bootstrapper.DefaultInstallDir,
+		"Directory the kubelet and related files were installed to. Defaults to C:\\k")
+	statusCmd.PersistentFlags().StringVarP(&statusOpts.output, "output", "o", outputText,
+		"Output format, "+outputText+" or "+outputJSON)
+}
+
+// runStatusCmd reports the bootstrap state of the node
+func runStatusCmd(cmd *cobra.Command, args []string) {
+	flag.Parse()
+
+	config, err := loadConfig(cmd, statusOpts.configFile, statusOpts.installDir)
+	if err != nil {
+		log.Error(err, "could not load configuration")
+		os.Exit(exitInvalidConfig)
+	}
+	wmcb, err := bootstrapper.NewWinNodeBootstrapper(config.InstallDir, "", "")
+	if err != nil {
+		log.Error(err, "could not create bootstrapper")
+		os.Exit(exitFailure)
+	}
+	if err = wmcb.Configure(config); err != nil {
+		log.Error(err, "invalid configuration")
+		wmcb.Disconnect()
+		os.Exit(exitInvalidConfig)
+	}
+	status, err := wmcb.Status()
+	wmcb.Disconnect()
+	if err != nil {
+		log.Error(err, "could not get status")
+		os.Exit(exitFailure)
+	}
+	if statusOpts.output == outputJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(status)
+	} else {
+		err = printStatus(os.Stdout, status)
+	}
+	if err != nil {
+		log.Error(err, "could not print status")
+		os.Exit(exitFailure)
+	}
+}
+
+// printStatus writes the status in a human readable format
+func printStatus(out io.Writer, status *bootstrapper.NodeStatus) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SERVICE\tSTATE\tSTART TYPE\tBINARY PATH")
+	for _, service := range status.Services {
+		if !service.Installed {
+			fmt.Fprintf(w, "%s\tNotInstalled\t\t\n", service.Name)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", service.Name, service.State, service.StartType, service.BinaryPath)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "FILE\tSHA256")
+	for _, file := range status.Files {
+		digest := file.SHA256
+		if !file.Present {
+			digest = "missing"
+		}
+		if file.Modified {
+			digest += " (modified, " + file.RecordedSHA256 + " was installed)"
+		}
+		fmt.Fprintf(w, "%s\t%s\n", file.Path, digest)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(out)
+	if len(status.KubeletArgs) > 0 {
+		fmt.Fprintf(out, "Kubelet arguments:\n  %s\n", strings.Join(status.KubeletArgs, "\n  "))
+	}
+	switch cert := status.ClientCertificate; {
+	case cert == nil:
+		fmt.Fprintln(out, "Client certificate: not issued")
+	case cert.Expired:
+		fmt.Fprintf(out, "Client certificate: %s expired at %s\n", cert.Path, cert.NotAfter.Format(time.RFC3339))
+	default:
+		fmt.Fprintf(out, "Client certificate: %s expires at %s\n", cert.Path, cert.NotAfter.Format(time.RFC3339))
+	}
+	switch result := status.LastBootstrap; {
+	case result == nil:
+		fmt.Fprintln(out, "Last bootstrap: never run")
+	case result.Succeeded && result.Changes != nil && !result.Changes.Changed():
+		fmt.Fprintf(out, "Last bootstrap: succeeded at %s (no changes)\n", result.Time.Format(time.RFC3339))
+	case result.Succeeded:
+		fmt.Fprintf(out, "Last bootstrap: succeeded at %s\n", result.Time.Format(time.RFC3339))
+	default:
+		fmt.Fprintf(out, "Last bootstrap: failed at %s: %s\n", result.Time.Format(time.RFC3339), result.Error)
+	}
+	switch result := status.LastReconcile; {
+	case result == nil:
+	case result.Succeeded && !result.Applied:
+		fmt.Fprintf(out, "Last reconcile: up to date at %s, next at %s\n", result.Time.Format(time.RFC3339),
+			result.NextReconcile.Format(time.RFC3339))
+	case result.Succeeded:
+		fmt.Fprintf(out, "Last reconcile: applied at %s, next at %s\n", result.Time.Format(time.RFC3339),
+			result.NextReconcile.Format(time.RFC3339))
+	default:
+		fmt.Fprintf(out, "Last reconcile: failed at %s (%d in a row), retrying at %s: %s\n",
+			result.Time.Format(time.RFC3339), result.ConsecutiveFailures, result.NextReconcile.Format(time.RFC3339),
+			result.Error)
+	}
+	return nil
+}
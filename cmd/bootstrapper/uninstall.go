@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"time"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/bootstrapper"
+	"github.com/spf13/cobra"
+)
+
+var (
+	uninstallCmd = &cobra.Command{
+		Use:   "uninstall",
+		Short: "Deconfigures a Windows node bootstrapped by the Windows Machine Config Bootstrapper",
+		Long: "Stops and removes the services run by the Windows Machine Config Bootstrapper, optionally deletes the " +
+			"Node object, and deletes the files it generated, including the kubelet certificates. This allows the " +
+			"host to be bootstrapped into another cluster",
+		Run: runUninstallCmd,
+	}
+
+	uninstallOpts struct {
+		// The location of the configuration file the node was bootstrapped with
+		configFile string
+		// The directory the kubelet and related files were installed to
+		installDir string
+		// Delete the Node object of the node
+		deleteNode bool
+		// The kubeconfig used to delete the Node object
+		kubeconfig string
+		// The zip file to archive the generated files to before deleting them
+		archivePath string
+		// Archive the files holding credentials of the node too
+		archiveCredentials bool
+		// The time to wait for another run of wmcb to release the install directory
+		lockWait time.Duration
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(uninstallCmd)
+	uninstallCmd.PersistentFlags().StringVar(&uninstallOpts.configFile, "config", "",
+		"Configuration file the node was bootstrapped with, which gives the install directory and file locations")
+	uninstallCmd.PersistentFlags().StringVar(&uninstallOpts.installDir, "install-dir", bootstrapper.DefaultInstallDir,
+		"Directory the kubelet and related files were installed to. Defaults to C:\\k")
+	uninstallCmd.PersistentFlags().BoolVar(&uninstallOpts.deleteNode, "delete-node", false,
+		"Delete the Node object of the node from the cluster")
+	uninstallCmd.PersistentFlags().StringVar(&uninstallOpts.kubeconfig, "kubeconfig", "",
+		"Kubeconfig used to delete the Node object. Defaults to the kubeconfig of the node in the install directory")
+	uninstallCmd.PersistentFlags().StringVar(&uninstallOpts.archivePath, "archive", "",
+		"New zip file, only readable by its owner, to archive the generated files, such as the logs, to before they "+
+			"are deleted. The files holding credentials of the node are left out unless --archive-credentials is given")
+	uninstallCmd.PersistentFlags().BoolVar(&uninstallOpts.archiveCredentials, "archive-credentials", false,
+		"Also archive the kubelet certificates and private keys, the kubeconfigs, the cloud provider configuration "+
+			"and the cached ignition file to the --archive zip file")
+	uninstallCmd.PersistentFlags().DurationVar(&uninstallOpts.lockWait, "lock-wait", 0,
+		"Time to wait for another run of wmcb to release the install directory. If 0, fails at once if it is locked")
+}
+
+// runUninstallCmd deconfigures the node while holding the lock of the install directory
+func runUninstallCmd(cmd *cobra.Command, args []string) {
+	flag.Parse()
+
+	config, err := loadConfig(cmd, uninstallOpts.configFile, uninstallOpts.installDir)
+	if err != nil {
+		log.Error(err, "could not load configuration")
+		os.Exit(exitInvalidConfig)
+	}
+	if cmd.Flags().Changed("lock-wait") {
+		config.Timeouts.LockWait.Duration = uninstallOpts.lockWait
+	}
+	runLocked(config.InstallDir, config.Timeouts.LockWait.Duration, func(ctx context.Context) int {
+		return uninstall(ctx, config)
+	})
+}
+
+// uninstall deconfigures the node bootstrapped with the configuration until ctx is cancelled, and returns the exit code
+func uninstall(ctx context.Context, config *bootstrapper.Config) int {
+	wmcb, err := bootstrapper.NewWinNodeBootstrapper(config.InstallDir, "", "")
+	if err != nil {
+		log.Error(err, "could not create bootstrapper")
+		return exitFailure
+	}
+	if err = wmcb.Configure(config); err != nil {
+		log.Error(err, "invalid configuration")
+		wmcb.Disconnect()
+		return exitInvalidConfig
+	}
+	err = wmcb.Uninstall(ctx, bootstrapper.UninstallOptions{
+		DeleteNode:         uninstallOpts.deleteNode,
+		Kubeconfig:         uninstallOpts.kubeconfig,
+		ArchivePath:        uninstallOpts.archivePath,
+		ArchiveCredentials: uninstallOpts.archiveCredentials,
+	})
+	if err != nil {
+		log.Error(err, "could not uninstall")
+		wmcb.Disconnect()
+		return exitFailure
+	}
+	if err = wmcb.Disconnect(); err != nil {
+		log.Error(err, "can't clean up bootstrapper")
+		return exitFailure
+	}
+	log.Info("Uninstall completed successfully")
+	return 0
+}
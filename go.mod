@@ -13,7 +13,9 @@ replace (
 
 require (
 	github.com/ajeddeloh/go-json v0.0.0-20170920214419-6a2fe990e083 // indirect
+	github.com/coreos/go-semver v0.2.0
 	github.com/coreos/ignition v0.33.0
+	github.com/evanphx/json-patch v4.5.0+incompatible
 	github.com/go-logr/zapr v0.1.0
 	github.com/google/pprof v0.0.0-20190908185732-236ed259b199 // indirect
 	github.com/ianlancetaylor/demangle v0.0.0-20181102032728-5e5cf60278f6 // indirect
@@ -25,7 +27,10 @@ require (
 	go.uber.org/zap v1.10.0
 	go4.org v0.0.0-20190919214946-0cfe6e5be80f // indirect
 	golang.org/x/sys v0.0.0-20190215142949-d0b11bdaac8a
+	k8s.io/api v0.0.0-20190923155552-eac758366a00
 	k8s.io/apimachinery v0.0.0-20190923155427-ec87dd743e08
+	k8s.io/client-go v11.0.1-0.20190409021438-1a26190bd76a+incompatible
 	k8s.io/kubelet v0.0.0-20190923161547-13146ddde0d1
 	sigs.k8s.io/controller-runtime v0.2.1
+	sigs.k8s.io/yaml v1.1.0
 )
@@ -0,0 +1,269 @@
+package bootstrapper
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Options configure a Bootstrapper. Fields which are not set keep their defaults.
+type Options struct {
+	// InstallDir is the directory the kubelet and related files are installed to. Defaults to the install directory
+	// of Config, or DefaultInstallDir.
+	InstallDir string
+	// KubeletPath is the path of the kubelet binary which is installed. Defaults to the kubelet path of Config.
+	KubeletPath string
+	// Config is the configuration of the node, whose settings are applied to the bootstrapper
+	Config *Config
+	// IgnitionSource provides the worker ignition config. Defaults to the ignition file, or the Machine Config Server,
+	// of Config.
+	IgnitionSource IgnitionSource
+	// ServiceManager connects to the service manager the services are installed with. Defaults to the Windows service
+	// manager of the host.
+	ServiceManager func() (ServiceManager, error)
+	// FileSystem is the filesystem the files are installed to, and the binaries are read from. Defaults to the
+	// filesystem of the host.
+	FileSystem FileSystem
+	// Services are the services run alongside the kubelet, see AddService
+	Services []ServiceSpec
+}
+
+// Option sets a field of the Options a Bootstrapper is created with
+type Option func(*Options)
+
+// WithInstallDir sets the directory the kubelet and related files are installed to
+func WithInstallDir(installDir string) Option {
+	return func(o *Options) { o.InstallDir = installDir }
+}
+
+// WithKubelet sets the path of the kubelet binary which is installed
+func WithKubelet(path string) Option {
+	return func(o *Options) { o.KubeletPath = path }
+}
+
+// WithConfig sets the configuration of the node
+func WithConfig(config *Config) Option {
+	return func(o *Options) { o.Config = config }
+}
+
+// WithIgnitionSource sets the source of the worker ignition config
+func WithIgnitionSource(source IgnitionSource) Option {
+	return func(o *Options) { o.IgnitionSource = source }
+}
+
+// WithIgnitionFile reads the worker ignition config from the file at path
+func WithIgnitionFile(path string) Option {
+	return WithIgnitionSource(IgnitionFromFile(path))
+}
+
+// WithServiceManager sets how the service manager the services are installed with is connected to
+func WithServiceManager(connect func() (ServiceManager, error)) Option {
+	return func(o *Options) { o.ServiceManager = connect }
+}
+
+// WithFileSystem sets the filesystem the files are installed to
+func WithFileSystem(fs FileSystem) Option {
+	return func(o *Options) { o.FileSystem = fs }
+}
+
+// WithService adds a service which is run alongside the kubelet
+func WithService(spec ServiceSpec) Option {
+	return func(o *Options) { o.Services = append(o.Services, spec) }
+}
+
+// Bootstrapper bootstraps a Windows node, for programs which drive the bootstrapper as a library. The node is
+// bootstrapped in phases: Prepare reads the ignition config and verifies the kubelet binary without changing the
+// node, Apply installs the files and configures and starts the services, and Verify checks that the node still
+// matches what was applied. The phases can also be run on their own, Apply prepares the node itself if Prepare was not
+// called first.
+type Bootstrapper struct {
+	// wmcb is the bootstrapper the phases are run by
+	wmcb *winNodeBootstrapper
+}
+
+// New returns a Bootstrapper configured by the options, see NewFromOptions
+func New(opts ...Option) (*Bootstrapper, error) {
+	options := Options{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return NewFromOptions(options)
+}
+
+// NewFromOptions returns a Bootstrapper configured by the options. It connects to the service manager, and must be
+// closed once it is no longer used. A kubelet path and an ignition source must be given, either directly or through
+// the configuration.
+func NewFromOptions(options Options) (*Bootstrapper, error) {
+	installDir, kubeletPath, source := options.InstallDir, options.KubeletPath, options.IgnitionSource
+	if config := options.Config; config != nil {
+		if err := config.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid configuration: %s", err)
+		}
+		if installDir == "" {
+			installDir = config.InstallDir
+		}
+		if kubeletPath == "" {
+			kubeletPath = config.Kubelet.Path
+		}
+	}
+	if installDir == "" {
+		installDir = DefaultInstallDir
+	}
+	if kubeletPath == "" {
+		return nil, fmt.Errorf("a kubelet path must be given")
+	}
+	if source == nil && options.Config != nil {
+		var err error
+		if source, err = ignitionSourceOf(options.Config, installDir); err != nil {
+			return nil, err
+		}
+	}
+	if source == nil {
+		return nil, fmt.Errorf("an ignition source must be given")
+	}
+
+	connect := serviceManagerConnector(connectServiceManager)
+	if options.ServiceManager != nil {
+		connect = options.ServiceManager
+	}
+	wmcb, err := newWinNodeBootstrapper(installDir, "", kubeletPath, connect)
+	if err != nil {
+		return nil, err
+	}
+	wmcb.ignitionSource = source
+	if options.FileSystem != nil {
+		wmcb.fs = options.FileSystem
+	}
+	if options.Config != nil {
+		err = wmcb.Configure(options.Config)
+	}
+	for i := 0; err == nil && i < len(options.Services); i++ {
+		err = wmcb.AddService(options.Services[i])
+	}
+	if err != nil {
+		wmcb.Disconnect()
+		return nil, err
+	}
+	return &Bootstrapper{wmcb: wmcb}, nil
+}
+
+// ignitionSourceOf returns the source of the ignition config given by the configuration, which fetches it from the
+// Machine Config Server into the install directory if it is given by URL. It returns nil if neither is set.
+func ignitionSourceOf(config *Config, installDir string) (IgnitionSource, error) {
+	if config.Ignition.File != "" {
+		return IgnitionFromFile(config.Ignition.File), nil
+	}
+	if config.Ignition.URL == "" {
+		return nil, nil
+	}
+	fetcher, err := NewIgnitionFetcher(config.Ignition.URL, config.Ignition.CABundle, installDir)
+	if err != nil {
+		return nil, err
+	}
+	if timeout := config.Timeouts.IgnitionFetch.Duration; timeout != 0 {
+		fetcher.SetTimeout(timeout)
+	}
+	return fetcher, nil
+}
+
+// Prepare reads and parses the ignition config and verifies the kubelet binary, without changing the node, so that
+// a node which cannot be bootstrapped is caught before any of it is touched. The parsed ignition config is used by the
+// next Apply. If it fails, the error is a *PhaseError giving the phase of Apply which would have failed.
+func (b *Bootstrapper) Prepare(ctx context.Context) error {
+	wmcb := b.wmcb
+	wmcb.prepared = nil
+	configuration, err := wmcb.readIgnition(ctx)
+	if err != nil {
+		return &PhaseError{Phase: PhaseIgnitionParse, Category: FailureIgnition, Err: err}
+	}
+	if err = wmcb.verifyKubelet(); err != nil {
+		return &PhaseError{Phase: PhaseKubeletCopy, Category: FailureKubelet, Err: err}
+	}
+	wmcb.prepared = configuration
+	return nil
+}
+
+// Apply installs the files and configures and starts the services, changing only what differs from what is installed,
+// as Run of the bootstrapper returned by NewWinNodeBootstrapper does. It returns the changes it made. If it fails, the
+// node is rolled back and the error is a *PhaseError giving the phase which failed.
+func (b *Bootstrapper) Apply(ctx context.Context) (*RunChanges, error) {
+	if err := b.wmcb.Run(ctx); err != nil {
+		return nil, err
+	}
+	return b.wmcb.Changes(), nil
+}
+
+// VerificationError is returned by Verify when the node no longer matches what was applied
+type VerificationError struct {
+	// Problems describe each way the node differs from what was applied
+	Problems []string
+}
+
+func (e *VerificationError) Error() string {
+	return "node does not match what was applied: " + strings.Join(e.Problems, "; ")
+}
+
+// Verify checks that the node still matches what the last Apply applied: that the installed files are unchanged,
+// and that the services are installed as they were configured and, unless they are disabled, running. It returns a
+// *VerificationError describing the differences if it does not. Apply must have succeeded first.
+func (b *Bootstrapper) Verify(ctx context.Context) error {
+	wmcb := b.wmcb
+	if wmcb.result == nil || !wmcb.result.Succeeded {
+		return fmt.Errorf("could not verify node: it has not been applied")
+	}
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("could not verify node: %s", err)
+	}
+	var problems []string
+	for _, file := range wmcb.result.Files {
+		if file.SHA256 == "" {
+			// The file could not be hashed once it was installed
+			continue
+		}
+		digest, err := fileSHA256(wmcb.fs, file.Path)
+		if os.IsNotExist(err) {
+			problems = append(problems, fmt.Sprintf("file %s is missing", file.Path))
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("could not hash %s: %s", file.Path, err)
+		}
+		if digest != file.SHA256 {
+			problems = append(problems, fmt.Sprintf("file %s was modified", file.Path))
+		}
+	}
+	specs, err := wmcb.serviceSpecs()
+	if err != nil {
+		return fmt.Errorf("could not verify services: %s", err)
+	}
+	serviceProblems, err := wmcb.services.verify(specs)
+	if err != nil {
+		return fmt.Errorf("could not verify services: %s", err)
+	}
+	problems = append(problems, serviceProblems...)
+	if len(problems) > 0 {
+		return &VerificationError{Problems: problems}
+	}
+	return nil
+}
+
+// Report returns the report of the last Apply, or nil if it has not been run
+func (b *Bootstrapper) Report() *BootstrapResult {
+	return b.wmcb.Report()
+}
+
+// Status reports the bootstrap state of the node
+func (b *Bootstrapper) Status() (*NodeStatus, error) {
+	return b.wmcb.Status()
+}
+
+// Uninstall deconfigures the node, see Uninstall of the bootstrapper returned by NewWinNodeBootstrapper
+func (b *Bootstrapper) Uninstall(ctx context.Context, options UninstallOptions) error {
+	return b.wmcb.Uninstall(ctx, options)
+}
+
+// Close closes the connection to the service manager
+func (b *Bootstrapper) Close() error {
+	return b.wmcb.Disconnect()
+}
@@ -0,0 +1,208 @@
+package bootstrapper
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// staticIgnition is an IgnitionSource returning a fixed ignition config
+type staticIgnition []byte
+
+func (s staticIgnition) Ignition(ctx context.Context) ([]byte, error) {
+	return s, nil
+}
+
+// recordingFileSystem is the filesystem of the host, recording the paths of the files written to it
+type recordingFileSystem struct {
+	FileSystem
+	// written are the paths of the files which were created or renamed to
+	written []string
+}
+
+func (fs *recordingFileSystem) Create(path string, perm os.FileMode) (WritableFile, error) {
+	fs.written = append(fs.written, path)
+	return fs.FileSystem.Create(path, perm)
+}
+
+func (fs *recordingFileSystem) Rename(from, to string) error {
+	fs.written = append(fs.written, to)
+	return fs.FileSystem.Rename(from, to)
+}
+
+// testOptions returns the options of a Bootstrapper backed by the given fake SCM, which installs to a temporary
+// directory, along with the directory. The ignition config is read from a test ignition file.
+func testOptions(t *testing.T, scm *fakeSCM) (string, []Option) {
+	dir, err := ioutil.TempDir("", "wmcb")
+	require.NoError(t, err)
+	kubeletPath := filepath.Join(dir, "kubelet-download.exe")
+	require.NoError(t, ioutil.WriteFile(kubeletPath, []byte("kubelet binary"), 0755))
+	config := &Config{APIVersion: ConfigAPIVersion, Kind: ConfigKind}
+	config.Kubelet.CertDir = filepath.Join(dir, "pki")
+	config.Timeouts.ServiceWait.Duration = time.Second
+	return dir, []Option{
+		WithInstallDir(filepath.Join(dir, "k")),
+		WithKubelet(kubeletPath),
+		WithIgnitionFile(writeTestIgnitionFile(t, dir)),
+		WithConfig(config),
+		WithServiceManager(scm.connect),
+	}
+}
+
+// TestBootstrapper tests that the node is bootstrapped by preparing and applying it, and that Verify finds it matches
+// what was applied until one of its files is modified or a service is stopped
+func TestBootstrapper(t *testing.T) {
+	scm := newFakeSCM()
+	dir, options := testOptions(t, scm)
+	defer os.RemoveAll(dir)
+	installDir := filepath.Join(dir, "k")
+	b, err := New(append(options, WithIgnitionSource(staticIgnition(testIgnitionConfig("3.1.0"))))...)
+	require.NoError(t, err)
+	defer b.Close()
+
+	require.Error(t, b.Verify(context.Background()), "a node which has not been applied should not be verified")
+	require.NoError(t, b.Prepare(context.Background()))
+	changes, err := b.Apply(context.Background())
+	require.NoError(t, err)
+	assert.True(t, changes.Changed())
+	assert.True(t, b.Report().Succeeded)
+	assertKubeletServiceRunning(t, scm, installDir)
+	require.NoError(t, b.Verify(context.Background()))
+
+	kubeletConf := filepath.Join(installDir, "kubelet.conf")
+	require.NoError(t, ioutil.WriteFile(kubeletConf, []byte("modified"), 0644))
+	scm.get(KubeletServiceName).state = ServiceStopped
+	err = b.Verify(context.Background())
+	require.Error(t, err)
+	require.IsType(t, &VerificationError{}, err)
+	assert.ElementsMatch(t, []string{"file " + kubeletConf + " was modified",
+		"service kubelet is not running, it is Stopped"}, err.(*VerificationError).Problems)
+
+	// Applying again, without preparing first, repairs the node
+	changes, err = b.Apply(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{kubeletConf}, changes.Files)
+	require.NoError(t, b.Verify(context.Background()))
+}
+
+// TestBootstrapperPrepare tests that Prepare fails, without changing the node, if the ignition config cannot be parsed
+// or the kubelet fails verification
+func TestBootstrapperPrepare(t *testing.T) {
+	tests := []struct {
+		name         string
+		options      []Option
+		wantPhase    string
+		wantCategory FailureCategory
+	}{
+		{
+			name:         "Invalid ignition config",
+			options:      []Option{WithIgnitionSource(staticIgnition("{"))},
+			wantPhase:    PhaseIgnitionParse,
+			wantCategory: FailureIgnition,
+		},
+		{
+			name: "Kubelet digest mismatch",
+			options: []Option{WithConfig(&Config{APIVersion: ConfigAPIVersion, Kind: ConfigKind,
+				Kubelet: KubeletConfig{SHA256: strings.Repeat("00", 32)}})},
+			wantPhase:    PhaseKubeletCopy,
+			wantCategory: FailureKubelet,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scm := newFakeSCM()
+			dir, options := testOptions(t, scm)
+			defer os.RemoveAll(dir)
+			b, err := New(append(options, tt.options...)...)
+			require.NoError(t, err)
+			defer b.Close()
+
+			err = b.Prepare(context.Background())
+			require.Error(t, err)
+			require.IsType(t, &PhaseError{}, err)
+			assert.Equal(t, tt.wantPhase, err.(*PhaseError).Phase)
+			assert.Equal(t, tt.wantCategory, FailureCategoryOf(err))
+			_, err = os.Stat(filepath.Join(dir, "k"))
+			assert.True(t, os.IsNotExist(err), "install directory should not be made")
+			assert.Nil(t, scm.get(KubeletServiceName))
+		})
+	}
+}
+
+// TestBootstrapperFileSystem tests that the files are installed through the given filesystem
+func TestBootstrapperFileSystem(t *testing.T) {
+	scm := newFakeSCM()
+	dir, options := testOptions(t, scm)
+	defer os.RemoveAll(dir)
+	fs := &recordingFileSystem{FileSystem: hostFileSystem{}}
+	b, err := New(append(options, WithFileSystem(fs))...)
+	require.NoError(t, err)
+	defer b.Close()
+
+	_, err = b.Apply(context.Background())
+	require.NoError(t, err)
+	for _, name := range []string{"kubelet.exe", "kubelet.conf", "bootstrap-kubeconfig", "kubelet-ca.crt"} {
+		assert.Contains(t, fs.written, filepath.Join(dir, "k", name))
+	}
+}
+
+// TestNewFromOptions tests that a Bootstrapper takes its defaults from the configuration, and is not created without a
+// kubelet or an ignition source, or with an invalid configuration or service
+func TestNewFromOptions(t *testing.T) {
+	scm := newFakeSCM()
+	tests := []struct {
+		name      string
+		options   Options
+		wantError string
+	}{
+		{
+			name:      "No kubelet",
+			options:   Options{IgnitionSource: staticIgnition("{}"), ServiceManager: scm.connect},
+			wantError: "a kubelet path must be given",
+		},
+		{
+			name:      "No ignition source",
+			options:   Options{KubeletPath: "kubelet.exe", ServiceManager: scm.connect},
+			wantError: "an ignition source must be given",
+		},
+		{
+			name: "Ignition file from configuration",
+			options: Options{ServiceManager: scm.connect, Config: &Config{APIVersion: ConfigAPIVersion,
+				Kind: ConfigKind, Ignition: IgnitionConfig{File: "worker.ign"},
+				Kubelet: KubeletConfig{Path: "kubelet.exe"}}},
+		},
+		{
+			name:      "Invalid configuration",
+			options:   Options{KubeletPath: "kubelet.exe", ServiceManager: scm.connect, Config: &Config{}},
+			wantError: "invalid configuration",
+		},
+		{
+			name: "Invalid service",
+			options: Options{KubeletPath: "kubelet.exe", IgnitionSource: staticIgnition("{}"),
+				ServiceManager: scm.connect, Services: []ServiceSpec{{Name: KubeletServiceName, BinaryPath: "a.exe",
+					StartType: StartAutomatic}}},
+			wantError: "already managed by the bootstrapper",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := NewFromOptions(tt.options)
+			if tt.wantError != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, DefaultInstallDir, b.wmcb.installDir)
+			assert.Equal(t, IgnitionFromFile("worker.ign"), b.wmcb.ignitionSource)
+			require.NoError(t, b.Close())
+		})
+	}
+}
@@ -3,16 +3,13 @@ package bootstrapper
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	ignitionv2 "github.com/coreos/ignition/config/v2_2"
-	"github.com/vincent-petithory/dataurl"
-	"golang.org/x/sys/windows/svc"
 	"io"
 	"io/ioutil"
-	"os"
 	"path/filepath"
-	"regexp"
+	"sort"
 	"strings"
 	"time"
 
@@ -20,8 +17,7 @@ import (
 	"k8s.io/apimachinery/pkg/runtime/serializer"
 	"k8s.io/apimachinery/pkg/util/yaml"
 	kubeletConfig "k8s.io/kubelet/config/v1beta1"
-
-	"golang.org/x/sys/windows/svc/mgr"
+	logger "sigs.k8s.io/controller-runtime/pkg/log"
 )
 
 /*
@@ -41,14 +37,19 @@ const (
 	// kubeletSystemdName is the name of the systemd service that the kubelet runs under,
 	// this is used to parse the kubelet args
 	kubeletSystemdName = "kubelet.service"
-	// kubeletPauseContainerImage is the location of the image we will use for the kubelet pause container
+	// kubeletConfIgnitionPath is the path of the kubelet configuration in the ignition file
+	kubeletConfIgnitionPath = "/etc/kubernetes/kubelet.conf"
+	// kubeletPauseContainerImage is the location of the image we will use for the kubelet pause container by default
 	kubeletPauseContainerImage = "mcr.microsoft.com/k8s/core/pause:1.2.0"
-	// serviceWaitTime is an arbitrary amount of time to wait for the Windows service API to complete requests
+	// serviceWaitTime is the default amount of time to wait for the Windows service API to complete requests
 	serviceWaitTime = time.Second * 10
-	// certDirectory is where the kubelet will look for certificates
-	certDirectory = "c:/var/lib/kubelet/pki/"
+	// certDirName is the name of the directory in the install directory the kubelet keeps its certificates in by
+	// default
+	certDirName = "pki"
 )
 
+var log = logger.Log.WithName("bootstrapper")
+
 // winNodeBootstrapper is responsible for bootstrapping and ensuring kubelet runs as a Windows service
 type winNodeBootstrapper struct {
 	// kubeconfigPath is the file path of the node bootstrap kubeconfig
@@ -58,53 +59,117 @@ type winNodeBootstrapper struct {
 	// ignitionFilePath is the path to the ignition file which is used to set up worker nodes
 	// https://github.com/coreos/ignition/blob/spec2x/doc/getting-started.md
 	ignitionFilePath string
+	// ignitionSource provides the ignition config. If nil, it is read from the ignition file.
+	ignitionSource IgnitionSource
+	// prepared is the ignition config parsed ahead of the next run by Prepare. If nil, the run reads it.
+	prepared *ignitionConfig
+	// ignitionFromCache is true if the ignition config last read is the cached copy of one which could not be fetched
+	ignitionFromCache bool
 	//initialKubeletPath is the path to the kubelet that we'll be using to bootstrap this node
 	initialKubeletPath string
-	// TODO: When more services are added consider decomposing the services to a separate Service struct with common functions
-	// kubeletSVC is a pointer to the kubelet Windows service object
-	kubeletSVC *mgr.Service
-	// svcMgr is used to interact with the Windows service API
-	svcMgr *mgr.Mgr
+	// fs is the filesystem the files of a run are installed to
+	fs FileSystem
+	// services are the Windows services the bootstrapper manages
+	services *serviceSet
+	// extraServices are the services run in addition to the kubelet and hybrid overlay
+	extraServices []ServiceSpec
+	// kubeletVerification verifies the kubelet binary before it is installed. If nil, it is not verified
+	kubeletVerification *kubeletVerification
+	// certDir is the directory the kubelet keeps its certificates in
+	certDir string
+	// logDir is the directory the services write their logs to
+	logDir string
+	// pauseImage is the image of the pod infra container
+	pauseImage string
+	// recoveryActions are the actions taken when the kubelet or hybrid overlay node service fails
+	recoveryActions []RecoveryAction
+	// recoveryResetPeriod is the time in seconds without failures after which the failure count of the kubelet or
+	// hybrid overlay node service is reset
+	recoveryResetPeriod uint32
+	// newKubeClient is used to create Kubernetes clients
+	newKubeClient kubeClientFactory
 	// installDir is the directory the the kubelet service will be installed
 	installDir string
-	// kubeletArgs is a map of the variable arguments that will be passed to the kubelet
+	// kubeletArgs is a map of the variable arguments that will be passed to the kubelet. It is rebuilt from the
+	// ignition file and the configuration file whenever the kubelet is initialized.
 	kubeletArgs map[string]string
+	// extraKubeletArgs are the kubelet arguments from the configuration file, which override the ones in kubeletArgs
+	extraKubeletArgs map[string]string
+	// kubeletConfigOverrides is a JSON merge patch applied to the kubelet configuration after it is translated for
+	// Windows. If nil, the translated configuration is used as is.
+	kubeletConfigOverrides []byte
+	// nodeLabels are the labels the node registers with, in addition to the ones from the ignition file
+	nodeLabels []string
+	// nodeTaints are the taints the node registers with, in addition to the ones from the ignition file
+	nodeTaints []string
+	// cni configures CNI networking for the kubelet. If nil, the kubelet is run without a network plugin
+	cni *cniOptions
+	// hybridOverlay configures the hybrid overlay node service. If nil, the service is not run
+	hybridOverlay *hybridOverlayOptions
+	// proxyEnv are the proxy variables, as KEY=VALUE, from the ignition file, which the services are run with
+	proxyEnv []string
+	// trustStore is the store of the CA certificates the services trust, which the proxy CA certificates are added to
+	trustStore certificateStore
+	// fileMappings are the rules mapping files of the ignition file, other than the ones the kubelet needs, to where
+	// they are written
+	fileMappings []FileMappingRule
+	// logRotator configures the log rotator service. If nil, the service is not run and the logs are not rotated
+	logRotator *logRotatorOptions
+	// changes are the changes made by the last successful run
+	changes *RunChanges
+	// result is the report of the last run
+	result *BootstrapResult
 }
 
 // NewWinNodeBootstrapper takes the path to install the kubelet to, and paths to the ignition file and kubelet as inputs,
 // and generates the winNodeBootstrapper object
 func NewWinNodeBootstrapper(k8sInstallDir, ignitionFile, kubeletPath string) (*winNodeBootstrapper, error) {
-	svcMgr, err := mgr.Connect()
+	return newWinNodeBootstrapper(k8sInstallDir, ignitionFile, kubeletPath, connectServiceManager)
+}
+
+// newWinNodeBootstrapper generates the winNodeBootstrapper object, using connectSvcMgr to connect to the service API
+func newWinNodeBootstrapper(k8sInstallDir, ignitionFile, kubeletPath string,
+	connectSvcMgr serviceManagerConnector) (*winNodeBootstrapper, error) {
+	services, err := newServiceSet(connectSvcMgr, serviceWaitTime)
 	if err != nil {
-		return nil, fmt.Errorf("could not connect to Windows SCM: %s", err)
+		return nil, err
 	}
 	bootstrapper := winNodeBootstrapper{
-		kubeconfigPath:     filepath.Join(k8sInstallDir, "kubeconfig"),
-		kubeletConfPath:    filepath.Join(k8sInstallDir, "kubelet.conf"),
-		ignitionFilePath:   ignitionFile,
-		installDir:         k8sInstallDir,
-		initialKubeletPath: kubeletPath,
-		svcMgr:             svcMgr,
-		kubeletArgs:        make(map[string]string),
-	}
-	// If there is already a kubelet service running, find it
-	if ksvc, err := svcMgr.OpenService(KubeletServiceName); err == nil {
-		bootstrapper.kubeletSVC = ksvc
-	}
+		kubeconfigPath:      filepath.Join(k8sInstallDir, "kubeconfig"),
+		kubeletConfPath:     filepath.Join(k8sInstallDir, "kubelet.conf"),
+		ignitionFilePath:    ignitionFile,
+		installDir:          k8sInstallDir,
+		initialKubeletPath:  kubeletPath,
+		fs:                  hostFileSystem{},
+		services:            services,
+		certDir:             filepath.Join(k8sInstallDir, certDirName),
+		logDir:              k8sInstallDir,
+		pauseImage:          kubeletPauseContainerImage,
+		recoveryActions:     defaultRecoveryActions,
+		recoveryResetPeriod: defaultRecoveryResetPeriod,
+		newKubeClient:       newKubeClient,
+		kubeletArgs:         make(map[string]string),
+		nodeTaints:          []string{DefaultNodeTaint},
+		trustStore:          systemTrustStore(),
+	}
+	// If the services are already installed, find them
+	services.open(KubeletServiceName, HybridOverlayServiceName, LogRotatorServiceName)
+	// The kubelet is stopped before the hybrid overlay even if this run does not configure them
+	services.after[KubeletServiceName] = []string{HybridOverlayServiceName}
 	return &bootstrapper, nil
 }
 
+// SetIgnitionSource sets the source the ignition config is read from, in place of the ignition file
+func (wmcb *winNodeBootstrapper) SetIgnitionSource(source IgnitionSource) {
+	wmcb.ignitionSource = source
+}
+
 // translationFunc is a function that takes a byte array and changes it for use on windows
 type translationFunc func(*winNodeBootstrapper, []byte) ([]byte, error)
 
-// fileTranslation indicates where a file should be written and what should be done to the contents
-type fileTranslation struct {
-	dest string
-	translationFunc
-}
-
 // prepKubeletConfForWindows adds all Windows specific configuration options we need to the kubelet configuration
-// specifically, we change the cgroup driver, CA path, resolv.conf path, and enforce node allocatable.
+// specifically, we change the cgroup driver, CA path, resolv.conf path, and enforce node allocatable. The kubelet
+// configuration overrides are applied last.
 func prepKubeletConfForWindows(wmcb *winNodeBootstrapper, initialConfig []byte) ([]byte, error) {
 	var out []byte
 	// Here we parse the initial configuration, which was yaml, into a KubeletConfiguration struct
@@ -146,20 +211,28 @@ func prepKubeletConfForWindows(wmcb *winNodeBootstrapper, initialConfig []byte)
 	}
 
 	// replacing EnforceNodeAllocatable with an empty slice,
-	outString := strings.Replace(string(out), "\"THIS_MUST_BE_EMPTY\"", "", -1)
-	return []byte(outString), err
+	out = []byte(strings.Replace(string(out), "\"THIS_MUST_BE_EMPTY\"", "", -1))
+	if wmcb.kubeletConfigOverrides == nil {
+		return out, nil
+	}
+	if out, err = applyKubeletConfigOverrides(out, wmcb.kubeletConfigOverrides); err != nil {
+		return nil, err
+	}
+	log.Info("applied kubelet configuration overrides", "fields", overriddenFields(wmcb.kubeletConfigOverrides))
+	log.V(1).Info("kubelet configuration with overrides", "config", string(out))
+	return out, nil
 }
 
-// translateFile decodes an ignition "Storage.Files.Contents.Source" field and transforms it via the function provided.
-// if fileTranslateFn is nil, ignitionSource will be decoded, but not transformed
-func (wmcb *winNodeBootstrapper) translateFile(ignitionSource string, fileTranslateFn translationFunc) ([]byte, error) {
-	contents, err := dataurl.DecodeString(ignitionSource)
+// translateFile decodes the contents of an ignition file and transforms it via the function provided.
+// if fileTranslateFn is nil, the contents will be decoded, but not transformed
+func (wmcb *winNodeBootstrapper) translateFile(ignFile ignitionFile, fileTranslateFn translationFunc) ([]byte, error) {
+	contents, err := ignFile.contents()
 	if err != nil {
 		return []byte{}, err
 	}
-	newContents := contents.Data
+	newContents := contents
 	if fileTranslateFn != nil {
-		newContents, err = fileTranslateFn(wmcb, contents.Data)
+		newContents, err = fileTranslateFn(wmcb, contents)
 		if err != nil {
 			return []byte{}, err
 		}
@@ -167,275 +240,440 @@ func (wmcb *winNodeBootstrapper) translateFile(ignitionSource string, fileTransl
 	return newContents, err
 }
 
-// These regex are global, so that we only need to compile them once
-// cloudProviderRegex searches for the cloud provider option given to the kubelet
-var cloudProviderRegex = regexp.MustCompile(`--cloud-provider=(\w*)`)
-
-// verbosityRegex searches for the verbosity option given to the kubelet
-var verbosityRegex = regexp.MustCompile(`--v=(\w*)`)
-
-// parseIgnitionFile parses the ignition file and writes the contents of the described files
-// to the k8s installation directory
-func (wmcb *winNodeBootstrapper) parseIgnitionFile(ignitionFilePath string, filesToTranslate map[string]fileTranslation) error {
+// readIgnitionFile reads and parses the ignition file, whichever spec version it is
+func readIgnitionFile(ignitionFilePath string) (ignitionConfig, error) {
 	ignitionFileContents, err := ioutil.ReadFile(ignitionFilePath)
 	if err != nil {
-		return err
+		return ignitionConfig{}, err
 	}
-	// Parse configuration file
-	configuration, _, err := ignitionv2.Parse(ignitionFileContents)
+	return parseIgnition(ignitionFileContents)
+}
+
+// readIgnition reads and parses the ignition config from the ignition source, or the ignition file if there is no
+// source. The config parsed by Prepare is used instead, once. It returns nil if there is neither a source nor a file.
+func (wmcb *winNodeBootstrapper) readIgnition(ctx context.Context) (*ignitionConfig, error) {
+	if prepared := wmcb.prepared; prepared != nil {
+		wmcb.prepared = nil
+		return prepared, nil
+	}
+	source := wmcb.ignitionSource
+	if source == nil {
+		if wmcb.ignitionFilePath == "" {
+			return nil, nil
+		}
+		source = IgnitionFromFile(wmcb.ignitionFilePath)
+	}
+	contents, err := source.Ignition(ctx)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("could not read ignition config: %s", err)
+	}
+	cached, ok := source.(cachedIgnitionSource)
+	wmcb.ignitionFromCache = ok && cached.FromCache()
+	parsed, err := parseIgnition(contents)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse ignition file: %s", err)
 	}
+	return &parsed, nil
+}
 
-	// For each new file in the ignition file check if is a file we are interested in, if so, decode, transform,
-	// and write it to the destination path
-	for _, ignFile := range configuration.Storage.Files {
-		if filePair, ok := filesToTranslate[ignFile.Node.Path]; ok {
-			newContents, err := wmcb.translateFile(ignFile.Contents.Source, filePair.translationFunc)
+// translateIgnitionFiles writes the contents of the files described by the ignition configuration which the file
+// mapping rules apply to through the stage, takes the kubelet arguments and proxy settings from its kubelet unit,
+// pointing the kubelet at the cloud provider configuration, and installs its proxy CA certificates
+func (wmcb *winNodeBootstrapper) translateIgnitionFiles(configuration ignitionConfig, stage *fileStage) error {
+	// Find the kubelet systemd service specified in the ignition file and grab the variable arguments. This is done
+	// first, as the cloud provider configuration is translated for the cloud provider the kubelet is run with.
+	filesByPath := make(map[string]ignitionFile, len(configuration.files))
+	for _, ignFile := range configuration.files {
+		filesByPath[ignFile.path] = ignFile
+	}
+	for _, unit := range configuration.units {
+		if unit.name == kubeletSystemdName {
+			dropped, err := wmcb.kubeletArgsFromUnit(unit, filesByPath)
 			if err != nil {
-				return fmt.Errorf("could not process %s: %s", ignFile.Node.Path, err)
+				return fmt.Errorf("could not get kubelet arguments from %s: %s", kubeletSystemdName, err)
 			}
-			if err = ioutil.WriteFile(filePair.dest, newContents, 0644); err != nil {
-				return fmt.Errorf("could not write to %s: %s", filePair.dest, err)
+			if len(dropped) > 0 {
+				log.Info("ignoring kubelet flags not supported on Windows", "flags", dropped)
 			}
 		}
 	}
 
-	// Find the kubelet systemd service specified in the ignition file and grab the variable arguments
-	for _, unit := range configuration.Systemd.Units {
-		if unit.Name == kubeletSystemdName {
-			results := cloudProviderRegex.FindStringSubmatch(unit.Contents)
-			if len(results) == 2 {
-				wmcb.kubeletArgs["cloud-provider"] = results[1]
-			}
-			results = verbosityRegex.FindStringSubmatch(unit.Contents)
-			if len(results) == 2 {
-				wmcb.kubeletArgs["v"] = results[1]
-			}
+	// For each new file in the ignition file check if is a file we are interested in, if so, decode, transform,
+	// and write it to the destination path
+	written := make(map[string]string)
+	for _, ignFile := range configuration.files {
+		rule, dest, ok := wmcb.mapIgnitionFile(ignFile.path)
+		if !ok {
+			continue
+		}
+		if other, ok := written[dest]; ok {
+			return fmt.Errorf("%s and %s are both mapped to %s", other, ignFile.path, dest)
+		}
+		written[dest] = ignFile.path
+		if err := wmcb.fs.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return fmt.Errorf("could not make directory of %s: %s", dest, err)
+		}
+		newContents, err := wmcb.translateFile(ignFile, rule.translation())
+		if err != nil {
+			return fmt.Errorf("could not process %s: %s", ignFile.path, err)
+		}
+		if err = stage.writeFile(dest, newContents, 0644); err != nil {
+			return fmt.Errorf("could not write to %s: %s", dest, err)
 		}
 	}
-	return nil
+	wmcb.setCloudConfigArg(written)
+
+	proxyEnv, err := proxyFromIgnition(configuration, filesByPath)
+	if err != nil {
+		return fmt.Errorf("could not get proxy settings: %s", err)
+	}
+	if len(proxyEnv) > 0 {
+		log.Info("running services through the cluster-wide proxy", "environment", proxyEnv)
+	}
+	wmcb.proxyEnv = proxyEnv
+	return wmcb.installProxyCABundle(configuration, stage)
 }
 
-// Initializes the kubelet after copying the kubelet to the desired location
-func (wmcb *winNodeBootstrapper) initializeKubelet() error {
-	filesToTranslate := map[string]fileTranslation{
-		"/etc/kubernetes/kubelet.conf": {
-			dest:            wmcb.kubeletConfPath,
-			translationFunc: prepKubeletConfForWindows,
-		},
-		"/etc/kubernetes/kubeconfig": {
-			dest: filepath.Join(wmcb.installDir, "bootstrap-kubeconfig"),
-		},
-		"/etc/kubernetes/kubelet-ca.crt": {
-			dest: filepath.Join(wmcb.installDir, "kubelet-ca.crt"),
-		},
-	}
-	err := os.MkdirAll(wmcb.installDir, os.ModeDir)
+// initializeKubelet populates the install directory with the files the kubelet needs, translated from the ignition
+// configuration if it is not nil, writing the files through the stage
+func (wmcb *winNodeBootstrapper) initializeKubelet(configuration *ignitionConfig, stage *fileStage) error {
+	err := wmcb.fs.MkdirAll(wmcb.installDir, 0755)
 	if err != nil {
 		return fmt.Errorf("could not make install directory: %s", err)
 	}
+	if err = wmcb.fs.MkdirAll(wmcb.logDir, 0755); err != nil {
+		return fmt.Errorf("could not make log directory: %s", err)
+	}
+	// The kubelet arguments are rebuilt on every run, so that a flag removed from the ignition file is no longer given
+	// to the kubelet
+	wmcb.kubeletArgs = make(map[string]string)
+	// Populate destination directory with the files we need
+	if configuration != nil {
+		if err = wmcb.translateIgnitionFiles(*configuration, stage); err != nil {
+			return fmt.Errorf("could not translate ignition file: %s", err)
+		}
+	}
+	// Add the labels and taints the node registers with, and the arguments of the configuration file
+	wmcb.addRegistrationArgs()
+	for name, value := range wmcb.extraKubeletArgs {
+		wmcb.kubeletArgs[name] = value
+	}
+	if wmcb.cni != nil {
+		if err = wmcb.configureCNI(stage); err != nil {
+			return fmt.Errorf("could not configure CNI: %s", err)
+		}
+	}
+	return nil
+}
+
+// installBinaries copies the kubelet, once it is verified, the hybrid overlay node binary and the bootstrapper binary
+// the log rotator service runs to the install directory through the stage
+func (wmcb *winNodeBootstrapper) installBinaries(stage *fileStage) error {
 	if wmcb.initialKubeletPath != "" {
-		err = copyFile(wmcb.initialKubeletPath, filepath.Join(wmcb.installDir, "kubelet.exe"))
-		if err != nil {
+		if err := wmcb.installKubelet(stage); err != nil {
 			return fmt.Errorf("could not copy kubelet: %s", err)
 		}
 	}
-	// Populate destination directory with the files we need
-	if wmcb.ignitionFilePath != "" {
-		err = wmcb.parseIgnitionFile(wmcb.ignitionFilePath, filesToTranslate)
-		if err != nil {
-			return fmt.Errorf("could not parse ignition file: %s", err)
+	if wmcb.hybridOverlay != nil {
+		if err := wmcb.initializeHybridOverlay(stage); err != nil {
+			return err
 		}
 	}
+	if wmcb.logRotator != nil {
+		return wmcb.initializeLogRotator(stage)
+	}
 	return nil
 }
 
-// createKubeletService creates a new kubelet service to our specifications
-func (wmcb *winNodeBootstrapper) createKubeletService() error {
-	var err error
+// kubeletServiceSpec returns the spec of the kubelet service, run with the arguments found when the kubelet was
+// initialized
+func (wmcb *winNodeBootstrapper) kubeletServiceSpec() ServiceSpec {
 	kubeletArgs := []string{
 		"--config=" + wmcb.kubeletConfPath,
 		"--bootstrap-kubeconfig=" + filepath.Join(wmcb.installDir, "bootstrap-kubeconfig"),
 		"--kubeconfig=" + wmcb.kubeconfigPath,
-		"--pod-infra-container-image=" + kubeletPauseContainerImage,
-		"--cert-dir=" + certDirectory,
+		"--pod-infra-container-image=" + wmcb.pauseImage,
+		"--cert-dir=" + wmcb.certDir,
 		"--windows-service",
 		"--logtostderr=false",
-		"--log-file=" + filepath.Join(wmcb.installDir, "kubelet.log"),
-		// TODO: Uncomment this when we have a CNI solution
-		/*
-			network-plugin=cni",
-			cni-bin-dir=" + filepath.Join(k8sInstallDir, "cni"),
-			cni-conf-dir=" + filepath.Join(k8sInstallDir, "cni"),
-		*/
-	}
-	if cloudProvider, ok := wmcb.kubeletArgs["cloud-provider"]; ok {
-		kubeletArgs = append(kubeletArgs, "--cloud-provider="+cloudProvider)
-	}
-	if v, ok := wmcb.kubeletArgs["v"]; ok {
-		kubeletArgs = append(kubeletArgs, "--v="+v)
-	}
-	// Mostly default values here
-	c := mgr.Config{
-		ServiceType: 0,
-		// StartAutomatic will start the service again if the node restarts
-		StartType:    mgr.StartAutomatic,
-		ErrorControl: 0,
-		// Path to kubelet.exe
-		BinaryPathName:   filepath.Join(wmcb.installDir, "kubelet.exe"),
-		LoadOrderGroup:   "",
-		TagId:            0,
-		Dependencies:     nil,
-		ServiceStartName: "",
-		DisplayName:      "",
-		Password:         "",
-		Description:      "OpenShift Kubelet",
-	}
-	wmcb.kubeletSVC, err = wmcb.svcMgr.CreateService(KubeletServiceName, filepath.Join(wmcb.installDir, "kubelet.exe"), c, kubeletArgs...)
-	if err != nil {
+		"--log-file=" + filepath.Join(wmcb.logDir, "kubelet.log"),
+	}
+	if wmcb.cni != nil {
+		kubeletArgs = append(kubeletArgs, wmcb.cniKubeletArgs()...)
+	}
+	// Add the arguments found in the ignition file and the configuration file, sorted so that the service arguments
+	// are stable across runs
+	var names []string
+	for name := range wmcb.kubeletArgs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		kubeletArgs = append(kubeletArgs, "--"+name+"="+wmcb.kubeletArgs[name])
+	}
+	spec := ServiceSpec{
+		Name:                KubeletServiceName,
+		BinaryPath:          filepath.Join(wmcb.installDir, "kubelet.exe"),
+		Args:                kubeletArgs,
+		Description:         "OpenShift Kubelet",
+		StartType:           StartAutomatic,
+		RecoveryActions:     wmcb.recoveryActions,
+		RecoveryResetPeriod: wmcb.recoveryResetPeriod,
+	}
+	if wmcb.hybridOverlay != nil {
+		// The kubelet is started after the hybrid overlay, without depending on it so that it runs if the overlay fails
+		spec.After = []string{HybridOverlayServiceName}
+	}
+	return spec
+}
+
+// AddService adds a service, such as kube-proxy, which is run alongside the kubelet. Services are started after the
+// services they depend on, and a service with the name of an added service is replaced when the bootstrapper is run.
+func (wmcb *winNodeBootstrapper) AddService(spec ServiceSpec) error {
+	if err := spec.validate(); err != nil {
 		return err
 	}
-	err = wmcb.kubeletSVC.SetRecoveryActions([]mgr.RecoveryAction{
-		{Type: mgr.ServiceRestart, Delay: 5},
-	}, 600)
-	if err != nil {
-		return err
+	if spec.Name == KubeletServiceName || spec.Name == HybridOverlayServiceName || spec.Name == LogRotatorServiceName {
+		return fmt.Errorf("service %s is already managed by the bootstrapper", spec.Name)
 	}
+	for _, existing := range wmcb.extraServices {
+		if existing.Name == spec.Name {
+			return fmt.Errorf("service %s has already been added", spec.Name)
+		}
+	}
+	wmcb.extraServices = append(wmcb.extraServices, spec)
+	wmcb.services.open(spec.Name)
 	return nil
 }
 
-// startKubeletService starts the kubelet as a Windows service
-func (wmcb *winNodeBootstrapper) startKubeletService() error {
-	if wmcb.kubeletSVC == nil {
-		return fmt.Errorf("no kubelet service")
+// serviceSpecs returns the specs of all the services the bootstrapper runs. The services are run with the proxy
+// settings from the ignition file, unless an added service sets them itself.
+func (wmcb *winNodeBootstrapper) serviceSpecs() ([]ServiceSpec, error) {
+	specs := []ServiceSpec{wmcb.kubeletServiceSpec()}
+	if wmcb.hybridOverlay != nil {
+		spec, err := wmcb.hybridOverlayServiceSpec()
+		if err != nil {
+			return nil, err
+		}
+		specs = append(specs, spec)
 	}
-	err := wmcb.kubeletSVC.Start()
-	if err != nil {
-		return err
+	if wmcb.logRotator != nil {
+		specs = append(specs, wmcb.logRotatorServiceSpec())
 	}
-	return nil
-}
-
-// removeKubeletService deletes the kubelet service via the Windows service API
-func (wmcb *winNodeBootstrapper) removeKubeletService() error {
-	if wmcb.kubeletSVC == nil {
-		return nil
+	specs = append(specs, wmcb.extraServices...)
+	for i := range specs {
+		specs[i].Environment = withEnvironment(specs[i].Environment, wmcb.proxyEnv)
 	}
-	return wmcb.kubeletSVC.Delete()
+	return specs, nil
 }
 
-// controlService sends a signal to the service and waits until it changes state in response to the signal
-func (wmcb *winNodeBootstrapper) controlService(cmd svc.Cmd, desiredState svc.State) error {
-	status, err := wmcb.kubeletSVC.Control(cmd)
-	if err != nil {
-		return err
+// withEnvironment returns the environment variables, as KEY=VALUE, with the defaults added for the variables which
+// are not set, sorted by name
+func withEnvironment(env, defaults []string) []string {
+	if len(defaults) == 0 {
+		return env
 	}
-	// Most of the rest of the function borrowed from the package (golang.org/x/sys/windows/svc/mgr) example
-	// Arbitrary wait time
-	timeout := time.Now().Add(serviceWaitTime)
-	for status.State != desiredState {
-		if timeout.Before(time.Now()) {
-			return fmt.Errorf("timeout waiting for service to go to state=%d", desiredState)
-		}
-		time.Sleep(300 * time.Millisecond)
-		status, err = wmcb.kubeletSVC.Query()
-		if err != nil {
-			return fmt.Errorf("could not retrieve service status: %v", err)
+	set := make(map[string]bool)
+	merged := append([]string{}, env...)
+	for _, variable := range env {
+		set[strings.SplitN(variable, "=", 2)[0]] = true
+	}
+	for _, variable := range defaults {
+		if !set[strings.SplitN(variable, "=", 2)[0]] {
+			merged = append(merged, variable)
 		}
 	}
-	return nil
+	sort.Strings(merged)
+	return merged
 }
 
-// stopKubeletService stops the kubelet via the Windows service API
-func (wmcb *winNodeBootstrapper) stopKubeletService() error {
-	if wmcb.kubeletSVC == nil {
-		return nil
-	}
-	return wmcb.controlService(svc.Stop, svc.Stopped)
+// StopAndRemoveServices stops and removes the services managed by the bootstrapper, each before the services it
+// depends on, and waits until Windows has deleted them
+func (wmcb *winNodeBootstrapper) StopAndRemoveServices(ctx context.Context) error {
+	return wmcb.services.removeAll(ctx)
 }
 
-// TODO: Remove OVN service as well
-// StopAndRemoveServices stops and removes the kubelet service
-func (wmcb *winNodeBootstrapper) StopAndRemoveServices() error {
-	if wmcb.kubeletSVC == nil {
-		return nil
-	}
-	wmcb.stopKubeletService()
-	return wmcb.removeKubeletService()
+// RunChanges are the changes made to the node by a run of the bootstrapper
+type RunChanges struct {
+	// Files are the paths of the files which were written
+	Files []string `json:"files,omitempty"`
+	// Services are the names of the services which were created, reconfigured or removed
+	Services []string `json:"services,omitempty"`
+	// Started are the names of the services which were started or restarted
+	Started []string `json:"started,omitempty"`
+}
 
+// Changed returns true if the run changed anything
+func (c *RunChanges) Changed() bool {
+	return len(c.Files) > 0 || len(c.Services) > 0 || len(c.Started) > 0
 }
 
-// refreshServiceManager will disconnect and reconnect from the Windows service API. In order to complete certain
-// operations, there must be zero handlers to the API present on the system.
-func (wmcb *winNodeBootstrapper) refreshServiceManager() error {
-	var err error
-	if err = wmcb.Disconnect(); err != nil {
-		return err
-	}
-	// We need to give Windows time to clean up the services we've marked for deletion
-	time.Sleep(serviceWaitTime)
-	wmcb.svcMgr, err = mgr.Connect()
+// Run runs the bootstrapper. It sets up the install directory, then creates or updates the kubelet service and the
+// other configured services, and starts them, each after the services it depends on. Only the files and services which
+// differ from what is installed are changed, and a running service is only restarted if it or its files changed. If
+// any step fails, the files and services are restored to how they were before the run, and the error is a
+// *PhaseError giving the phase which failed. A report of the run is recorded in the install directory, returned by
+// Report, and reported by Status. The run fails, and is rolled back, if ctx is done before it completes.
+func (wmcb *winNodeBootstrapper) Run(ctx context.Context) error {
+	result := newBootstrapResult()
+	changes, err := wmcb.run(ctx, result)
+	result.finish(changes, err)
+	wmcb.changes = changes
+	wmcb.result = result
+	wmcb.recordBootstrapResult(result)
 	return err
 }
 
-// TODO: add OVN service start here as well
-// Run runs the bootstrapper. It sets up the install directory, creates the kubelet service, and then starts the kubelet service
-func (wmcb *winNodeBootstrapper) Run() error {
-	var err error
-	if wmcb.kubeletSVC != nil {
-		// if the kubelet service exists, we silently remove it and continue, to preserve idempotency
-		err = wmcb.StopAndRemoveServices()
-		if err != nil {
-			return err
+// Changes returns the changes made by the last successful Run, or nil if it has not succeeded
+func (wmcb *winNodeBootstrapper) Changes() *RunChanges {
+	return wmcb.changes
+}
+
+// Report returns the report of the last Run, or nil if it has not been run
+func (wmcb *winNodeBootstrapper) Report() *BootstrapResult {
+	return wmcb.result
+}
+
+// run sets up the install directory and runs the services, rolling back the changes if it fails. The phases are
+// recorded in the result.
+func (wmcb *winNodeBootstrapper) run(ctx context.Context, result *BootstrapResult) (*RunChanges, error) {
+	stage := newFileStage(wmcb.fs, wmcb.installDir)
+	snapshot, err := wmcb.services.snapshot()
+	if err != nil {
+		return nil, &PhaseError{Category: FailureServices, Err: err}
+	}
+	changes, err := wmcb.apply(ctx, stage, result)
+	if err != nil {
+		log.Info("rolling back failed run", "error", err.Error())
+		if rollbackErr := wmcb.rollback(stage, snapshot); rollbackErr != nil {
+			phase := ""
+			if phaseErr, ok := err.(*PhaseError); ok {
+				phase = phaseErr.Phase
+			}
+			return nil, &PhaseError{Phase: phase, Category: FailureRollback,
+				Err: fmt.Errorf("%s, and could not roll back: %s", err, rollbackErr)}
 		}
-		// We need to refresh the service to allow the service to be removed by Windows
-		err = wmcb.refreshServiceManager()
+		result.RolledBack = true
+		return nil, err
+	}
+	// The node is configured, so failing to keep the backups does not fail the run
+	if err = stage.commit(); err != nil {
+		log.Error(err, "could not keep the files replaced by the run as the previous generation")
+	}
+	return changes, nil
+}
+
+// apply writes the files to the install directory through the stage, and configures and starts the services, in
+// phases recorded in the result. It returns what was changed.
+func (wmcb *winNodeBootstrapper) apply(ctx context.Context, stage *fileStage, result *BootstrapResult) (*RunChanges,
+	error) {
+	var configuration *ignitionConfig
+	err := result.runPhase(PhaseIgnitionParse, FailureIgnition, func() error {
+		var err error
+		configuration, err = wmcb.readIgnition(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	result.IgnitionFromCache = wmcb.ignitionFromCache
+	err = result.runPhase(PhaseFileTranslation, FailureFiles, func() error {
+		return wmcb.initializeKubelet(configuration, stage)
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = result.runPhase(PhaseKubeletCopy, FailureKubelet, func() error { return wmcb.installBinaries(stage) })
+	result.Files = installedFiles(stage)
+	if err != nil {
+		return nil, err
+	}
+
+	// Existing services are reconfigured in place, which preserves idempotency without waiting on Windows to delete
+	// and recreate them
+	var ordered []ServiceSpec
+	var changedServices []string
+	err = result.runPhase(PhaseServiceCreate, FailureServices, func() error {
+		specs, err := wmcb.serviceSpecs()
 		if err != nil {
 			return err
 		}
-	}
-	err = wmcb.initializeKubelet()
-	if err != nil {
+		result.KubeletArgs = specs[0].Args
+		ordered, changedServices, err = wmcb.services.configure(ctx, specs, wmcb.servicesToRestart(stage), enabled)
 		return err
+	})
+	if err != nil {
+		return nil, err
 	}
-	err = wmcb.createKubeletService()
+	var started []string
+	err = result.runPhase(PhaseServiceStart, FailureServices, func() error {
+		var err error
+		started, err = wmcb.services.startServices(ctx, ordered, enabled)
+		return err
+	})
 	if err != nil {
+		return nil, err
+	}
+	return &RunChanges{Files: stage.changed(), Services: changedServices, Started: started}, nil
+}
+
+// servicesToRestart returns the names of the services which must be restarted to pick up the files changed by the
+// stage. The hybrid overlay node and log rotator services only use their binaries, every other file is used by the
+// kubelet.
+func (wmcb *winNodeBootstrapper) servicesToRestart(stage *fileStage) map[string]bool {
+	restart := make(map[string]bool)
+	for _, path := range stage.changed() {
+		switch path {
+		case filepath.Join(wmcb.installDir, hybridOverlayBinary):
+			restart[HybridOverlayServiceName] = true
+		case filepath.Join(wmcb.installDir, wmcbBinary):
+			restart[LogRotatorServiceName] = true
+		default:
+			restart[KubeletServiceName] = true
+		}
+	}
+	return restart
+}
+
+// rollback restores the files written by the stage and the services to the snapshot, recreating the services the
+// run removed. The services are stopped first, so that they are not running while their files are restored. It is not
+// cancelled along with the run, as the node would be left half configured, but each wait on a service is still bounded
+// by the service wait time.
+func (wmcb *winNodeBootstrapper) rollback(stage *fileStage, snapshot *serviceSnapshot) error {
+	ctx := context.Background()
+	if err := wmcb.services.stopAll(ctx); err != nil {
 		return err
 	}
-	err = wmcb.startKubeletService()
-	if err != nil {
+	if err := stage.rollback(); err != nil {
 		return err
 	}
-	return nil
+	return wmcb.services.restore(ctx, snapshot)
 }
 
 // Disconnect removes all connections to the Windows service manager api, and allows services to be deleted
 func (wmcb *winNodeBootstrapper) Disconnect() error {
-	if wmcb.kubeletSVC != nil {
-		err := wmcb.kubeletSVC.Close()
-		if err != nil {
-			return err
-		}
-	}
-	err := wmcb.svcMgr.Disconnect()
-	wmcb.svcMgr = nil
-	return err
+	return wmcb.services.close()
 }
 
-func copyFile(src, dest string) error {
-	from, err := os.Open(src)
+// copyFile copies src to dest on the filesystem, replacing dest, and flushes it to disk
+func copyFile(fs FileSystem, src, dest string) error {
+	from, err := fs.Open(src)
 	if err != nil {
 		return err
 	}
 	defer from.Close()
 
-	to, err := os.OpenFile(dest, os.O_RDWR|os.O_CREATE, 0666)
+	to, err := fs.Create(dest, 0666)
 	if err != nil {
 		return err
 	}
 	defer to.Close()
 
-	_, err = io.Copy(to, from)
-	return err
+	if _, err = io.Copy(to, from); err != nil {
+		return err
+	}
+	if err = to.Sync(); err != nil {
+		return err
+	}
+	return to.Close()
 }
@@ -1,9 +1,19 @@
 package bootstrapper
 
 import (
-	"github.com/stretchr/testify/assert"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vincent-petithory/dataurl"
 )
 
 // TestTranslateFile tests decoding and transforming ignition file sources
@@ -75,7 +85,7 @@ rqLuyNO+hCh/ZclPL+UiGJH1dlQ=
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			bs := winNodeBootstrapper{installDir: filepath.Base("tmp")}
-			got, err := bs.translateFile(tt.args.input, tt.args.lambda)
+			got, err := bs.translateFile(ignitionFile{source: tt.args.input}, tt.args.lambda)
 			assert.Nil(t, err)
 			assert.Equalf(t, tt.want, got, "got = %v, want %v", string(got), string(tt.want))
 		})
@@ -84,6 +94,9 @@ rqLuyNO+hCh/ZclPL+UiGJH1dlQ=
 
 // TestPrepKubeletConfForWindows tests that we are changing the kubelet configuration in a way that allows it to run on windows
 func TestPrepKubeletConfForWindows(t *testing.T) {
+	// The CA path is joined with the separator of the OS running the test
+	clientCAFile, err := json.Marshal(filepath.Join(`C:\k`, "kubelet-ca.crt"))
+	require.NoError(t, err)
 	type args struct {
 		in []byte
 	}
@@ -95,7 +108,7 @@ func TestPrepKubeletConfForWindows(t *testing.T) {
 		{
 			name: "Base case",
 			args: args{in: []byte(`{"kind":"KubeletConfiguration","apiVersion":"kubelet.config.k8s.io/v1beta1","staticPodPath":"/etc/kubernetes/manifests","syncFrequency":"0s","fileCheckFrequency":"0s","httpCheckFrequency":"0s","rotateCertificates":true,"serverTLSBootstrap":true,"authentication":{"x509":{"clientCAFile":"/etc/kubernetes/kubelet-ca.crt"},"webhook":{"cacheTTL":"0s"},"anonymous":{"enabled":false}},"authorization":{"webhook":{"cacheAuthorizedTTL":"0s","cacheUnauthorizedTTL":"0s"}},"clusterDomain":"cluster.local","clusterDNS":["172.30.0.10"],"streamingConnectionIdleTimeout":"0s","nodeStatusUpdateFrequency":"0s","nodeStatusReportFrequency":"0s","imageMinimumGCAge":"0s","volumeStatsAggPeriod":"0s","cgroupDriver":"systemd","cpuManagerReconcilePeriod":"0s","runtimeRequestTimeout":"10m0s","maxPods":250,"serializeImagePulls":false,"evictionPressureTransitionPeriod":"0s","featureGates":{"ExperimentalCriticalPodAnnotation":true,"LocalStorageCapacityIsolation":false,"RotateKubeletServerCertificate":true,"SupportPodPidsLimit":true},"containerLogMaxSize":"50Mi","systemReserved":{"cpu":"500m","memory":"500Mi"}}`)},
-			want: []byte(`{"kind":"KubeletConfiguration","apiVersion":"kubelet.config.k8s.io/v1beta1","staticPodPath":"/etc/kubernetes/manifests","syncFrequency":"0s","fileCheckFrequency":"0s","httpCheckFrequency":"0s","rotateCertificates":true,"serverTLSBootstrap":true,"authentication":{"x509":{"clientCAFile":"C:\\k\\kubelet-ca.crt"},"webhook":{"cacheTTL":"0s"},"anonymous":{"enabled":false}},"authorization":{"webhook":{"cacheAuthorizedTTL":"0s","cacheUnauthorizedTTL":"0s"}},"clusterDomain":"cluster.local","clusterDNS":["172.30.0.10"],"streamingConnectionIdleTimeout":"0s","nodeStatusUpdateFrequency":"0s","nodeStatusReportFrequency":"0s","imageMinimumGCAge":"0s","volumeStatsAggPeriod":"0s","cgroupsPerQOS":false,"cgroupDriver":"cgroupfs","cpuManagerReconcilePeriod":"0s","runtimeRequestTimeout":"10m0s","maxPods":250,"serializeImagePulls":false,"evictionPressureTransitionPeriod":"0s","featureGates":{"ExperimentalCriticalPodAnnotation":true,"LocalStorageCapacityIsolation":false,"RotateKubeletServerCertificate":true,"SupportPodPidsLimit":true},"containerLogMaxSize":"50Mi","systemReserved":{"cpu":"500m","memory":"500Mi"},"enforceNodeAllocatable":[]}`),
+			want: []byte(`{"kind":"KubeletConfiguration","apiVersion":"kubelet.config.k8s.io/v1beta1","staticPodPath":"/etc/kubernetes/manifests","syncFrequency":"0s","fileCheckFrequency":"0s","httpCheckFrequency":"0s","rotateCertificates":true,"serverTLSBootstrap":true,"authentication":{"x509":{"clientCAFile":` + string(clientCAFile) + `},"webhook":{"cacheTTL":"0s"},"anonymous":{"enabled":false}},"authorization":{"webhook":{"cacheAuthorizedTTL":"0s","cacheUnauthorizedTTL":"0s"}},"clusterDomain":"cluster.local","clusterDNS":["172.30.0.10"],"streamingConnectionIdleTimeout":"0s","nodeStatusUpdateFrequency":"0s","nodeStatusReportFrequency":"0s","imageMinimumGCAge":"0s","volumeStatsAggPeriod":"0s","cgroupsPerQOS":false,"cgroupDriver":"cgroupfs","cpuManagerReconcilePeriod":"0s","runtimeRequestTimeout":"10m0s","maxPods":250,"serializeImagePulls":false,"evictionPressureTransitionPeriod":"0s","featureGates":{"ExperimentalCriticalPodAnnotation":true,"LocalStorageCapacityIsolation":false,"RotateKubeletServerCertificate":true,"SupportPodPidsLimit":true},"containerLogMaxSize":"50Mi","systemReserved":{"cpu":"500m","memory":"500Mi"},"enforceNodeAllocatable":[]}`),
 		},
 	}
 	for _, tt := range tests {
@@ -107,3 +120,317 @@ func TestPrepKubeletConfForWindows(t *testing.T) {
 		})
 	}
 }
+
+// testKubeletConf is a minimal kubelet configuration, as served in the worker ignition file
+const testKubeletConf = `{"kind":"KubeletConfiguration","apiVersion":"kubelet.config.k8s.io/v1beta1","cgroupDriver":"systemd","authentication":{"x509":{"clientCAFile":"/etc/kubernetes/kubelet-ca.crt"}}}`
+
+// testKubeletUnit is a kubelet systemd unit, as served in the worker ignition file
+const testKubeletUnit = `[Unit]
+Description=Kubernetes Kubelet
+
+[Service]
+ExecStart=/usr/bin/hyperkube kubelet --config=/etc/kubernetes/kubelet.conf --cloud-provider=aws --v=3
+
+[Install]
+WantedBy=multi-user.target
+`
+
+// testIgnitionFiles are the files, in the worker ignition file, that the bootstrapper consumes
+var testIgnitionFiles = map[string]string{
+	"/etc/kubernetes/kubelet.conf":   testKubeletConf,
+	"/etc/kubernetes/kubeconfig":     "apiVersion: v1\nkind: Config\n",
+	"/etc/kubernetes/kubelet-ca.crt": "-----BEGIN CERTIFICATE-----\n-----END CERTIFICATE-----\n",
+}
+
+// testIgnitionConfig returns an ignition config of the given spec version containing the testIgnitionFiles and the
+// kubelet unit
+func testIgnitionConfig(version string) string {
+	var ignFiles []string
+	for path, contents := range testIgnitionFiles {
+		source := dataurl.EncodeBytes([]byte(contents))
+		if strings.HasPrefix(version, "2.") {
+			ignFiles = append(ignFiles, fmt.Sprintf(`{"filesystem":"root","path":%q,"contents":{"source":%q},"mode":420}`,
+				path, source))
+		} else {
+			ignFiles = append(ignFiles, fmt.Sprintf(`{"path":%q,"contents":{"source":%q},"mode":420}`, path, source))
+		}
+	}
+	return fmt.Sprintf(`{"ignition":{"version":%q},"storage":{"files":[%s]},"systemd":{"units":[{"name":"kubelet.service","enabled":true,"contents":%q}]}}`,
+		version, strings.Join(ignFiles, ","), testKubeletUnit)
+}
+
+// writeTestIgnitionFile writes a spec 2.2 ignition file containing the files and units the bootstrapper consumes
+// to dir, and returns its path
+func writeTestIgnitionFile(t *testing.T, dir string) string {
+	path := filepath.Join(dir, "worker.ign")
+	require.NoError(t, ioutil.WriteFile(path, []byte(testIgnitionConfig("2.2.0")), 0644))
+	return path
+}
+
+// newTestBootstrapper returns a bootstrapper backed by the given fake SCM, which installs to a temporary directory,
+// and looks for the kubelet certificates in it
+func newTestBootstrapper(t *testing.T, scm *fakeSCM) *winNodeBootstrapper {
+	dir, err := ioutil.TempDir("", "wmcb")
+	require.NoError(t, err)
+	kubeletPath := filepath.Join(dir, "kubelet-download.exe")
+	require.NoError(t, ioutil.WriteFile(kubeletPath, []byte("kubelet binary"), 0755))
+	wmcb, err := newWinNodeBootstrapper(filepath.Join(dir, "k"), writeTestIgnitionFile(t, dir), kubeletPath,
+		scm.connect)
+	require.NoError(t, err)
+	wmcb.services.waitTime = 10 * time.Millisecond
+	wmcb.certDir = filepath.Join(dir, "pki")
+	return wmcb
+}
+
+// assertKubeletServiceRunning checks that the kubelet service is installed as the bootstrapper configures it
+func assertKubeletServiceRunning(t *testing.T, scm *fakeSCM, installDir string) {
+	kubelet := scm.get(KubeletServiceName)
+	require.NotNil(t, kubelet, "kubelet service is not installed")
+	assert.Equal(t, ServiceRunning, kubelet.state)
+	assert.False(t, kubelet.deletePending)
+	assert.Equal(t, StartAutomatic, kubelet.config.StartType)
+	assert.Equal(t, filepath.Join(installDir, "kubelet.exe"), kubelet.exePath())
+	assert.Contains(t, kubelet.args, "--cloud-provider=aws")
+	assert.Contains(t, kubelet.args, "--v=3")
+	assert.Contains(t, kubelet.args, "--register-with-taints="+DefaultNodeTaint)
+	assert.Contains(t, kubelet.args, "--config="+filepath.Join(installDir, "kubelet.conf"))
+	assert.Equal(t, []RecoveryAction{{Type: ServiceRestart, Delay: 5 * time.Second}}, kubelet.recoveryActions)
+	for _, file := range []string{"kubelet.exe", "kubelet.conf", "bootstrap-kubeconfig", "kubelet-ca.crt"} {
+		assert.FileExists(t, filepath.Join(installDir, file))
+	}
+}
+
+// TestRun tests that Run installs and starts the kubelet service on a node without one
+func TestRun(t *testing.T) {
+	scm := newFakeSCM()
+	wmcb := newTestBootstrapper(t, scm)
+	defer os.RemoveAll(filepath.Dir(wmcb.installDir))
+
+	require.NoError(t, wmcb.Run(context.Background()))
+	require.NoError(t, wmcb.Disconnect())
+	assertKubeletServiceRunning(t, scm, wmcb.installDir)
+	kubelet := scm.get(KubeletServiceName)
+	assert.Equal(t, 0, kubelet.openHandles, "handles to the kubelet service were leaked")
+}
+
+// TestRunIgnitionV3 tests that Run bootstraps the node from a spec 3.x ignition file
+func TestRunIgnitionV3(t *testing.T) {
+	scm := newFakeSCM()
+	wmcb := newTestBootstrapper(t, scm)
+	defer os.RemoveAll(filepath.Dir(wmcb.installDir))
+	require.NoError(t, ioutil.WriteFile(wmcb.ignitionFilePath, []byte(testIgnitionConfig("3.1.0")), 0644))
+
+	require.NoError(t, wmcb.Run(context.Background()))
+	require.NoError(t, wmcb.Disconnect())
+	assertKubeletServiceRunning(t, scm, wmcb.installDir)
+	caContents, err := ioutil.ReadFile(filepath.Join(wmcb.installDir, "kubelet-ca.crt"))
+	require.NoError(t, err)
+	assert.Equal(t, testIgnitionFiles["/etc/kubernetes/kubelet-ca.crt"], string(caContents))
+}
+
+// TestRunIdempotent tests that Run reconfigures an existing kubelet service, and can be run repeatedly
+func TestRunIdempotent(t *testing.T) {
+	scm := newFakeSCM()
+	scm.install(KubeletServiceName, ServiceConfig{StartType: StartManual, BinaryPathName: "C:\\old\\kubelet.exe"},
+		ServiceRunning, "--v=10")
+	wmcb := newTestBootstrapper(t, scm)
+	defer os.RemoveAll(filepath.Dir(wmcb.installDir))
+
+	require.NoError(t, wmcb.Run(context.Background()))
+	require.NoError(t, wmcb.Disconnect())
+	assertKubeletServiceRunning(t, scm, wmcb.installDir)
+	assert.NotContains(t, scm.get(KubeletServiceName).args, "--v=10")
+
+	// Run it again with a new bootstrapper, as the e2e test does, to ensure it maintains state
+	wmcb, err := newWinNodeBootstrapper(wmcb.installDir, wmcb.ignitionFilePath, wmcb.initialKubeletPath, scm.connect)
+	require.NoError(t, err)
+	wmcb.services.waitTime = 10 * time.Millisecond
+	require.NoError(t, wmcb.Run(context.Background()))
+	require.NoError(t, wmcb.Disconnect())
+	assertKubeletServiceRunning(t, scm, wmcb.installDir)
+	assert.Equal(t, 0, scm.get(KubeletServiceName).openHandles, "handles to the kubelet service were leaked")
+}
+
+// TestStopAndRemoveServices tests that the kubelet service is stopped, and has been deleted once the bootstrapper has
+// removed it
+func TestStopAndRemoveServices(t *testing.T) {
+	scm := newFakeSCM()
+	scm.install(KubeletServiceName, ServiceConfig{StartType: StartAutomatic}, ServiceRunning)
+	wmcb, err := newWinNodeBootstrapper("C:\\k", "", "", scm.connect)
+	require.NoError(t, err)
+	wmcb.services.waitTime = 10 * time.Millisecond
+
+	require.NoError(t, wmcb.StopAndRemoveServices(context.Background()))
+	assert.Nil(t, scm.get(KubeletServiceName))
+	require.NoError(t, wmcb.Disconnect())
+}
+
+// TestRunAddedService tests that added services are run alongside the kubelet, and services managed by the
+// bootstrapper cannot be added
+func TestRunAddedService(t *testing.T) {
+	scm := newFakeSCM()
+	wmcb := newTestBootstrapper(t, scm)
+	defer os.RemoveAll(filepath.Dir(wmcb.installDir))
+
+	require.NoError(t, wmcb.AddService(testServiceSpec("kube-proxy", KubeletServiceName)))
+	assert.Error(t, wmcb.AddService(testServiceSpec("kube-proxy")), "services cannot be added twice")
+	assert.Error(t, wmcb.AddService(testServiceSpec(KubeletServiceName)))
+	assert.Error(t, wmcb.AddService(ServiceSpec{Name: "exporter"}))
+	require.NoError(t, wmcb.Run(context.Background()))
+	require.NoError(t, wmcb.Disconnect())
+
+	assertKubeletServiceRunning(t, scm, wmcb.installDir)
+	kubeProxy := scm.get("kube-proxy")
+	require.NotNil(t, kubeProxy, "kube-proxy service is not installed")
+	assert.Equal(t, ServiceRunning, kubeProxy.state)
+	assert.Equal(t, []string{KubeletServiceName, "kube-proxy"}, scm.started)
+}
+
+// TestRunRollback tests that the files and services are restored if Run fails, and that the backups of the last
+// successful run are kept
+func TestRunRollback(t *testing.T) {
+	scm := newFakeSCM()
+	wmcb := newTestBootstrapper(t, scm)
+	defer os.RemoveAll(filepath.Dir(wmcb.installDir))
+	require.NoError(t, wmcb.Run(context.Background()))
+	require.NoError(t, wmcb.Disconnect())
+
+	// Run again with an updated kubelet, which backs up the previous one
+	wmcb, err := newWinNodeBootstrapper(wmcb.installDir, wmcb.ignitionFilePath, wmcb.initialKubeletPath, scm.connect)
+	require.NoError(t, err)
+	wmcb.services.waitTime = 10 * time.Millisecond
+	require.NoError(t, ioutil.WriteFile(wmcb.initialKubeletPath, []byte("updated kubelet binary"), 0755))
+	require.NoError(t, wmcb.Run(context.Background()))
+	require.NoError(t, wmcb.Disconnect())
+	assertFileContents(t, filepath.Join(wmcb.installDir, backupDirName, "kubelet.exe"), "kubelet binary")
+	kubeletArgs := scm.get(KubeletServiceName).args
+	kubeletDigest, err := ioutil.ReadFile(filepath.Join(wmcb.installDir, kubeletDigestFile))
+	require.NoError(t, err)
+
+	// Run again with a new kubelet and node labels, along with a service which cannot be started
+	wmcb, err = newWinNodeBootstrapper(wmcb.installDir, wmcb.ignitionFilePath, wmcb.initialKubeletPath, scm.connect)
+	require.NoError(t, err)
+	wmcb.services.waitTime = 10 * time.Millisecond
+	require.NoError(t, ioutil.WriteFile(wmcb.initialKubeletPath, []byte("new kubelet binary"), 0755))
+	require.NoError(t, wmcb.SetNodeLabels([]string{"example.com/pool=windows"}))
+	require.NoError(t, wmcb.AddService(testServiceSpec("kube-proxy", "missing-service")))
+	assert.Error(t, wmcb.Run(context.Background()))
+	require.NoError(t, wmcb.Disconnect())
+
+	assertKubeletServiceRunning(t, scm, wmcb.installDir)
+	assert.Equal(t, kubeletArgs, scm.get(KubeletServiceName).args)
+	assert.Nil(t, scm.get("kube-proxy"), "service created by the failed run was not removed")
+	assertFileContents(t, filepath.Join(wmcb.installDir, "kubelet.exe"), "updated kubelet binary")
+	assertFileContents(t, filepath.Join(wmcb.installDir, kubeletDigestFile), string(kubeletDigest))
+	assertFileContents(t, filepath.Join(wmcb.installDir, backupDirName, "kubelet.exe"), "kubelet binary")
+	_, err = os.Stat(filepath.Join(wmcb.installDir, nextBackupDirName))
+	assert.True(t, os.IsNotExist(err), "backup directory of the failed run was not removed")
+}
+
+// TestRunRollbackRemovedService tests that a service removed by a run which fails is installed and running again once
+// the run is rolled back
+func TestRunRollbackRemovedService(t *testing.T) {
+	scm := newFakeSCM()
+	wmcb := newTestHybridOverlayBootstrapper(t, scm)
+	defer os.RemoveAll(filepath.Dir(wmcb.installDir))
+	require.NoError(t, wmcb.Run(context.Background()))
+	require.NoError(t, wmcb.Disconnect())
+	hybridOverlayArgs := scm.get(HybridOverlayServiceName).args
+
+	// Run again without the hybrid overlay, along with a service which cannot be started
+	wmcb, err := newWinNodeBootstrapper(wmcb.installDir, wmcb.ignitionFilePath, wmcb.initialKubeletPath, scm.connect)
+	require.NoError(t, err)
+	wmcb.services.waitTime = 10 * time.Millisecond
+	wmcb.certDir = filepath.Join(filepath.Dir(wmcb.installDir), "pki")
+	require.NoError(t, wmcb.AddService(testServiceSpec("kube-proxy", "missing-service")))
+	err = wmcb.Run(context.Background())
+	require.Error(t, err)
+	assert.Equal(t, FailureServices, FailureCategoryOf(err))
+	require.NoError(t, wmcb.Disconnect())
+	assert.True(t, wmcb.Report().RolledBack)
+
+	assertKubeletServiceRunning(t, scm, wmcb.installDir)
+	hybridOverlay := scm.get(HybridOverlayServiceName)
+	require.NotNil(t, hybridOverlay, "service removed by the failed run was not restored")
+	assert.False(t, hybridOverlay.deletePending)
+	assert.Equal(t, ServiceRunning, hybridOverlay.state)
+	assert.Equal(t, hybridOverlayArgs, hybridOverlay.args)
+	assert.Nil(t, scm.get("kube-proxy"), "service created by the failed run was not removed")
+}
+
+// TestRunCancelled tests that a run whose context is done fails before it changes the services, and is rolled back
+func TestRunCancelled(t *testing.T) {
+	scm := newFakeSCM()
+	wmcb := newTestBootstrapper(t, scm)
+	defer os.RemoveAll(filepath.Dir(wmcb.installDir))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := wmcb.Run(ctx)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "context canceled")
+	assert.Equal(t, FailureServices, FailureCategoryOf(err))
+	require.NoError(t, wmcb.Disconnect())
+	assert.True(t, wmcb.Report().RolledBack)
+	assert.Nil(t, scm.get(KubeletServiceName), "kubelet service should not be installed")
+	_, err = os.Stat(filepath.Join(wmcb.installDir, "kubelet.exe"))
+	assert.True(t, os.IsNotExist(err), "kubelet should not be installed")
+}
+
+// TestRunUnchanged tests that running the bootstrapper again leaves the node untouched if nothing changed, and only
+// restarts the kubelet if it or its files changed
+func TestRunUnchanged(t *testing.T) {
+	scm := newFakeSCM()
+	wmcb := newTestHybridOverlayBootstrapper(t, scm)
+	defer os.RemoveAll(filepath.Dir(wmcb.installDir))
+	require.NoError(t, wmcb.Run(context.Background()))
+	require.NoError(t, wmcb.Disconnect())
+	assert.True(t, wmcb.Changes().Changed())
+	assert.Equal(t, []string{HybridOverlayServiceName, KubeletServiceName}, wmcb.Changes().Started)
+	hybridOverlayPath := wmcb.hybridOverlay.binaryPath
+
+	tests := []struct {
+		name        string
+		nodeLabels  []string
+		kubelet     string
+		wantFiles   []string
+		wantStarted []string
+	}{
+		{name: "Unchanged"},
+		{name: "Changed node labels", nodeLabels: []string{"example.com/pool=windows"},
+			wantStarted: []string{KubeletServiceName}},
+		{name: "Changed kubelet binary", nodeLabels: []string{"example.com/pool=windows"},
+			kubelet:     "new kubelet binary",
+			wantFiles:   []string{"kubelet.exe", kubeletDigestFile},
+			wantStarted: []string{KubeletServiceName}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.kubelet != "" {
+				require.NoError(t, ioutil.WriteFile(wmcb.initialKubeletPath, []byte(tt.kubelet), 0755))
+			}
+			wmcb, err := newWinNodeBootstrapper(wmcb.installDir, wmcb.ignitionFilePath, wmcb.initialKubeletPath,
+				scm.connect)
+			require.NoError(t, err)
+			wmcb.services.waitTime = 10 * time.Millisecond
+			wmcb.certDir = filepath.Join(filepath.Dir(wmcb.installDir), "pki")
+			require.NoError(t, wmcb.SetHybridOverlayOptions(hybridOverlayPath, "10.132.0.0/14", 0))
+			require.NoError(t, wmcb.SetNodeLabels(tt.nodeLabels))
+			scm.started = nil
+			require.NoError(t, wmcb.Run(context.Background()))
+			require.NoError(t, wmcb.Disconnect())
+
+			changes := wmcb.Changes()
+			var wantFiles []string
+			for _, file := range tt.wantFiles {
+				wantFiles = append(wantFiles, filepath.Join(wmcb.installDir, file))
+			}
+			assert.Equal(t, wantFiles, changes.Files)
+			assert.Equal(t, tt.wantStarted, changes.Started)
+			assert.Equal(t, tt.wantStarted != nil, changes.Changed())
+			assert.Equal(t, tt.wantStarted, scm.started)
+			assert.Equal(t, ServiceRunning, scm.get(HybridOverlayServiceName).state)
+			assertKubeletServiceRunning(t, scm, wmcb.installDir)
+		})
+	}
+}
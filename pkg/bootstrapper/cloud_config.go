@@ -0,0 +1,127 @@
+package bootstrapper
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// cloudConfigIgnitionPath is the path of the cloud provider configuration in the ignition file
+	cloudConfigIgnitionPath = "/etc/kubernetes/cloud.conf"
+	// cloudConfigFile is the name the cloud provider configuration is installed under
+	cloudConfigFile = "cloud.conf"
+	// TranslationCloudConfig adjusts the cloud provider configuration of the cloud provider the kubelet is run with
+	// for Windows, rewriting the paths of the files it refers to, to where the file mapping rules write them
+	TranslationCloudConfig = "cloud-config"
+)
+
+// iniCloudConfigPathKeys are the keys of the INI cloud provider configurations, such as the vSphere and OpenStack
+// ones, whose values are paths of files on the node
+var iniCloudConfigPathKeys = map[string]bool{"ca-file": true}
+
+// azureCloudConfigPathKeys are the keys of the Azure cloud provider configuration whose values are paths of files on
+// the node
+var azureCloudConfigPathKeys = []string{"aadClientCertPath"}
+
+// translateCloudConfig adjusts the cloud provider configuration for the cloud provider the kubelet is run with. The
+// configuration is left unchanged if the kubelet is not run with an in-tree cloud provider.
+func translateCloudConfig(wmcb *winNodeBootstrapper, contents []byte) ([]byte, error) {
+	switch provider := wmcb.kubeletArgs["cloud-provider"]; provider {
+	case "", "external":
+		return contents, nil
+	case "azure":
+		return wmcb.translateAzureCloudConfig(contents)
+	default:
+		return wmcb.translateINICloudConfig(contents), nil
+	}
+}
+
+// translateAzureCloudConfig makes the kubelet find the node through the Azure instance metadata service, which is how
+// Windows nodes get their addresses, and rewrites the paths of the files the JSON configuration refers to
+func (wmcb *winNodeBootstrapper) translateAzureCloudConfig(contents []byte) ([]byte, error) {
+	var config map[string]interface{}
+	// Numbers are kept as they are written, rather than converted to floats
+	decoder := json.NewDecoder(bytes.NewReader(contents))
+	decoder.UseNumber()
+	if err := decoder.Decode(&config); err != nil || config == nil {
+		return nil, fmt.Errorf("azure cloud provider configuration must be a JSON object")
+	}
+	config["useInstanceMetadata"] = true
+	for _, key := range azureCloudConfigPathKeys {
+		if path, ok := config[key].(string); ok && path != "" {
+			config[key] = wmcb.cloudConfigPath(key, path)
+		}
+	}
+	out, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
+
+// translateINICloudConfig rewrites the paths of the files the INI configuration refers to, keeping the rest of it as
+// it is
+func (wmcb *winNodeBootstrapper) translateINICloudConfig(contents []byte) []byte {
+	lines := strings.Split(string(contents), "\n")
+	for i, line := range lines {
+		parts := strings.SplitN(line, "=", 2)
+		key := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || !iniCloudConfigPathKeys[strings.ToLower(key)] {
+			continue
+		}
+		path := strings.TrimSpace(parts[1])
+		if unquoted, err := strconv.Unquote(path); err == nil {
+			path = unquoted
+		}
+		// Backslashes in Windows paths have to be escaped in a quoted value
+		indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		lines[i] = indent + key + " = " + strconv.Quote(wmcb.cloudConfigPath(key, path))
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
+
+// cloudConfigPath returns where the file mapping rules write the file at path, which the cloud provider configuration
+// refers to under key. A file which is not mapped keeps its path.
+func (wmcb *winNodeBootstrapper) cloudConfigPath(key, path string) string {
+	_, dest, ok := wmcb.mapIgnitionFile(path)
+	if !ok {
+		log.Info("cloud provider configuration refers to a file which is not written on Windows", "key", key,
+			"path", path)
+		return path
+	}
+	return dest
+}
+
+// translateCloudConfigArg points the kubelet at where the cloud provider configuration it is given is written
+func translateCloudConfigArg(wmcb *winNodeBootstrapper, value string) (string, error) {
+	_, dest, ok := wmcb.mapIgnitionFile(value)
+	if !ok {
+		return "", nil
+	}
+	return dest, nil
+}
+
+// setCloudConfigArg points the kubelet at the cloud provider configuration, if it was written. written are the ignition
+// files written, by their destination.
+func (wmcb *winNodeBootstrapper) setCloudConfigArg(written map[string]string) {
+	path, ok := wmcb.kubeletArgs["cloud-config"]
+	if ok {
+		if _, ok = written[path]; !ok {
+			log.Info("ignoring kubelet flag as the cloud provider configuration is not in the ignition file",
+				"flag", "cloud-config", "path", path)
+			delete(wmcb.kubeletArgs, "cloud-config")
+		}
+		return
+	}
+	if wmcb.kubeletArgs["cloud-provider"] == "" {
+		return
+	}
+	if _, dest, ok := wmcb.mapIgnitionFile(cloudConfigIgnitionPath); ok {
+		if _, ok = written[dest]; ok {
+			wmcb.kubeletArgs["cloud-config"] = dest
+		}
+	}
+}
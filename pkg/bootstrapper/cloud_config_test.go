@@ -0,0 +1,116 @@
+package bootstrapper
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readCloudConfigFixture returns the contents of the cloud provider configuration fixture with the given name, with
+// {{installDir}} replaced by installDir
+func readCloudConfigFixture(t *testing.T, name, installDir string) string {
+	contents, err := ioutil.ReadFile(filepath.Join("testdata", "cloud-config", name))
+	require.NoError(t, err)
+	return strings.Replace(string(contents), "{{installDir}}", installDir, -1)
+}
+
+// TestRunCloudConfig tests that Run writes the cloud provider configuration from the ignition file, adjusted for the
+// cloud provider, and runs the kubelet with it
+func TestRunCloudConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		// provider is the cloud provider the kubelet unit runs the kubelet with
+		provider string
+		// cloudConfigFlag is true if the kubelet unit gives the kubelet --cloud-config
+		cloudConfigFlag bool
+		// fixture is the cloud provider configuration in the ignition file, it has none if empty
+		fixture string
+		// files are other files in the ignition file
+		files map[string]string
+		// rules are the file mapping rules
+		rules []FileMappingRule
+		// want is the fixture the written configuration must match, it must not be written if empty
+		want string
+	}{
+		{name: "AWS", provider: "aws", fixture: "aws.conf", want: "aws.windows.conf"},
+		{name: "Azure", provider: "azure", cloudConfigFlag: true, fixture: "azure.conf",
+			files: map[string]string{"/etc/kubernetes/azure-client.pfx": "client certificate"},
+			rules: []FileMappingRule{{Source: "/etc/kubernetes/azure-client.pfx", Destination: "azure-client.pfx"}},
+			want:  "azure.windows.conf"},
+		{name: "vSphere", provider: "vsphere", cloudConfigFlag: true, fixture: "vsphere.conf",
+			files: map[string]string{"/etc/kubernetes/vsphere-ca.crt": "vCenter CA"},
+			rules: []FileMappingRule{{Source: "/etc/kubernetes/*-ca.crt", Destination: "."}},
+			want:  "vsphere.windows.conf"},
+		{name: "OpenStack with unmapped CA", provider: "openstack", cloudConfigFlag: true, fixture: "openstack.conf",
+			want: "openstack.windows.conf"},
+		{name: "External", provider: "external", cloudConfigFlag: true, fixture: "vsphere.conf",
+			want: "vsphere.conf"},
+		{name: "Flag without configuration", provider: "azure", cloudConfigFlag: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scm := newFakeSCM()
+			wmcb := newTestBootstrapper(t, scm)
+			testDir := filepath.Dir(wmcb.installDir)
+			defer os.RemoveAll(testDir)
+			files := map[string]string{}
+			for path, contents := range tt.files {
+				files[path] = contents
+			}
+			if tt.fixture != "" {
+				files[cloudConfigIgnitionPath] = readCloudConfigFixture(t, tt.fixture, "")
+			}
+			args := "--cloud-provider=" + tt.provider
+			if tt.cloudConfigFlag {
+				args += " --cloud-config=" + cloudConfigIgnitionPath
+			}
+			wmcb.ignitionFilePath = writeCustomIgnitionFile(t, testDir, files, map[string]string{
+				"20-cloud-provider.conf": fmt.Sprintf("[Service]\nExecStart=\nExecStart=/usr/bin/hyperkube kubelet "+
+					"--config=/etc/kubernetes/kubelet.conf %s --v=3\n", args),
+			})
+			wmcb.fileMappings = tt.rules
+
+			require.NoError(t, wmcb.Run(context.Background()))
+			require.NoError(t, wmcb.Disconnect())
+			kubelet := scm.get(KubeletServiceName)
+			require.NotNil(t, kubelet, "kubelet service is not installed")
+			assert.Equal(t, ServiceRunning, kubelet.state)
+			assert.Contains(t, kubelet.args, "--cloud-provider="+tt.provider)
+			cloudConfigPath := filepath.Join(wmcb.installDir, cloudConfigFile)
+			contents, err := ioutil.ReadFile(cloudConfigPath)
+			if tt.want == "" {
+				assert.True(t, os.IsNotExist(err), "cloud provider configuration should not be written")
+				for _, arg := range kubelet.args {
+					assert.False(t, strings.HasPrefix(arg, "--cloud-config"), "unexpected kubelet flag %s", arg)
+				}
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, readCloudConfigFixture(t, tt.want, wmcb.installDir), string(contents))
+			assert.Contains(t, kubelet.args, "--cloud-config="+cloudConfigPath)
+		})
+	}
+}
+
+// TestTranslateCloudConfig tests that a cloud provider configuration which is invalid for its cloud provider is
+// rejected
+func TestTranslateCloudConfig(t *testing.T) {
+	wmcb := &winNodeBootstrapper{installDir: os.TempDir(), kubeletArgs: map[string]string{"cloud-provider": "azure"}}
+	_, err := translateCloudConfig(wmcb, []byte("[Global]\nZone = centralus\n"))
+	assert.Error(t, err)
+	_, err = translateCloudConfig(wmcb, []byte("null"))
+	assert.Error(t, err)
+
+	wmcb.kubeletArgs["cloud-provider"] = "gce"
+	contents := "[global]\nproject-id = ci\nnode-tags = ci-worker\n"
+	got, err := translateCloudConfig(wmcb, []byte(contents))
+	require.NoError(t, err)
+	assert.Equal(t, contents, string(got))
+}
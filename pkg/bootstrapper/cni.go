@@ -0,0 +1,244 @@
+package bootstrapper
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"path/filepath"
+	"text/template"
+)
+
+const (
+	// CNIPluginWinBridge is the win-bridge CNI plugin, which attaches pods to an L2 bridge network
+	CNIPluginWinBridge = "win-bridge"
+	// CNIPluginWinOverlay is the win-overlay CNI plugin, which attaches pods to a VXLAN overlay network
+	CNIPluginWinOverlay = "win-overlay"
+	// cniConfigFile is the name of the CNI config file in the CNI config directory
+	cniConfigFile = "cni.conf"
+)
+
+// cniConfigTemplates are the templates of the CNI config of each supported plugin. They are rendered with
+// cniTemplateData.
+// https://github.com/containernetworking/plugins/tree/master/plugins/main/windows
+var cniConfigTemplates = map[string]*template.Template{
+	CNIPluginWinBridge: template.Must(template.New(CNIPluginWinBridge).Parse(`{
+    "cniVersion": "0.2.0",
+    "name": "cbr0",
+    "type": "win-bridge",
+    "capabilities": {
+        "portMappings": true
+    },
+    "ipam": {
+        "type": "host-local",
+        "subnet": "{{.PodCIDR}}",
+        "routes": [
+            {
+                "gw": "{{.Gateway}}"
+            }
+        ]
+    },
+    "policies": [
+        {
+            "name": "EndpointPolicy",
+            "value": {
+                "type": "OutBoundNAT",
+                "exceptionList": [
+                    "{{.PodCIDR}}",
+                    "{{.ServiceCIDR}}"
+                ]
+            }
+        },
+        {
+            "name": "EndpointPolicy",
+            "value": {
+                "type": "ROUTE",
+                "destinationPrefix": "{{.ServiceCIDR}}",
+                "needEncap": true
+            }
+        }
+    ]
+}
+`)),
+	CNIPluginWinOverlay: template.Must(template.New(CNIPluginWinOverlay).Parse(`{
+    "cniVersion": "0.2.0",
+    "name": "OVNKubernetesHybridOverlayNetwork",
+    "type": "win-overlay",
+    "apiVersion": 2,
+    "capabilities": {
+        "portMappings": true,
+        "dns": true
+    },
+    "ipam": {
+        "type": "host-local",
+        "subnet": "{{.PodCIDR}}"
+    },
+    "policies": [
+        {
+            "name": "EndpointPolicy",
+            "value": {
+                "type": "OutBoundNAT",
+                "settings": {
+                    "exceptions": [
+                        "{{.ServiceCIDR}}"
+                    ]
+                }
+            }
+        },
+        {
+            "name": "EndpointPolicy",
+            "value": {
+                "type": "SDNRoute",
+                "settings": {
+                    "destinationPrefix": "{{.ServiceCIDR}}",
+                    "needEncap": true
+                }
+            }
+        }
+    ]
+}
+`)),
+}
+
+// cniOptions describes how pod networking is configured on the node
+type cniOptions struct {
+	// plugin is the CNI plugin pods are attached to the network with
+	plugin string
+	// pluginDir is the directory the CNI plugin binaries are copied from
+	pluginDir string
+	// podCIDR is the subnet pods on this node are given addresses from
+	podCIDR *net.IPNet
+	// serviceCIDR is the subnet cluster services are given addresses from
+	serviceCIDR *net.IPNet
+}
+
+// cniTemplateData is the data the CNI config templates are rendered with
+type cniTemplateData struct {
+	PodCIDR     string
+	ServiceCIDR string
+	// Gateway is the first address in the pod CIDR, which is used by the host
+	Gateway string
+}
+
+// SetCNIOptions enables CNI networking for the kubelet. The binaries of the given plugin, and any other plugins it
+// uses such as host-local, are copied from pluginDir. The CNI config is rendered using the subnet of the pods on this
+// node, podCIDR, and the subnet of the cluster services, serviceCIDR.
+func (wmcb *winNodeBootstrapper) SetCNIOptions(plugin, pluginDir, podCIDR, serviceCIDR string) error {
+	if _, ok := cniConfigTemplates[plugin]; !ok {
+		return fmt.Errorf("unsupported CNI plugin %q, must be %s or %s", plugin, CNIPluginWinBridge,
+			CNIPluginWinOverlay)
+	}
+	if pluginDir == "" {
+		return fmt.Errorf("CNI plugin directory must be given")
+	}
+	_, podNet, err := net.ParseCIDR(podCIDR)
+	if err != nil {
+		return fmt.Errorf("invalid pod CIDR: %s", err)
+	}
+	if _, err = podGateway(podNet); err != nil {
+		return err
+	}
+	_, serviceNet, err := net.ParseCIDR(serviceCIDR)
+	if err != nil {
+		return fmt.Errorf("invalid service CIDR: %s", err)
+	}
+	wmcb.cni = &cniOptions{plugin: plugin, pluginDir: pluginDir, podCIDR: podNet, serviceCIDR: serviceNet}
+	return nil
+}
+
+// cniBinDir returns the directory the CNI plugin binaries are installed to
+func (wmcb *winNodeBootstrapper) cniBinDir() string {
+	return filepath.Join(wmcb.installDir, "cni", "bin")
+}
+
+// cniConfDir returns the directory the CNI config is written to
+func (wmcb *winNodeBootstrapper) cniConfDir() string {
+	return filepath.Join(wmcb.installDir, "cni", "config")
+}
+
+// renderCNIConfig renders the CNI config for the plugin
+func renderCNIConfig(options *cniOptions) ([]byte, error) {
+	tmpl, ok := cniConfigTemplates[options.plugin]
+	if !ok {
+		return nil, fmt.Errorf("unsupported CNI plugin %q", options.plugin)
+	}
+	gateway, err := podGateway(options.podCIDR)
+	if err != nil {
+		return nil, err
+	}
+	data := cniTemplateData{
+		PodCIDR:     options.podCIDR.String(),
+		ServiceCIDR: options.serviceCIDR.String(),
+		Gateway:     gateway.String(),
+	}
+	var config bytes.Buffer
+	if err := tmpl.Execute(&config, data); err != nil {
+		return nil, err
+	}
+	// Ensure the template renders to a valid config, the CNI plugin gives no useful error otherwise
+	if !json.Valid(config.Bytes()) {
+		return nil, fmt.Errorf("rendered invalid %s CNI config", options.plugin)
+	}
+	return config.Bytes(), nil
+}
+
+// podGateway returns the gateway of the pod network, which is the first address after the network address
+func podGateway(podCIDR *net.IPNet) (net.IP, error) {
+	gateway := make(net.IP, len(podCIDR.IP))
+	copy(gateway, podCIDR.IP)
+	for i := len(gateway) - 1; i >= 0; i-- {
+		if gateway[i]++; gateway[i] != 0 {
+			break
+		}
+	}
+	// The pod network also needs room for the broadcast address besides the gateway
+	if ones, bits := podCIDR.Mask.Size(); bits-ones < 2 || !podCIDR.Contains(gateway) {
+		return nil, fmt.Errorf("pod CIDR %s has no room for a gateway", podCIDR)
+	}
+	return gateway, nil
+}
+
+// configureCNI creates the CNI directories, copies the CNI plugin binaries into place and writes the CNI config through
+// the stage
+func (wmcb *winNodeBootstrapper) configureCNI(stage *fileStage) error {
+	for _, dir := range []string{wmcb.cniBinDir(), wmcb.cniConfDir()} {
+		if err := wmcb.fs.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("could not make CNI directory %s: %s", dir, err)
+		}
+	}
+	plugins, err := wmcb.fs.ReadDir(wmcb.cni.pluginDir)
+	if err != nil {
+		return fmt.Errorf("could not read CNI plugin directory: %s", err)
+	}
+	found := false
+	for _, plugin := range plugins {
+		if !plugin.Mode().IsRegular() {
+			continue
+		}
+		err = stage.copyFile(filepath.Join(wmcb.cni.pluginDir, plugin.Name()),
+			filepath.Join(wmcb.cniBinDir(), plugin.Name()))
+		if err != nil {
+			return fmt.Errorf("could not copy CNI plugin %s: %s", plugin.Name(), err)
+		}
+		if plugin.Name() == wmcb.cni.plugin || plugin.Name() == wmcb.cni.plugin+".exe" {
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("CNI plugin %s not found in %s", wmcb.cni.plugin, wmcb.cni.pluginDir)
+	}
+	config, err := renderCNIConfig(wmcb.cni)
+	if err != nil {
+		return err
+	}
+	return stage.writeFile(filepath.Join(wmcb.cniConfDir(), cniConfigFile), config, 0644)
+}
+
+// cniKubeletArgs returns the kubelet arguments needed to use the CNI plugin
+func (wmcb *winNodeBootstrapper) cniKubeletArgs() []string {
+	return []string{
+		"--network-plugin=cni",
+		"--cni-bin-dir=" + wmcb.cniBinDir(),
+		"--cni-conf-dir=" + wmcb.cniConfDir(),
+	}
+}
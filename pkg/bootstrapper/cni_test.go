@@ -0,0 +1,137 @@
+package bootstrapper
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRenderCNIConfig tests rendering the CNI config of each supported plugin
+func TestRenderCNIConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		plugin string
+		want   string
+	}{
+		{
+			name:   "win-bridge",
+			plugin: CNIPluginWinBridge,
+			want:   `{"cniVersion":"0.2.0","name":"cbr0","type":"win-bridge","capabilities":{"portMappings":true},"ipam":{"type":"host-local","subnet":"10.132.1.0/24","routes":[{"gw":"10.132.1.1"}]},"policies":[{"name":"EndpointPolicy","value":{"type":"OutBoundNAT","exceptionList":["10.132.1.0/24","172.30.0.0/16"]}},{"name":"EndpointPolicy","value":{"type":"ROUTE","destinationPrefix":"172.30.0.0/16","needEncap":true}}]}`,
+		},
+		{
+			name:   "win-overlay",
+			plugin: CNIPluginWinOverlay,
+			want:   `{"cniVersion":"0.2.0","name":"OVNKubernetesHybridOverlayNetwork","type":"win-overlay","apiVersion":2,"capabilities":{"portMappings":true,"dns":true},"ipam":{"type":"host-local","subnet":"10.132.1.0/24"},"policies":[{"name":"EndpointPolicy","value":{"type":"OutBoundNAT","settings":{"exceptions":["172.30.0.0/16"]}}},{"name":"EndpointPolicy","value":{"type":"SDNRoute","settings":{"destinationPrefix":"172.30.0.0/16","needEncap":true}}}]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wmcb := winNodeBootstrapper{}
+			// The pod CIDR is normalized to the network address
+			require.NoError(t, wmcb.SetCNIOptions(tt.plugin, "C:\\cni", "10.132.1.5/24", "172.30.0.0/16"))
+			got, err := renderCNIConfig(wmcb.cni)
+			require.NoError(t, err)
+			assert.JSONEq(t, tt.want, string(got))
+		})
+	}
+}
+
+// TestSetCNIOptions tests validation of the CNI options
+func TestSetCNIOptions(t *testing.T) {
+	tests := []struct {
+		name        string
+		plugin      string
+		pluginDir   string
+		podCIDR     string
+		serviceCIDR string
+	}{
+		{name: "Unsupported plugin", plugin: "flannel", pluginDir: "C:\\cni", podCIDR: "10.132.1.0/24",
+			serviceCIDR: "172.30.0.0/16"},
+		{name: "No plugin directory", plugin: CNIPluginWinBridge, podCIDR: "10.132.1.0/24",
+			serviceCIDR: "172.30.0.0/16"},
+		{name: "Invalid pod CIDR", plugin: CNIPluginWinBridge, pluginDir: "C:\\cni", podCIDR: "10.132.1.0",
+			serviceCIDR: "172.30.0.0/16"},
+		{name: "Invalid service CIDR", plugin: CNIPluginWinOverlay, pluginDir: "C:\\cni", podCIDR: "10.132.1.0/24",
+			serviceCIDR: ""},
+		{name: "Pod CIDR without room for a gateway", plugin: CNIPluginWinBridge, pluginDir: "C:\\cni",
+			podCIDR: "10.132.1.255/32", serviceCIDR: "172.30.0.0/16"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wmcb := winNodeBootstrapper{}
+			assert.Error(t, wmcb.SetCNIOptions(tt.plugin, tt.pluginDir, tt.podCIDR, tt.serviceCIDR))
+			assert.Nil(t, wmcb.cni)
+		})
+	}
+}
+
+// TestPodGateway tests that the gateway is the address after the network address, carried into the higher bytes, and
+// that it must be inside the pod network
+func TestPodGateway(t *testing.T) {
+	tests := []struct {
+		name    string
+		podCIDR net.IPNet
+		want    string
+		wantErr bool
+	}{
+		{name: "Network address", podCIDR: net.IPNet{IP: net.IPv4(10, 132, 1, 0).To4(), Mask: net.CIDRMask(24, 32)},
+			want: "10.132.1.1"},
+		{name: "Carried", podCIDR: net.IPNet{IP: net.IPv4(10, 132, 0, 255).To4(), Mask: net.CIDRMask(16, 32)},
+			want: "10.132.1.0"},
+		{name: "Outside of the network",
+			podCIDR: net.IPNet{IP: net.IPv4(10, 132, 1, 255).To4(), Mask: net.CIDRMask(24, 32)}, wantErr: true},
+		{name: "Single address", podCIDR: net.IPNet{IP: net.IPv4(10, 132, 1, 255).To4(), Mask: net.CIDRMask(32, 32)},
+			wantErr: true},
+		{name: "Two addresses", podCIDR: net.IPNet{IP: net.IPv4(10, 132, 1, 254).To4(), Mask: net.CIDRMask(31, 32)},
+			wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway, err := podGateway(&tt.podCIDR)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, gateway.String())
+		})
+	}
+}
+
+// TestConfigureCNI tests that the CNI plugins and config are installed, and the kubelet is configured to use them
+func TestConfigureCNI(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wmcb")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	pluginDir := filepath.Join(dir, "plugins")
+	require.NoError(t, os.Mkdir(pluginDir, 0755))
+	for _, plugin := range []string{"win-overlay.exe", "host-local.exe"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(pluginDir, plugin), []byte(plugin), 0755))
+	}
+
+	wmcb := winNodeBootstrapper{installDir: filepath.Join(dir, "k"), fs: hostFileSystem{}}
+	require.NoError(t, wmcb.SetCNIOptions(CNIPluginWinOverlay, pluginDir, "10.132.1.0/24", "172.30.0.0/16"))
+	stage := newFileStage(wmcb.fs, wmcb.installDir)
+	require.NoError(t, wmcb.configureCNI(stage))
+	for _, plugin := range []string{"win-overlay.exe", "host-local.exe"} {
+		assert.FileExists(t, filepath.Join(wmcb.installDir, "cni", "bin", plugin))
+	}
+	config, err := ioutil.ReadFile(filepath.Join(wmcb.installDir, "cni", "config", cniConfigFile))
+	require.NoError(t, err)
+	assert.True(t, json.Valid(config))
+	assert.Equal(t, []string{
+		"--network-plugin=cni",
+		"--cni-bin-dir=" + filepath.Join(wmcb.installDir, "cni", "bin"),
+		"--cni-conf-dir=" + filepath.Join(wmcb.installDir, "cni", "config"),
+	}, wmcb.cniKubeletArgs())
+
+	// The selected plugin must be among the binaries
+	require.NoError(t, wmcb.SetCNIOptions(CNIPluginWinBridge, pluginDir, "10.132.1.0/24", "172.30.0.0/16"))
+	assert.Error(t, wmcb.configureCNI(stage))
+}
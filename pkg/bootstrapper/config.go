@@ -0,0 +1,307 @@
+package bootstrapper
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// ConfigAPIVersion is the version of the configuration file schema
+	ConfigAPIVersion = "wmcb.openshift.io/v1alpha1"
+	// ConfigKind is the kind of the configuration file
+	ConfigKind = "BootstrapperConfiguration"
+	// DefaultInstallDir is the directory the kubelet and related files are installed to by default
+	DefaultInstallDir = "c:\\k"
+)
+
+// Config is the configuration file of the bootstrapper, which describes a node profile. Fields which are not set
+// keep their defaults.
+type Config struct {
+	// APIVersion must be ConfigAPIVersion
+	APIVersion string `json:"apiVersion"`
+	// Kind must be ConfigKind
+	Kind string `json:"kind"`
+	// InstallDir is the directory the kubelet and related files are installed to. Defaults to DefaultInstallDir.
+	InstallDir string `json:"installDir,omitempty"`
+	// LogDir is the directory the services write their logs to. Defaults to the install directory.
+	LogDir string `json:"logDir,omitempty"`
+	// Ignition describes where the worker ignition file is read from
+	Ignition IgnitionConfig `json:"ignition,omitempty"`
+	// Kubelet configures the kubelet
+	Kubelet KubeletConfig `json:"kubelet,omitempty"`
+	// CNI configures pod networking. The kubelet is run without a network plugin if it is not set.
+	CNI *CNIConfig `json:"cni,omitempty"`
+	// HybridOverlay configures the hybrid overlay node service. The service is not run if it is not set.
+	HybridOverlay *HybridOverlayConfig `json:"hybridOverlay,omitempty"`
+	// Services configures the services run by the bootstrapper
+	Services ServicesConfig `json:"services,omitempty"`
+	// Timeouts are the times the bootstrapper waits for operations to complete
+	Timeouts TimeoutsConfig `json:"timeouts,omitempty"`
+	// LogRotation configures the rotation of the service logs. The logs are not rotated if it is not set.
+	LogRotation *LogRotationConfig `json:"logRotation,omitempty"`
+	// Daemon configures the reconcile daemon, which keeps the node reconciled with its ignition config
+	Daemon DaemonConfig `json:"daemon,omitempty"`
+	// FileMappings is the path of the file mapping rules file, which maps files of the ignition file other than the
+	// ones the kubelet needs to where they are written, see SetFileMappings
+	FileMappings string `json:"fileMappings,omitempty"`
+}
+
+// IgnitionConfig describes where the worker ignition file is read from. Only one of File and URL can be set.
+type IgnitionConfig struct {
+	// File is the path of the worker ignition file
+	File string `json:"file,omitempty"`
+	// URL is the Machine Config Server URL to fetch the worker ignition file from
+	URL string `json:"url,omitempty"`
+	// CABundle is the path of the CA bundle used to verify the Machine Config Server. Defaults to the system roots.
+	CABundle string `json:"caBundle,omitempty"`
+}
+
+// KubeletConfig configures the kubelet
+type KubeletConfig struct {
+	// Path is the path of the kubelet binary to install
+	Path string `json:"path,omitempty"`
+	// SHA256 is the expected hex encoded SHA-256 digest of the kubelet binary
+	SHA256 string `json:"sha256,omitempty"`
+	// Signature is the path of a detached signature of the kubelet binary
+	Signature string `json:"signature,omitempty"`
+	// SigningKey is the path of the PEM encoded public key the signature is verified with
+	SigningKey string `json:"signingKey,omitempty"`
+	// PauseImage is the image of the pod infra container
+	PauseImage string `json:"pauseImage,omitempty"`
+	// CertDir is the directory the kubelet keeps its certificates in. Defaults to pki in the install directory.
+	CertDir string `json:"certDir,omitempty"`
+	// NodeLabels are the labels, as key[=value], the node registers with in addition to the ones in the ignition file
+	NodeLabels []string `json:"nodeLabels,omitempty"`
+	// NodeTaints are the taints, as key[=value]:effect, the node registers with in addition to the ones in the
+	// ignition file. Defaults to DefaultNodeTaint, an empty list registers the node without it.
+	NodeTaints []string `json:"nodeTaints,omitempty"`
+	// ConfigOverrides is the path of a partial KubeletConfiguration, in YAML or JSON, which is merged into the kubelet
+	// configuration from the ignition file once it is translated for Windows, see SetKubeletConfigOverrides
+	ConfigOverrides string `json:"configOverrides,omitempty"`
+	// ExtraArgs are kubelet flags, by name without the leading dashes, which are set in addition to the ones in the
+	// ignition file, overriding them
+	ExtraArgs map[string]string `json:"extraArgs,omitempty"`
+}
+
+// CNIConfig configures pod networking, see SetCNIOptions
+type CNIConfig struct {
+	// Plugin is the CNI plugin, CNIPluginWinBridge or CNIPluginWinOverlay
+	Plugin string `json:"plugin"`
+	// PluginDir is the directory containing the CNI plugin binaries
+	PluginDir string `json:"pluginDir"`
+	// PodCIDR is the subnet pods on this node are given addresses from
+	PodCIDR string `json:"podCIDR"`
+	// ServiceCIDR is the subnet cluster services are given addresses from
+	ServiceCIDR string `json:"serviceCIDR"`
+}
+
+// HybridOverlayConfig configures the hybrid overlay node service, see SetHybridOverlayOptions
+type HybridOverlayConfig struct {
+	// Path is the path of the hybrid overlay node binary
+	Path string `json:"path"`
+	// ClusterSubnets are the hybrid overlay cluster subnets of the cluster network config
+	ClusterSubnets []string `json:"clusterSubnets"`
+	// VXLANPort is the hybrid overlay VXLAN port of the cluster network config. Defaults to the hybrid overlay default.
+	VXLANPort uint16 `json:"vxlanPort,omitempty"`
+}
+
+// ServicesConfig configures the recovery of the kubelet and hybrid overlay node services
+type ServicesConfig struct {
+	// RestartDelay is the time after which a service which failed is restarted
+	RestartDelay metav1.Duration `json:"restartDelay,omitempty"`
+	// RecoveryResetPeriod is the time without failures after which the failure count of a service is reset
+	RecoveryResetPeriod metav1.Duration `json:"recoveryResetPeriod,omitempty"`
+}
+
+// TimeoutsConfig are the times the bootstrapper waits for operations to complete
+type TimeoutsConfig struct {
+	// ServiceWait is the time to wait for a service to stop, start or be deleted
+	ServiceWait metav1.Duration `json:"serviceWait,omitempty"`
+	// IgnitionFetch is the time to wait for a single request for the ignition file to complete
+	IgnitionFetch metav1.Duration `json:"ignitionFetch,omitempty"`
+	// LockWait is the time to wait for another run of the bootstrapper to release the install directory, see
+	// AcquireInstallLock. If zero, the bootstrapper fails at once if it is locked.
+	LockWait metav1.Duration `json:"lockWait,omitempty"`
+}
+
+// DaemonConfig configures the reconcile daemon, see DaemonOptions
+type DaemonConfig struct {
+	// Interval is the time between reconciles of a node which is up to date. Defaults to 5 minutes.
+	Interval metav1.Duration `json:"interval,omitempty"`
+	// RetryInterval is the time to wait before retrying a failed reconcile, which is doubled after each consecutive
+	// failure. Defaults to 10 seconds.
+	RetryInterval metav1.Duration `json:"retryInterval,omitempty"`
+}
+
+// LogRotationConfig configures the rotation of the service logs, see SetLogRotationOptions
+type LogRotationConfig struct {
+	// MaxSize is the size a log is rotated at, as a quantity such as 100Mi
+	MaxSize resource.Quantity `json:"maxSize,omitempty"`
+	// MaxAge is the time after which a log is rotated
+	MaxAge metav1.Duration `json:"maxAge,omitempty"`
+	// MaxBackups is the number of rotated logs kept for each log. All are kept if 0.
+	MaxBackups int `json:"maxBackups,omitempty"`
+	// Retention is the time after which rotated logs are deleted. They are kept regardless of their age if 0.
+	Retention metav1.Duration `json:"retention,omitempty"`
+	// Compress gzip compresses the rotated logs
+	Compress bool `json:"compress,omitempty"`
+	// CheckInterval is how often the logs are checked. Defaults to a minute.
+	CheckInterval metav1.Duration `json:"checkInterval,omitempty"`
+	// ExtraLogs are the paths of other logs to rotate, such as the logs of services added to the bootstrapper
+	ExtraLogs []string `json:"extraLogs,omitempty"`
+}
+
+// options returns the log rotation options the configuration describes
+func (c *LogRotationConfig) options() LogRotationOptions {
+	return LogRotationOptions{
+		MaxSize:       c.MaxSize.Value(),
+		MaxAge:        c.MaxAge.Duration,
+		MaxBackups:    c.MaxBackups,
+		Retention:     c.Retention.Duration,
+		Compress:      c.Compress,
+		CheckInterval: c.CheckInterval.Duration,
+		ExtraLogs:     c.ExtraLogs,
+	}
+}
+
+// LoadConfig reads and validates the configuration file at path, which can be YAML or JSON. Unknown fields are
+// rejected, so that a misspelt setting is not silently ignored.
+func LoadConfig(path string) (*Config, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{}
+	if err = yaml.UnmarshalStrict(contents, config); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %s", path, err)
+	}
+	if err = config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid %s: %s", path, err)
+	}
+	return config, nil
+}
+
+// Validate returns an error if the configuration is not of the supported schema version, or has settings which cannot
+// be applied. The CNI and hybrid overlay settings are validated when they are applied.
+func (c *Config) Validate() error {
+	if c.APIVersion != ConfigAPIVersion || c.Kind != ConfigKind {
+		return fmt.Errorf("unsupported configuration %s %s, must be %s %s", c.APIVersion, c.Kind, ConfigAPIVersion,
+			ConfigKind)
+	}
+	if c.Ignition.File != "" && c.Ignition.URL != "" {
+		return fmt.Errorf("only one of ignition.file or ignition.url can be set")
+	}
+	durations := map[string]time.Duration{
+		"services.restartDelay":        c.Services.RestartDelay.Duration,
+		"services.recoveryResetPeriod": c.Services.RecoveryResetPeriod.Duration,
+		"timeouts.serviceWait":         c.Timeouts.ServiceWait.Duration,
+		"timeouts.ignitionFetch":       c.Timeouts.IgnitionFetch.Duration,
+		"timeouts.lockWait":            c.Timeouts.LockWait.Duration,
+		"daemon.interval":              c.Daemon.Interval.Duration,
+		"daemon.retryInterval":         c.Daemon.RetryInterval.Duration,
+	}
+	for name, duration := range durations {
+		if duration < 0 {
+			return fmt.Errorf("%s must not be negative", name)
+		}
+	}
+	if c.LogRotation != nil {
+		options := c.LogRotation.options()
+		if err := options.validate(); err != nil {
+			return fmt.Errorf("invalid logRotation: %s", err)
+		}
+	}
+	if c.Services.RecoveryResetPeriod.Duration%time.Second != 0 {
+		return fmt.Errorf("services.recoveryResetPeriod must be a whole number of seconds")
+	}
+	for name := range c.Kubelet.ExtraArgs {
+		if err := validateExtraKubeletArg(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateExtraKubeletArg returns an error if the kubelet flag cannot be set in the configuration file, as it is set
+// by the bootstrapper or a dedicated setting, or does not apply to Windows
+func validateExtraKubeletArg(name string) error {
+	switch {
+	case name == "" || strings.HasPrefix(name, "-") || strings.Contains(name, "="):
+		return fmt.Errorf("invalid kubelet flag name %q, it must be given without the leading dashes", name)
+	case name == "node-labels" || name == "register-with-taints":
+		return fmt.Errorf("kubelet flag --%s must be set with kubelet.nodeLabels or kubelet.nodeTaints", name)
+	}
+	if rule, ok := kubeletArgRules[name]; ok && rule.action == kubeletArgDeny {
+		return fmt.Errorf("kubelet flag --%s is set by the bootstrapper or does not apply to Windows", name)
+	}
+	return nil
+}
+
+// Configure applies the settings of the configuration, other than the install directory, ignition file and kubelet
+// path, which the bootstrapper is created with
+func (wmcb *winNodeBootstrapper) Configure(config *Config) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	err := wmcb.SetKubeletVerification(config.Kubelet.SHA256, config.Kubelet.Signature, config.Kubelet.SigningKey)
+	if err != nil {
+		return err
+	}
+	if err = wmcb.SetKubeletConfigOverrides(config.Kubelet.ConfigOverrides); err != nil {
+		return err
+	}
+	if err = wmcb.SetFileMappings(config.FileMappings); err != nil {
+		return err
+	}
+	if err = wmcb.SetNodeLabels(config.Kubelet.NodeLabels); err != nil {
+		return err
+	}
+	if config.Kubelet.NodeTaints != nil {
+		if err = wmcb.SetNodeTaints(config.Kubelet.NodeTaints); err != nil {
+			return err
+		}
+	}
+	if cni := config.CNI; cni != nil {
+		if err = wmcb.SetCNIOptions(cni.Plugin, cni.PluginDir, cni.PodCIDR, cni.ServiceCIDR); err != nil {
+			return err
+		}
+	}
+	if hybridOverlay := config.HybridOverlay; hybridOverlay != nil {
+		err = wmcb.SetHybridOverlayOptions(hybridOverlay.Path, strings.Join(hybridOverlay.ClusterSubnets, ","),
+			hybridOverlay.VXLANPort)
+		if err != nil {
+			return err
+		}
+	}
+	if config.LogRotation != nil {
+		if err = wmcb.SetLogRotationOptions(config.LogRotation.options()); err != nil {
+			return err
+		}
+	}
+	if config.LogDir != "" {
+		wmcb.logDir = config.LogDir
+	}
+	if config.Kubelet.PauseImage != "" {
+		wmcb.pauseImage = config.Kubelet.PauseImage
+	}
+	if config.Kubelet.CertDir != "" {
+		wmcb.certDir = config.Kubelet.CertDir
+	}
+	wmcb.extraKubeletArgs = config.Kubelet.ExtraArgs
+	if config.Services.RestartDelay.Duration != 0 {
+		wmcb.recoveryActions = []RecoveryAction{{Type: ServiceRestart, Delay: config.Services.RestartDelay.Duration}}
+	}
+	if config.Services.RecoveryResetPeriod.Duration != 0 {
+		wmcb.recoveryResetPeriod = uint32(config.Services.RecoveryResetPeriod.Duration / time.Second)
+	}
+	if config.Timeouts.ServiceWait.Duration != 0 {
+		wmcb.services.waitTime = config.Timeouts.ServiceWait.Duration
+	}
+	return nil
+}
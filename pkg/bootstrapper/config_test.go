@@ -0,0 +1,159 @@
+package bootstrapper
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testConfig is a configuration file setting the layout and service settings
+const testConfig = `apiVersion: wmcb.openshift.io/v1alpha1
+kind: BootstrapperConfiguration
+installDir: C:\k
+logDir: C:\var\log\wmcb
+ignition:
+  url: https://api-int.example.com:22623/config/worker
+kubelet:
+  path: C:\Windows\Temp\kubelet.exe
+  pauseImage: registry.example.com/pause:3.1
+  certDir: C:\k\pki
+  nodeLabels:
+  - example.com/pool=windows
+  nodeTaints: []
+  extraArgs:
+    v: "4"
+    max-pods: "100"
+services:
+  restartDelay: 30s
+  recoveryResetPeriod: 1h
+timeouts:
+  serviceWait: 1m
+  ignitionFetch: 10s
+logRotation:
+  maxSize: 100Mi
+  maxBackups: 5
+  compress: true
+`
+
+// writeTestConfig writes the configuration file contents to dir, and returns its path
+func writeTestConfig(t *testing.T, dir, contents string) string {
+	path := filepath.Join(dir, "wmcb.yaml")
+	require.NoError(t, ioutil.WriteFile(path, []byte(contents), 0644))
+	return path
+}
+
+// TestLoadConfig tests that configuration files are parsed and validated
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wmcb")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	config, err := LoadConfig(writeTestConfig(t, dir, testConfig))
+	require.NoError(t, err)
+	assert.Equal(t, `C:\k`, config.InstallDir)
+	assert.Equal(t, "https://api-int.example.com:22623/config/worker", config.Ignition.URL)
+	assert.Equal(t, "registry.example.com/pause:3.1", config.Kubelet.PauseImage)
+	assert.Equal(t, []string{}, config.Kubelet.NodeTaints)
+	assert.Equal(t, map[string]string{"v": "4", "max-pods": "100"}, config.Kubelet.ExtraArgs)
+	assert.Equal(t, time.Hour, config.Services.RecoveryResetPeriod.Duration)
+	assert.Equal(t, time.Minute, config.Timeouts.ServiceWait.Duration)
+	require.NotNil(t, config.LogRotation)
+	assert.Equal(t, LogRotationOptions{MaxSize: 100 << 20, MaxBackups: 5, Compress: true}, config.LogRotation.options())
+
+	header := "apiVersion: " + ConfigAPIVersion + "\nkind: " + ConfigKind + "\n"
+	tests := []struct {
+		name     string
+		contents string
+	}{
+		{name: "Unsupported version", contents: "apiVersion: wmcb.openshift.io/v2\nkind: " + ConfigKind + "\n"},
+		{name: "Missing kind", contents: "apiVersion: " + ConfigAPIVersion + "\n"},
+		{name: "Unknown field", contents: header + "kubelet:\n  pauseImages: pause\n"},
+		{name: "Ignition file and URL", contents: header + "ignition:\n  file: worker.ign\n  url: https://mcs\n"},
+		{name: "Invalid duration", contents: header + "timeouts:\n  serviceWait: soon\n"},
+		{name: "Negative duration", contents: header + "services:\n  restartDelay: -5s\n"},
+		{name: "Negative lock wait", contents: header + "timeouts:\n  lockWait: -1m\n"},
+		{name: "Negative daemon interval", contents: header + "daemon:\n  interval: -5m\n"},
+		{name: "Fractional reset period", contents: header + "services:\n  recoveryResetPeriod: 1500ms\n"},
+		{name: "Managed kubelet flag", contents: header + "kubelet:\n  extraArgs:\n    cert-dir: C:\\pki\n"},
+		{name: "Linux kubelet flag", contents: header + "kubelet:\n  extraArgs:\n    cgroup-driver: systemd\n"},
+		{name: "Kubelet flag with dashes", contents: header + "kubelet:\n  extraArgs:\n    --v: \"4\"\n"},
+		{name: "Node labels flag", contents: header + "kubelet:\n  extraArgs:\n    node-labels: a=b\n"},
+		{name: "Log rotation without limits", contents: header + "logRotation:\n  compress: true\n"},
+		{name: "Invalid log size", contents: header + "logRotation:\n  maxSize: big\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadConfig(writeTestConfig(t, dir, tt.contents))
+			assert.Error(t, err)
+		})
+	}
+}
+
+// TestRunConfig tests that the kubelet service is configured according to the configuration file
+func TestRunConfig(t *testing.T) {
+	scm := newFakeSCM()
+	wmcb := newTestBootstrapper(t, scm)
+	testDir := filepath.Dir(wmcb.installDir)
+	defer os.RemoveAll(testDir)
+	config, err := LoadConfig(writeTestConfig(t, testDir, testConfig))
+	require.NoError(t, err)
+	config.LogDir = filepath.Join(testDir, "logs")
+	config.Kubelet.CertDir = filepath.Join(testDir, "certs")
+
+	require.NoError(t, wmcb.Configure(config))
+	assert.Equal(t, time.Minute, wmcb.services.waitTime)
+	require.NotNil(t, wmcb.logRotator)
+	// Copy a stand-in rather than the test binary
+	wmcb.logRotator.binaryPath = wmcb.initialKubeletPath
+	require.NoError(t, wmcb.Run(context.Background()))
+	require.NoError(t, wmcb.Disconnect())
+
+	kubelet := scm.get(KubeletServiceName)
+	require.NotNil(t, kubelet, "kubelet service is not installed")
+	assert.Equal(t, ServiceRunning, kubelet.state)
+	for _, arg := range []string{
+		"--pod-infra-container-image=registry.example.com/pause:3.1",
+		"--cert-dir=" + config.Kubelet.CertDir,
+		"--log-file=" + filepath.Join(config.LogDir, "kubelet.log"),
+		"--node-labels=example.com/pool=windows",
+		"--max-pods=100",
+		"--v=4",
+		"--cloud-provider=aws",
+	} {
+		assert.Contains(t, kubelet.args, arg)
+	}
+	// The ignition file sets --v=3, which the configuration file overrides
+	assert.NotContains(t, kubelet.args, "--v=3")
+	for _, arg := range kubelet.args {
+		assert.NotContains(t, arg, "--register-with-taints", "the node must register without taints")
+	}
+	assert.Equal(t, []RecoveryAction{{Type: ServiceRestart, Delay: 30 * time.Second}}, kubelet.recoveryActions)
+	assert.Equal(t, uint32(3600), kubelet.resetPeriod)
+	assert.DirExists(t, config.LogDir)
+	rotator := scm.get(LogRotatorServiceName)
+	require.NotNil(t, rotator, "log rotator service is not installed")
+	assert.Contains(t, rotator.args, "--log-file="+filepath.Join(config.LogDir, "kubelet.log"))
+}
+
+// TestConfigureDefaultCertDir tests that the kubelet keeps its certificates in the install directory unless the
+// configuration file gives a directory
+func TestConfigureDefaultCertDir(t *testing.T) {
+	scm := newFakeSCM()
+	installDir := filepath.Join("C:\\", "wmcb")
+	wmcb, err := newWinNodeBootstrapper(installDir, "", "", scm.connect)
+	require.NoError(t, err)
+	defer wmcb.Disconnect()
+	config := &Config{APIVersion: ConfigAPIVersion, Kind: ConfigKind}
+	require.NoError(t, wmcb.Configure(config))
+	assert.Equal(t, filepath.Join(installDir, certDirName), wmcb.certDir)
+
+	config.Kubelet.CertDir = filepath.Join("C:\\", "certs")
+	require.NoError(t, wmcb.Configure(config))
+	assert.Equal(t, config.Kubelet.CertDir, wmcb.certDir)
+}
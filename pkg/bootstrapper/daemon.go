@@ -0,0 +1,254 @@
+package bootstrapper
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// DaemonServiceName is the name of the Windows service the reconcile daemon is run as
+	DaemonServiceName = "wmcb-daemon"
+	// reconcileResultFile is the name of the file, in the install directory, the result of the last reconcile of the
+	// daemon is recorded in
+	reconcileResultFile = "wmcb-reconcile.json"
+	// defaultReconcileInterval is the default time between reconciles of a node which is up to date
+	defaultReconcileInterval = 5 * time.Minute
+	// defaultReconcileRetryInterval is the default time to wait before retrying a failed reconcile
+	defaultReconcileRetryInterval = 10 * time.Second
+)
+
+// DaemonOptions configure the reconcile daemon
+type DaemonOptions struct {
+	// Interval is the time between reconciles of a node which is up to date. Defaults to 5 minutes.
+	Interval time.Duration
+	// RetryInterval is the time to wait before retrying a failed reconcile. It is doubled after each consecutive
+	// failure, up to Interval. Defaults to 10 seconds.
+	RetryInterval time.Duration
+	// LockWait is the time each reconcile waits for another run of the bootstrapper to release the install directory
+	LockWait time.Duration
+}
+
+// ReconcileResult is the result of a reconcile of the node by the daemon
+type ReconcileResult struct {
+	// Time is when the reconcile finished
+	Time      time.Time `json:"time"`
+	Succeeded bool      `json:"succeeded"`
+	// Applied is true if the node was applied, as it did not match the ignition config and kubelet, rather than found
+	// to be up to date
+	Applied bool `json:"applied"`
+	// Error is the error the reconcile failed with
+	Error string `json:"error,omitempty"`
+	// FailureCategory classifies the error the reconcile failed with
+	FailureCategory FailureCategory `json:"failureCategory,omitempty"`
+	// IgnitionSHA256 is the hex encoded SHA-256 digest of the ignition config the node was reconciled with
+	IgnitionSHA256 string `json:"ignitionSHA256,omitempty"`
+	// KubeletSHA256 is the hex encoded SHA-256 digest of the kubelet the node was reconciled with
+	KubeletSHA256 string `json:"kubeletSHA256,omitempty"`
+	// Changes are the changes made to the node, if it was applied
+	Changes *RunChanges `json:"changes,omitempty"`
+	// ConsecutiveFailures is the number of reconciles which failed in a row, including this one
+	ConsecutiveFailures int `json:"consecutiveFailures,omitempty"`
+	// NextReconcile is when the daemon reconciles the node next
+	NextReconcile time.Time `json:"nextReconcile"`
+}
+
+// Daemon keeps a node reconciled with its ignition config, so that changes to the machine config made after the node
+// was bootstrapped, such as a rotated kubelet CA, reach it. Each reconcile reads the ignition config again, and applies
+// the node only if the ignition config or kubelet changed since they were last applied, or the node no longer matches
+// what was applied. Applying the node only changes the files and services which differ, so an up to date node is
+// not disrupted.
+type Daemon struct {
+	// bootstrapper prepares, applies and verifies the node
+	bootstrapper *Bootstrapper
+	// source is the ignition source of the bootstrapper, recording the digest of the ignition config it reads
+	source *digestingIgnitionSource
+	// options configure the daemon
+	options DaemonOptions
+	// applied identifies the ignition config and kubelet last applied successfully
+	applied string
+	// failures is the number of reconciles which failed in a row
+	failures int
+	// lock guards last
+	lock sync.Mutex
+	// last is the result of the last reconcile
+	last *ReconcileResult
+}
+
+// NewDaemon returns a daemon reconciling the node with the bootstrapper, which must not be used by anything else
+// while the daemon runs
+func NewDaemon(b *Bootstrapper, options DaemonOptions) *Daemon {
+	if options.Interval == 0 {
+		options.Interval = defaultReconcileInterval
+	}
+	if options.RetryInterval == 0 {
+		options.RetryInterval = defaultReconcileRetryInterval
+	}
+	source := &digestingIgnitionSource{IgnitionSource: b.wmcb.ignitionSource}
+	b.wmcb.ignitionSource = source
+	return &Daemon{bootstrapper: b, source: source, options: options}
+}
+
+// Run reconciles the node until ctx is done. A failed reconcile is retried with an exponential backoff, so that
+// transient failures, such as the Machine Config Server being unreachable, do not stop the daemon.
+func (d *Daemon) Run(ctx context.Context) {
+	for {
+		result := d.Reconcile(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		timer := time.NewTimer(time.Until(result.NextReconcile))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// Reconcile reconciles the node once, and records the result in the install directory. A node which does not match
+// the ignition config and kubelet is applied while holding the lock of the install directory.
+func (d *Daemon) Reconcile(ctx context.Context) *ReconcileResult {
+	result := &ReconcileResult{}
+	err := d.reconcile(ctx, result)
+	result.Time = time.Now()
+	if err != nil {
+		d.failures++
+		result.Error = err.Error()
+		result.FailureCategory = FailureCategoryOf(err)
+		log.Error(err, "could not reconcile node", "category", result.FailureCategory, "failures", d.failures)
+	} else {
+		d.failures = 0
+		result.Succeeded = true
+	}
+	result.ConsecutiveFailures = d.failures
+	result.NextReconcile = result.Time.Add(d.retryDelay())
+
+	d.lock.Lock()
+	d.last = result
+	d.lock.Unlock()
+	d.recordReconcileResult(result)
+	return result
+}
+
+// reconcile applies the node if it does not match the ignition config and kubelet, recording what it found and did in
+// the result
+func (d *Daemon) reconcile(ctx context.Context, result *ReconcileResult) error {
+	wmcb := d.bootstrapper.wmcb
+	lock, err := AcquireInstallLock(wmcb.installDir, d.options.LockWait)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := lock.Release(); err != nil {
+			log.Error(err, "could not release install directory lock")
+		}
+	}()
+
+	if err = d.bootstrapper.Prepare(ctx); err != nil {
+		return err
+	}
+	result.IgnitionSHA256 = d.source.sha256
+	if result.KubeletSHA256, err = fileSHA256(wmcb.fs, wmcb.initialKubeletPath); err != nil {
+		return &PhaseError{Phase: PhaseKubeletCopy, Category: FailureKubelet, Err: err}
+	}
+	desired := result.IgnitionSHA256 + " " + result.KubeletSHA256
+	switch {
+	case d.applied == "":
+		log.Info("applying node")
+	case desired != d.applied:
+		log.Info("ignition config or kubelet changed, applying node", "ignitionSHA256", result.IgnitionSHA256,
+			"kubeletSHA256", result.KubeletSHA256)
+	default:
+		err = d.bootstrapper.Verify(ctx)
+		if err == nil {
+			log.Info("node is up to date")
+			return nil
+		}
+		if ctx.Err() != nil {
+			// The node is not applied as the daemon is stopping, as it would only be rolled back
+			return err
+		}
+		log.Info("node does not match what was applied, applying it again", "reason", err.Error())
+	}
+
+	changes, err := d.bootstrapper.Apply(ctx)
+	if err != nil {
+		return err
+	}
+	result.Applied = true
+	result.Changes = changes
+	d.applied = desired
+	log.Info("applied node", "files", changes.Files, "services", changes.Services, "started", changes.Started)
+	return nil
+}
+
+// retryDelay returns the time to wait before the next reconcile, which is the retry interval doubled for each
+// consecutive failure after the first, up to the interval
+func (d *Daemon) retryDelay() time.Duration {
+	if d.failures == 0 {
+		return d.options.Interval
+	}
+	delay := d.options.RetryInterval
+	for i := 1; i < d.failures && delay < d.options.Interval; i++ {
+		delay *= 2
+	}
+	if delay > d.options.Interval {
+		return d.options.Interval
+	}
+	return delay
+}
+
+// LastResult returns the result of the last reconcile, or nil if the node has not been reconciled yet
+func (d *Daemon) LastResult() *ReconcileResult {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.last
+}
+
+// recordReconcileResult records the result of a reconcile in the install directory, where Status reports it. Failing
+// to record it is logged, as it must not stop the daemon.
+func (d *Daemon) recordReconcileResult(result *ReconcileResult) {
+	installDir := d.bootstrapper.wmcb.installDir
+	contents, err := json.MarshalIndent(result, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(installDir, 0755)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(installDir, reconcileResultFile), contents, 0644)
+	}
+	if err != nil {
+		log.Error(err, "could not record reconcile result")
+	}
+}
+
+// digestingIgnitionSource is an IgnitionSource which records the digest of the ignition config it last read
+type digestingIgnitionSource struct {
+	IgnitionSource
+	// sha256 is the hex encoded SHA-256 digest of the ignition config last read, or empty if it could not be read
+	sha256 string
+}
+
+// Ignition reads the ignition config from the underlying source, recording its digest
+func (s *digestingIgnitionSource) Ignition(ctx context.Context) ([]byte, error) {
+	contents, err := s.IgnitionSource.Ignition(ctx)
+	s.sha256 = ""
+	if err == nil {
+		digest := sha256.Sum256(contents)
+		s.sha256 = hex.EncodeToString(digest[:])
+	}
+	return contents, err
+}
+
+// FromCache returns true if the underlying source last returned its cached copy of the ignition config
+func (s *digestingIgnitionSource) FromCache() bool {
+	cached, ok := s.IgnitionSource.(cachedIgnitionSource)
+	return ok && cached.FromCache()
+}
@@ -0,0 +1,207 @@
+package bootstrapper
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// changingIgnition is an IgnitionSource whose ignition config can be changed, or made to fail
+type changingIgnition struct {
+	lock     sync.Mutex
+	contents string
+	err      error
+}
+
+func (s *changingIgnition) Ignition(ctx context.Context) ([]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return []byte(s.contents), s.err
+}
+
+// set changes the ignition config the source returns, or the error it fails with
+func (s *changingIgnition) set(contents string, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.contents, s.err = contents, err
+}
+
+// newTestDaemon returns a daemon backed by the given fake SCM, reconciling a node installed to a temporary directory
+// with the ignition config of the source, along with the directory
+func newTestDaemon(t *testing.T, scm *fakeSCM, source IgnitionSource, options DaemonOptions) (string, *Daemon) {
+	dir, opts := testOptions(t, scm)
+	b, err := New(append(opts, WithIgnitionSource(source))...)
+	require.NoError(t, err)
+	return dir, NewDaemon(b, options)
+}
+
+// TestDaemonReconcile tests that the daemon applies the node when it is first reconciled, when the ignition config
+// changes and when the node no longer matches what was applied, leaves an up to date node alone, and keeps reconciling
+// through failures
+func TestDaemonReconcile(t *testing.T) {
+	scm := newFakeSCM()
+	source := &changingIgnition{contents: testIgnitionConfig("3.1.0")}
+	dir, daemon := newTestDaemon(t, scm, source, DaemonOptions{Interval: time.Hour, RetryInterval: time.Minute})
+	defer os.RemoveAll(dir)
+	defer daemon.bootstrapper.Close()
+	installDir := filepath.Join(dir, "k")
+
+	result := daemon.Reconcile(context.Background())
+	require.True(t, result.Succeeded, result.Error)
+	assert.True(t, result.Applied)
+	assert.True(t, result.Changes.Changed())
+	assert.NotEmpty(t, result.IgnitionSHA256)
+	assert.Equal(t, testKubeletDigest, result.KubeletSHA256)
+	assertKubeletServiceRunning(t, scm, installDir)
+	assert.Equal(t, result, daemon.LastResult())
+	assert.WithinDuration(t, result.Time.Add(time.Hour), result.NextReconcile, time.Second)
+	_, err := os.Stat(filepath.Join(installDir, lockFileName))
+	assert.True(t, os.IsNotExist(err), "install directory lock should be released")
+
+	result = daemon.Reconcile(context.Background())
+	require.True(t, result.Succeeded, result.Error)
+	assert.False(t, result.Applied, "an up to date node should not be applied")
+
+	// A change to the ignition config is applied, restarting only what it affects. The config is generated once, as
+	// the order of its files is not stable.
+	changed := strings.Replace(testIgnitionConfig("3.1.0"), "--v=3", "--v=4", 1)
+	source.set(changed, nil)
+	result = daemon.Reconcile(context.Background())
+	require.True(t, result.Succeeded, result.Error)
+	assert.True(t, result.Applied)
+	assert.Equal(t, []string{KubeletServiceName}, result.Changes.Services)
+	assert.Empty(t, result.Changes.Files)
+	assert.Contains(t, scm.get(KubeletServiceName).args, "--v=4")
+
+	// A stopped service is started again
+	scm.get(KubeletServiceName).state = ServiceStopped
+	result = daemon.Reconcile(context.Background())
+	require.True(t, result.Succeeded, result.Error)
+	assert.True(t, result.Applied)
+	assert.Equal(t, []string{KubeletServiceName}, result.Changes.Started)
+
+	// Failures are counted and retried with a backoff, without changing the node
+	source.set("", fmt.Errorf("connection refused"))
+	for failures := 1; failures <= 3; failures++ {
+		result = daemon.Reconcile(context.Background())
+		require.False(t, result.Succeeded)
+		assert.Contains(t, result.Error, "connection refused")
+		assert.Equal(t, FailureIgnition, result.FailureCategory)
+		assert.Equal(t, failures, result.ConsecutiveFailures)
+		assert.WithinDuration(t, result.Time.Add(time.Minute<<uint(failures-1)), result.NextReconcile, time.Second)
+	}
+	assert.Equal(t, ServiceRunning, scm.get(KubeletServiceName).state)
+
+	lock, err := AcquireInstallLock(installDir, 0)
+	require.NoError(t, err)
+	source.set(changed, nil)
+	result = daemon.Reconcile(context.Background())
+	assert.False(t, result.Succeeded, "a locked node should not be reconciled")
+	assert.Contains(t, result.Error, "install directory is locked")
+	require.NoError(t, lock.Release())
+
+	result = daemon.Reconcile(context.Background())
+	require.True(t, result.Succeeded, result.Error)
+	assert.False(t, result.Applied)
+	assert.Equal(t, 0, result.ConsecutiveFailures)
+
+	status, err := daemon.bootstrapper.Status()
+	require.NoError(t, err)
+	require.NotNil(t, status.LastReconcile)
+	assert.True(t, status.LastReconcile.Succeeded)
+	assert.Equal(t, result.IgnitionSHA256, status.LastReconcile.IgnitionSHA256)
+}
+
+// TestDaemonReconcileRemovedFlag tests that a kubelet flag removed from the ignition config is removed from the kubelet
+// service, and that the node is then found to be up to date
+func TestDaemonReconcileRemovedFlag(t *testing.T) {
+	scm := newFakeSCM()
+	// The config is generated once, as the order of its files is not stable
+	config := testIgnitionConfig("3.1.0")
+	source := &changingIgnition{contents: config}
+	dir, daemon := newTestDaemon(t, scm, source, DaemonOptions{Interval: time.Hour})
+	defer os.RemoveAll(dir)
+	defer daemon.bootstrapper.Close()
+
+	result := daemon.Reconcile(context.Background())
+	require.True(t, result.Succeeded, result.Error)
+	assert.Contains(t, scm.get(KubeletServiceName).args, "--v=3")
+
+	source.set(strings.Replace(config, " --v=3", "", 1), nil)
+	result = daemon.Reconcile(context.Background())
+	require.True(t, result.Succeeded, result.Error)
+	assert.True(t, result.Applied)
+	assert.Equal(t, []string{KubeletServiceName}, result.Changes.Services)
+	args := scm.get(KubeletServiceName).args
+	assert.NotContains(t, args, "--v=3")
+	assert.Contains(t, args, "--cloud-provider=aws")
+
+	require.NoError(t, daemon.bootstrapper.Verify(context.Background()))
+	assert.Equal(t, args, scm.get(KubeletServiceName).args)
+	result = daemon.Reconcile(context.Background())
+	require.True(t, result.Succeeded, result.Error)
+	assert.False(t, result.Applied, "an up to date node should not be applied")
+}
+
+// TestDaemonRetryDelay tests that failed reconciles are retried with an exponential backoff bounded by the interval
+func TestDaemonRetryDelay(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{failures: 0, want: time.Minute},
+		{failures: 1, want: 10 * time.Second},
+		{failures: 2, want: 20 * time.Second},
+		{failures: 3, want: 40 * time.Second},
+		{failures: 4, want: time.Minute},
+		{failures: 100, want: time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d failures", tt.failures), func(t *testing.T) {
+			daemon := &Daemon{options: DaemonOptions{Interval: time.Minute, RetryInterval: 10 * time.Second},
+				failures: tt.failures}
+			assert.Equal(t, tt.want, daemon.retryDelay())
+		})
+	}
+}
+
+// TestDaemonRun tests that the daemon reconciles the node until it is stopped
+func TestDaemonRun(t *testing.T) {
+	scm := newFakeSCM()
+	source := &changingIgnition{err: fmt.Errorf("connection refused")}
+	dir, daemon := newTestDaemon(t, scm, source,
+		DaemonOptions{Interval: 10 * time.Millisecond, RetryInterval: time.Millisecond})
+	defer os.RemoveAll(dir)
+	defer daemon.bootstrapper.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		daemon.Run(ctx)
+		close(done)
+	}()
+	// The daemon keeps running through failures, and applies the node once the ignition config can be read
+	time.Sleep(20 * time.Millisecond)
+	source.set(testIgnitionConfig("3.1.0"), nil)
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if result := daemon.LastResult(); result != nil && result.Succeeded {
+			break
+		}
+		require.True(t, time.Now().Before(deadline), "node was not applied")
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("daemon did not stop")
+	}
+	assertKubeletServiceRunning(t, scm, filepath.Join(dir, "k"))
+}
@@ -0,0 +1,382 @@
+package bootstrapper
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// fakeSCM is an in-memory model of the Windows service control manager database. It tracks the state of every
+// service, and like Windows, only removes a service marked for deletion once it is stopped and has no open handles.
+type fakeSCM struct {
+	sync.Mutex
+	services map[string]*fakeServiceRecord
+	// connections is the number of service manager connections that have been opened
+	connections int
+	// started records the names of the services in the order they were started
+	started []string
+	// stopped records the names of the services in the order they were stopped
+	stopped []string
+}
+
+// fakeServiceRecord is a service installed in the fakeSCM
+type fakeServiceRecord struct {
+	name            string
+	config          ServiceConfig
+	args            []string
+	state           ServiceState
+	recoveryActions []RecoveryAction
+	resetPeriod     uint32
+	deletePending   bool
+	openHandles     int
+	// pendingQueries is the number of queries for which a service which is sent a stop control reports it is stopping,
+	// like a slow Windows service. A service with a negative number never stops.
+	pendingQueries int
+	// remainingQueries is the number of queries left before a stopping service is stopped
+	remainingQueries int
+	// crashes is true if the service exits as soon as it is started
+	crashes bool
+}
+
+// exePath returns the path of the executable the service runs
+func (record *fakeServiceRecord) exePath() string {
+	return splitCommandLine(record.config.BinaryPathName)[0]
+}
+
+// fakeServiceManager is a connection to a fakeSCM
+type fakeServiceManager struct {
+	scm          *fakeSCM
+	disconnected bool
+}
+
+// fakeService is a handle to a service in a fakeSCM
+type fakeService struct {
+	scm    *fakeSCM
+	record *fakeServiceRecord
+	closed bool
+}
+
+func newFakeSCM() *fakeSCM {
+	return &fakeSCM{services: make(map[string]*fakeServiceRecord)}
+}
+
+// connect returns a new connection to the fakeSCM, it satisfies serviceManagerConnector
+func (scm *fakeSCM) connect() (ServiceManager, error) {
+	scm.Lock()
+	defer scm.Unlock()
+	scm.connections++
+	return &fakeServiceManager{scm: scm}, nil
+}
+
+// get returns the service record with the given name, or nil if it is not installed
+func (scm *fakeSCM) get(name string) *fakeServiceRecord {
+	scm.Lock()
+	defer scm.Unlock()
+	return scm.services[name]
+}
+
+// install adds a service running config.BinaryPathName with the given arguments to the database without going through
+// a connection, leaving no handles open
+func (scm *fakeSCM) install(name string, config ServiceConfig, state ServiceState, args ...string) {
+	scm.Lock()
+	defer scm.Unlock()
+	config.BinaryPathName = commandLine(config.BinaryPathName, args)
+	if config.ServiceType == 0 {
+		config.ServiceType = ServiceOwnProcess
+	}
+	scm.services[name] = &fakeServiceRecord{name: name, config: config, args: args, state: state}
+}
+
+// holdHandle opens a handle to the service without going through a connection, as another process would, and returns
+// a function which closes it
+func (scm *fakeSCM) holdHandle(name string) func() {
+	scm.Lock()
+	defer scm.Unlock()
+	record := scm.services[name]
+	record.openHandles++
+	return func() {
+		scm.Lock()
+		defer scm.Unlock()
+		record.openHandles--
+		scm.removeIfDeletable(record)
+	}
+}
+
+// removeIfDeletable removes the service from the database if it is marked for deletion, stopped and unused.
+// The caller must hold the lock.
+func (scm *fakeSCM) removeIfDeletable(record *fakeServiceRecord) {
+	if record.deletePending && record.openHandles == 0 && record.state == ServiceStopped {
+		delete(scm.services, record.name)
+	}
+}
+
+func (m *fakeServiceManager) CreateService(name, exepath string, config ServiceConfig, args ...string) (Service, error) {
+	m.scm.Lock()
+	defer m.scm.Unlock()
+	if m.disconnected {
+		return nil, fmt.Errorf("service manager is disconnected")
+	}
+	if existing, ok := m.scm.services[name]; ok {
+		if existing.deletePending {
+			return nil, fmt.Errorf("the specified service %s has been marked for deletion", name)
+		}
+		return nil, fmt.Errorf("the specified service %s already exists", name)
+	}
+	// Like Windows, the binary path of the service is the command line it is run with
+	config.BinaryPathName = commandLine(exepath, args)
+	if config.ServiceType == 0 {
+		config.ServiceType = ServiceOwnProcess
+	}
+	record := &fakeServiceRecord{name: name, config: config, args: args, state: ServiceStopped, openHandles: 1}
+	m.scm.services[name] = record
+	return &fakeService{scm: m.scm, record: record}, nil
+}
+
+func (m *fakeServiceManager) OpenService(name string) (Service, error) {
+	m.scm.Lock()
+	defer m.scm.Unlock()
+	if m.disconnected {
+		return nil, fmt.Errorf("service manager is disconnected")
+	}
+	record, ok := m.scm.services[name]
+	if !ok {
+		return nil, fmt.Errorf("the specified service %s does not exist", name)
+	}
+	record.openHandles++
+	return &fakeService{scm: m.scm, record: record}, nil
+}
+
+func (m *fakeServiceManager) ListServices() ([]string, error) {
+	m.scm.Lock()
+	defer m.scm.Unlock()
+	var names []string
+	for name := range m.scm.services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (m *fakeServiceManager) Disconnect() error {
+	m.scm.Lock()
+	defer m.scm.Unlock()
+	if m.disconnected {
+		return fmt.Errorf("service manager is already disconnected")
+	}
+	m.disconnected = true
+	return nil
+}
+
+// check returns an error if the handle can no longer be used. The caller must hold the lock.
+func (s *fakeService) check() error {
+	if s.closed {
+		return fmt.Errorf("the handle to service %s is closed", s.record.name)
+	}
+	return nil
+}
+
+func (s *fakeService) Name() string {
+	return s.record.name
+}
+
+func (s *fakeService) Config() (ServiceConfig, error) {
+	s.scm.Lock()
+	defer s.scm.Unlock()
+	if err := s.check(); err != nil {
+		return ServiceConfig{}, err
+	}
+	return s.record.config, nil
+}
+
+func (s *fakeService) UpdateConfig(config ServiceConfig) error {
+	s.scm.Lock()
+	defer s.scm.Unlock()
+	if err := s.check(); err != nil {
+		return err
+	}
+	if err := validateServiceConfig(config); err != nil {
+		return err
+	}
+	s.record.config = config
+	s.record.args = splitCommandLine(config.BinaryPathName)[1:]
+	return nil
+}
+
+// validateServiceConfig returns an error if Windows would reject the configuration when replacing the configuration of
+// a service. Unlike when a service is created, no default is taken for a zero service type.
+func validateServiceConfig(config ServiceConfig) error {
+	switch config.ServiceType {
+	case ServiceOwnProcess, ServiceShareProcess:
+	default:
+		return fmt.Errorf("the parameter is incorrect: invalid service type %d", config.ServiceType)
+	}
+	switch config.ErrorControl {
+	case ServiceErrorIgnore, ServiceErrorNormal, ServiceErrorSevere, ServiceErrorCritical:
+	default:
+		return fmt.Errorf("the parameter is incorrect: invalid error control %d", config.ErrorControl)
+	}
+	switch config.StartType {
+	case StartAutomatic, StartManual, StartDisabled:
+	default:
+		return fmt.Errorf("the parameter is incorrect: invalid start type %d", config.StartType)
+	}
+	return nil
+}
+
+func (s *fakeService) Start(args ...string) error {
+	s.scm.Lock()
+	defer s.scm.Unlock()
+	if err := s.check(); err != nil {
+		return err
+	}
+	if s.record.deletePending {
+		return fmt.Errorf("the specified service %s has been marked for deletion", s.record.name)
+	}
+	if s.record.config.StartType == StartDisabled {
+		return fmt.Errorf("the specified service %s is disabled", s.record.name)
+	}
+	if s.record.state != ServiceStopped {
+		return fmt.Errorf("an instance of the service %s is already running", s.record.name)
+	}
+	return s.scm.start(s.record)
+}
+
+// start starts the service, starting its dependencies first as Windows does. The caller must hold the lock.
+func (scm *fakeSCM) start(record *fakeServiceRecord) error {
+	for _, name := range record.config.Dependencies {
+		dependency, ok := scm.services[name]
+		if !ok || dependency.deletePending {
+			return fmt.Errorf("the dependency service %s of %s does not exist or has been marked for deletion",
+				name, record.name)
+		}
+		if dependency.state != ServiceRunning {
+			if err := scm.start(dependency); err != nil {
+				return err
+			}
+		}
+	}
+	record.state = ServiceRunning
+	if record.crashes {
+		record.state = ServiceStopped
+	}
+	scm.started = append(scm.started, record.name)
+	return nil
+}
+
+// runningDependents returns the names of the running services which depend on the service. The caller must hold
+// the lock.
+func (scm *fakeSCM) runningDependents(name string) []string {
+	var dependents []string
+	for _, record := range scm.services {
+		for _, dependency := range record.config.Dependencies {
+			if dependency == name && record.state != ServiceStopped {
+				dependents = append(dependents, record.name)
+			}
+		}
+	}
+	return dependents
+}
+
+func (s *fakeService) Control(cmd ServiceCmd) (ServiceStatus, error) {
+	s.scm.Lock()
+	defer s.scm.Unlock()
+	if err := s.check(); err != nil {
+		return ServiceStatus{}, err
+	}
+	if dependents := s.scm.runningDependents(s.record.name); cmd == ServiceStop && len(dependents) > 0 {
+		return ServiceStatus{State: s.record.state},
+			fmt.Errorf("a stop control has been sent to %s which other running services %v depend on",
+				s.record.name, dependents)
+	}
+	if cmd == ServiceStop && s.record.state != ServiceStopped {
+		s.scm.stopped = append(s.scm.stopped, s.record.name)
+	}
+	switch {
+	case cmd == ServiceStop && s.record.state != ServiceStopped && s.record.pendingQueries != 0:
+		s.record.state, s.record.remainingQueries = ServiceStopPending, s.record.pendingQueries
+	case cmd == ServiceStop && s.record.state != ServiceStopped:
+		s.record.state = ServiceStopped
+	case cmd == ServicePause && s.record.state == ServiceRunning:
+		s.record.state = ServicePaused
+	case cmd == ServiceContinue && s.record.state == ServicePaused:
+		s.record.state = ServiceRunning
+	default:
+		return ServiceStatus{State: s.record.state},
+			fmt.Errorf("the service %s cannot accept control %d in state %d", s.record.name, cmd, s.record.state)
+	}
+	s.scm.removeIfDeletable(s.record)
+	return ServiceStatus{State: s.record.state}, nil
+}
+
+func (s *fakeService) Query() (ServiceStatus, error) {
+	s.scm.Lock()
+	defer s.scm.Unlock()
+	if err := s.check(); err != nil {
+		return ServiceStatus{}, err
+	}
+	status := ServiceStatus{State: s.record.state}
+	if s.record.state == ServiceStopPending && s.record.remainingQueries > 0 {
+		if s.record.remainingQueries--; s.record.remainingQueries == 0 {
+			s.record.state = ServiceStopped
+			s.scm.removeIfDeletable(s.record)
+		}
+	}
+	return status, nil
+}
+
+func (s *fakeService) SetRecoveryActions(actions []RecoveryAction, resetPeriod uint32) error {
+	s.scm.Lock()
+	defer s.scm.Unlock()
+	if err := s.check(); err != nil {
+		return err
+	}
+	if actions == nil {
+		return fmt.Errorf("recoveryActions cannot be nil")
+	}
+	s.record.recoveryActions = actions
+	s.record.resetPeriod = resetPeriod
+	return nil
+}
+
+func (s *fakeService) RecoveryActions() ([]RecoveryAction, error) {
+	s.scm.Lock()
+	defer s.scm.Unlock()
+	if err := s.check(); err != nil {
+		return nil, err
+	}
+	return s.record.recoveryActions, nil
+}
+
+func (s *fakeService) ResetPeriod() (uint32, error) {
+	s.scm.Lock()
+	defer s.scm.Unlock()
+	if err := s.check(); err != nil {
+		return 0, err
+	}
+	return s.record.resetPeriod, nil
+}
+
+func (s *fakeService) Delete() error {
+	s.scm.Lock()
+	defer s.scm.Unlock()
+	if err := s.check(); err != nil {
+		return err
+	}
+	if s.record.deletePending {
+		return fmt.Errorf("the specified service %s has been marked for deletion", s.record.name)
+	}
+	s.record.deletePending = true
+	return nil
+}
+
+func (s *fakeService) Close() error {
+	s.scm.Lock()
+	defer s.scm.Unlock()
+	if err := s.check(); err != nil {
+		return err
+	}
+	s.closed = true
+	s.record.openHandles--
+	s.scm.removeIfDeletable(s.record)
+	return nil
+}
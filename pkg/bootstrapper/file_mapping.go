@@ -0,0 +1,239 @@
+package bootstrapper
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	clientcmdv1 "k8s.io/client-go/tools/clientcmd/api/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// FileMappingRulesKind is the kind of the file mapping rules file
+	FileMappingRulesKind = "FileMappingRules"
+	// TranslationIdentity writes the contents of the file unchanged
+	TranslationIdentity = "identity"
+	// TranslationCRLF converts the line endings of the file to CRLF
+	TranslationCRLF = "crlf"
+	// TranslationKubeconfig rewrites the paths of the files a kubeconfig refers to, such as its certificate
+	// authority, to where the file mapping rules write them
+	TranslationKubeconfig = "kubeconfig"
+	// TranslationKubeletConfig translates the kubelet configuration for Windows, applying the kubelet configuration
+	// overrides, and lints it
+	TranslationKubeletConfig = "kubelet-config"
+)
+
+// fileTranslations are the translations file mapping rules can apply to the contents of a file, by name. A nil
+// translation writes the contents unchanged.
+var fileTranslations = map[string]translationFunc{
+	TranslationIdentity:      nil,
+	TranslationCRLF:          convertLineEndings,
+	TranslationKubeconfig:    translateKubeconfigPaths,
+	TranslationKubeletConfig: prepAndLintKubeletConf,
+	TranslationCloudConfig:   translateCloudConfig,
+}
+
+// FileMappingRules is the file mapping rules file, which maps files of the ignition file to where they are written on
+// Windows
+type FileMappingRules struct {
+	// APIVersion must be ConfigAPIVersion
+	APIVersion string `json:"apiVersion"`
+	// Kind must be FileMappingRulesKind
+	Kind string `json:"kind"`
+	// Rules are the rules, the first one which matches a file applies to it
+	Rules []FileMappingRule `json:"rules"`
+}
+
+// FileMappingRule maps the files of the ignition file at a path, or matching a glob, to where they are written on
+// Windows
+type FileMappingRule struct {
+	// Source is the path of the file in the ignition file, or a glob, as understood by path.Match, matching the paths
+	// of the files
+	Source string `json:"source"`
+	// Destination is the path, relative to the install directory, the file is written to, or the directory the
+	// files are written to, under their own name, if Source is a glob
+	Destination string `json:"destination"`
+	// Translation is the name of the translation applied to the contents of the file. Defaults to
+	// TranslationIdentity.
+	Translation string `json:"translation,omitempty"`
+}
+
+// isGlob returns true if the source of the rule is a glob
+func (rule *FileMappingRule) isGlob() bool {
+	return strings.ContainsAny(rule.Source, "*?[")
+}
+
+// matches returns true if the rule applies to the ignition file at ignPath
+func (rule *FileMappingRule) matches(ignPath string) bool {
+	if !rule.isGlob() {
+		return ignPath == rule.Source
+	}
+	matched, _ := path.Match(rule.Source, ignPath)
+	return matched
+}
+
+// destination returns the path the ignition file at ignPath, which the rule applies to, is written to
+func (rule *FileMappingRule) destination(installDir, ignPath string) string {
+	dest := rule.Destination
+	if !filepath.IsAbs(dest) {
+		dest = filepath.Join(installDir, dest)
+	}
+	if rule.isGlob() {
+		dest = filepath.Join(dest, path.Base(ignPath))
+	}
+	return dest
+}
+
+// validate returns an error if the rule cannot be applied
+func (rule *FileMappingRule) validate() error {
+	if !strings.HasPrefix(rule.Source, "/") {
+		return fmt.Errorf("source %q must be an absolute path", rule.Source)
+	}
+	if _, err := path.Match(rule.Source, ""); err != nil {
+		return fmt.Errorf("invalid source %q: %s", rule.Source, err)
+	}
+	if rule.Destination == "" {
+		return fmt.Errorf("destination of %s must be given", rule.Source)
+	}
+	// Files are only written to the install directory, so that they can be rolled back and uninstalled
+	clean := filepath.Clean(rule.Destination)
+	if filepath.IsAbs(clean) || filepath.VolumeName(clean) != "" || clean == ".." ||
+		strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("destination %q of %s must be relative to the install directory", rule.Destination,
+			rule.Source)
+	}
+	if _, ok := fileTranslations[rule.Translation]; !ok && rule.Translation != "" {
+		var names []string
+		for name := range fileTranslations {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown translation %q of %s, must be one of %s", rule.Translation, rule.Source,
+			strings.Join(names, ", "))
+	}
+	return nil
+}
+
+// translation returns the translation the rule applies to the contents of the file
+func (rule *FileMappingRule) translation() translationFunc {
+	return fileTranslations[rule.Translation]
+}
+
+// parseFileMappingRules returns the rules in the file mapping rules file, which can be YAML or JSON
+func parseFileMappingRules(contents []byte) ([]FileMappingRule, error) {
+	rules := FileMappingRules{}
+	if err := yaml.UnmarshalStrict(contents, &rules); err != nil {
+		return nil, err
+	}
+	if rules.APIVersion != ConfigAPIVersion || rules.Kind != FileMappingRulesKind {
+		return nil, fmt.Errorf("unsupported file mapping rules %s %s, must be %s %s", rules.APIVersion, rules.Kind,
+			ConfigAPIVersion, FileMappingRulesKind)
+	}
+	for i := range rules.Rules {
+		if err := rules.Rules[i].validate(); err != nil {
+			return nil, err
+		}
+	}
+	return rules.Rules, nil
+}
+
+// SetFileMappings sets the file holding the rules which map files of the ignition file, other than the ones the
+// kubelet needs, to where they are written on Windows. The file is a FileMappingRules, in YAML or JSON. The built-in
+// rules for the kubelet configuration, bootstrap kubeconfig and kubelet CA take precedence over its rules.
+func (wmcb *winNodeBootstrapper) SetFileMappings(path string) error {
+	if path == "" {
+		wmcb.fileMappings = nil
+		return nil
+	}
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read file mapping rules: %s", err)
+	}
+	rules, err := parseFileMappingRules(contents)
+	if err != nil {
+		return fmt.Errorf("invalid file mapping rules %s: %s", path, err)
+	}
+	wmcb.fileMappings = rules
+	return nil
+}
+
+// fileMappingRules returns the rules which map the files of the ignition file to where they are written, the built-in
+// rules for the files the kubelet needs first
+func (wmcb *winNodeBootstrapper) fileMappingRules() []FileMappingRule {
+	rules := []FileMappingRule{
+		{Source: kubeletConfIgnitionPath, Destination: wmcb.kubeletConfPath, Translation: TranslationKubeletConfig},
+		{Source: "/etc/kubernetes/kubeconfig", Destination: "bootstrap-kubeconfig", Translation: TranslationKubeconfig},
+		{Source: "/etc/kubernetes/kubelet-ca.crt", Destination: "kubelet-ca.crt"},
+		{Source: cloudConfigIgnitionPath, Destination: cloudConfigFile, Translation: TranslationCloudConfig},
+	}
+	return append(rules, wmcb.fileMappings...)
+}
+
+// mapIgnitionFile returns the rule which applies to the ignition file at ignPath, and the path it is written to. If no
+// rule applies, the file is not written.
+func (wmcb *winNodeBootstrapper) mapIgnitionFile(ignPath string) (*FileMappingRule, string, bool) {
+	for _, rule := range wmcb.fileMappingRules() {
+		if rule.matches(ignPath) {
+			return &rule, rule.destination(wmcb.installDir, ignPath), true
+		}
+	}
+	return nil, "", false
+}
+
+// mappedPaths returns the paths the rules of the file mapping rules file write files to, for them to be removed on
+// uninstall. The files a glob matched are found in the directory they were written to.
+func (wmcb *winNodeBootstrapper) mappedPaths() []string {
+	var paths []string
+	for _, rule := range wmcb.fileMappings {
+		if !rule.isGlob() {
+			paths = append(paths, rule.destination(wmcb.installDir, rule.Source))
+			continue
+		}
+		matches, _ := filepath.Glob(rule.destination(wmcb.installDir, rule.Source))
+		paths = append(paths, matches...)
+	}
+	return paths
+}
+
+// convertLineEndings converts the line endings of the contents to CRLF, leaving the ones which are already CRLF
+func convertLineEndings(_ *winNodeBootstrapper, contents []byte) ([]byte, error) {
+	contents = bytes.Replace(contents, []byte("\r\n"), []byte("\n"), -1)
+	return bytes.Replace(contents, []byte("\n"), []byte("\r\n"), -1), nil
+}
+
+// translateKubeconfigPaths rewrites the paths of the certificate authorities, client certificates and keys, and token
+// files the kubeconfig refers to, to where the file mapping rules write the files. Paths of files which are not mapped
+// are left unchanged, and so is the kubeconfig if none of its paths are mapped.
+func translateKubeconfigPaths(wmcb *winNodeBootstrapper, contents []byte) ([]byte, error) {
+	config := clientcmdv1.Config{}
+	if err := yaml.Unmarshal(contents, &config); err != nil {
+		return nil, fmt.Errorf("could not parse kubeconfig: %s", err)
+	}
+	rewritten := false
+	rewrite := func(path *string) {
+		if *path == "" {
+			return
+		}
+		if _, dest, ok := wmcb.mapIgnitionFile(*path); ok {
+			*path = dest
+			rewritten = true
+		}
+	}
+	for i := range config.Clusters {
+		rewrite(&config.Clusters[i].Cluster.CertificateAuthority)
+	}
+	for i := range config.AuthInfos {
+		rewrite(&config.AuthInfos[i].AuthInfo.ClientCertificate)
+		rewrite(&config.AuthInfos[i].AuthInfo.ClientKey)
+		rewrite(&config.AuthInfos[i].AuthInfo.TokenFile)
+	}
+	if !rewritten {
+		return contents, nil
+	}
+	return yaml.Marshal(config)
+}
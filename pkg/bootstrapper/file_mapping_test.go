@@ -0,0 +1,238 @@
+package bootstrapper
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// testFileMappingsHeader is the header of a file mapping rules file
+const testFileMappingsHeader = "apiVersion: wmcb.openshift.io/v1alpha1\nkind: FileMappingRules\n"
+
+// testKubeconfigWithCAFile is a kubeconfig referring to its certificate authority by path
+const testKubeconfigWithCAFile = `apiVersion: v1
+kind: Config
+clusters:
+- name: cluster
+  cluster:
+    server: https://api-int.example.com:6443
+    certificate-authority: /etc/kubernetes/ca.crt
+users:
+- name: kubelet
+  user:
+    tokenFile: /var/lib/kubelet/token
+contexts:
+- name: kubelet
+  context:
+    cluster: cluster
+    user: kubelet
+current-context: kubelet
+`
+
+// TestParseFileMappingRules tests parsing and validation of the file mapping rules file
+func TestParseFileMappingRules(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		want     []FileMappingRule
+		wantErr  bool
+	}{
+		{name: "Rules", contents: testFileMappingsHeader + "rules:\n" +
+			"- source: /etc/containers/registries.conf\n  destination: registries.conf\n  translation: crlf\n" +
+			"- source: /etc/pki/ca-trust/source/anchors/*.crt\n  destination: ca\n",
+			want: []FileMappingRule{
+				{Source: "/etc/containers/registries.conf", Destination: "registries.conf", Translation: TranslationCRLF},
+				{Source: "/etc/pki/ca-trust/source/anchors/*.crt", Destination: "ca"},
+			}},
+		{name: "JSON", contents: `{"apiVersion":"wmcb.openshift.io/v1alpha1","kind":"FileMappingRules",` +
+			`"rules":[{"source":"/etc/kubernetes/kubeconfig-ca.conf","destination":"kubeconfig-ca.conf",` +
+			`"translation":"kubeconfig"}]}`,
+			want: []FileMappingRule{{Source: "/etc/kubernetes/kubeconfig-ca.conf", Destination: "kubeconfig-ca.conf",
+				Translation: TranslationKubeconfig}}},
+		{name: "Wrong kind", contents: "apiVersion: wmcb.openshift.io/v1alpha1\nkind: BootstrapperConfiguration\n",
+			wantErr: true},
+		{name: "Unknown field", contents: testFileMappingsHeader + "rules:\n- source: /etc/a\n  dest: a\n",
+			wantErr: true},
+		{name: "Unknown translation",
+			contents: testFileMappingsHeader + "rules:\n- source: /etc/a\n  destination: a\n  translation: dos\n",
+			wantErr:  true},
+		{name: "Relative source", contents: testFileMappingsHeader + "rules:\n- source: etc/a\n  destination: a\n",
+			wantErr: true},
+		{name: "Invalid glob", contents: testFileMappingsHeader + "rules:\n- source: /etc/[a\n  destination: a\n",
+			wantErr: true},
+		{name: "No destination", contents: testFileMappingsHeader + "rules:\n- source: /etc/a\n", wantErr: true},
+		{name: "Absolute destination",
+			contents: testFileMappingsHeader + "rules:\n- source: /etc/a\n  destination: /var/a\n", wantErr: true},
+		{name: "Destination outside install directory",
+			contents: testFileMappingsHeader + "rules:\n- source: /etc/a\n  destination: ../a\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := parseFileMappingRules([]byte(tt.contents))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, rules)
+		})
+	}
+}
+
+// TestMapIgnitionFile tests that the first rule which matches a file applies to it, the built-in rules first
+func TestMapIgnitionFile(t *testing.T) {
+	installDir := filepath.Join(os.TempDir(), "k")
+	wmcb := winNodeBootstrapper{installDir: installDir, kubeletConfPath: filepath.Join(installDir, "kubelet.conf"),
+		fileMappings: []FileMappingRule{
+			{Source: "/etc/containers/registries.conf", Destination: "registries.conf", Translation: TranslationCRLF},
+			{Source: "/etc/kubernetes/*", Destination: "kubernetes"},
+			{Source: "/etc/pki/ca-trust/source/anchors/*.crt", Destination: filepath.Join("pki", "anchors")},
+		}}
+	tests := []struct {
+		ignPath         string
+		wantDest        string
+		wantTranslation string
+	}{
+		{ignPath: kubeletConfIgnitionPath, wantDest: "kubelet.conf", wantTranslation: TranslationKubeletConfig},
+		{ignPath: "/etc/kubernetes/kubeconfig", wantDest: "bootstrap-kubeconfig",
+			wantTranslation: TranslationKubeconfig},
+		{ignPath: "/etc/containers/registries.conf", wantDest: "registries.conf", wantTranslation: TranslationCRLF},
+		{ignPath: "/etc/kubernetes/ca.crt", wantDest: filepath.Join("kubernetes", "ca.crt")},
+		{ignPath: "/etc/pki/ca-trust/source/anchors/user-ca.crt",
+			wantDest: filepath.Join("pki", "anchors", "user-ca.crt")},
+		{ignPath: "/etc/pki/ca-trust/source/anchors/user-ca.pem"},
+		{ignPath: "/etc/kubernetes/manifests/pod.yaml"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ignPath, func(t *testing.T) {
+			rule, dest, ok := wmcb.mapIgnitionFile(tt.ignPath)
+			if tt.wantDest == "" {
+				assert.False(t, ok, "file should not be mapped")
+				return
+			}
+			require.True(t, ok, "file is not mapped")
+			assert.Equal(t, filepath.Join(installDir, tt.wantDest), dest)
+			assert.Equal(t, tt.wantTranslation, rule.Translation)
+		})
+	}
+}
+
+// TestConvertLineEndings tests that line endings are converted to CRLF
+func TestConvertLineEndings(t *testing.T) {
+	for contents, want := range map[string]string{
+		"":                      "",
+		"[Global]\nZone = a\n":  "[Global]\r\nZone = a\r\n",
+		"[Global]\r\nZone = a":  "[Global]\r\nZone = a",
+		"mixed\r\nendings\n\n":  "mixed\r\nendings\r\n\r\n",
+		"no line ending at all": "no line ending at all",
+	} {
+		got, err := convertLineEndings(nil, []byte(contents))
+		require.NoError(t, err)
+		assert.Equal(t, want, string(got))
+	}
+}
+
+// TestTranslateKubeconfigPaths tests that the paths a kubeconfig refers to are rewritten to where the files are
+// mapped, and that a kubeconfig without mapped paths is left unchanged
+func TestTranslateKubeconfigPaths(t *testing.T) {
+	installDir := filepath.Join(os.TempDir(), "k")
+	wmcb := &winNodeBootstrapper{installDir: installDir, kubeletConfPath: filepath.Join(installDir, "kubelet.conf")}
+
+	got, err := translateKubeconfigPaths(wmcb, []byte(testKubeconfigWithCAFile))
+	require.NoError(t, err)
+	assert.Equal(t, testKubeconfigWithCAFile, string(got))
+
+	wmcb.fileMappings = []FileMappingRule{{Source: "/etc/kubernetes/*.crt", Destination: "pki"}}
+	got, err = translateKubeconfigPaths(wmcb, []byte(testKubeconfigWithCAFile))
+	require.NoError(t, err)
+	config, err := clientcmd.Load(got)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(installDir, "pki", "ca.crt"), config.Clusters["cluster"].CertificateAuthority)
+	assert.Equal(t, "/var/lib/kubelet/token", config.AuthInfos["kubelet"].TokenFile)
+	assert.Equal(t, "https://api-int.example.com:6443", config.Clusters["cluster"].Server)
+
+	_, err = translateKubeconfigPaths(wmcb, []byte("clusters: ["))
+	assert.Error(t, err)
+}
+
+// TestRunFileMappings tests that Run writes the files the file mapping rules map, and that they are removed on
+// uninstall
+func TestRunFileMappings(t *testing.T) {
+	scm := newFakeSCM()
+	wmcb := newTestBootstrapper(t, scm)
+	testDir := filepath.Dir(wmcb.installDir)
+	defer os.RemoveAll(testDir)
+	wmcb.ignitionFilePath = writeCustomIgnitionFile(t, testDir, map[string]string{
+		"/etc/containers/registries.conf":    "[registries.search]\nregistries = ['quay.io']\n",
+		"/etc/kubernetes/ca.crt":             "cluster CA",
+		"/etc/kubernetes/kubeconfig-ca":      testKubeconfigWithCAFile,
+		"/etc/kubernetes/manifests/pod.yaml": "not mapped",
+		"/etc/ssl/certs/additional.crt":      "additional CA",
+		"/etc/ssl/certs/README":              "not mapped",
+	}, nil)
+	rulesPath := filepath.Join(testDir, "file-mappings.yaml")
+	require.NoError(t, ioutil.WriteFile(rulesPath, []byte(testFileMappingsHeader+"rules:\n"+
+		"- source: /etc/containers/registries.conf\n  destination: registries.conf\n  translation: crlf\n"+
+		"- source: /etc/kubernetes/kubeconfig-ca\n  destination: kubeconfig-ca\n  translation: kubeconfig\n"+
+		"- source: /etc/kubernetes/*.crt\n  destination: .\n"+
+		"- source: /etc/ssl/certs/*.crt\n  destination: certs\n"), 0644))
+	require.NoError(t, wmcb.SetFileMappings(rulesPath))
+
+	require.NoError(t, wmcb.Run(context.Background()))
+	require.NoError(t, wmcb.Disconnect())
+	assertKubeletServiceRunning(t, scm, wmcb.installDir)
+	for path, want := range map[string]string{
+		"registries.conf":                        "[registries.search]\r\nregistries = ['quay.io']\r\n",
+		"ca.crt":                                 "cluster CA",
+		filepath.Join("certs", "additional.crt"): "additional CA",
+		// The built-in rule for the kubelet CA takes precedence over the glob
+		"kubelet-ca.crt": testIgnitionFiles["/etc/kubernetes/kubelet-ca.crt"],
+	} {
+		contents, err := ioutil.ReadFile(filepath.Join(wmcb.installDir, path))
+		require.NoError(t, err)
+		assert.Equal(t, want, string(contents), path)
+	}
+	assert.Equal(t, []string{"additional.crt"}, listDir(t, filepath.Join(wmcb.installDir, "certs")))
+	kubeconfig, err := clientcmd.LoadFromFile(filepath.Join(wmcb.installDir, "kubeconfig-ca"))
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(wmcb.installDir, "ca.crt"), kubeconfig.Clusters["cluster"].CertificateAuthority)
+
+	wmcb, err = newWinNodeBootstrapper(wmcb.installDir, "", "", scm.connect)
+	require.NoError(t, err)
+	wmcb.services.waitTime = 10 * time.Millisecond
+	wmcb.certDir = filepath.Join(testDir, "pki")
+	require.NoError(t, wmcb.SetFileMappings(rulesPath))
+	require.NoError(t, wmcb.Uninstall(context.Background(), UninstallOptions{}))
+	require.NoError(t, wmcb.Disconnect())
+	for _, path := range []string{"registries.conf", "ca.crt", "kubeconfig-ca", filepath.Join("certs", "additional.crt")} {
+		_, err = os.Stat(filepath.Join(wmcb.installDir, path))
+		assert.True(t, os.IsNotExist(err), "%s was not removed", path)
+	}
+}
+
+// TestRunFileMappingsConflict tests that Run fails if two files are mapped to the same destination
+func TestRunFileMappingsConflict(t *testing.T) {
+	scm := newFakeSCM()
+	wmcb := newTestBootstrapper(t, scm)
+	testDir := filepath.Dir(wmcb.installDir)
+	defer os.RemoveAll(testDir)
+	wmcb.ignitionFilePath = writeCustomIgnitionFile(t, testDir, map[string]string{
+		"/etc/kubernetes/ca.crt": "cluster CA",
+		"/etc/ssl/certs/ca.crt":  "other CA",
+	}, nil)
+	wmcb.fileMappings = []FileMappingRule{{Source: "/etc/kubernetes/*.crt", Destination: "certs"},
+		{Source: "/etc/ssl/certs/*.crt", Destination: "certs"}}
+
+	err := wmcb.Run(context.Background())
+	require.Error(t, err)
+	require.NoError(t, wmcb.Disconnect())
+	assert.Equal(t, FailureFiles, FailureCategoryOf(err))
+	assert.Contains(t, err.Error(), "are both mapped to")
+}
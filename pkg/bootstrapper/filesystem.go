@@ -0,0 +1,87 @@
+package bootstrapper
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// FileSystem is the filesystem the bootstrapper installs the files of a run to, and reads the binaries it installs
+// from. It allows the bootstrapper to be driven against a filesystem other than the host's, such as a sandbox.
+type FileSystem interface {
+	// Open opens the file at path for reading
+	Open(path string) (io.ReadCloser, error)
+	// Create creates the file at path for writing, truncating it if it exists
+	Create(path string, perm os.FileMode) (WritableFile, error)
+	// Stat returns the file info of the file at path, following symbolic links
+	Stat(path string) (os.FileInfo, error)
+	// Lstat returns the file info of the file at path, without following symbolic links
+	Lstat(path string) (os.FileInfo, error)
+	// ReadDir returns the file infos of the entries of the directory at path, sorted by name
+	ReadDir(path string) ([]os.FileInfo, error)
+	// MkdirAll makes the directory at path along with its parents, if they do not exist
+	MkdirAll(path string, perm os.FileMode) error
+	// Rename moves the file at from to to, replacing it
+	Rename(from, to string) error
+	// Remove removes the file or empty directory at path
+	Remove(path string) error
+	// RemoveAll removes the file or directory at path, along with its contents. It is not an error if it does not
+	// exist.
+	RemoveAll(path string) error
+}
+
+// WritableFile is a file opened for writing by a FileSystem
+type WritableFile interface {
+	io.WriteCloser
+	// Sync flushes the contents of the file to disk
+	Sync() error
+}
+
+// readFile returns the contents of the file at path on the filesystem
+func readFile(fs FileSystem, path string) ([]byte, error) {
+	file, err := fs.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ioutil.ReadAll(file)
+}
+
+// hostFileSystem is the FileSystem of the host
+type hostFileSystem struct{}
+
+func (hostFileSystem) Open(path string) (io.ReadCloser, error) {
+	return os.Open(path)
+}
+
+func (hostFileSystem) Create(path string, perm os.FileMode) (WritableFile, error) {
+	return os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+}
+
+func (hostFileSystem) Stat(path string) (os.FileInfo, error) {
+	return os.Stat(path)
+}
+
+func (hostFileSystem) Lstat(path string) (os.FileInfo, error) {
+	return os.Lstat(path)
+}
+
+func (hostFileSystem) ReadDir(path string) ([]os.FileInfo, error) {
+	return ioutil.ReadDir(path)
+}
+
+func (hostFileSystem) MkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+func (hostFileSystem) Rename(from, to string) error {
+	return os.Rename(from, to)
+}
+
+func (hostFileSystem) Remove(path string) error {
+	return os.Remove(path)
+}
+
+func (hostFileSystem) RemoveAll(path string) error {
+	return os.RemoveAll(path)
+}
@@ -0,0 +1,107 @@
+package bootstrapper
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// HybridOverlayServiceName is the name of the Windows service the OVN-Kubernetes hybrid overlay node runs under
+	HybridOverlayServiceName = "hybrid-overlay-node"
+	// hybridOverlayBinary is the name the hybrid overlay node binary is installed under
+	hybridOverlayBinary = "hybrid-overlay-node.exe"
+)
+
+// hybridOverlayOptions describes how the hybrid overlay node, which connects the node to the OVN-Kubernetes cluster
+// network, is configured
+type hybridOverlayOptions struct {
+	// binaryPath is the path the hybrid overlay node binary is copied from
+	binaryPath string
+	// clusterSubnets are the hybrid overlay subnets of the cluster network config, from which Windows nodes are given
+	// their pod subnets
+	clusterSubnets []string
+	// vxlanPort is the VXLAN port of the cluster network config. If 0, the hybrid overlay default is used
+	vxlanPort uint16
+}
+
+// SetHybridOverlayOptions enables the hybrid overlay node service. The service binary is copied from binaryPath.
+// clusterSubnets and vxlanPort come from the hybrid overlay config of the cluster network config, where clusterSubnets
+// are given as comma separated CIDRs. A vxlanPort of 0 uses the hybrid overlay default.
+func (wmcb *winNodeBootstrapper) SetHybridOverlayOptions(binaryPath, clusterSubnets string, vxlanPort uint16) error {
+	if binaryPath == "" {
+		return fmt.Errorf("hybrid overlay node binary path must be given")
+	}
+	subnets := splitNonEmpty(clusterSubnets)
+	if len(subnets) == 0 {
+		return fmt.Errorf("hybrid overlay cluster subnets must be given")
+	}
+	for _, subnet := range subnets {
+		if _, _, err := net.ParseCIDR(subnet); err != nil {
+			return fmt.Errorf("invalid hybrid overlay cluster subnet: %s", err)
+		}
+	}
+	wmcb.hybridOverlay = &hybridOverlayOptions{binaryPath: binaryPath, clusterSubnets: subnets, vxlanPort: vxlanPort}
+	return nil
+}
+
+// nodeName returns the name the node registers with, which the hybrid overlay node needs to find its Node object
+func (wmcb *winNodeBootstrapper) nodeName() (string, error) {
+	if name, ok := wmcb.kubeletArgs["hostname-override"]; ok {
+		return name, nil
+	}
+	// The kubelet registers with the lowercase hostname
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", fmt.Errorf("could not get hostname: %s", err)
+	}
+	return strings.ToLower(hostname), nil
+}
+
+// hybridOverlayArgs returns the arguments the hybrid overlay node service is run with
+func (wmcb *winNodeBootstrapper) hybridOverlayArgs() ([]string, error) {
+	nodeName, err := wmcb.nodeName()
+	if err != nil {
+		return nil, err
+	}
+	args := []string{
+		"--node=" + nodeName,
+		"--hybrid-overlay-cluster-subnets=" + strings.Join(wmcb.hybridOverlay.clusterSubnets, ","),
+		"--k8s-kubeconfig=" + wmcb.kubeconfigPath,
+		"--windows-service",
+		"--logfile=" + filepath.Join(wmcb.logDir, "hybrid-overlay.log"),
+	}
+	if wmcb.hybridOverlay.vxlanPort != 0 {
+		args = append(args, "--hybrid-overlay-vxlan-port="+strconv.Itoa(int(wmcb.hybridOverlay.vxlanPort)))
+	}
+	return args, nil
+}
+
+// initializeHybridOverlay copies the hybrid overlay node binary to the install directory through the stage
+func (wmcb *winNodeBootstrapper) initializeHybridOverlay(stage *fileStage) error {
+	err := stage.copyFile(wmcb.hybridOverlay.binaryPath, filepath.Join(wmcb.installDir, hybridOverlayBinary))
+	if err != nil {
+		return fmt.Errorf("could not copy hybrid overlay node: %s", err)
+	}
+	return nil
+}
+
+// hybridOverlayServiceSpec returns the spec of the hybrid overlay node service
+func (wmcb *winNodeBootstrapper) hybridOverlayServiceSpec() (ServiceSpec, error) {
+	args, err := wmcb.hybridOverlayArgs()
+	if err != nil {
+		return ServiceSpec{}, err
+	}
+	return ServiceSpec{
+		Name:                HybridOverlayServiceName,
+		BinaryPath:          filepath.Join(wmcb.installDir, hybridOverlayBinary),
+		Args:                args,
+		Description:         "OpenShift OVN-Kubernetes Hybrid Overlay Node",
+		StartType:           StartAutomatic,
+		RecoveryActions:     wmcb.recoveryActions,
+		RecoveryResetPeriod: wmcb.recoveryResetPeriod,
+	}, nil
+}
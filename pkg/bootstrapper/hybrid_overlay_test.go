@@ -0,0 +1,140 @@
+package bootstrapper
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestSetHybridOverlayOptions tests validation of the hybrid overlay options
+func TestSetHybridOverlayOptions(t *testing.T) {
+	tests := []struct {
+		name           string
+		binaryPath     string
+		clusterSubnets string
+		wantSubnets    []string
+		wantErr        bool
+	}{
+		{name: "Single subnet", binaryPath: "C:\\hybrid-overlay-node.exe", clusterSubnets: "10.132.0.0/14",
+			wantSubnets: []string{"10.132.0.0/14"}},
+		{name: "Multiple subnets", binaryPath: "C:\\hybrid-overlay-node.exe",
+			clusterSubnets: "10.132.0.0/14,,10.140.0.0/14", wantSubnets: []string{"10.132.0.0/14", "10.140.0.0/14"}},
+		{name: "No binary path", clusterSubnets: "10.132.0.0/14", wantErr: true},
+		{name: "No subnets", binaryPath: "C:\\hybrid-overlay-node.exe", wantErr: true},
+		{name: "Invalid subnet", binaryPath: "C:\\hybrid-overlay-node.exe", clusterSubnets: "10.132.0.0",
+			wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wmcb := winNodeBootstrapper{}
+			err := wmcb.SetHybridOverlayOptions(tt.binaryPath, tt.clusterSubnets, 0)
+			if tt.wantErr {
+				assert.Error(t, err)
+				assert.Nil(t, wmcb.hybridOverlay)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantSubnets, wmcb.hybridOverlay.clusterSubnets)
+		})
+	}
+}
+
+// TestHybridOverlayArgs tests the arguments the hybrid overlay node service is run with
+func TestHybridOverlayArgs(t *testing.T) {
+	tests := []struct {
+		name      string
+		vxlanPort uint16
+		want      []string
+	}{
+		{
+			name: "Default VXLAN port",
+			want: []string{"--node=winnode", "--hybrid-overlay-cluster-subnets=10.132.0.0/14",
+				"--k8s-kubeconfig=" + filepath.Join("C:\\k", "kubeconfig"), "--windows-service",
+				"--logfile=" + filepath.Join("C:\\k", "hybrid-overlay.log")},
+		},
+		{
+			name:      "Custom VXLAN port",
+			vxlanPort: 9898,
+			want: []string{"--node=winnode", "--hybrid-overlay-cluster-subnets=10.132.0.0/14",
+				"--k8s-kubeconfig=" + filepath.Join("C:\\k", "kubeconfig"), "--windows-service",
+				"--logfile=" + filepath.Join("C:\\k", "hybrid-overlay.log"), "--hybrid-overlay-vxlan-port=9898"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wmcb := winNodeBootstrapper{
+				installDir:     "C:\\k",
+				logDir:         "C:\\k",
+				kubeconfigPath: filepath.Join("C:\\k", "kubeconfig"),
+				kubeletArgs:    map[string]string{"hostname-override": "winnode"},
+			}
+			require.NoError(t, wmcb.SetHybridOverlayOptions("C:\\hybrid-overlay-node.exe", "10.132.0.0/14",
+				tt.vxlanPort))
+			got, err := wmcb.hybridOverlayArgs()
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+// newTestHybridOverlayBootstrapper returns a test bootstrapper with the hybrid overlay enabled
+func newTestHybridOverlayBootstrapper(t *testing.T, scm *fakeSCM) *winNodeBootstrapper {
+	wmcb := newTestBootstrapper(t, scm)
+	binaryPath := filepath.Join(filepath.Dir(wmcb.installDir), "hybrid-overlay-download.exe")
+	require.NoError(t, ioutil.WriteFile(binaryPath, []byte("hybrid overlay binary"), 0755))
+	require.NoError(t, wmcb.SetHybridOverlayOptions(binaryPath, "10.132.0.0/14", 0))
+	return wmcb
+}
+
+// TestRunHybridOverlay tests that Run installs the hybrid overlay node service and starts it before the kubelet,
+// without making the kubelet service depend on it
+func TestRunHybridOverlay(t *testing.T) {
+	scm := newFakeSCM()
+	wmcb := newTestHybridOverlayBootstrapper(t, scm)
+	defer os.RemoveAll(filepath.Dir(wmcb.installDir))
+
+	require.NoError(t, wmcb.Run(context.Background()))
+	require.NoError(t, wmcb.Disconnect())
+	assertKubeletServiceRunning(t, scm, wmcb.installDir)
+	assert.Empty(t, scm.get(KubeletServiceName).config.Dependencies)
+
+	hybridOverlay := scm.get(HybridOverlayServiceName)
+	require.NotNil(t, hybridOverlay, "hybrid overlay node service is not installed")
+	assert.Equal(t, ServiceRunning, hybridOverlay.state)
+	assert.Equal(t, filepath.Join(wmcb.installDir, hybridOverlayBinary), hybridOverlay.exePath())
+	assert.Contains(t, hybridOverlay.args, "--hybrid-overlay-cluster-subnets=10.132.0.0/14")
+	assert.Equal(t, 0, hybridOverlay.openHandles, "handles to the hybrid overlay service were leaked")
+	assert.FileExists(t, filepath.Join(wmcb.installDir, hybridOverlayBinary))
+	assert.Equal(t, []string{HybridOverlayServiceName, KubeletServiceName}, scm.started)
+}
+
+// TestRunHybridOverlayIdempotent tests that Run reconfigures the existing services, stopping the kubelet before the
+// hybrid overlay node it is started after
+func TestRunHybridOverlayIdempotent(t *testing.T) {
+	scm := newFakeSCM()
+	wmcb := newTestHybridOverlayBootstrapper(t, scm)
+	defer os.RemoveAll(filepath.Dir(wmcb.installDir))
+	require.NoError(t, wmcb.Run(context.Background()))
+	require.NoError(t, wmcb.Disconnect())
+
+	wmcb, err := newWinNodeBootstrapper(wmcb.installDir, wmcb.ignitionFilePath, wmcb.initialKubeletPath, scm.connect)
+	require.NoError(t, err)
+	wmcb.services.waitTime = 10 * time.Millisecond
+	require.NoError(t, wmcb.SetHybridOverlayOptions(filepath.Join(filepath.Dir(wmcb.installDir),
+		"hybrid-overlay-download.exe"), "10.140.0.0/14", 0))
+	require.NoError(t, wmcb.Run(context.Background()))
+	require.NoError(t, wmcb.Disconnect())
+
+	assertKubeletServiceRunning(t, scm, wmcb.installDir)
+	hybridOverlay := scm.get(HybridOverlayServiceName)
+	require.NotNil(t, hybridOverlay, "hybrid overlay node service is not installed")
+	assert.Equal(t, ServiceRunning, hybridOverlay.state)
+	assert.Contains(t, hybridOverlay.args, "--hybrid-overlay-cluster-subnets=10.140.0.0/14")
+	assert.Equal(t, []string{KubeletServiceName, HybridOverlayServiceName}, scm.stopped)
+}
@@ -0,0 +1,222 @@
+package bootstrapper
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+
+	"github.com/coreos/go-semver/semver"
+	ignitionv2 "github.com/coreos/ignition/config/v2_3"
+	"github.com/vincent-petithory/dataurl"
+)
+
+/*
+	The worker ignition file is served in different spec versions depending on the version of the cluster. Each
+	supported spec version has its own parser, which maps the parts of the config the bootstrapper consumes onto
+	ignitionConfig, so the rest of the bootstrapper does not need to be aware of the spec version.
+*/
+
+var (
+	// minIgnitionV2Version and maxIgnitionV2Version bound the spec 2.x versions we can parse
+	minIgnitionV2Version = semver.Version{Major: 2}
+	maxIgnitionV2Version = semver.Version{Major: 2, Minor: 3}
+	// minIgnitionV3Version and maxIgnitionV3Version bound the spec 3.x versions we can parse
+	minIgnitionV3Version = semver.Version{Major: 3}
+	maxIgnitionV3Version = semver.Version{Major: 3, Minor: 2}
+)
+
+// ignitionConfig is the spec version independent representation of the files and systemd units described by an
+// ignition config
+type ignitionConfig struct {
+	// files are the files ignition would write to the node
+	files []ignitionFile
+	// units are the systemd units ignition would configure on the node
+	units []ignitionUnit
+}
+
+// ignitionFile is a file described by an ignition config
+type ignitionFile struct {
+	// path is the absolute path of the file on a Linux node
+	path string
+	// source is the URL of the file contents. Only data URLs are supported
+	source string
+	// compression is the compression applied to the contents, either "" or "gzip"
+	compression string
+}
+
+// ignitionUnit is a systemd unit described by an ignition config
+type ignitionUnit struct {
+	name     string
+	contents string
+	// dropins are the drop-in files of the unit, in the order they are given in the config
+	dropins []ignitionDropin
+}
+
+// ignitionDropin is a systemd drop-in for an ignitionUnit
+type ignitionDropin struct {
+	name     string
+	contents string
+}
+
+// ignitionV3Config is the subset of the spec 3.x config schema that the bootstrapper consumes. The fields used
+// here have not changed between 3.0.0 and 3.2.0.
+// https://github.com/coreos/ignition/blob/master/docs/configuration-v3_2.md
+type ignitionV3Config struct {
+	Ignition struct {
+		Version string `json:"version"`
+	} `json:"ignition"`
+	Storage struct {
+		Files []struct {
+			Path     string `json:"path"`
+			Contents struct {
+				Source      *string `json:"source"`
+				Compression *string `json:"compression"`
+			} `json:"contents"`
+		} `json:"files"`
+	} `json:"storage"`
+	Systemd struct {
+		Units []struct {
+			Name     string  `json:"name"`
+			Contents *string `json:"contents"`
+			Dropins  []struct {
+				Name     string  `json:"name"`
+				Contents *string `json:"contents"`
+			} `json:"dropins"`
+		} `json:"units"`
+	} `json:"systemd"`
+}
+
+// contents decodes the data URL source of the file and decompresses it if needed. A file without a source is empty.
+func (f ignitionFile) contents() ([]byte, error) {
+	if f.source == "" {
+		return []byte{}, nil
+	}
+	decoded, err := dataurl.DecodeString(f.source)
+	if err != nil {
+		return nil, err
+	}
+	switch f.compression {
+	case "":
+		return decoded.Data, nil
+	case "gzip":
+		reader, err := gzip.NewReader(bytes.NewReader(decoded.Data))
+		if err != nil {
+			return nil, fmt.Errorf("could not decompress contents: %s", err)
+		}
+		defer reader.Close()
+		return ioutil.ReadAll(reader)
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", f.compression)
+	}
+}
+
+// ignitionVersion returns the spec version of the raw ignition config
+func ignitionVersion(rawConfig []byte) (*semver.Version, error) {
+	var versionOnly struct {
+		Ignition struct {
+			Version string `json:"version"`
+		} `json:"ignition"`
+	}
+	if err := json.Unmarshal(rawConfig, &versionOnly); err != nil {
+		return nil, fmt.Errorf("could not decode ignition config: %s", err)
+	}
+	if versionOnly.Ignition.Version == "" {
+		return nil, fmt.Errorf("ignition config does not specify ignition.version")
+	}
+	version, err := semver.NewVersion(versionOnly.Ignition.Version)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ignition.version %q: %s", versionOnly.Ignition.Version, err)
+	}
+	return version, nil
+}
+
+// parseIgnition detects the spec version of the raw ignition config and parses it with the matching parser
+func parseIgnition(rawConfig []byte) (ignitionConfig, error) {
+	version, err := ignitionVersion(rawConfig)
+	if err != nil {
+		return ignitionConfig{}, err
+	}
+	switch {
+	case inVersionRange(*version, minIgnitionV2Version, maxIgnitionV2Version):
+		return parseIgnitionV2(rawConfig)
+	case inVersionRange(*version, minIgnitionV3Version, maxIgnitionV3Version):
+		return parseIgnitionV3(rawConfig)
+	default:
+		return ignitionConfig{}, fmt.Errorf("unsupported ignition spec version %s, supported versions are %s to %s "+
+			"and %s to %s", version, minIgnitionV2Version, maxIgnitionV2Version, minIgnitionV3Version,
+			maxIgnitionV3Version)
+	}
+}
+
+// inVersionRange returns true if min <= version <= max. Pre-release versions, such as 3.1.0-experimental, are
+// never in range.
+func inVersionRange(version, min, max semver.Version) bool {
+	return version.PreRelease == "" && !version.LessThan(min) && !max.LessThan(version)
+}
+
+// parseIgnitionV2 parses a spec 2.x ignition config
+func parseIgnitionV2(rawConfig []byte) (ignitionConfig, error) {
+	configuration, _, err := ignitionv2.Parse(rawConfig)
+	if err != nil {
+		return ignitionConfig{}, fmt.Errorf("could not parse spec 2.x ignition config: %s", err)
+	}
+	var config ignitionConfig
+	for _, file := range configuration.Storage.Files {
+		config.files = append(config.files, ignitionFile{
+			path:        file.Node.Path,
+			source:      file.Contents.Source,
+			compression: file.Contents.Compression,
+		})
+	}
+	for _, unit := range configuration.Systemd.Units {
+		ignUnit := ignitionUnit{name: unit.Name, contents: unit.Contents}
+		for _, dropin := range unit.Dropins {
+			ignUnit.dropins = append(ignUnit.dropins, ignitionDropin{name: dropin.Name, contents: dropin.Contents})
+		}
+		config.units = append(config.units, ignUnit)
+	}
+	return config, nil
+}
+
+// parseIgnitionV3 parses a spec 3.x ignition config
+func parseIgnitionV3(rawConfig []byte) (ignitionConfig, error) {
+	var configuration ignitionV3Config
+	if err := json.Unmarshal(rawConfig, &configuration); err != nil {
+		return ignitionConfig{}, fmt.Errorf("could not parse spec 3.x ignition config: %s", err)
+	}
+	var config ignitionConfig
+	for _, file := range configuration.Storage.Files {
+		if !path.IsAbs(file.Path) {
+			return ignitionConfig{}, fmt.Errorf("invalid spec 3.x ignition config: file path %q is not absolute",
+				file.Path)
+		}
+		config.files = append(config.files, ignitionFile{
+			path:        file.Path,
+			source:      stringValue(file.Contents.Source),
+			compression: stringValue(file.Contents.Compression),
+		})
+	}
+	for _, unit := range configuration.Systemd.Units {
+		if unit.Name == "" {
+			return ignitionConfig{}, fmt.Errorf("invalid spec 3.x ignition config: unit without a name")
+		}
+		ignUnit := ignitionUnit{name: unit.Name, contents: stringValue(unit.Contents)}
+		for _, dropin := range unit.Dropins {
+			ignUnit.dropins = append(ignUnit.dropins, ignitionDropin{name: dropin.Name,
+				contents: stringValue(dropin.Contents)})
+		}
+		config.units = append(config.units, ignUnit)
+	}
+	return config, nil
+}
+
+// stringValue returns the value s points to, or "" if s is nil
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
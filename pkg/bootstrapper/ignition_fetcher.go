@@ -0,0 +1,194 @@
+package bootstrapper
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// machineConfigServerWorkerPath is the path the Machine Config Server serves the worker ignition config on
+	machineConfigServerWorkerPath = "/config/worker"
+	// ignitionCacheFile is the name of the file, in the install directory, the fetched ignition config is cached to
+	ignitionCacheFile = "worker.ign"
+	// ignitionFetchAttempts is the number of times we try to fetch the ignition config before giving up
+	ignitionFetchAttempts = 5
+	// ignitionFetchBackoff is the time to wait after the first failed attempt, it is doubled after each attempt
+	ignitionFetchBackoff = 2 * time.Second
+	// ignitionFetchTimeout is the time to wait for a single request to complete
+	ignitionFetchTimeout = 30 * time.Second
+)
+
+// ignitionFetcher fetches the worker ignition config from the Machine Config Server
+type ignitionFetcher struct {
+	// url is the URL the ignition config is served on
+	url string
+	// cachePath is the path the fetched ignition config is written to
+	cachePath string
+	// attempts is the number of times we try to fetch the ignition config before giving up
+	attempts int
+	// backoff is the time to wait after the first failed attempt
+	backoff time.Duration
+	// fromCache is true if the last fetch failed and the cached ignition config was returned instead
+	fromCache bool
+	client    *http.Client
+}
+
+// NewIgnitionFetcher takes the URL of the Machine Config Server, the path to the CA bundle used to verify it and the
+// directory to cache the ignition config in, and generates the ignitionFetcher object. If the URL has no path the
+// worker ignition config is fetched. If caBundlePath is empty the system roots are used to verify the server.
+func NewIgnitionFetcher(ignitionURL, caBundlePath, cacheDir string) (*ignitionFetcher, error) {
+	u, err := url.Parse(ignitionURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ignition URL %s: %s", ignitionURL, err)
+	}
+	if u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid ignition URL %s: the Machine Config Server must be reached over https",
+			ignitionURL)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = machineConfigServerWorkerPath
+	}
+
+	tlsConfig := &tls.Config{}
+	if caBundlePath != "" {
+		caBundle, err := ioutil.ReadFile(caBundlePath)
+		if err != nil {
+			return nil, fmt.Errorf("could not read CA bundle: %s", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caBundle) {
+			return nil, fmt.Errorf("no PEM encoded certificates found in CA bundle %s", caBundlePath)
+		}
+	}
+	return &ignitionFetcher{
+		url:       u.String(),
+		cachePath: filepath.Join(cacheDir, ignitionCacheFile),
+		attempts:  ignitionFetchAttempts,
+		backoff:   ignitionFetchBackoff,
+		client: &http.Client{
+			Timeout:   ignitionFetchTimeout,
+			Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig},
+		},
+	}, nil
+}
+
+// SetTimeout sets the time to wait for a single request for the ignition config to complete
+func (f *ignitionFetcher) SetTimeout(timeout time.Duration) {
+	f.client.Timeout = timeout
+}
+
+// Fetch fetches the ignition config, retrying with an exponential backoff until ctx is done, and caches it. It returns
+// the path of the cached ignition config. If the ignition config cannot be fetched, a previously cached one is used.
+func (f *ignitionFetcher) Fetch(ctx context.Context) (string, error) {
+	if _, err := f.Ignition(ctx); err != nil {
+		return "", err
+	}
+	return f.cachePath, nil
+}
+
+// Ignition fetches the ignition config, retrying with an exponential backoff until ctx is done, and caches it. It
+// returns the contents of the ignition config, which makes the fetcher an IgnitionSource. If the ignition config
+// cannot be fetched, a previously cached one is returned.
+func (f *ignitionFetcher) Ignition(ctx context.Context) ([]byte, error) {
+	f.fromCache = false
+	var contents []byte
+	var err error
+	var retry bool
+	backoff := f.backoff
+	for attempt := 1; attempt <= f.attempts; attempt++ {
+		contents, retry, err = f.fetchOnce(ctx)
+		if err == nil || !retry {
+			break
+		}
+		if attempt < f.attempts {
+			select {
+			case <-ctx.Done():
+				return nil, fmt.Errorf("could not fetch ignition config from %s: %s, and gave up: %s", f.url, err,
+					ctx.Err())
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+	}
+	if err != nil {
+		err = fmt.Errorf("could not fetch ignition config from %s: %s", f.url, err)
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		return f.cached(err)
+	}
+
+	if err = os.MkdirAll(filepath.Dir(f.cachePath), 0755); err != nil {
+		return nil, fmt.Errorf("could not make ignition cache directory: %s", err)
+	}
+	// Write to a temporary file first, so that a previously cached config is never left partially written
+	tmpPath := f.cachePath + ".tmp"
+	if err = ioutil.WriteFile(tmpPath, contents, 0600); err != nil {
+		return nil, fmt.Errorf("could not cache ignition config: %s", err)
+	}
+	if err = os.Rename(tmpPath, f.cachePath); err != nil {
+		return nil, fmt.Errorf("could not cache ignition config: %s", err)
+	}
+	return contents, nil
+}
+
+// cached returns the cached ignition config in place of the one which could not be fetched because of fetchErr. It
+// returns fetchErr if there is no valid cached ignition config.
+func (f *ignitionFetcher) cached(fetchErr error) ([]byte, error) {
+	contents, err := ioutil.ReadFile(f.cachePath)
+	if err != nil {
+		return nil, fetchErr
+	}
+	if _, err = ignitionVersion(contents); err != nil {
+		return nil, fetchErr
+	}
+	log.Info("using the cached ignition config", "path", f.cachePath, "reason", fetchErr.Error())
+	f.fromCache = true
+	return contents, nil
+}
+
+// FromCache returns true if the last fetch failed and the cached ignition config was used instead
+func (f *ignitionFetcher) FromCache() bool {
+	return f.fromCache
+}
+
+// fetchOnce makes a single request for the ignition config. If the request failed, it also returns if the failure
+// could be transient, in which case the request should be retried.
+func (f *ignitionFetcher) fetchOnce(ctx context.Context) ([]byte, bool, error) {
+	req, err := http.NewRequest(http.MethodGet, f.url, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	req = req.WithContext(ctx)
+	// The Machine Config Server serves the config in the spec version requested by the Accept header, so we ask for
+	// the newest version we can parse
+	req.Header.Set("Accept", fmt.Sprintf("application/vnd.coreos.ignition+json;version=%s, */*;q=0.1",
+		maxIgnitionV3Version))
+	resp, err := f.client.Do(req)
+	if err != nil {
+		// A request cancelled along with ctx is not retried
+		return nil, ctx.Err() == nil, err
+	}
+	defer resp.Body.Close()
+	contents, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, true, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		// Server errors are seen while the Machine Config Server is starting, anything else will not go away on a retry
+		return nil, resp.StatusCode >= http.StatusInternalServerError, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	// Ensure we got an ignition config before we overwrite the cached copy
+	if _, err = ignitionVersion(contents); err != nil {
+		return nil, false, err
+	}
+	return contents, false, nil
+}
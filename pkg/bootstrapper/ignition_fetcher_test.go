@@ -0,0 +1,217 @@
+package bootstrapper
+
+import (
+	"context"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestMachineConfigServer starts a TLS server which serves the worker ignition config after failing the given
+// number of requests with the given status. It returns the server and the path to a CA bundle which trusts it.
+func newTestMachineConfigServer(t *testing.T, dir string, failures int32, failureStatus int,
+	requests *int32) (*httptest.Server, string) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(requests, 1) <= failures {
+			w.WriteHeader(failureStatus)
+			return
+		}
+		if r.URL.Path != machineConfigServerWorkerPath {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Header.Get("Accept") != "application/vnd.coreos.ignition+json;version=3.2.0, */*;q=0.1" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(testIgnitionConfig("3.2.0")))
+	}))
+	caBundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	caBundlePath := filepath.Join(dir, "ca.crt")
+	require.NoError(t, ioutil.WriteFile(caBundlePath, caBundle, 0644))
+	return server, caBundlePath
+}
+
+// TestIgnitionFetcherFetch tests fetching and caching the ignition config from the Machine Config Server
+func TestIgnitionFetcherFetch(t *testing.T) {
+	tests := []struct {
+		name          string
+		failures      int32
+		failureStatus int
+		wantRequests  int32
+		wantErr       bool
+	}{
+		{
+			name:         "Fetched on the first attempt",
+			wantRequests: 1,
+		},
+		{
+			name:          "Server errors are retried",
+			failures:      3,
+			failureStatus: http.StatusServiceUnavailable,
+			wantRequests:  4,
+		},
+		{
+			name:          "Server errors on every attempt",
+			failures:      ignitionFetchAttempts,
+			failureStatus: http.StatusInternalServerError,
+			wantRequests:  ignitionFetchAttempts,
+			wantErr:       true,
+		},
+		{
+			name:          "Client errors are not retried",
+			failures:      1,
+			failureStatus: http.StatusForbidden,
+			wantRequests:  1,
+			wantErr:       true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "wmcb")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+			var requests int32
+			server, caBundlePath := newTestMachineConfigServer(t, dir, tt.failures, tt.failureStatus, &requests)
+			defer server.Close()
+
+			fetcher, err := NewIgnitionFetcher(server.URL, caBundlePath, dir)
+			require.NoError(t, err)
+			fetcher.backoff = time.Millisecond
+			path, err := fetcher.Fetch(context.Background())
+			assert.Equal(t, tt.wantRequests, atomic.LoadInt32(&requests))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, filepath.Join(dir, ignitionCacheFile), path)
+			contents, err := ioutil.ReadFile(path)
+			require.NoError(t, err)
+			config, err := parseIgnition(contents)
+			require.NoError(t, err)
+			assert.Len(t, config.files, len(testIgnitionFiles))
+		})
+	}
+}
+
+// TestIgnitionFetcherCancelled tests that the fetcher stops retrying once ctx is done
+func TestIgnitionFetcherCancelled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wmcb")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	var requests int32
+	server, caBundlePath := newTestMachineConfigServer(t, dir, ignitionFetchAttempts, http.StatusServiceUnavailable,
+		&requests)
+	defer server.Close()
+
+	fetcher, err := NewIgnitionFetcher(server.URL, caBundlePath, dir)
+	require.NoError(t, err)
+	fetcher.backoff = time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = fetcher.Ignition(ctx)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), context.DeadlineExceeded.Error())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+}
+
+// TestIgnitionFetcherCached tests that the cached ignition config is used once the Machine Config Server cannot be
+// reached, and that the report of the run notes it
+func TestIgnitionFetcherCached(t *testing.T) {
+	scm := newFakeSCM()
+	dir, options := testOptions(t, scm)
+	defer os.RemoveAll(dir)
+	var requests int32
+	server, caBundlePath := newTestMachineConfigServer(t, dir, 0, 0, &requests)
+	defer server.Close()
+	fetcher, err := NewIgnitionFetcher(server.URL, caBundlePath, filepath.Join(dir, "k"))
+	require.NoError(t, err)
+	fetcher.backoff = time.Millisecond
+	b, err := New(append(options, WithIgnitionSource(fetcher))...)
+	require.NoError(t, err)
+	defer b.Close()
+
+	_, err = b.Apply(context.Background())
+	require.NoError(t, err)
+	assert.False(t, fetcher.FromCache())
+	assert.False(t, b.Report().IgnitionFromCache)
+
+	server.Close()
+	_, err = b.Apply(context.Background())
+	require.NoError(t, err)
+	assert.True(t, fetcher.FromCache())
+	assert.True(t, b.Report().IgnitionFromCache)
+
+	// Without a cached ignition config, the run fails
+	require.NoError(t, os.Remove(filepath.Join(dir, "k", ignitionCacheFile)))
+	_, err = b.Apply(context.Background())
+	require.Error(t, err)
+	assert.Equal(t, FailureIgnition, FailureCategoryOf(err))
+}
+
+// TestIgnitionFetcherUntrustedServer tests that the server certificate must be verified
+func TestIgnitionFetcherUntrustedServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wmcb")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	var requests int32
+	server, _ := newTestMachineConfigServer(t, dir, 0, 0, &requests)
+	defer server.Close()
+
+	// Without the CA bundle, the self signed test server is not trusted by the system roots
+	fetcher, err := NewIgnitionFetcher(server.URL+machineConfigServerWorkerPath, "", dir)
+	require.NoError(t, err)
+	fetcher.backoff = time.Millisecond
+	_, err = fetcher.Fetch(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&requests))
+	_, err = os.Stat(filepath.Join(dir, ignitionCacheFile))
+	assert.True(t, os.IsNotExist(err), "nothing should be cached")
+}
+
+// TestNewIgnitionFetcher tests validation of the fetcher arguments
+func TestNewIgnitionFetcher(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		wantURL string
+		wantErr bool
+	}{
+		{
+			name:    "Server URL",
+			url:     "https://api-int.cluster.example.com:22623",
+			wantURL: "https://api-int.cluster.example.com:22623/config/worker",
+		},
+		{
+			name:    "Full URL",
+			url:     "https://api-int.cluster.example.com:22623/config/worker",
+			wantURL: "https://api-int.cluster.example.com:22623/config/worker",
+		},
+		{
+			name:    "Plain http",
+			url:     "http://api-int.cluster.example.com:22623/config/worker",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fetcher, err := NewIgnitionFetcher(tt.url, "", "C:\\k")
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantURL, fetcher.url)
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package bootstrapper
+
+import (
+	"context"
+	"io/ioutil"
+)
+
+// IgnitionSource provides the worker ignition config a node is bootstrapped with. The ignition fetcher returned by
+// NewIgnitionFetcher is an IgnitionSource which fetches it from the Machine Config Server.
+type IgnitionSource interface {
+	// Ignition returns the contents of the ignition config, in any of the supported spec versions
+	Ignition(ctx context.Context) ([]byte, error)
+}
+
+// cachedIgnitionSource is an IgnitionSource which returns a cached copy of the ignition config when it cannot get it
+type cachedIgnitionSource interface {
+	IgnitionSource
+	// FromCache returns true if the ignition config last returned was the cached copy
+	FromCache() bool
+}
+
+// IgnitionFromFile returns an IgnitionSource which reads the ignition config from the file at path
+func IgnitionFromFile(path string) IgnitionSource {
+	return ignitionFileSource(path)
+}
+
+// ignitionFileSource is an IgnitionSource reading the ignition config from a file
+type ignitionFileSource string
+
+// Ignition reads the ignition config from the file
+func (path ignitionFileSource) Ignition(ctx context.Context) ([]byte, error) {
+	return ioutil.ReadFile(string(path))
+}
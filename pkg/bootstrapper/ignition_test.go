@@ -0,0 +1,141 @@
+package bootstrapper
+
+import (
+	"bytes"
+	"compress/gzip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vincent-petithory/dataurl"
+)
+
+// TestParseIgnition tests that each supported spec version is mapped onto the same ignitionConfig
+func TestParseIgnition(t *testing.T) {
+	want := ignitionConfig{
+		files: []ignitionFile{{path: "/etc/kubernetes/kubelet-ca.crt", source: "data:,ca"}},
+		units: []ignitionUnit{{
+			name:     "kubelet.service",
+			contents: "[Service]\nExecStart=/usr/bin/hyperkube kubelet\n",
+			dropins:  []ignitionDropin{{name: "10-mco-default-env.conf", contents: "[Service]\nEnvironment=A=B\n"}},
+		}},
+	}
+	tests := []struct {
+		name string
+		in   string
+	}{
+		{
+			name: "Spec 2.2",
+			in:   `{"ignition":{"version":"2.2.0"},"storage":{"files":[{"filesystem":"root","path":"/etc/kubernetes/kubelet-ca.crt","contents":{"source":"data:,ca"}}]},"systemd":{"units":[{"name":"kubelet.service","contents":"[Service]\nExecStart=/usr/bin/hyperkube kubelet\n","dropins":[{"name":"10-mco-default-env.conf","contents":"[Service]\nEnvironment=A=B\n"}]}]}}`,
+		},
+		{
+			name: "Spec 2.3",
+			in:   `{"ignition":{"version":"2.3.0"},"storage":{"files":[{"filesystem":"root","path":"/etc/kubernetes/kubelet-ca.crt","contents":{"source":"data:,ca"}}]},"systemd":{"units":[{"name":"kubelet.service","contents":"[Service]\nExecStart=/usr/bin/hyperkube kubelet\n","dropins":[{"name":"10-mco-default-env.conf","contents":"[Service]\nEnvironment=A=B\n"}]}]}}`,
+		},
+		{
+			name: "Spec 3.0",
+			in:   `{"ignition":{"version":"3.0.0"},"storage":{"files":[{"path":"/etc/kubernetes/kubelet-ca.crt","contents":{"source":"data:,ca"}}]},"systemd":{"units":[{"name":"kubelet.service","contents":"[Service]\nExecStart=/usr/bin/hyperkube kubelet\n","dropins":[{"name":"10-mco-default-env.conf","contents":"[Service]\nEnvironment=A=B\n"}]}]}}`,
+		},
+		{
+			name: "Spec 3.2",
+			in:   `{"ignition":{"version":"3.2.0"},"storage":{"files":[{"path":"/etc/kubernetes/kubelet-ca.crt","contents":{"compression":"","source":"data:,ca"},"overwrite":true}]},"systemd":{"units":[{"name":"kubelet.service","enabled":true,"contents":"[Service]\nExecStart=/usr/bin/hyperkube kubelet\n","dropins":[{"name":"10-mco-default-env.conf","contents":"[Service]\nEnvironment=A=B\n"}]}]}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseIgnition([]byte(tt.in))
+			require.NoError(t, err)
+			assert.Equal(t, want, got)
+		})
+	}
+}
+
+// TestParseIgnitionErrors tests that configs we cannot handle are rejected with a clear error
+func TestParseIgnitionErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		wantErr string
+	}{
+		{
+			name:    "Missing version",
+			in:      `{"ignition":{},"storage":{}}`,
+			wantErr: "ignition config does not specify ignition.version",
+		},
+		{
+			name:    "Invalid version",
+			in:      `{"ignition":{"version":"three"}}`,
+			wantErr: `invalid ignition.version "three"`,
+		},
+		{
+			name:    "Spec 1",
+			in:      `{"ignitionVersion":1,"ignition":{"version":"1.0.0"}}`,
+			wantErr: "unsupported ignition spec version 1.0.0, supported versions are 2.0.0 to 2.3.0 and 3.0.0 to 3.2.0",
+		},
+		{
+			name:    "Newer spec 3",
+			in:      `{"ignition":{"version":"3.5.0"}}`,
+			wantErr: "unsupported ignition spec version 3.5.0",
+		},
+		{
+			name:    "Experimental spec",
+			in:      `{"ignition":{"version":"3.1.0-experimental"}}`,
+			wantErr: "unsupported ignition spec version 3.1.0-experimental",
+		},
+		{
+			name:    "Not JSON",
+			in:      `#cloud-config`,
+			wantErr: "could not decode ignition config",
+		},
+		{
+			name:    "Relative spec 3 file path",
+			in:      `{"ignition":{"version":"3.0.0"},"storage":{"files":[{"path":"etc/kubernetes/kubelet-ca.crt"}]}}`,
+			wantErr: `file path "etc/kubernetes/kubelet-ca.crt" is not absolute`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseIgnition([]byte(tt.in))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+// TestIgnitionFileContents tests decoding the contents of ignition files
+func TestIgnitionFileContents(t *testing.T) {
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	_, err := writer.Write([]byte("compressed contents"))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	tests := []struct {
+		name string
+		in   ignitionFile
+		want string
+	}{
+		{
+			name: "Uncompressed",
+			in:   ignitionFile{source: "data:,plain%20contents"},
+			want: "plain contents",
+		},
+		{
+			name: "Gzip compressed",
+			in:   ignitionFile{source: dataurl.EncodeBytes(compressed.Bytes()), compression: "gzip"},
+			want: "compressed contents",
+		},
+		{
+			name: "No source",
+			in:   ignitionFile{},
+			want: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.in.contents()
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, string(got))
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package bootstrapper
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// kubeletArgAction is what is done with a kubelet flag, found in the Linux kubelet unit, when running on Windows
+type kubeletArgAction int
+
+const (
+	// kubeletArgDeny drops the flag
+	kubeletArgDeny kubeletArgAction = iota
+	// kubeletArgAllow passes the flag through unchanged
+	kubeletArgAllow
+	// kubeletArgTranslate passes the flag through after changing its value for Windows
+	kubeletArgTranslate
+)
+
+// kubeletArgTranslationFunc takes the value of a kubelet flag and changes it for use on Windows
+type kubeletArgTranslationFunc func(*winNodeBootstrapper, string) (string, error)
+
+// kubeletArgRule describes how a kubelet flag is handled on Windows
+type kubeletArgRule struct {
+	action kubeletArgAction
+	// translate is used by kubeletArgTranslate rules
+	translate kubeletArgTranslationFunc
+}
+
+var (
+	allowKubeletArg = kubeletArgRule{action: kubeletArgAllow}
+	denyKubeletArg  = kubeletArgRule{action: kubeletArgDeny}
+)
+
+// kubeletArgRules determines which of the kubelet flags set in the Linux kubelet unit are passed to the Windows
+// kubelet. Flags which are not listed are dropped, as a Linux specific flag can prevent the kubelet from starting.
+var kubeletArgRules = map[string]kubeletArgRule{
+	// Flags that are valid on Windows
+	"cloud-provider":                  allowKubeletArg,
+	"enable-controller-attach-detach": allowKubeletArg,
+	"feature-gates":                   allowKubeletArg,
+	"hostname-override":               allowKubeletArg,
+	"max-pods":                        allowKubeletArg,
+	"minimum-container-ttl-duration":  allowKubeletArg,
+	"node-ip":                         allowKubeletArg,
+	"register-with-taints":            allowKubeletArg,
+	"v":                               allowKubeletArg,
+	"cloud-config":                    {action: kubeletArgTranslate, translate: translateCloudConfigArg},
+	"node-labels":                     {action: kubeletArgTranslate, translate: translateNodeLabels},
+	"volume-plugin-dir":               {action: kubeletArgTranslate, translate: translateVolumePluginDir},
+	// Flags the bootstrapper sets itself
+	"bootstrap-kubeconfig":      denyKubeletArg,
+	"cert-dir":                  denyKubeletArg,
+	"cni-bin-dir":               denyKubeletArg,
+	"cni-conf-dir":              denyKubeletArg,
+	"config":                    denyKubeletArg,
+	"kubeconfig":                denyKubeletArg,
+	"log-file":                  denyKubeletArg,
+	"logtostderr":               denyKubeletArg,
+	"network-plugin":            denyKubeletArg,
+	"pod-infra-container-image": denyKubeletArg,
+	"windows-service":           denyKubeletArg,
+	// Flags that only apply to Linux
+	"cgroup-driver":              denyKubeletArg,
+	"cgroup-root":                denyKubeletArg,
+	"cgroups-per-qos":            denyKubeletArg,
+	"container-runtime":          denyKubeletArg,
+	"container-runtime-endpoint": denyKubeletArg,
+	"enforce-node-allocatable":   denyKubeletArg,
+	"kubelet-cgroups":            denyKubeletArg,
+	"lock-file":                  denyKubeletArg,
+	"exit-on-lock-contention":    denyKubeletArg,
+	"resolv-conf":                denyKubeletArg,
+	"runtime-cgroups":            denyKubeletArg,
+	"system-cgroups":             denyKubeletArg,
+}
+
+// kubeletArg is a flag given to the kubelet
+type kubeletArg struct {
+	name  string
+	value string
+}
+
+// parseKubeletCommandLine returns the flags in a kubelet command line, in order. Flags without a value are boolean
+// flags and are given the value "true".
+func parseKubeletCommandLine(words []string) []kubeletArg {
+	var args []kubeletArg
+	for i := 0; i < len(words); i++ {
+		if !strings.HasPrefix(words[i], "-") {
+			// The binary, or a subcommand such as "hyperkube kubelet"
+			continue
+		}
+		flag := strings.TrimLeft(words[i], "-")
+		if flag == "" {
+			continue
+		}
+		if parts := strings.SplitN(flag, "=", 2); len(parts) == 2 {
+			args = append(args, kubeletArg{name: parts[0], value: parts[1]})
+			continue
+		}
+		// The value can also be given as the next word
+		if i+1 < len(words) && !strings.HasPrefix(words[i+1], "-") {
+			args = append(args, kubeletArg{name: flag, value: words[i+1]})
+			i++
+			continue
+		}
+		args = append(args, kubeletArg{name: flag, value: "true"})
+	}
+	return args
+}
+
+// applyKubeletArgRules passes each flag through kubeletArgRules, and adds the ones allowed on Windows to the kubelet
+// arguments. It returns the names of the flags that were dropped.
+func (wmcb *winNodeBootstrapper) applyKubeletArgRules(args []kubeletArg) ([]string, error) {
+	var dropped []string
+	for _, arg := range args {
+		rule, ok := kubeletArgRules[arg.name]
+		// Flags with values coming from variables which are not set on Windows are left empty
+		if !ok || rule.action == kubeletArgDeny || arg.value == "" {
+			dropped = append(dropped, arg.name)
+			continue
+		}
+		value := arg.value
+		if rule.action == kubeletArgTranslate {
+			var err error
+			value, err = rule.translate(wmcb, value)
+			if err != nil {
+				return dropped, fmt.Errorf("could not translate kubelet flag --%s=%s: %s", arg.name, arg.value, err)
+			}
+			if value == "" {
+				dropped = append(dropped, arg.name)
+				continue
+			}
+		}
+		wmcb.kubeletArgs[arg.name] = value
+	}
+	return dropped, nil
+}
+
+// kubeletArgsFromUnit finds the flags the kubelet unit starts the kubelet with, expanding its environment from the
+// ignition files, and adds the ones allowed on Windows to the kubelet arguments
+func (wmcb *winNodeBootstrapper) kubeletArgsFromUnit(unit ignitionUnit, files map[string]ignitionFile) ([]string,
+	error) {
+	parsed, err := parseSystemdUnit(unit.contents, unit.dropins)
+	if err != nil {
+		return nil, err
+	}
+	env, err := parsed.environment(files)
+	if err != nil {
+		return nil, err
+	}
+	command, err := parsed.execStart(env)
+	if err != nil {
+		return nil, err
+	}
+	return wmcb.applyKubeletArgRules(parseKubeletCommandLine(command))
+}
+
+// translateNodeLabels sets the OS ID label to Windows, and drops labels whose value could not be resolved
+func translateNodeLabels(_ *winNodeBootstrapper, value string) (string, error) {
+	var labels []string
+	for _, label := range strings.Split(value, ",") {
+		parts := strings.SplitN(label, "=", 2)
+		switch {
+		case parts[0] == "node.openshift.io/os_id":
+			labels = append(labels, "node.openshift.io/os_id=Windows")
+		case len(parts) == 2 && parts[1] == "":
+			continue
+		case label != "":
+			labels = append(labels, label)
+		}
+	}
+	return strings.Join(labels, ","), nil
+}
+
+// translateVolumePluginDir moves the flex volume plugin directory into the install directory
+func translateVolumePluginDir(wmcb *winNodeBootstrapper, _ string) (string, error) {
+	return filepath.Join(wmcb.installDir, "kubelet-plugins", "volume", "exec"), nil
+}
@@ -0,0 +1,132 @@
+package bootstrapper
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testMCOKubeletUnit is the kubelet unit, as written by the MCO
+const testMCOKubeletUnit = `[Unit]
+Description=Kubernetes Kubelet
+Wants=rpc-statd.service crio.service
+After=crio.service
+
+[Service]
+Type=notify
+ExecStartPre=/bin/mkdir --parents /etc/kubernetes/manifests
+ExecStartPre=/bin/rm -f /var/lib/kubelet/cpu_manager_state
+EnvironmentFile=/etc/os-release
+EnvironmentFile=-/etc/kubernetes/kubelet-workaround
+EnvironmentFile=-/etc/kubernetes/kubelet-env
+
+ExecStart=/usr/bin/hyperkube \
+    kubelet \
+      --config=/etc/kubernetes/kubelet.conf \
+      --bootstrap-kubeconfig=/etc/kubernetes/kubeconfig \
+      --kubeconfig=/var/lib/kubelet/kubeconfig \
+      --container-runtime=remote \
+      --container-runtime-endpoint=/var/run/crio/crio.sock \
+      --node-labels=node-role.kubernetes.io/worker,node.openshift.io/os_id=${ID} \
+      --node-ip=${KUBELET_NODE_IP} \
+      --minimum-container-ttl-duration=6m0s \
+      --volume-plugin-dir=/etc/kubernetes/kubelet-plugins/volume/exec \
+      --cloud-provider=aws \
+      \
+      --v=3 \
+
+Restart=always
+RestartSec=10
+
+[Install]
+WantedBy=multi-user.target
+`
+
+// TestKubeletArgsFromUnit tests that the kubelet flags are extracted from the unit and its drop-ins, and filtered for
+// Windows
+func TestKubeletArgsFromUnit(t *testing.T) {
+	unit := ignitionUnit{
+		name:     kubeletSystemdName,
+		contents: testMCOKubeletUnit,
+		dropins: []ignitionDropin{
+			{name: "20-feature-gates.conf", contents: "[Service]\nEnvironment=\"KUBELET_FEATURE_GATES=--feature-gates=A=true,B=false\"\n"},
+			{name: "30-args.conf", contents: "[Service]\nExecStart=\nExecStart=/usr/bin/hyperkube kubelet --config=/etc/kubernetes/kubelet.conf --node-labels=node-role.kubernetes.io/worker,node.openshift.io/os_id=${ID} $KUBELET_FEATURE_GATES --register-with-taints=${TAINTS} --cgroup-driver systemd --cloud-provider=aws --v=${VERBOSITY}\n"},
+		},
+	}
+	files := map[string]ignitionFile{
+		"/etc/kubernetes/kubelet-env": {source: "data:,TAINTS%3Dfoo%3Dbar%3ANoSchedule%0AVERBOSITY%3D4%0A"},
+	}
+	wmcb := winNodeBootstrapper{installDir: `C:\k`, kubeletArgs: make(map[string]string)}
+	dropped, err := wmcb.kubeletArgsFromUnit(unit, files)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"cloud-provider":       "aws",
+		"feature-gates":        "A=true,B=false",
+		"node-labels":          "node-role.kubernetes.io/worker,node.openshift.io/os_id=Windows",
+		"register-with-taints": "foo=bar:NoSchedule",
+		"v":                    "4",
+	}, wmcb.kubeletArgs)
+	assert.Equal(t, []string{"config", "cgroup-driver"}, dropped)
+}
+
+// TestApplyKubeletArgRules tests the allow, deny and translate rules
+func TestApplyKubeletArgRules(t *testing.T) {
+	tests := []struct {
+		name        string
+		in          []kubeletArg
+		want        map[string]string
+		wantDropped []string
+	}{
+		{
+			name: "Allowed flags",
+			in:   []kubeletArg{{"cloud-provider", "azure"}, {"v", "2"}, {"max-pods", "100"}},
+			want: map[string]string{"cloud-provider": "azure", "v": "2", "max-pods": "100"},
+		},
+		{
+			name:        "Flags set by the bootstrapper and Linux flags are denied",
+			in:          []kubeletArg{{"kubeconfig", "/var/lib/kubelet/kubeconfig"}, {"cgroup-driver", "systemd"}},
+			want:        map[string]string{},
+			wantDropped: []string{"kubeconfig", "cgroup-driver"},
+		},
+		{
+			name:        "Unknown flags are denied",
+			in:          []kubeletArg{{"not-a-flag", "true"}},
+			want:        map[string]string{},
+			wantDropped: []string{"not-a-flag"},
+		},
+		{
+			name:        "Empty values are dropped",
+			in:          []kubeletArg{{"node-ip", ""}, {"node-labels", "a="}},
+			want:        map[string]string{},
+			wantDropped: []string{"node-ip", "node-labels"},
+		},
+		{
+			name: "Translated flags",
+			in: []kubeletArg{{"volume-plugin-dir", "/etc/kubernetes/kubelet-plugins/volume/exec"},
+				{"node-labels", "a=b,node.openshift.io/os_id=rhcos,c="}},
+			want: map[string]string{
+				"volume-plugin-dir": filepath.Join(`C:\k`, "kubelet-plugins", "volume", "exec"),
+				"node-labels":       "a=b,node.openshift.io/os_id=Windows",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wmcb := winNodeBootstrapper{installDir: `C:\k`, kubeletArgs: make(map[string]string)}
+			dropped, err := wmcb.applyKubeletArgRules(tt.in)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, wmcb.kubeletArgs)
+			assert.Equal(t, tt.wantDropped, dropped)
+		})
+	}
+}
+
+// TestParseKubeletCommandLine tests the supported flag syntaxes
+func TestParseKubeletCommandLine(t *testing.T) {
+	got := parseKubeletCommandLine([]string{"/usr/bin/hyperkube", "kubelet", "--v=3", "--cgroup-driver", "systemd",
+		"--windows-service", "-anonymous-auth=false"})
+	assert.Equal(t, []kubeletArg{{"v", "3"}, {"cgroup-driver", "systemd"}, {"windows-service", "true"},
+		{"anonymous-auth", "false"}}, got)
+}
@@ -0,0 +1,115 @@
+package bootstrapper
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	kubeletConfig "k8s.io/kubelet/config/v1beta1"
+	"sigs.k8s.io/yaml"
+)
+
+// SetKubeletConfigOverrides sets the file holding overrides of the kubelet configuration, which are applied after it
+// is translated for Windows. The file is a partial KubeletConfiguration, in YAML or JSON, and is applied as a JSON
+// merge patch (RFC 7386): the fields it sets replace the ones in the translated configuration, objects are merged, and
+// fields set to null are removed. If apiVersion and kind are given, they must match the kubelet configuration.
+func (wmcb *winNodeBootstrapper) SetKubeletConfigOverrides(path string) error {
+	if path == "" {
+		wmcb.kubeletConfigOverrides = nil
+		return nil
+	}
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read kubelet configuration overrides: %s", err)
+	}
+	overrides, err := parseKubeletConfigOverrides(contents)
+	if err != nil {
+		return fmt.Errorf("invalid kubelet configuration overrides %s: %s", path, err)
+	}
+	wmcb.kubeletConfigOverrides = overrides
+	return nil
+}
+
+// parseKubeletConfigOverrides returns the overrides as a JSON merge patch, without apiVersion and kind. The fields
+// must be fields of the kubelet configuration, of the right type.
+func parseKubeletConfigOverrides(contents []byte) ([]byte, error) {
+	patch, err := yaml.YAMLToJSON(contents)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	if err = json.Unmarshal(patch, &fields); err != nil || fields == nil {
+		return nil, fmt.Errorf("overrides must be a KubeletConfiguration object")
+	}
+	for field, want := range map[string]string{
+		"apiVersion": kubeletConfig.SchemeGroupVersion.String(),
+		"kind":       "KubeletConfiguration",
+	} {
+		if value, ok := fields[field]; ok && value != want {
+			return nil, fmt.Errorf("%s must be %s", field, want)
+		}
+		delete(fields, field)
+	}
+	if patch, err = json.Marshal(fields); err != nil {
+		return nil, err
+	}
+	// Fields set to null are removed by the patch, and are accepted as they decode to their zero value
+	if err = yaml.UnmarshalStrict(patch, &kubeletConfig.KubeletConfiguration{}); err != nil {
+		return nil, err
+	}
+	return patch, nil
+}
+
+// applyKubeletConfigOverrides applies the overrides, a JSON merge patch, to the JSON kubelet configuration, and
+// returns the merged configuration once it is validated
+func applyKubeletConfigOverrides(config, overrides []byte) ([]byte, error) {
+	merged, err := jsonpatch.MergePatch(config, overrides)
+	if err != nil {
+		return nil, fmt.Errorf("could not apply kubelet configuration overrides: %s", err)
+	}
+	if err = validateKubeletConfig(merged); err != nil {
+		return nil, fmt.Errorf("kubelet configuration is invalid with the overrides applied: %s", err)
+	}
+	return merged, nil
+}
+
+// overriddenFields returns the paths of the fields the overrides set or remove, such as authentication.webhook.enabled,
+// sorted
+func overriddenFields(overrides []byte) []string {
+	var patch map[string]interface{}
+	if err := json.Unmarshal(overrides, &patch); err != nil {
+		return nil
+	}
+	var fields []string
+	var walk func(prefix string, object map[string]interface{})
+	walk = func(prefix string, object map[string]interface{}) {
+		for name, value := range object {
+			if nested, ok := value.(map[string]interface{}); ok && len(nested) > 0 {
+				walk(prefix+name+".", nested)
+				continue
+			}
+			fields = append(fields, prefix+name)
+		}
+	}
+	walk("", patch)
+	sort.Strings(fields)
+	return fields
+}
+
+// validateKubeletConfig returns an error if the JSON kubelet configuration is not a KubeletConfiguration of the kubelet
+// configuration scheme, or has fields which are unknown or of the wrong type
+func validateKubeletConfig(config []byte) error {
+	scheme := runtime.NewScheme()
+	if err := kubeletConfig.AddToScheme(scheme); err != nil {
+		return err
+	}
+	decoded := kubeletConfig.KubeletConfiguration{}
+	if _, _, err := serializer.NewCodecFactory(scheme).UniversalDeserializer().Decode(config, nil, &decoded); err != nil {
+		return err
+	}
+	return yaml.UnmarshalStrict(config, &kubeletConfig.KubeletConfiguration{})
+}
@@ -0,0 +1,265 @@
+package bootstrapper
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// LintSeverity is how severe a finding of the kubelet configuration linter is
+type LintSeverity string
+
+const (
+	// LintWarning is a field which has no effect on Windows
+	LintWarning LintSeverity = "warning"
+	// LintError is a field which can prevent the kubelet from running on Windows
+	LintError LintSeverity = "error"
+)
+
+// LintFinding is a field of the kubelet configuration which is not supported on Windows
+type LintFinding struct {
+	// Field is the name of the field in the kubelet configuration
+	Field    string       `json:"field"`
+	Severity LintSeverity `json:"severity"`
+	Message  string       `json:"message"`
+	// Fixed is true if the field was changed to a value which is supported on Windows
+	Fixed bool `json:"fixed"`
+	// SetByOverride is true if the field is set by the kubelet configuration overrides, so it is never fixed
+	SetByOverride bool `json:"setByOverride,omitempty"`
+}
+
+// KubeletConfigLint is the result of linting a kubelet configuration
+type KubeletConfigLint struct {
+	// Findings are the fields which are not supported on Windows, in the order of kubeletConfigLintRules
+	Findings []LintFinding `json:"findings"`
+	// Config is the JSON kubelet configuration, with the findings which could be fixed fixed
+	Config json.RawMessage `json:"config"`
+}
+
+// Errors returns the findings which are errors and could not be fixed
+func (l *KubeletConfigLint) Errors() []LintFinding {
+	var errors []LintFinding
+	for _, finding := range l.Findings {
+		if finding.Severity == LintError && !finding.Fixed {
+			errors = append(errors, finding)
+		}
+	}
+	return errors
+}
+
+// kubeletConfigLintRule describes a field of the kubelet configuration which is not supported on Windows
+type kubeletConfigLintRule struct {
+	// field is the JSON name of the field
+	field    string
+	severity LintSeverity
+	message  string
+	// applies returns true if the value of the field, which is nil if it is not set, is not supported on Windows. If
+	// nil, the rule applies to any value the field is set to.
+	applies func(value interface{}) bool
+	// fix returns the value the field is changed to, or nil to remove it. If nil, the field cannot be fixed safely and
+	// is left for the user to change.
+	fix func(value interface{}) interface{}
+}
+
+// linuxEvictionSignals are the eviction signals the kubelet only supports on Linux
+var linuxEvictionSignals = []string{"allocatableMemory.available", "imagefs.inodesFree", "nodefs.inodesFree",
+	"pid.available"}
+
+// linuxFeatureGates are the kubelet feature gates which only have an effect on Linux
+var linuxFeatureGates = []string{"CPUManager", "HugePages", "SupportNodePidsLimit", "SupportPodPidsLimit",
+	"TopologyManager"}
+
+// kubeletConfigLintRules are the fields of the kubelet configuration which are not supported on Windows, in the order
+// they are reported
+var kubeletConfigLintRules = []kubeletConfigLintRule{
+	{field: "cgroupDriver", severity: LintError, message: "Windows only supports the cgroupfs cgroup driver",
+		applies: func(value interface{}) bool { return value != nil && value != "cgroupfs" },
+		fix:     func(interface{}) interface{} { return "cgroupfs" }},
+	{field: "cgroupsPerQOS", severity: LintError, message: "QoS cgroups are not supported on Windows",
+		applies: func(value interface{}) bool { return value != false },
+		fix:     func(interface{}) interface{} { return false }},
+	{field: "enforceNodeAllocatable", severity: LintError,
+		message: "node allocatable is enforced with cgroups, which are not supported on Windows",
+		applies: func(value interface{}) bool {
+			values, ok := value.([]interface{})
+			return !ok || len(values) > 0
+		},
+		fix: func(interface{}) interface{} { return []interface{}{} }},
+	{field: "cgroupRoot", severity: LintWarning, message: "cgroups are not supported on Windows", fix: removeField},
+	{field: "kubeletCgroups", severity: LintWarning, message: "cgroups are not supported on Windows", fix: removeField},
+	{field: "systemCgroups", severity: LintWarning, message: "cgroups are not supported on Windows", fix: removeField},
+	{field: "systemReservedCgroup", severity: LintWarning, message: "cgroups are not supported on Windows",
+		fix: removeField},
+	{field: "kubeReservedCgroup", severity: LintWarning, message: "cgroups are not supported on Windows",
+		fix: removeField},
+	{field: "systemReserved", severity: LintWarning, message: "process IDs cannot be reserved on Windows",
+		applies: hasKeys("pid"), fix: removeKeys("pid")},
+	{field: "kubeReserved", severity: LintWarning, message: "process IDs cannot be reserved on Windows",
+		applies: hasKeys("pid"), fix: removeKeys("pid")},
+	{field: "resolvConf", severity: LintWarning, message: "Windows does not use a resolver configuration file",
+		applies: func(value interface{}) bool { return value != nil && value != "" }, fix: removeField},
+	{field: "evictionHard", severity: LintWarning, message: "the eviction signals are only supported on Linux",
+		applies: hasKeys(linuxEvictionSignals...), fix: removeKeys(linuxEvictionSignals...)},
+	{field: "evictionSoft", severity: LintWarning, message: "the eviction signals are only supported on Linux",
+		applies: hasKeys(linuxEvictionSignals...), fix: removeKeys(linuxEvictionSignals...)},
+	{field: "evictionSoftGracePeriod", severity: LintWarning,
+		message: "the eviction signals are only supported on Linux",
+		applies: hasKeys(linuxEvictionSignals...), fix: removeKeys(linuxEvictionSignals...)},
+	{field: "evictionMinimumReclaim", severity: LintWarning,
+		message: "the eviction signals are only supported on Linux",
+		applies: hasKeys(linuxEvictionSignals...), fix: removeKeys(linuxEvictionSignals...)},
+	{field: "featureGates", severity: LintWarning, message: "the feature gates only have an effect on Linux",
+		applies: hasKeys(linuxFeatureGates...), fix: removeKeys(linuxFeatureGates...)},
+	{field: "podPidsLimit", severity: LintWarning, message: "process IDs cannot be limited on Windows",
+		fix: removeField},
+	{field: "oomScoreAdj", severity: LintWarning, message: "Windows has no OOM killer", fix: removeField},
+	{field: "protectKernelDefaults", severity: LintWarning, message: "kernel tunables are only checked on Linux",
+		fix: removeField},
+	{field: "kernelMemcgNotification", severity: LintWarning, message: "memory cgroups are only supported on Linux",
+		fix: removeField},
+	{field: "makeIPTablesUtilChains", severity: LintWarning, message: "iptables is not used on Windows",
+		fix: removeField},
+	{field: "iptablesMasqueradeBit", severity: LintWarning, message: "iptables is not used on Windows",
+		fix: removeField},
+	{field: "iptablesDropBit", severity: LintWarning, message: "iptables is not used on Windows", fix: removeField},
+	{field: "cpuManagerPolicy", severity: LintError, message: "Windows only supports the none CPU manager policy",
+		applies: func(value interface{}) bool { return value != nil && value != "none" }},
+}
+
+// removeField is the fix of rules whose field is removed
+func removeField(interface{}) interface{} {
+	return nil
+}
+
+// hasKeys returns a function which returns true if its value is an object with any of the keys
+func hasKeys(keys ...string) func(interface{}) bool {
+	return func(value interface{}) bool {
+		object, _ := value.(map[string]interface{})
+		for _, key := range keys {
+			if _, ok := object[key]; ok {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// removeKeys returns a fix which removes the keys from an object. The field is removed if no keys are left.
+func removeKeys(keys ...string) func(interface{}) interface{} {
+	return func(value interface{}) interface{} {
+		fixed := make(map[string]interface{})
+		for key, v := range value.(map[string]interface{}) {
+			fixed[key] = v
+		}
+		for _, key := range keys {
+			delete(fixed, key)
+		}
+		if len(fixed) == 0 {
+			return nil
+		}
+		return fixed
+	}
+}
+
+// lintKubeletConfig reports the fields of the kubelet configuration, in YAML or JSON, which are not supported on
+// Windows. If fix is true, the fields which can be fixed safely are fixed in the returned configuration, other than
+// the ones the overridden fields are in. The configuration is returned unchanged if nothing was fixed.
+func lintKubeletConfig(config []byte, fix bool, overridden []string) (*KubeletConfigLint, error) {
+	contents, err := yaml.YAMLToJSON(config)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	if err = json.Unmarshal(contents, &fields); err != nil || fields == nil {
+		return nil, fmt.Errorf("kubelet configuration must be a KubeletConfiguration object")
+	}
+	lint := &KubeletConfigLint{Config: contents}
+	fixed := false
+	for _, rule := range kubeletConfigLintRules {
+		value, ok := fields[rule.field]
+		if rule.applies == nil && !ok || rule.applies != nil && !rule.applies(value) {
+			continue
+		}
+		finding := LintFinding{Field: rule.field, Severity: rule.severity, Message: rule.message,
+			SetByOverride: setByOverride(rule.field, overridden)}
+		if fix && rule.fix != nil && !finding.SetByOverride {
+			if fixedValue := rule.fix(value); fixedValue != nil {
+				fields[rule.field] = fixedValue
+			} else {
+				delete(fields, rule.field)
+			}
+			finding.Fixed, fixed = true, true
+		}
+		lint.Findings = append(lint.Findings, finding)
+	}
+	if fixed {
+		if lint.Config, err = json.Marshal(fields); err != nil {
+			return nil, err
+		}
+	}
+	return lint, nil
+}
+
+// setByOverride returns true if any of the overridden fields is the field or is in it
+func setByOverride(field string, overridden []string) bool {
+	for _, path := range overridden {
+		if path == field || strings.HasPrefix(path, field+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// prepAndLintKubeletConf translates the kubelet configuration for Windows, then lints it, fixing the fields which can
+// be fixed safely. The findings are logged, and an error is returned if any of the errors could not be fixed.
+func prepAndLintKubeletConf(wmcb *winNodeBootstrapper, initialConfig []byte) ([]byte, error) {
+	config, err := prepKubeletConfForWindows(wmcb, initialConfig)
+	if err != nil {
+		return nil, err
+	}
+	lint, err := lintKubeletConfig(config, true, overriddenFields(wmcb.kubeletConfigOverrides))
+	if err != nil {
+		return nil, err
+	}
+	for _, finding := range lint.Findings {
+		log.Info("kubelet configuration field is not supported on Windows", "field", finding.Field,
+			"severity", finding.Severity, "message", finding.Message, "fixed", finding.Fixed,
+			"setByOverride", finding.SetByOverride)
+	}
+	if unfixed := lint.Errors(); len(unfixed) > 0 {
+		var messages []string
+		for _, finding := range unfixed {
+			messages = append(messages, finding.Field+": "+finding.Message)
+		}
+		return nil, fmt.Errorf("kubelet configuration is not supported on Windows: %s", strings.Join(messages, ", "))
+	}
+	return lint.Config, nil
+}
+
+// LintKubeletConfig translates the kubelet configuration in the ignition file for Windows, as Run does, applying the
+// kubelet configuration overrides if overridesPath is given, and reports the fields of the translated configuration
+// which are not supported on Windows. If fix is true, the fields which can be fixed safely and are not set by the
+// overrides are fixed in the returned configuration. installDir is the directory the node is, or would be, bootstrapped into.
+func LintKubeletConfig(ignitionFilePath, installDir, overridesPath string, fix bool) (*KubeletConfigLint, error) {
+	wmcb := &winNodeBootstrapper{installDir: installDir}
+	if err := wmcb.SetKubeletConfigOverrides(overridesPath); err != nil {
+		return nil, err
+	}
+	configuration, err := readIgnitionFile(ignitionFilePath)
+	if err != nil {
+		return nil, err
+	}
+	for _, ignFile := range configuration.files {
+		if ignFile.path != kubeletConfIgnitionPath {
+			continue
+		}
+		config, err := wmcb.translateFile(ignFile, prepKubeletConfForWindows)
+		if err != nil {
+			return nil, fmt.Errorf("could not process %s: %s", ignFile.path, err)
+		}
+		return lintKubeletConfig(config, fix, overriddenFields(wmcb.kubeletConfigOverrides))
+	}
+	return nil, fmt.Errorf("%s not found in %s", kubeletConfIgnitionPath, ignitionFilePath)
+}
@@ -0,0 +1,150 @@
+package bootstrapper
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestLintKubeletConfig tests that fields which are not supported on Windows are reported, and fixed if they can be
+// fixed safely
+func TestLintKubeletConfig(t *testing.T) {
+	tests := []struct {
+		name         string
+		config       string
+		fix          bool
+		overridden   []string
+		wantFindings []LintFinding
+		// wantConfig is the expected JSON configuration, which is the given one if empty
+		wantConfig string
+	}{
+		{
+			name:   "Supported",
+			config: `{"cgroupDriver":"cgroupfs","cgroupsPerQOS":false,"enforceNodeAllocatable":[],"maxPods":250}`,
+		},
+		{
+			name: "Not fixed",
+			config: `{"cgroupDriver":"systemd","cgroupsPerQOS":false,"enforceNodeAllocatable":[],` +
+				`"featureGates":{"SupportPodPidsLimit":true,"RotateKubeletServerCertificate":true}}`,
+			wantFindings: []LintFinding{
+				{Field: "cgroupDriver", Severity: LintError,
+					Message: "Windows only supports the cgroupfs cgroup driver"},
+				{Field: "featureGates", Severity: LintWarning,
+					Message: "the feature gates only have an effect on Linux"},
+			},
+		},
+		{
+			name: "Fixed",
+			config: `cgroupDriver: systemd
+systemReservedCgroup: /system.slice
+systemReserved:
+  cpu: 500m
+  pid: "1000"
+evictionHard:
+  memory.available: 500Mi
+  nodefs.inodesFree: 5%
+podPidsLimit: 1024
+`,
+			fix: true,
+			wantFindings: []LintFinding{
+				{Field: "cgroupDriver", Severity: LintError,
+					Message: "Windows only supports the cgroupfs cgroup driver", Fixed: true},
+				{Field: "cgroupsPerQOS", Severity: LintError, Message: "QoS cgroups are not supported on Windows",
+					Fixed: true},
+				{Field: "enforceNodeAllocatable", Severity: LintError,
+					Message: "node allocatable is enforced with cgroups, which are not supported on Windows", Fixed: true},
+				{Field: "systemReservedCgroup", Severity: LintWarning, Message: "cgroups are not supported on Windows",
+					Fixed: true},
+				{Field: "systemReserved", Severity: LintWarning, Message: "process IDs cannot be reserved on Windows",
+					Fixed: true},
+				{Field: "evictionHard", Severity: LintWarning,
+					Message: "the eviction signals are only supported on Linux", Fixed: true},
+				{Field: "podPidsLimit", Severity: LintWarning, Message: "process IDs cannot be limited on Windows",
+					Fixed: true},
+			},
+			wantConfig: `{"cgroupDriver":"cgroupfs","cgroupsPerQOS":false,"enforceNodeAllocatable":[],` +
+				`"systemReserved":{"cpu":"500m"},"evictionHard":{"memory.available":"500Mi"}}`,
+		},
+		{
+			name:       "Set by override",
+			config:     `{"cgroupsPerQOS":false,"enforceNodeAllocatable":[],"podPidsLimit":1024,"oomScoreAdj":-999}`,
+			fix:        true,
+			overridden: []string{"maxPods", "podPidsLimit"},
+			wantFindings: []LintFinding{
+				{Field: "podPidsLimit", Severity: LintWarning, Message: "process IDs cannot be limited on Windows",
+					SetByOverride: true},
+				{Field: "oomScoreAdj", Severity: LintWarning, Message: "Windows has no OOM killer", Fixed: true},
+			},
+			wantConfig: `{"cgroupsPerQOS":false,"enforceNodeAllocatable":[],"podPidsLimit":1024}`,
+		},
+		{
+			name:       "Set by override within an object",
+			config:     `{"cgroupsPerQOS":false,"enforceNodeAllocatable":[],"systemReserved":{"pid":"1000"}}`,
+			fix:        true,
+			overridden: []string{"systemReserved.pid"},
+			wantFindings: []LintFinding{
+				{Field: "systemReserved", Severity: LintWarning, Message: "process IDs cannot be reserved on Windows",
+					SetByOverride: true},
+			},
+		},
+		{
+			name:   "Cannot be fixed",
+			config: `{"cgroupsPerQOS":false,"enforceNodeAllocatable":[],"cpuManagerPolicy":"static"}`,
+			fix:    true,
+			wantFindings: []LintFinding{
+				{Field: "cpuManagerPolicy", Severity: LintError,
+					Message: "Windows only supports the none CPU manager policy"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lint, err := lintKubeletConfig([]byte(tt.config), tt.fix, tt.overridden)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantFindings, lint.Findings)
+			wantConfig := tt.wantConfig
+			if wantConfig == "" {
+				wantConfig = tt.config
+			}
+			assert.JSONEq(t, wantConfig, string(lint.Config))
+		})
+	}
+
+	_, err := lintKubeletConfig([]byte("- cgroupDriver"), false, nil)
+	assert.Error(t, err, "a configuration which is not an object should be rejected")
+}
+
+// TestLintKubeletConfigIgnition tests that the kubelet configuration in an ignition file is linted once it is
+// translated for Windows and the overrides are applied, and that Run refuses a configuration with errors which cannot
+// be fixed
+func TestLintKubeletConfigIgnition(t *testing.T) {
+	scm := newFakeSCM()
+	wmcb := newTestBootstrapper(t, scm)
+	dir := filepath.Dir(wmcb.installDir)
+	defer os.RemoveAll(dir)
+
+	lint, err := LintKubeletConfig(wmcb.ignitionFilePath, wmcb.installDir, "", false)
+	require.NoError(t, err)
+	assert.Empty(t, lint.Findings, "the Windows translation should leave no unsupported fields")
+
+	overrides := filepath.Join(dir, "overrides.yaml")
+	require.NoError(t, ioutil.WriteFile(overrides, []byte("cpuManagerPolicy: static\npodPidsLimit: 1024\n"), 0644))
+	lint, err = LintKubeletConfig(wmcb.ignitionFilePath, wmcb.installDir, overrides, true)
+	require.NoError(t, err)
+	require.Len(t, lint.Findings, 2)
+	assert.Equal(t, "podPidsLimit", lint.Findings[0].Field)
+	assert.False(t, lint.Findings[0].Fixed, "a field set by the overrides should not be fixed")
+	assert.True(t, lint.Findings[0].SetByOverride)
+	assert.Contains(t, string(lint.Config), `"podPidsLimit":1024`)
+	assert.Equal(t, []LintFinding{lint.Findings[1]}, lint.Errors())
+
+	require.NoError(t, wmcb.SetKubeletConfigOverrides(overrides))
+	assert.Error(t, wmcb.Run(context.Background()))
+	require.NoError(t, wmcb.Disconnect())
+	assert.Nil(t, scm.get(KubeletServiceName), "the kubelet should not be installed")
+}
@@ -0,0 +1,96 @@
+package bootstrapper
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestSetKubeletConfigOverrides tests that overrides are accepted as YAML or JSON, and rejected if they are not a
+// partial kubelet configuration
+func TestSetKubeletConfigOverrides(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wmcb")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name      string
+		overrides string
+		want      string
+		wantErr   bool
+	}{
+		{name: "YAML", overrides: "apiVersion: kubelet.config.k8s.io/v1beta1\nkind: KubeletConfiguration\nmaxPods: 100\n",
+			want: `{"maxPods":100}`},
+		{name: "JSON merge patch", overrides: `{"systemReserved":{"memory":"1Gi"},"cgroupDriver":null}`,
+			want: `{"cgroupDriver":null,"systemReserved":{"memory":"1Gi"}}`},
+		{name: "Wrong kind", overrides: "kind: KubeProxyConfiguration\n", wantErr: true},
+		{name: "Wrong API version", overrides: "apiVersion: kubelet.config.k8s.io/v1\n", wantErr: true},
+		{name: "Unknown field", overrides: "maxPod: 100\n", wantErr: true},
+		{name: "Wrong type", overrides: "maxPods: many\n", wantErr: true},
+		{name: "Not an object", overrides: "- maxPods\n", wantErr: true},
+		{name: "Empty", overrides: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "overrides.yaml")
+			require.NoError(t, ioutil.WriteFile(path, []byte(tt.overrides), 0644))
+			wmcb := winNodeBootstrapper{}
+			err := wmcb.SetKubeletConfigOverrides(path)
+			if tt.wantErr {
+				assert.Error(t, err)
+				assert.Nil(t, wmcb.kubeletConfigOverrides)
+				return
+			}
+			require.NoError(t, err)
+			assert.JSONEq(t, tt.want, string(wmcb.kubeletConfigOverrides))
+		})
+	}
+}
+
+// TestPrepKubeletConfForWindowsOverrides tests that the overrides are merged into the kubelet configuration after it
+// is translated for Windows
+func TestPrepKubeletConfForWindowsOverrides(t *testing.T) {
+	in := `{"kind":"KubeletConfiguration","apiVersion":"kubelet.config.k8s.io/v1beta1","maxPods":250,` +
+		`"systemReserved":{"cpu":"500m","memory":"500Mi"},"featureGates":{"RotateKubeletServerCertificate":true},` +
+		`"cgroupDriver":"systemd","containerLogMaxSize":"50Mi"}`
+	overrides, err := parseKubeletConfigOverrides([]byte(`
+maxPods: 100
+systemReserved:
+  memory: 1Gi
+featureGates:
+  WindowsGMSA: true
+evictionHard:
+  memory.available: 500Mi
+containerLogMaxSize: null
+`))
+	require.NoError(t, err)
+	bs := winNodeBootstrapper{installDir: `C:\k`, kubeletConfigOverrides: overrides}
+	out, err := prepKubeletConfForWindows(&bs, []byte(in))
+	require.NoError(t, err)
+
+	var got map[string]interface{}
+	require.NoError(t, json.Unmarshal(out, &got))
+	assert.Equal(t, "KubeletConfiguration", got["kind"])
+	assert.Equal(t, float64(100), got["maxPods"])
+	assert.Equal(t, map[string]interface{}{"cpu": "500m", "memory": "1Gi"}, got["systemReserved"])
+	assert.Equal(t, map[string]interface{}{"RotateKubeletServerCertificate": true, "WindowsGMSA": true},
+		got["featureGates"])
+	assert.Equal(t, map[string]interface{}{"memory.available": "500Mi"}, got["evictionHard"])
+	assert.NotContains(t, got, "containerLogMaxSize")
+	// The Windows translation is kept where it is not overridden
+	assert.Equal(t, "cgroupfs", got["cgroupDriver"])
+	assert.Equal(t, []interface{}{}, got["enforceNodeAllocatable"])
+}
+
+// TestOverriddenFields tests that the fields set or removed by the overrides are found in nested objects
+func TestOverriddenFields(t *testing.T) {
+	assert.Equal(t, []string{"cgroupDriver", "maxPods", "systemReserved.cpu", "systemReserved.memory"},
+		overriddenFields([]byte(`{"systemReserved":{"memory":"1Gi","cpu":"1"},"maxPods":100,"cgroupDriver":null}`)))
+	assert.Equal(t, []string{"featureGates"}, overriddenFields([]byte(`{"featureGates":{}}`)))
+	assert.Nil(t, overriddenFields([]byte(`{}`)))
+}
@@ -0,0 +1,236 @@
+package bootstrapper
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// kubeletDigestFile is the name of the file in the install directory the digest of the installed kubelet is
+	// recorded in, in the sha256sum format
+	kubeletDigestFile = "kubelet.exe.sha256"
+)
+
+// kubeletVerification describes how the kubelet binary is verified before it is installed
+type kubeletVerification struct {
+	// sha256 is the expected SHA-256 digest of the kubelet binary. It is not checked if nil.
+	sha256 []byte
+	// signature is the detached signature of the SHA-256 digest of the kubelet binary. It is not checked if nil.
+	signature []byte
+	// publicKey is the public key the signature is verified with
+	publicKey crypto.PublicKey
+}
+
+// SetKubeletVerification sets how the kubelet binary is verified before it is installed. expectedSHA256 is the hex
+// encoded SHA-256 digest the binary must have. signaturePath is the path of a detached signature of the binary, such
+// as one created with `openssl dgst -sha256 -sign`, which is verified with the RSA or ECDSA public key in the PEM file
+// at publicKeyPath. The signature can be given raw or base64 encoded. Either check is skipped if its arguments are
+// empty.
+func (wmcb *winNodeBootstrapper) SetKubeletVerification(expectedSHA256, signaturePath, publicKeyPath string) error {
+	verification := &kubeletVerification{}
+	if expectedSHA256 != "" {
+		digest, err := hex.DecodeString(expectedSHA256)
+		if err != nil || len(digest) != sha256.Size {
+			return fmt.Errorf("invalid kubelet SHA-256 digest %q", expectedSHA256)
+		}
+		verification.sha256 = digest
+	}
+	if (signaturePath == "") != (publicKeyPath == "") {
+		return fmt.Errorf("both a kubelet signature and the public key to verify it with must be given")
+	}
+	if signaturePath != "" {
+		var err error
+		if verification.signature, err = readSignature(signaturePath); err != nil {
+			return fmt.Errorf("could not read kubelet signature: %s", err)
+		}
+		if verification.publicKey, err = readPublicKey(publicKeyPath); err != nil {
+			return fmt.Errorf("could not read kubelet signing key: %s", err)
+		}
+	}
+	wmcb.kubeletVerification = verification
+	return nil
+}
+
+// installKubelet copies the kubelet binary to the install directory through the stage, verifying it on the way. The
+// binary is copied to a temporary file first, so that a binary which fails verification never replaces the installed
+// kubelet. The digest of the installed binary is recorded, so that later changes to it can be detected.
+func (wmcb *winNodeBootstrapper) installKubelet(stage *fileStage) error {
+	dest := filepath.Join(wmcb.installDir, "kubelet.exe")
+	if err := wmcb.checkInstalledKubelet(); err != nil {
+		log.Info("installed kubelet does not match its recorded digest, replacing it", "reason", err.Error())
+	}
+	tmpPath := dest + ".tmp"
+	digest, err := copyFileWithDigest(stage.fs, wmcb.initialKubeletPath, tmpPath)
+	if err != nil {
+		stage.fs.Remove(tmpPath)
+		return err
+	}
+	if err = wmcb.kubeletVerification.verify(digest); err != nil {
+		stage.fs.Remove(tmpPath)
+		return fmt.Errorf("kubelet %s failed verification: %s", wmcb.initialKubeletPath, err)
+	}
+	if err = stage.install(tmpPath, dest); err != nil {
+		return err
+	}
+	record := hex.EncodeToString(digest) + "  kubelet.exe\n"
+	return stage.writeFile(filepath.Join(wmcb.installDir, kubeletDigestFile), []byte(record), 0644)
+}
+
+// verifyKubelet verifies the kubelet binary without installing it
+func (wmcb *winNodeBootstrapper) verifyKubelet() error {
+	from, err := wmcb.fs.Open(wmcb.initialKubeletPath)
+	if err != nil {
+		return fmt.Errorf("could not read kubelet: %s", err)
+	}
+	defer from.Close()
+	h := sha256.New()
+	if _, err = io.Copy(h, from); err != nil {
+		return fmt.Errorf("could not read kubelet: %s", err)
+	}
+	if err = wmcb.kubeletVerification.verify(h.Sum(nil)); err != nil {
+		return fmt.Errorf("kubelet %s failed verification: %s", wmcb.initialKubeletPath, err)
+	}
+	return nil
+}
+
+// checkInstalledKubelet returns an error if the installed kubelet binary does not match its recorded digest. It is not
+// an error if the kubelet or its digest are missing.
+func (wmcb *winNodeBootstrapper) checkInstalledKubelet() error {
+	recorded, err := wmcb.recordedKubeletDigest()
+	if err != nil || recorded == "" {
+		return err
+	}
+	actual, err := fileSHA256(wmcb.fs, filepath.Join(wmcb.installDir, "kubelet.exe"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if actual != recorded {
+		return fmt.Errorf("kubelet has SHA-256 digest %s, but %s was recorded", actual, recorded)
+	}
+	return nil
+}
+
+// recordedKubeletDigest returns the hex encoded digest recorded for the installed kubelet, or an empty string if none
+// was recorded
+func (wmcb *winNodeBootstrapper) recordedKubeletDigest() (string, error) {
+	contents, err := readFile(wmcb.fs, filepath.Join(wmcb.installDir, kubeletDigestFile))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(string(contents))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("%s is empty", kubeletDigestFile)
+	}
+	return fields[0], nil
+}
+
+// verify checks the digest of the kubelet binary against the expected digest and signature. Nothing is checked if
+// the verification is nil.
+func (v *kubeletVerification) verify(digest []byte) error {
+	if v == nil {
+		return nil
+	}
+	if v.sha256 != nil && !bytes.Equal(v.sha256, digest) {
+		return fmt.Errorf("SHA-256 digest %x does not match the expected digest %x", digest, v.sha256)
+	}
+	if v.signature == nil {
+		return nil
+	}
+	switch key := v.publicKey.(type) {
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest, v.signature); err != nil {
+			return fmt.Errorf("invalid signature: %s", err)
+		}
+	case *ecdsa.PublicKey:
+		var sig struct{ R, S *big.Int }
+		if rest, err := asn1.Unmarshal(v.signature, &sig); err != nil || len(rest) > 0 {
+			return fmt.Errorf("invalid ECDSA signature encoding")
+		}
+		if !ecdsa.Verify(key, digest, sig.R, sig.S) {
+			return fmt.Errorf("invalid signature")
+		}
+	default:
+		return fmt.Errorf("unsupported public key type %T", key)
+	}
+	return nil
+}
+
+// copyFileWithDigest copies src to dest on the filesystem, replacing dest, and returns the SHA-256 digest of the
+// copied contents
+func copyFileWithDigest(fs FileSystem, src, dest string) ([]byte, error) {
+	from, err := fs.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer from.Close()
+	to, err := fs.Create(dest, 0755)
+	if err != nil {
+		return nil, err
+	}
+	defer to.Close()
+	h := sha256.New()
+	if _, err = io.Copy(io.MultiWriter(to, h), from); err != nil {
+		return nil, err
+	}
+	if err = to.Sync(); err != nil {
+		return nil, err
+	}
+	if err = to.Close(); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// readSignature reads a detached signature, which can be raw or base64 encoded
+func readSignature(path string) ([]byte, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(contents))); err == nil {
+		return decoded, nil
+	}
+	return contents, nil
+}
+
+// readPublicKey reads an RSA or ECDSA public key from a PEM file
+func readPublicKey(path string) (crypto.PublicKey, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(contents)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("no PEM encoded public key found in %s", path)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch key.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported public key type %T, must be RSA or ECDSA", key)
+}
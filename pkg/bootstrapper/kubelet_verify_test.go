@@ -0,0 +1,178 @@
+package bootstrapper
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testKubeletDigest is the SHA-256 digest of the test kubelet binary, "kubelet binary"
+const testKubeletDigest = "a0293c2f7bd5dfaf9a245d01cc7f74313786457207f0b5ec8a104cbf51405c00"
+
+// writeTestSigningKey writes the public key of the signer to a PEM file in dir, and returns its path
+func writeTestSigningKey(t *testing.T, dir string, signer crypto.Signer) string {
+	der, err := x509.MarshalPKIXPublicKey(signer.Public())
+	require.NoError(t, err)
+	path := filepath.Join(dir, "signing-key.pem")
+	require.NoError(t, ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644))
+	return path
+}
+
+// writeTestSignature signs contents as `openssl dgst -sha256 -sign` does, writes the signature to a file in dir, and
+// returns its path
+func writeTestSignature(t *testing.T, dir string, signer crypto.Signer, contents []byte, encode bool) string {
+	digest := sha256.Sum256(contents)
+	signature, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	require.NoError(t, err)
+	if encode {
+		signature = []byte(base64.StdEncoding.EncodeToString(signature) + "\n")
+	}
+	path := filepath.Join(dir, "kubelet.exe.sig")
+	require.NoError(t, ioutil.WriteFile(path, signature, 0644))
+	return path
+}
+
+// TestSetKubeletVerification tests validation of the kubelet verification options
+func TestSetKubeletVerification(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wmcb")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	keyPath := writeTestSigningKey(t, dir, key)
+	signaturePath := writeTestSignature(t, dir, key, []byte("kubelet binary"), false)
+
+	tests := []struct {
+		name          string
+		sha256        string
+		signaturePath string
+		keyPath       string
+		wantErr       bool
+	}{
+		{name: "Digest", sha256: testKubeletDigest},
+		{name: "Signature", signaturePath: signaturePath, keyPath: keyPath},
+		{name: "Invalid digest", sha256: "a0293c2f", wantErr: true},
+		{name: "Signature without key", signaturePath: signaturePath, wantErr: true},
+		{name: "Key without signature", keyPath: keyPath, wantErr: true},
+		{name: "Key is not a public key", signaturePath: signaturePath, keyPath: signaturePath, wantErr: true},
+		{name: "Missing signature", signaturePath: filepath.Join(dir, "missing.sig"), keyPath: keyPath,
+			wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wmcb := winNodeBootstrapper{}
+			err := wmcb.SetKubeletVerification(tt.sha256, tt.signaturePath, tt.keyPath)
+			if tt.wantErr {
+				assert.Error(t, err)
+				assert.Nil(t, wmcb.kubeletVerification)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+// TestInstallKubeletVerification tests that the kubelet is only installed if it passes verification, and that its
+// digest is recorded
+func TestInstallKubeletVerification(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		// sha256 is the expected digest
+		sha256 string
+		// signer signs the kubelet, and verifier is the key the signature is verified with
+		signer   crypto.Signer
+		verifier crypto.Signer
+		encode   bool
+		wantErr  bool
+	}{
+		{name: "No verification"},
+		{name: "Matching digest", sha256: testKubeletDigest},
+		{name: "RSA signature", signer: rsaKey, verifier: rsaKey},
+		{name: "Base64 encoded ECDSA signature", signer: ecdsaKey, verifier: ecdsaKey, encode: true},
+		{name: "Matching digest and signature", sha256: testKubeletDigest, signer: ecdsaKey, verifier: ecdsaKey},
+		{name: "Mismatched digest", sha256: hex.EncodeToString(make([]byte, sha256.Size)), wantErr: true},
+		{name: "Signed by another key", signer: otherKey, verifier: ecdsaKey, wantErr: true},
+		{name: "Mismatched digest with valid signature", sha256: hex.EncodeToString(make([]byte, sha256.Size)),
+			signer: rsaKey, verifier: rsaKey, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scm := newFakeSCM()
+			wmcb := newTestBootstrapper(t, scm)
+			testDir := filepath.Dir(wmcb.installDir)
+			defer os.RemoveAll(testDir)
+			defer wmcb.Disconnect()
+			var signaturePath, keyPath string
+			if tt.signer != nil {
+				signaturePath = writeTestSignature(t, testDir, tt.signer, []byte("kubelet binary"), tt.encode)
+				keyPath = writeTestSigningKey(t, testDir, tt.verifier)
+			}
+			require.NoError(t, wmcb.SetKubeletVerification(tt.sha256, signaturePath, keyPath))
+			// A previously installed kubelet must be kept if the new one fails verification
+			require.NoError(t, os.MkdirAll(wmcb.installDir, 0755))
+			installed := filepath.Join(wmcb.installDir, "kubelet.exe")
+			require.NoError(t, ioutil.WriteFile(installed, []byte("previous kubelet binary"), 0755))
+
+			err := wmcb.installKubelet(newFileStage(wmcb.fs, wmcb.installDir))
+			_, tmpErr := os.Stat(installed + ".tmp")
+			assert.True(t, os.IsNotExist(tmpErr), "temporary kubelet was left behind")
+			contents, readErr := ioutil.ReadFile(installed)
+			require.NoError(t, readErr)
+			if tt.wantErr {
+				assert.Error(t, err)
+				assert.Equal(t, "previous kubelet binary", string(contents))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "kubelet binary", string(contents))
+			recorded, err := wmcb.recordedKubeletDigest()
+			require.NoError(t, err)
+			assert.Equal(t, testKubeletDigest, recorded)
+		})
+	}
+}
+
+// TestStatusModifiedKubelet tests that status reports a kubelet which was changed after it was installed
+func TestStatusModifiedKubelet(t *testing.T) {
+	scm := newFakeSCM()
+	wmcb := newTestBootstrapper(t, scm)
+	defer os.RemoveAll(filepath.Dir(wmcb.installDir))
+	require.NoError(t, wmcb.SetKubeletVerification(testKubeletDigest, "", ""))
+	require.NoError(t, wmcb.Run(context.Background()))
+
+	status, err := wmcb.Status()
+	require.NoError(t, err)
+	kubelet := status.Files[len(status.Files)-1]
+	assert.Equal(t, testKubeletDigest, kubelet.RecordedSHA256)
+	assert.False(t, kubelet.Modified)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(wmcb.installDir, "kubelet.exe"), []byte("tampered"), 0755))
+	status, err = wmcb.Status()
+	require.NoError(t, err)
+	require.NoError(t, wmcb.Disconnect())
+	kubelet = status.Files[len(status.Files)-1]
+	assert.Equal(t, testKubeletDigest, kubelet.RecordedSHA256)
+	assert.True(t, kubelet.Modified)
+}
@@ -0,0 +1,184 @@
+package bootstrapper
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// lockFileName is the name of the lock file in the install directory
+	lockFileName = "wmcb.lock"
+	// lockPollInterval is how often a lock held by another process is checked while waiting for it
+	lockPollInterval = 500 * time.Millisecond
+)
+
+// LockHolder is the process holding the lock on an install directory
+type LockHolder struct {
+	// PID is the process ID of the holder
+	PID int `json:"pid"`
+	// Command is the command line of the holder
+	Command string `json:"command"`
+	// AcquiredAt is when the holder acquired the lock
+	AcquiredAt time.Time `json:"acquiredAt"`
+}
+
+// String describes the holder for error messages
+func (h LockHolder) String() string {
+	return fmt.Sprintf("process %d (%s) since %s", h.PID, h.Command, h.AcquiredAt.Format(time.RFC3339))
+}
+
+// LockedError is returned when the install directory is locked by another process
+type LockedError struct {
+	// Holder is the process holding the lock
+	Holder LockHolder
+}
+
+func (e *LockedError) Error() string {
+	return "install directory is locked by " + e.Holder.String()
+}
+
+// InstallLock is an exclusive lock on an install directory, held on a lock file which records its holder
+type InstallLock struct {
+	// path is the path of the lock file
+	path string
+	// holder is this process
+	holder LockHolder
+	// file is the open lock file, which is locked
+	file *os.File
+	// release releases the lock once
+	release sync.Once
+}
+
+// AcquireInstallLock locks the install directory, creating it if it does not exist. If another process holds the
+// lock, it waits up to wait for the lock to be released, and returns a *LockedError if it is not.
+func AcquireInstallLock(installDir string, wait time.Duration) (*InstallLock, error) {
+	return acquireInstallLock(installDir, wait, lockPollInterval)
+}
+
+// acquireInstallLock locks the install directory, checking a lock held by another process every pollInterval
+func acquireInstallLock(installDir string, wait, pollInterval time.Duration) (*InstallLock, error) {
+	if err := os.MkdirAll(installDir, 0755); err != nil {
+		return nil, fmt.Errorf("could not make install directory: %s", err)
+	}
+	lock := &InstallLock{path: filepath.Join(installDir, lockFileName), holder: currentLockHolder()}
+	deadline := time.Now().Add(wait)
+	for waiting := false; ; waiting = true {
+		locked, err := lock.tryAcquire()
+		if err == nil {
+			return lock, nil
+		}
+		if locked == nil {
+			return nil, err
+		}
+		if !time.Now().Before(deadline) {
+			return nil, locked
+		}
+		if !waiting {
+			log.Info("waiting for install directory lock", "holder", locked.Holder.String(), "wait", wait)
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// tryAcquire opens the lock file, creating it if it does not exist, locks it and records this process as its holder.
+// If the lock is held by another process, the returned *LockedError describes it.
+func (l *InstallLock) tryAcquire() (*LockedError, error) {
+	contents, err := json.Marshal(l.holder)
+	if err != nil {
+		return nil, err
+	}
+	for attempt := 0; attempt < 3; attempt++ {
+		file, err := os.OpenFile(l.path, os.O_RDWR|os.O_CREATE, 0644)
+		if os.IsNotExist(err) {
+			// The install directory was removed by the holder releasing the lock, once it uninstalled the node
+			if err = os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+				return nil, fmt.Errorf("could not make install directory: %s", err)
+			}
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not open lock file: %s", err)
+		}
+		locked, err := tryLockFile(file)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("could not lock lock file: %s", err)
+		}
+		if !locked {
+			file.Close()
+			lockedErr := &LockedError{Holder: readLockHolder(l.path)}
+			return lockedErr, lockedErr
+		}
+		// The holder releasing the lock removes the lock file, so a file opened before it was removed is no longer
+		// the lock file, even though it could be locked
+		if !l.isLockFile(file) {
+			file.Close()
+			continue
+		}
+		if err = writeLockHolder(file, contents); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("could not write lock file: %s", err)
+		}
+		l.file = file
+		return nil, nil
+	}
+	return nil, fmt.Errorf("could not lock %s: it was removed by other processes releasing it", l.path)
+}
+
+// isLockFile returns true if the open file is the file at the path of the lock file
+func (l *InstallLock) isLockFile(file *os.File) bool {
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	current, err := os.Stat(l.path)
+	return err == nil && os.SameFile(info, current)
+}
+
+// writeLockHolder replaces the contents of the open lock file with the holder it records
+func writeLockHolder(file *os.File, contents []byte) error {
+	if err := file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := file.WriteAt(contents, 0); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// readLockHolder returns the holder recorded in the lock file. The holder is empty if it cannot be read, as the lock
+// file is briefly empty while its holder writes it.
+func readLockHolder(path string) LockHolder {
+	var holder LockHolder
+	if contents, err := ioutil.ReadFile(path); err == nil {
+		json.Unmarshal(contents, &holder)
+	}
+	return holder
+}
+
+// Release releases the lock and removes the lock file. The install directory is removed if the lock file was all that
+// was left in it, as it is once the node is uninstalled. It is safe to call more than once.
+func (l *InstallLock) Release() error {
+	var err error
+	l.release.Do(func() {
+		err = releaseLockFile(l.file, l.path)
+		// Removing a directory fails if it is not empty
+		os.Remove(filepath.Dir(l.path))
+	})
+	return err
+}
+
+// currentLockHolder returns the lock holder describing this process
+func currentLockHolder() LockHolder {
+	return LockHolder{
+		PID:        os.Getpid(),
+		Command:    strings.Join(os.Args, " "),
+		AcquiredAt: time.Now(),
+	}
+}
@@ -0,0 +1,33 @@
+//go:build !windows
+// +build !windows
+
+package bootstrapper
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// tryLockFile locks the open file with an exclusive lock, without waiting. It returns false if another process holds
+// the lock. The lock is released when the file is closed.
+func tryLockFile(file *os.File) (bool, error) {
+	err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// releaseLockFile removes the locked lock file, and then unlocks it by closing it. It is removed first, as a process
+// which opened it before could otherwise lock it once it is closed, while another process locks a new lock file.
+func releaseLockFile(file *os.File, path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		file.Close()
+		return fmt.Errorf("could not remove lock file: %s", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("could not close lock file: %s", err)
+	}
+	return nil
+}
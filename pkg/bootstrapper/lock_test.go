@@ -0,0 +1,147 @@
+package bootstrapper
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTestLock writes a lock file held by holder to installDir
+func writeTestLock(t *testing.T, installDir string, holder LockHolder) {
+	contents, err := json.Marshal(holder)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(installDir, lockFileName), contents, 0644))
+}
+
+// exitedProcessPID returns the PID of a process which has exited
+func exitedProcessPID(t *testing.T) int {
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	require.NoError(t, cmd.Run())
+	return cmd.Process.Pid
+}
+
+// TestAcquireInstallLock tests that the install directory can only be locked by one holder at a time, and that a lock
+// file left behind by a holder which exited without releasing it does not keep the install directory locked
+func TestAcquireInstallLock(t *testing.T) {
+	current := currentLockHolder()
+	tests := []struct {
+		name string
+		// unlocked is true if there is no existing lock file
+		unlocked bool
+		// holder is the holder recorded in the existing lock file, the lock file has contents instead if nil
+		holder *LockHolder
+		// contents are the contents of the existing lock file
+		contents string
+	}{
+		{name: "Unlocked", unlocked: true},
+		{name: "Exited holder", holder: &LockHolder{PID: exitedProcessPID(t)}},
+		{name: "Holder not holding the lock", holder: &LockHolder{PID: current.PID}},
+		{name: "Empty lock file"},
+		{name: "Invalid lock file", contents: "{"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "wmcb")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+			installDir := filepath.Join(dir, "k")
+			lockPath := filepath.Join(installDir, lockFileName)
+			if !tt.unlocked {
+				require.NoError(t, os.MkdirAll(installDir, 0755))
+				if tt.holder != nil {
+					writeTestLock(t, installDir, *tt.holder)
+				} else {
+					require.NoError(t, ioutil.WriteFile(lockPath, []byte(tt.contents), 0644))
+				}
+			}
+
+			lock, err := acquireInstallLock(installDir, 0, time.Millisecond)
+			require.NoError(t, err)
+			contents, err := ioutil.ReadFile(lockPath)
+			require.NoError(t, err)
+			var holder LockHolder
+			require.NoError(t, json.Unmarshal(contents, &holder))
+			assert.Equal(t, os.Getpid(), holder.PID)
+
+			_, err = acquireInstallLock(installDir, 0, time.Millisecond)
+			require.Error(t, err, "install directory should be locked")
+			locked, ok := err.(*LockedError)
+			require.True(t, ok, "expected a LockedError, got %s", err)
+			assert.Equal(t, os.Getpid(), locked.Holder.PID)
+			require.NoError(t, lock.Release())
+			require.NoError(t, lock.Release())
+			_, err = os.Stat(installDir)
+			assert.True(t, os.IsNotExist(err), "empty install directory should be removed")
+		})
+	}
+}
+
+// TestAcquireInstallLockWait tests that the install directory is locked once its holder releases it, if it is
+// released within the wait time
+func TestAcquireInstallLockWait(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wmcb")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	first, err := acquireInstallLock(dir, 0, time.Millisecond)
+	require.NoError(t, err)
+	_, err = acquireInstallLock(dir, 20*time.Millisecond, time.Millisecond)
+	require.Error(t, err, "lock should not be released in time")
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		first.Release()
+	}()
+	second, err := acquireInstallLock(dir, 10*time.Second, time.Millisecond)
+	require.NoError(t, err)
+	assert.NoError(t, second.Release())
+}
+
+// TestInstallLockConcurrent tests that concurrent attempts to lock the install directory, while other holders release
+// it and a lock file left behind by an exited holder is taken over, never let two holders hold the lock at once
+func TestInstallLockConcurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wmcb")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	installDir := filepath.Join(dir, "k")
+	require.NoError(t, os.MkdirAll(installDir, 0755))
+	writeTestLock(t, installDir, LockHolder{PID: exitedProcessPID(t)})
+
+	var lock sync.Mutex
+	holders, maxHolders, acquired := 0, 0, 0
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				installLock, err := acquireInstallLock(installDir, 10*time.Second, time.Millisecond)
+				if !assert.NoError(t, err) {
+					return
+				}
+				lock.Lock()
+				holders++
+				if holders > maxHolders {
+					maxHolders = holders
+				}
+				acquired++
+				lock.Unlock()
+				time.Sleep(100 * time.Microsecond)
+				lock.Lock()
+				holders--
+				lock.Unlock()
+				assert.NoError(t, installLock.Release())
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, maxHolders, "install directory was locked by more than one holder at once")
+	assert.Equal(t, 8*20, acquired)
+}
@@ -0,0 +1,52 @@
+//go:build windows
+// +build windows
+
+package bootstrapper
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	// errorLockViolation is the error locking a file which another process has locked
+	errorLockViolation = syscall.Errno(33)
+	// lockfileFailImmediately and lockfileExclusiveLock make LockFileEx take an exclusive lock without waiting
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+	// lockedRegionOffsetHigh is the high part of the offset of the byte of the lock file which is locked. The byte is
+	// past the contents of the lock file, as Windows does not allow a locked region to be read by other processes.
+	lockedRegionOffsetHigh = 0x7fffffff
+)
+
+// procLockFileEx is LockFileEx, which golang.org/x/sys/windows does not define
+var procLockFileEx = windows.NewLazySystemDLL("kernel32.dll").NewProc("LockFileEx")
+
+// tryLockFile locks the open file with an exclusive lock, without waiting. It returns false if another process holds
+// the lock. The lock is released when the file is closed.
+func tryLockFile(file *os.File) (bool, error) {
+	overlapped := windows.Overlapped{OffsetHigh: lockedRegionOffsetHigh}
+	r, _, err := procLockFileEx.Call(file.Fd(), lockfileExclusiveLock|lockfileFailImmediately, 0, 1, 0,
+		uintptr(unsafe.Pointer(&overlapped)))
+	if r != 0 {
+		return true, nil
+	}
+	if err == errorLockViolation {
+		return false, nil
+	}
+	return false, err
+}
+
+// releaseLockFile unlocks the lock file by closing it, and then removes it. Windows does not remove a file which
+// another process has open, in which case the lock file is left to the process, which may lock it.
+func releaseLockFile(file *os.File, path string) error {
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("could not close lock file: %s", err)
+	}
+	os.Remove(path)
+	return nil
+}
@@ -0,0 +1,317 @@
+package bootstrapper
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// LogRotatorServiceName is the name of the Windows service which rotates the logs of the services the
+	// bootstrapper runs
+	LogRotatorServiceName = "wmcb-log-rotator"
+	// wmcbBinary is the name the bootstrapper binary is installed under, to run the log rotator service
+	wmcbBinary = "wmcb.exe"
+	// rotatedLogTimeFormat is the format of the time a log was rotated at, in the names of the rotated logs. It has no
+	// colons, which Windows does not allow in file names, and sorts in time order.
+	rotatedLogTimeFormat = "20060102T150405.000"
+	// defaultLogRotationCheckInterval is how often the log rotator service checks the logs by default
+	defaultLogRotationCheckInterval = time.Minute
+)
+
+// LogRotationOptions configures how the logs of the services are rotated. A log is rotated by copying it to a file
+// named after it and the time it was rotated at, then truncating it, as the services keep their logs open and Windows
+// does not allow renaming a file which is open. Lines written between the copy and the truncation are lost.
+type LogRotationOptions struct {
+	// MaxSize is the size in bytes a log is rotated at. Logs are not rotated by size if 0.
+	MaxSize int64
+	// MaxAge is the time after which a log is rotated, counted from when it was last rotated, or created. Logs are not
+	// rotated by age if 0.
+	MaxAge time.Duration
+	// MaxBackups is the number of rotated logs kept for each log, the oldest are deleted first. All are kept if 0.
+	MaxBackups int
+	// Retention is the time after which rotated logs are deleted. They are kept regardless of their age if 0.
+	Retention time.Duration
+	// Compress gzip compresses the rotated logs
+	Compress bool
+	// CheckInterval is how often the log rotator service checks the logs. Defaults to a minute.
+	CheckInterval time.Duration
+	// ExtraLogs are the paths of the logs of the added services, which are rotated along with the logs of the kubelet
+	// and hybrid overlay node
+	ExtraLogs []string
+}
+
+// validate returns an error if the options do not rotate the logs, or have negative values. A log rotated by age is
+// due when its newest rotated copy is MaxAge old, so the retention time must not be shorter than that: deleting every
+// copy would lose when the log was last rotated, and it would then be rotated at every check.
+func (o *LogRotationOptions) validate() error {
+	if o.MaxSize < 0 || o.MaxAge < 0 || o.MaxBackups < 0 || o.Retention < 0 || o.CheckInterval < 0 {
+		return fmt.Errorf("log rotation settings must not be negative")
+	}
+	if o.MaxSize == 0 && o.MaxAge == 0 {
+		return fmt.Errorf("logs must be rotated by size, age or both")
+	}
+	if o.Retention != 0 && o.Retention < o.MaxAge {
+		return fmt.Errorf("retention %s must not be shorter than the maximum age %s", o.Retention, o.MaxAge)
+	}
+	return nil
+}
+
+// args returns the arguments of the rotate-logs command of the bootstrapper which rotates the logs with the options
+func (o *LogRotationOptions) args() []string {
+	interval := o.CheckInterval
+	if interval == 0 {
+		interval = defaultLogRotationCheckInterval
+	}
+	args := []string{"--interval=" + interval.String()}
+	if o.MaxSize != 0 {
+		args = append(args, "--max-size="+strconv.FormatInt(o.MaxSize, 10))
+	}
+	if o.MaxAge != 0 {
+		args = append(args, "--max-age="+o.MaxAge.String())
+	}
+	if o.MaxBackups != 0 {
+		args = append(args, "--max-backups="+strconv.Itoa(o.MaxBackups))
+	}
+	if o.Retention != 0 {
+		args = append(args, "--retention="+o.Retention.String())
+	}
+	if o.Compress {
+		args = append(args, "--compress")
+	}
+	return args
+}
+
+// logRotatorOptions describes how the log rotator service is run
+type logRotatorOptions struct {
+	// binaryPath is the path the bootstrapper binary is copied from
+	binaryPath string
+	// rotation configures how the logs are rotated
+	rotation LogRotationOptions
+}
+
+// SetLogRotationOptions enables the log rotator service, which rotates the logs of the kubelet, the hybrid overlay
+// node and the logs given in options.ExtraLogs. The service runs the rotate-logs command of the running bootstrapper
+// binary, which is copied to the install directory.
+func (wmcb *winNodeBootstrapper) SetLogRotationOptions(options LogRotationOptions) error {
+	if err := options.validate(); err != nil {
+		return err
+	}
+	binaryPath, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("could not find bootstrapper binary: %s", err)
+	}
+	wmcb.logRotator = &logRotatorOptions{binaryPath: binaryPath, rotation: options}
+	return nil
+}
+
+// serviceLogs returns the paths of the logs written by the kubelet and hybrid overlay node services
+func (wmcb *winNodeBootstrapper) serviceLogs() []string {
+	return []string{filepath.Join(wmcb.logDir, "kubelet.log"), filepath.Join(wmcb.logDir, "hybrid-overlay.log")}
+}
+
+// initializeLogRotator copies the bootstrapper binary to the install directory through the stage
+func (wmcb *winNodeBootstrapper) initializeLogRotator(stage *fileStage) error {
+	if err := stage.copyFile(wmcb.logRotator.binaryPath, filepath.Join(wmcb.installDir, wmcbBinary)); err != nil {
+		return fmt.Errorf("could not copy bootstrapper for the log rotator: %s", err)
+	}
+	return nil
+}
+
+// logRotatorServiceSpec returns the spec of the log rotator service
+func (wmcb *winNodeBootstrapper) logRotatorServiceSpec() ServiceSpec {
+	args := append([]string{"rotate-logs", "--windows-service"}, wmcb.logRotator.rotation.args()...)
+	for _, path := range append(wmcb.serviceLogs(), wmcb.logRotator.rotation.ExtraLogs...) {
+		args = append(args, "--log-file="+path)
+	}
+	return ServiceSpec{
+		Name:                LogRotatorServiceName,
+		BinaryPath:          filepath.Join(wmcb.installDir, wmcbBinary),
+		Args:                args,
+		Description:         "OpenShift Windows Machine Config Bootstrapper log rotator",
+		StartType:           StartAutomatic,
+		RecoveryActions:     wmcb.recoveryActions,
+		RecoveryResetPeriod: wmcb.recoveryResetPeriod,
+	}
+}
+
+// rotatedLog is a copy of a log made when it was rotated
+type rotatedLog struct {
+	path string
+	// time is when the log was rotated
+	time time.Time
+}
+
+// rotatedLogName returns the name of the copy of the log at path rotated at the given time
+func rotatedLogName(path string, rotated time.Time, compress bool) string {
+	ext := filepath.Ext(path)
+	name := strings.TrimSuffix(path, ext) + "-" + rotated.UTC().Format(rotatedLogTimeFormat) + ext
+	if compress {
+		name += ".gz"
+	}
+	return name
+}
+
+// rotatedLogs returns the rotated copies of the log at path, oldest first
+func rotatedLogs(path string) ([]rotatedLog, error) {
+	ext := filepath.Ext(path)
+	prefix := strings.TrimSuffix(path, ext) + "-"
+	matches, err := filepath.Glob(escapeGlob(prefix) + "*" + escapeGlob(ext) + "*")
+	if err != nil {
+		return nil, err
+	}
+	var logs []rotatedLog
+	for _, match := range matches {
+		stamp := strings.TrimSuffix(strings.TrimSuffix(strings.TrimPrefix(match, prefix), ".gz"), ext)
+		rotated, err := time.Parse(rotatedLogTimeFormat, stamp)
+		if err != nil {
+			// Not a rotated copy of the log, such as the log of another service sharing its prefix
+			continue
+		}
+		logs = append(logs, rotatedLog{path: match, time: rotated})
+	}
+	sort.Slice(logs, func(i, j int) bool { return logs[i].time.Before(logs[j].time) })
+	return logs, nil
+}
+
+// escapeGlob escapes the characters of path which have a special meaning in a glob pattern
+func escapeGlob(path string) string {
+	replacer := strings.NewReplacer("*", "[*]", "?", "[?]", "[", "[[]")
+	return replacer.Replace(path)
+}
+
+// RotateLogs rotates each of the logs which is due to be rotated, then deletes the rotated copies of it which are no
+// longer retained. A log which is missing or empty is not rotated. An error with one log does not prevent the others
+// from being rotated, and the errors are returned together.
+func RotateLogs(paths []string, options LogRotationOptions) error {
+	var errs []string
+	for _, path := range paths {
+		if err := rotateLog(path, options, time.Now()); err != nil {
+			errs = append(errs, fmt.Sprintf("could not rotate %s: %s", path, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// rotateLog rotates the log at path if it is due to be rotated at now, then deletes the rotated copies of it which are
+// no longer retained
+func rotateLog(path string, options LogRotationOptions, now time.Time) error {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	logs, err := rotatedLogs(path)
+	if err != nil {
+		return err
+	}
+	// The log was last rotated when its newest copy was made, or if it was never rotated, when it was created
+	lastRotated := fileCreationTime(info)
+	if len(logs) > 0 {
+		lastRotated = logs[len(logs)-1].time
+	}
+	due := options.MaxSize != 0 && info.Size() >= options.MaxSize ||
+		options.MaxAge != 0 && now.Sub(lastRotated) >= options.MaxAge
+	if info.Size() > 0 && due {
+		rotated := rotatedLogName(path, now, options.Compress)
+		if err = copyTruncateLog(path, rotated, info.Size(), options.Compress); err != nil {
+			return err
+		}
+		log.Info("rotated log", "path", path, "rotated", rotated)
+		logs = append(logs, rotatedLog{path: rotated, time: now})
+	}
+	return pruneRotatedLogs(logs, options, now)
+}
+
+// copyTruncateLog copies the first size bytes of the log at path to dest, compressing them if compress is true, and
+// then truncates the log. The copy is written to a temporary file and renamed into place, so that an incomplete copy
+// is never taken for a rotated log.
+func copyTruncateLog(path, dest string, size int64, compress bool) error {
+	from, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer from.Close()
+	tmp := dest + ".tmp"
+	to, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	var w io.Writer = to
+	var gz *gzip.Writer
+	if compress {
+		gz = gzip.NewWriter(to)
+		w = gz
+	}
+	_, err = io.CopyN(w, from, size)
+	if err == nil && gz != nil {
+		err = gz.Close()
+	}
+	if err == nil {
+		err = to.Sync()
+	}
+	if closeErr := to.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, dest)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("could not copy log to %s: %s", dest, err)
+	}
+	// The services open their logs for appending, so they carry on writing from the start of the truncated log
+	if err = os.Truncate(path, 0); err != nil {
+		return fmt.Errorf("could not truncate log: %s", err)
+	}
+	return nil
+}
+
+// pruneRotatedLogs deletes the rotated logs, given oldest first, which are older than the retention time or are not
+// among the newest MaxBackups
+func pruneRotatedLogs(logs []rotatedLog, options LogRotationOptions, now time.Time) error {
+	for i, rotated := range logs {
+		expired := options.Retention != 0 && now.Sub(rotated.time) > options.Retention
+		excess := options.MaxBackups != 0 && i < len(logs)-options.MaxBackups
+		if !expired && !excess {
+			continue
+		}
+		if err := os.Remove(rotated.path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("could not delete rotated log: %s", err)
+		}
+		log.Info("deleted rotated log", "path", rotated.path)
+	}
+	return nil
+}
+
+// RunLogRotator rotates the logs with RotateLogs every CheckInterval, or every minute if it is not set, until stop is
+// closed. Errors are logged, so that a log which cannot be rotated does not stop the others from being rotated later.
+func RunLogRotator(paths []string, options LogRotationOptions, stop <-chan struct{}) {
+	interval := options.CheckInterval
+	if interval == 0 {
+		interval = defaultLogRotationCheckInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := RotateLogs(paths, options); err != nil {
+			log.Error(err, "could not rotate logs")
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package bootstrapper
+
+import (
+	"os"
+	"time"
+)
+
+// fileCreationTime returns when the file was last modified, as the creation time of files is not known on every
+// platform
+func fileCreationTime(info os.FileInfo) time.Time {
+	return info.ModTime()
+}
@@ -0,0 +1,220 @@
+package bootstrapper
+
+import (
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// listDir returns the names of the files in dir, sorted
+func listDir(t *testing.T, dir string) []string {
+	entries, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+// TestRotateLog tests that logs are rotated once they reach their maximum size or age, and that the rotated copies
+// which are no longer retained are deleted
+func TestRotateLog(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		log     string
+		backups []string
+		options LogRotationOptions
+		want    []string
+	}{
+		{name: "Below maximum size", log: "short", options: LogRotationOptions{MaxSize: 10},
+			want: []string{"kubelet.log"}},
+		{name: "Maximum size", log: "long enough", options: LogRotationOptions{MaxSize: 10},
+			want: []string{"kubelet-20200601T120000.000.log", "kubelet.log"}},
+		{name: "Empty", log: "", options: LogRotationOptions{MaxAge: time.Hour},
+			backups: []string{"kubelet-20200501T120000.000.log"},
+			want:    []string{"kubelet-20200501T120000.000.log", "kubelet.log"}},
+		{name: "Rotated recently", log: "line", options: LogRotationOptions{MaxAge: 24 * time.Hour},
+			backups: []string{"kubelet-20200601T000000.000.log"},
+			want:    []string{"kubelet-20200601T000000.000.log", "kubelet.log"}},
+		{name: "Maximum age", log: "line", options: LogRotationOptions{MaxAge: 24 * time.Hour},
+			backups: []string{"kubelet-20200531T000000.000.log"},
+			want:    []string{"kubelet-20200531T000000.000.log", "kubelet-20200601T120000.000.log", "kubelet.log"}},
+		{name: "Maximum backups", log: "long enough", options: LogRotationOptions{MaxSize: 10, MaxBackups: 2},
+			backups: []string{"kubelet-20200529T000000.000.log", "kubelet-20200530T000000.000.log.gz",
+				"kubelet-20200531T000000.000.log"},
+			want: []string{"kubelet-20200531T000000.000.log", "kubelet-20200601T120000.000.log", "kubelet.log"}},
+		{name: "Retention", log: "short", options: LogRotationOptions{MaxSize: 10, Retention: 48 * time.Hour},
+			backups: []string{"kubelet-20200529T000000.000.log", "kubelet-20200531T000000.000.log"},
+			want:    []string{"kubelet-20200531T000000.000.log", "kubelet.log"}},
+		{name: "Other files", log: "short", options: LogRotationOptions{MaxSize: 10, MaxBackups: 1},
+			backups: []string{"kubelet-old.log", "kubelet-20200531T000000.000.txt", "kubelet.log.tmp",
+				"kubelet-20200530T000000.000.log"},
+			want: []string{"kubelet-20200530T000000.000.log", "kubelet-20200531T000000.000.txt", "kubelet-old.log",
+				"kubelet.log", "kubelet.log.tmp"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "wmcb")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "kubelet.log")
+			require.NoError(t, ioutil.WriteFile(path, []byte(tt.log), 0644))
+			for _, name := range tt.backups {
+				require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte("rotated"), 0644))
+			}
+
+			require.NoError(t, rotateLog(path, tt.options, now))
+			assert.Equal(t, tt.want, listDir(t, dir))
+			rotated := filepath.Join(dir, "kubelet-20200601T120000.000.log")
+			if _, err := os.Stat(rotated); err == nil {
+				contents, err := ioutil.ReadFile(rotated)
+				require.NoError(t, err)
+				assert.Equal(t, tt.log, string(contents))
+				info, err := os.Stat(path)
+				require.NoError(t, err)
+				assert.Zero(t, info.Size(), "log was not truncated")
+			}
+		})
+	}
+}
+
+// TestRotateLogRetention tests that a log rotated by age is rotated every maximum age when the rotated copies are only
+// retained for that long, as its newest copy, which records when it was last rotated, is never deleted first
+func TestRotateLogRetention(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wmcb")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "kubelet.log")
+	start := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	options := LogRotationOptions{MaxAge: time.Hour, Retention: time.Hour}
+	require.NoError(t, options.validate())
+
+	rotations := make(map[string]bool)
+	for now := start.Add(10 * time.Minute); !now.After(start.Add(4 * time.Hour)); now = now.Add(10 * time.Minute) {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		require.NoError(t, err)
+		_, err = file.WriteString("line\n")
+		require.NoError(t, err)
+		require.NoError(t, file.Close())
+		// Truncating the log does not reset when it was created, which is what it falls back to
+		require.NoError(t, os.Chtimes(path, start, start))
+
+		require.NoError(t, rotateLog(path, options, now))
+		logs, err := rotatedLogs(path)
+		require.NoError(t, err)
+		if now.Sub(start) >= options.MaxAge {
+			require.NotEmpty(t, logs, "every rotated copy was deleted at %s", now)
+		}
+		for _, rotated := range logs {
+			rotations[filepath.Base(rotated.path)] = true
+		}
+	}
+	assert.Len(t, rotations, 4)
+}
+
+// TestRotateLogCompress tests that rotated logs are compressed, and that logs which do not exist are skipped
+func TestRotateLogCompress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wmcb")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "hybrid-overlay.log")
+	contents := strings.Repeat("I0601 12:00:00.000000 1 node.go:100] hybrid overlay node running\n", 100)
+	require.NoError(t, ioutil.WriteFile(path, []byte(contents), 0644))
+	options := LogRotationOptions{MaxSize: 1024, Compress: true}
+
+	require.NoError(t, RotateLogs([]string{path, filepath.Join(dir, "kubelet.log")}, options))
+	logs, err := rotatedLogs(path)
+	require.NoError(t, err)
+	require.Len(t, logs, 1)
+	assert.True(t, strings.HasSuffix(logs[0].path, ".log.gz"))
+	file, err := os.Open(logs[0].path)
+	require.NoError(t, err)
+	defer file.Close()
+	reader, err := gzip.NewReader(file)
+	require.NoError(t, err)
+	rotated, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, contents, string(rotated))
+	assert.Equal(t, []string{filepath.Base(logs[0].path), "hybrid-overlay.log"}, listDir(t, dir))
+}
+
+// TestRunLogRotator tests that Run installs the log rotator service along with the bootstrapper binary, and removes
+// it once log rotation is disabled
+func TestRunLogRotator(t *testing.T) {
+	scm := newFakeSCM()
+	wmcb := newTestBootstrapper(t, scm)
+	testDir := filepath.Dir(wmcb.installDir)
+	defer os.RemoveAll(testDir)
+	options := LogRotationOptions{MaxSize: 100 << 20, MaxBackups: 5, Retention: 168 * time.Hour, Compress: true,
+		ExtraLogs: []string{filepath.Join(testDir, "kube-proxy.log")}}
+	require.NoError(t, wmcb.SetLogRotationOptions(options))
+	// Copy a stand-in rather than the test binary
+	wmcb.logRotator.binaryPath = filepath.Join(testDir, "wmcb-download.exe")
+	require.NoError(t, ioutil.WriteFile(wmcb.logRotator.binaryPath, []byte("wmcb binary"), 0755))
+
+	require.NoError(t, wmcb.Run(context.Background()))
+	require.NoError(t, wmcb.Disconnect())
+	assertKubeletServiceRunning(t, scm, wmcb.installDir)
+	rotator := scm.get(LogRotatorServiceName)
+	require.NotNil(t, rotator, "log rotator service is not installed")
+	assert.Equal(t, ServiceRunning, rotator.state)
+	assert.Equal(t, filepath.Join(wmcb.installDir, wmcbBinary), rotator.exePath())
+	assert.Equal(t, []string{"rotate-logs", "--windows-service", "--interval=1m0s", "--max-size=104857600",
+		"--max-backups=5", "--retention=168h0m0s", "--compress",
+		"--log-file=" + filepath.Join(wmcb.installDir, "kubelet.log"),
+		"--log-file=" + filepath.Join(wmcb.installDir, "hybrid-overlay.log"),
+		"--log-file=" + filepath.Join(testDir, "kube-proxy.log")}, rotator.args)
+	assert.FileExists(t, filepath.Join(wmcb.installDir, wmcbBinary))
+
+	wmcb, err := newWinNodeBootstrapper(wmcb.installDir, wmcb.ignitionFilePath, wmcb.initialKubeletPath, scm.connect)
+	require.NoError(t, err)
+	wmcb.services.waitTime = 10 * time.Millisecond
+	wmcb.certDir = filepath.Join(testDir, "pki")
+	require.NoError(t, wmcb.Run(context.Background()))
+	require.NoError(t, wmcb.Disconnect())
+	assert.Nil(t, scm.get(LogRotatorServiceName), "log rotator service was not removed")
+	assertKubeletServiceRunning(t, scm, wmcb.installDir)
+}
+
+// TestSetLogRotationOptions tests validation of the log rotation options
+func TestSetLogRotationOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		options LogRotationOptions
+		wantErr bool
+	}{
+		{name: "Size", options: LogRotationOptions{MaxSize: 1 << 20}},
+		{name: "Age", options: LogRotationOptions{MaxAge: time.Hour, Compress: true}},
+		{name: "Retention only", options: LogRotationOptions{Retention: time.Hour}, wantErr: true},
+		{name: "Negative backups", options: LogRotationOptions{MaxSize: 1 << 20, MaxBackups: -1}, wantErr: true},
+		{name: "Retention of maximum age", options: LogRotationOptions{MaxAge: time.Hour, Retention: time.Hour}},
+		{name: "Retention shorter than maximum age",
+			options: LogRotationOptions{MaxAge: 24 * time.Hour, Retention: time.Hour}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wmcb := winNodeBootstrapper{}
+			err := wmcb.SetLogRotationOptions(tt.options)
+			if tt.wantErr {
+				assert.Error(t, err)
+				assert.Nil(t, wmcb.logRotator)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.options, wmcb.logRotator.rotation)
+			assert.NotEmpty(t, wmcb.logRotator.binaryPath)
+		})
+	}
+}
@@ -0,0 +1,18 @@
+//go:build windows
+// +build windows
+
+package bootstrapper
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// fileCreationTime returns when the file was created, or when it was last modified if that is not known
+func fileCreationTime(info os.FileInfo) time.Time {
+	if data, ok := info.Sys().(*syscall.Win32FileAttributeData); ok {
+		return time.Unix(0, data.CreationTime.Nanoseconds())
+	}
+	return info.ModTime()
+}
@@ -0,0 +1,276 @@
+package bootstrapper
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// proxyEnvIgnitionPath is the environment file the Machine Config Operator writes the cluster-wide proxy settings
+	// to, which the drop-ins of the kubelet and CRI-O units load
+	proxyEnvIgnitionPath = "/etc/mco/proxy.env"
+	// trustAnchorsIgnitionDir is the directory of the CA certificates Linux nodes trust, which holds the additional
+	// trust bundle of the cluster-wide proxy
+	trustAnchorsIgnitionDir = "/etc/pki/ca-trust/source/anchors/"
+	// proxyCABundleFile is the name the CA certificates from the trust anchors directory are installed under
+	proxyCABundleFile = "proxy-ca-bundle.crt"
+	// trustedProxyCAsFile is the name of the file recording the installed CA certificates which were already trusted,
+	// which are never removed from the certificate store
+	trustedProxyCAsFile = "proxy-ca-trusted.crt"
+)
+
+// proxyEnvVars are the environment variables which configure the proxy the services go through, which Go programs,
+// such as the kubelet, read in either case
+var proxyEnvVars = []string{"HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY"}
+
+// certificateStore is the store of the CA certificates the services trust
+type certificateStore interface {
+	// add adds the certificates to the store, keeping the ones which are already in it
+	add(certs []*x509.Certificate) error
+	// remove removes the certificates from the store, if they are in it
+	remove(certs []*x509.Certificate) error
+	// contains returns true if the certificate is in the store
+	contains(cert *x509.Certificate) (bool, error)
+}
+
+// proxyEnvironment returns the proxy variables set in env, as KEY=VALUE sorted by name. The uppercase variables take
+// precedence over the lowercase ones, and are returned in uppercase.
+func proxyEnvironment(env map[string]string) []string {
+	var proxyEnv []string
+	for _, name := range proxyEnvVars {
+		value, ok := env[name]
+		if !ok {
+			value = env[strings.ToLower(name)]
+		}
+		if value != "" {
+			proxyEnv = append(proxyEnv, name+"="+value)
+		}
+	}
+	sort.Strings(proxyEnv)
+	return proxyEnv
+}
+
+// proxyFromIgnition returns the proxy variables of the kubelet unit, as KEY=VALUE, falling back to the proxy
+// environment file written by the Machine Config Operator
+func proxyFromIgnition(configuration ignitionConfig, files map[string]ignitionFile) ([]string, error) {
+	for _, unit := range configuration.units {
+		if unit.name != kubeletSystemdName {
+			continue
+		}
+		parsed, err := parseSystemdUnit(unit.contents, unit.dropins)
+		if err != nil {
+			return nil, err
+		}
+		env, err := parsed.environment(files)
+		if err != nil {
+			return nil, err
+		}
+		if proxyEnv := proxyEnvironment(env); len(proxyEnv) > 0 {
+			return proxyEnv, nil
+		}
+	}
+	ignFile, ok := files[proxyEnvIgnitionPath]
+	if !ok {
+		return nil, nil
+	}
+	contents, err := ignFile.contents()
+	if err != nil {
+		return nil, fmt.Errorf("could not decode %s: %s", proxyEnvIgnitionPath, err)
+	}
+	env := make(map[string]string)
+	if err = parseEnvironmentFile(string(contents), env); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %s", proxyEnvIgnitionPath, err)
+	}
+	return proxyEnvironment(env), nil
+}
+
+// parseCertificates returns the certificates in the PEM bundle. Blocks which are not certificates are skipped.
+func parseCertificates(bundle []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, bundle = pem.Decode(bundle)
+		if block == nil {
+			return certs, nil
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+}
+
+// installProxyCABundle writes the CA certificates in the trust anchors directory of the ignition configuration to the
+// install directory through the stage, and adds them to the certificate store the services trust
+func (wmcb *winNodeBootstrapper) installProxyCABundle(configuration ignitionConfig, stage *fileStage) error {
+	var bundle bytes.Buffer
+	for _, ignFile := range configuration.files {
+		if !strings.HasPrefix(ignFile.path, trustAnchorsIgnitionDir) {
+			continue
+		}
+		contents, err := ignFile.contents()
+		if err != nil {
+			return fmt.Errorf("could not decode %s: %s", ignFile.path, err)
+		}
+		bundle.Write(bytes.TrimSpace(contents))
+		bundle.WriteString("\n")
+	}
+	var certs []*x509.Certificate
+	if bundle.Len() > 0 {
+		var err error
+		if certs, err = parseCertificates(bundle.Bytes()); err != nil {
+			return fmt.Errorf("invalid CA certificate in %s: %s", trustAnchorsIgnitionDir, err)
+		}
+		if len(certs) == 0 {
+			return fmt.Errorf("no CA certificates found in %s", trustAnchorsIgnitionDir)
+		}
+	}
+	installed, err := wmcb.installedProxyCAs()
+	if err != nil {
+		return fmt.Errorf("could not read installed proxy CA bundle: %s", err)
+	}
+	wasTrusted, err := wmcb.trustedProxyCAs()
+	if err != nil {
+		return fmt.Errorf("could not read the proxy CA certificates which were already trusted: %s", err)
+	}
+	// The certificates which were trusted before they were installed, by an administrator or a group policy, are
+	// never removed from the store, so the ones which are newly installed are looked for in it first
+	trusted := subtractCertificates(wasTrusted, subtractCertificates(wasTrusted, certs))
+	var added []*x509.Certificate
+	for _, cert := range subtractCertificates(certs, installed) {
+		found, err := wmcb.trustStore.contains(cert)
+		if err != nil {
+			return fmt.Errorf("could not look for proxy CA certificate %s in the trusted root certificates: %s",
+				cert.Subject, err)
+		}
+		if found {
+			trusted = append(trusted, cert)
+		} else {
+			added = append(added, cert)
+		}
+	}
+
+	if err = stageCertificates(stage, filepath.Join(wmcb.installDir, proxyCABundleFile), bundle.Bytes(),
+		certs); err != nil {
+		return fmt.Errorf("could not install proxy CA bundle: %s", err)
+	}
+	if err = stageCertificates(stage, filepath.Join(wmcb.installDir, trustedProxyCAsFile), encodeCertificates(trusted),
+		trusted); err != nil {
+		return fmt.Errorf("could not record the proxy CA certificates which were already trusted: %s", err)
+	}
+	if len(added) > 0 {
+		stage.onRollback(func() error {
+			if err := wmcb.trustStore.remove(added); err != nil {
+				return fmt.Errorf("could not stop trusting added proxy CA certificates: %s", err)
+			}
+			return nil
+		})
+	}
+	if len(certs) > 0 {
+		// The certificates which are already trusted are added again, in case they were removed from the store
+		if err = wmcb.trustStore.add(certs); err != nil {
+			return fmt.Errorf("could not add proxy CA certificates to the trusted root certificates: %s", err)
+		}
+		log.Info("trusting proxy CA certificates", "count", len(certs), "alreadyTrusted", len(trusted))
+	}
+	dropped := subtractCertificates(subtractCertificates(installed, certs), wasTrusted)
+	if len(dropped) > 0 {
+		stage.onRollback(func() error {
+			if err := wmcb.trustStore.add(dropped); err != nil {
+				return fmt.Errorf("could not trust dropped proxy CA certificates again: %s", err)
+			}
+			return nil
+		})
+		if err = wmcb.trustStore.remove(dropped); err != nil {
+			return fmt.Errorf("could not remove dropped proxy CA certificates from the trusted root certificates: %s",
+				err)
+		}
+		log.Info("no longer trusting dropped proxy CA certificates", "count", len(dropped))
+	}
+	return nil
+}
+
+// stageCertificates writes the encoded certificates to path through the stage, or removes it if there are none
+func stageCertificates(stage *fileStage, path string, encoded []byte, certs []*x509.Certificate) error {
+	if len(certs) == 0 {
+		return stage.removeFile(path)
+	}
+	return stage.writeFile(path, encoded, 0644)
+}
+
+// encodeCertificates returns the certificates as a PEM bundle
+func encodeCertificates(certs []*x509.Certificate) []byte {
+	var bundle bytes.Buffer
+	for _, cert := range certs {
+		pem.Encode(&bundle, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	}
+	return bundle.Bytes()
+}
+
+// subtractCertificates returns the certificates in certs which are not in other
+func subtractCertificates(certs, other []*x509.Certificate) []*x509.Certificate {
+	var difference []*x509.Certificate
+	for _, cert := range certs {
+		found := false
+		for _, otherCert := range other {
+			if cert.Equal(otherCert) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			difference = append(difference, cert)
+		}
+	}
+	return difference
+}
+
+// installedProxyCAs returns the CA certificates in the installed proxy CA bundle, or nil if there is none
+func (wmcb *winNodeBootstrapper) installedProxyCAs() ([]*x509.Certificate, error) {
+	return readCertificates(filepath.Join(wmcb.installDir, proxyCABundleFile))
+}
+
+// trustedProxyCAs returns the installed CA certificates which were already trusted when they were installed
+func (wmcb *winNodeBootstrapper) trustedProxyCAs() ([]*x509.Certificate, error) {
+	return readCertificates(filepath.Join(wmcb.installDir, trustedProxyCAsFile))
+}
+
+// readCertificates returns the certificates in the PEM bundle at path, or nil if it does not exist
+func readCertificates(path string) ([]*x509.Certificate, error) {
+	bundle, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseCertificates(bundle)
+}
+
+// removeProxyCABundle removes the CA certificates in the installed proxy CA bundle which were not already trusted from
+// the certificate store the services trust
+func (wmcb *winNodeBootstrapper) removeProxyCABundle() error {
+	certs, err := wmcb.installedProxyCAs()
+	if err != nil {
+		return err
+	}
+	trusted, err := wmcb.trustedProxyCAs()
+	if err != nil {
+		return err
+	}
+	if certs = subtractCertificates(certs, trusted); len(certs) == 0 {
+		return nil
+	}
+	return wmcb.trustStore.remove(certs)
+}
@@ -0,0 +1,377 @@
+package bootstrapper
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vincent-petithory/dataurl"
+)
+
+// testProxyDropin is the drop-in the Machine Config Operator adds to the kubelet unit on clusters with a proxy
+const testProxyDropin = `[Service]
+EnvironmentFile=/etc/mco/proxy.env
+`
+
+// testProxyEnv is the proxy environment file the Machine Config Operator writes
+const testProxyEnv = `HTTP_PROXY=http://proxy.example.com:3128
+HTTPS_PROXY=http://proxy.example.com:3128
+NO_PROXY=.cluster.local,.svc,10.0.0.0/16,api-int.example.com
+`
+
+// fakeCertificateStore is a certificateStore holding the certificates in memory, by their DER encoding
+type fakeCertificateStore struct {
+	certs map[string]*x509.Certificate
+}
+
+func (s *fakeCertificateStore) add(certs []*x509.Certificate) error {
+	for _, cert := range certs {
+		s.certs[string(cert.Raw)] = cert
+	}
+	return nil
+}
+
+func (s *fakeCertificateStore) remove(certs []*x509.Certificate) error {
+	for _, cert := range certs {
+		delete(s.certs, string(cert.Raw))
+	}
+	return nil
+}
+
+func (s *fakeCertificateStore) contains(cert *x509.Certificate) (bool, error) {
+	_, ok := s.certs[string(cert.Raw)]
+	return ok, nil
+}
+
+// testCACert returns a PEM encoded self-signed CA certificate with the given common name
+func testCACert(t *testing.T, commonName string) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+// writeCustomIgnitionFile writes a spec 3.1 ignition file to dir, with the files the bootstrapper consumes, the given
+// extra files, and the kubelet unit with the given drop-ins, and returns its path
+func writeCustomIgnitionFile(t *testing.T, dir string, files map[string]string, dropins map[string]string) string {
+	type ignFile struct {
+		Path     string `json:"path"`
+		Contents struct {
+			Source string `json:"source"`
+		} `json:"contents"`
+	}
+	type ignDropin struct {
+		Name     string `json:"name"`
+		Contents string `json:"contents"`
+	}
+	var config struct {
+		Ignition struct {
+			Version string `json:"version"`
+		} `json:"ignition"`
+		Storage struct {
+			Files []ignFile `json:"files"`
+		} `json:"storage"`
+		Systemd struct {
+			Units []struct {
+				Name     string      `json:"name"`
+				Contents string      `json:"contents"`
+				Dropins  []ignDropin `json:"dropins"`
+			} `json:"units"`
+		} `json:"systemd"`
+	}
+	config.Ignition.Version = "3.1.0"
+	for _, contents := range []map[string]string{testIgnitionFiles, files} {
+		for path, data := range contents {
+			file := ignFile{Path: path}
+			file.Contents.Source = dataurl.EncodeBytes([]byte(data))
+			config.Storage.Files = append(config.Storage.Files, file)
+		}
+	}
+	config.Systemd.Units = make([]struct {
+		Name     string      `json:"name"`
+		Contents string      `json:"contents"`
+		Dropins  []ignDropin `json:"dropins"`
+	}, 1)
+	config.Systemd.Units[0].Name = kubeletSystemdName
+	config.Systemd.Units[0].Contents = testKubeletUnit
+	for name, contents := range dropins {
+		config.Systemd.Units[0].Dropins = append(config.Systemd.Units[0].Dropins,
+			ignDropin{Name: name, Contents: contents})
+	}
+	contents, err := json.Marshal(config)
+	require.NoError(t, err)
+	path := filepath.Join(dir, "worker.ign")
+	require.NoError(t, ioutil.WriteFile(path, contents, 0644))
+	return path
+}
+
+// TestProxyFromIgnition tests that the proxy settings are found in the environment of the kubelet unit, or in the
+// proxy environment file if the kubelet unit does not set them
+func TestProxyFromIgnition(t *testing.T) {
+	wantProxyEnv := []string{"HTTPS_PROXY=http://proxy.example.com:3128", "HTTP_PROXY=http://proxy.example.com:3128",
+		"NO_PROXY=.cluster.local,.svc,10.0.0.0/16,api-int.example.com"}
+	tests := []struct {
+		name    string
+		files   map[string]string
+		dropins map[string]string
+		want    []string
+	}{
+		{name: "No proxy"},
+		{name: "Environment file", files: map[string]string{proxyEnvIgnitionPath: testProxyEnv},
+			dropins: map[string]string{"10-mco-default-env.conf": testProxyDropin}, want: wantProxyEnv},
+		{name: "Environment file without drop-in", files: map[string]string{proxyEnvIgnitionPath: testProxyEnv},
+			want: wantProxyEnv},
+		{name: "Lowercase environment",
+			dropins: map[string]string{"10-proxy.conf": "[Service]\nEnvironment=\"https_proxy=http://proxy:3128\" " +
+				"\"HTTP_PROXY=http://proxy:8080\" \"http_proxy=http://ignored:3128\"\n"},
+			want: []string{"HTTPS_PROXY=http://proxy:3128", "HTTP_PROXY=http://proxy:8080"}},
+		{name: "Drop-in over environment file", files: map[string]string{proxyEnvIgnitionPath: testProxyEnv},
+			dropins: map[string]string{"10-proxy.conf": "[Service]\nEnvironment=HTTPS_PROXY=http://other:3128\n"},
+			want:    []string{"HTTPS_PROXY=http://other:3128"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "wmcb")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+			configuration, err := readIgnitionFile(writeCustomIgnitionFile(t, dir, tt.files, tt.dropins))
+			require.NoError(t, err)
+			files := make(map[string]ignitionFile)
+			for _, file := range configuration.files {
+				files[file.path] = file
+			}
+			got, err := proxyFromIgnition(configuration, files)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+// TestWithEnvironment tests that default environment variables do not override the ones which are set
+func TestWithEnvironment(t *testing.T) {
+	assert.Nil(t, withEnvironment(nil, nil))
+	assert.Equal(t, []string{"B=2", "A=1"}, withEnvironment([]string{"B=2", "A=1"}, nil))
+	assert.Equal(t, []string{"A=1", "HTTPS_PROXY=http://own:3128", "NO_PROXY=.svc"},
+		withEnvironment([]string{"HTTPS_PROXY=http://own:3128", "A=1"},
+			[]string{"HTTPS_PROXY=http://proxy:3128", "NO_PROXY=.svc"}))
+}
+
+// TestRunProxy tests that Run runs the services with the proxy settings from the ignition file, trusts the proxy CA
+// certificates, and that uninstalling stops trusting them
+func TestRunProxy(t *testing.T) {
+	scm := newFakeSCM()
+	wmcb := newTestBootstrapper(t, scm)
+	testDir := filepath.Dir(wmcb.installDir)
+	defer os.RemoveAll(testDir)
+	store := &fakeCertificateStore{certs: make(map[string]*x509.Certificate)}
+	wmcb.trustStore = store
+	proxyCA := testCACert(t, "proxy-ca")
+	wmcb.ignitionFilePath = writeCustomIgnitionFile(t, testDir, map[string]string{
+		proxyEnvIgnitionPath: testProxyEnv,
+		trustAnchorsIgnitionDir + "openshift-config-user-ca-bundle.crt": proxyCA,
+	}, map[string]string{"10-mco-default-env.conf": testProxyDropin})
+	require.NoError(t, wmcb.AddService(ServiceSpec{Name: "kube-proxy", BinaryPath: `C:\k\kube-proxy.exe`,
+		StartType: StartAutomatic, Environment: []string{"NO_PROXY=*"}}))
+
+	require.NoError(t, wmcb.Run(context.Background()))
+	require.NoError(t, wmcb.Disconnect())
+	assertKubeletServiceRunning(t, scm, wmcb.installDir)
+	wantEnv := []string{"HTTPS_PROXY=http://proxy.example.com:3128", "HTTP_PROXY=http://proxy.example.com:3128",
+		"NO_PROXY=.cluster.local,.svc,10.0.0.0/16,api-int.example.com"}
+	assert.Equal(t, wantEnv, scm.get(KubeletServiceName).config.Environment)
+	assert.Equal(t, []string{"HTTPS_PROXY=http://proxy.example.com:3128", "HTTP_PROXY=http://proxy.example.com:3128",
+		"NO_PROXY=*"}, scm.get("kube-proxy").config.Environment)
+	bundle, err := ioutil.ReadFile(filepath.Join(wmcb.installDir, proxyCABundleFile))
+	require.NoError(t, err)
+	assert.Equal(t, proxyCA, string(bundle))
+	require.Len(t, store.certs, 1)
+	for _, cert := range store.certs {
+		assert.Equal(t, "proxy-ca", cert.Subject.CommonName)
+	}
+
+	// The proxy settings are removed from the services once the cluster no longer uses a proxy
+	wmcb, err = newWinNodeBootstrapper(wmcb.installDir, writeTestIgnitionFile(t, testDir), wmcb.initialKubeletPath,
+		scm.connect)
+	require.NoError(t, err)
+	wmcb.services.waitTime = 10 * time.Millisecond
+	wmcb.certDir = filepath.Join(testDir, "pki")
+	wmcb.trustStore = store
+	require.NoError(t, wmcb.Run(context.Background()))
+	require.NoError(t, wmcb.Disconnect())
+	assert.Empty(t, scm.get(KubeletServiceName).config.Environment)
+	assert.Equal(t, []string{KubeletServiceName}, wmcb.Changes().Services)
+
+	wmcb, err = newWinNodeBootstrapper(wmcb.installDir, "", "", scm.connect)
+	require.NoError(t, err)
+	wmcb.services.waitTime = 10 * time.Millisecond
+	wmcb.certDir = filepath.Join(testDir, "pki")
+	wmcb.trustStore = store
+	require.NoError(t, wmcb.Uninstall(context.Background(), UninstallOptions{}))
+	require.NoError(t, wmcb.Disconnect())
+	assert.Empty(t, store.certs, "proxy CA certificates are still trusted")
+	_, err = os.Stat(filepath.Join(wmcb.installDir, proxyCABundleFile))
+	assert.True(t, os.IsNotExist(err), "proxy CA bundle was not removed")
+}
+
+// TestRunProxyInvalidCA tests that Run fails if the trust anchors in the ignition file are not certificates
+func TestRunProxyInvalidCA(t *testing.T) {
+	scm := newFakeSCM()
+	wmcb := newTestBootstrapper(t, scm)
+	testDir := filepath.Dir(wmcb.installDir)
+	defer os.RemoveAll(testDir)
+	wmcb.trustStore = &fakeCertificateStore{certs: make(map[string]*x509.Certificate)}
+	wmcb.ignitionFilePath = writeCustomIgnitionFile(t, testDir, map[string]string{
+		trustAnchorsIgnitionDir + "openshift-config-user-ca-bundle.crt": "not a certificate",
+	}, nil)
+
+	err := wmcb.Run(context.Background())
+	require.Error(t, err)
+	require.NoError(t, wmcb.Disconnect())
+	assert.Equal(t, FailureFiles, FailureCategoryOf(err))
+	assert.Contains(t, err.Error(), "no CA certificates found")
+}
+
+// TestRunProxyCARotation tests that the proxy CA certificates dropped from the ignition file are no longer trusted,
+// and that the changes to the trusted certificates are undone if the run fails
+func TestRunProxyCARotation(t *testing.T) {
+	scm := newFakeSCM()
+	wmcb := newTestBootstrapper(t, scm)
+	testDir := filepath.Dir(wmcb.installDir)
+	defer os.RemoveAll(testDir)
+	store := &fakeCertificateStore{certs: make(map[string]*x509.Certificate)}
+	caA, caB, caC := testCACert(t, "ca-a"), testCACert(t, "ca-b"), testCACert(t, "ca-c")
+	trusted := func() []string {
+		var names []string
+		for _, cert := range store.certs {
+			names = append(names, cert.Subject.CommonName)
+		}
+		sort.Strings(names)
+		return names
+	}
+	// run runs a new bootstrapper with the given trust anchors, along with the given added services
+	run := func(anchors string, services ...ServiceSpec) error {
+		wmcb, err := newWinNodeBootstrapper(wmcb.installDir, "", wmcb.initialKubeletPath, scm.connect)
+		require.NoError(t, err)
+		wmcb.services.waitTime = 10 * time.Millisecond
+		wmcb.certDir = filepath.Join(testDir, "pki")
+		wmcb.trustStore = store
+		files := make(map[string]string)
+		if anchors != "" {
+			files[trustAnchorsIgnitionDir+"openshift-config-user-ca-bundle.crt"] = anchors
+		}
+		wmcb.ignitionFilePath = writeCustomIgnitionFile(t, testDir, files, nil)
+		for _, spec := range services {
+			require.NoError(t, wmcb.AddService(spec))
+		}
+		defer wmcb.Disconnect()
+		return wmcb.Run(context.Background())
+	}
+
+	require.NoError(t, run(caA+caB))
+	assert.Equal(t, []string{"ca-a", "ca-b"}, trusted())
+	require.NoError(t, run(caA+caC))
+	assert.Equal(t, []string{"ca-a", "ca-c"}, trusted())
+	assertFileContents(t, filepath.Join(wmcb.installDir, proxyCABundleFile), caA+caC)
+
+	// A run which fails keeps trusting the certificates it dropped, and stops trusting the ones it added
+	require.Error(t, run(caB, testServiceSpec("kube-proxy", "missing-service")))
+	assert.Equal(t, []string{"ca-a", "ca-c"}, trusted())
+	assertFileContents(t, filepath.Join(wmcb.installDir, proxyCABundleFile), caA+caC)
+
+	// Once the cluster no longer has trust anchors, none of them are trusted and the bundle is removed
+	require.NoError(t, run(""))
+	assert.Empty(t, trusted())
+	_, err := os.Stat(filepath.Join(wmcb.installDir, proxyCABundleFile))
+	assert.True(t, os.IsNotExist(err), "proxy CA bundle was not removed")
+}
+
+// TestRunProxyTrustedCA tests that the proxy CA certificates which were already trusted before they were installed
+// stay trusted once they are dropped, the run which installed them is rolled back, or the node is uninstalled
+func TestRunProxyTrustedCA(t *testing.T) {
+	scm := newFakeSCM()
+	wmcb := newTestBootstrapper(t, scm)
+	testDir := filepath.Dir(wmcb.installDir)
+	defer os.RemoveAll(testDir)
+	store := &fakeCertificateStore{certs: make(map[string]*x509.Certificate)}
+	caA, caB, caC := testCACert(t, "ca-a"), testCACert(t, "ca-b"), testCACert(t, "ca-c")
+	// trust adds the PEM encoded certificate to the store, as an administrator would
+	trust := func(ca string) {
+		certs, err := parseCertificates([]byte(ca))
+		require.NoError(t, err)
+		require.NoError(t, store.add(certs))
+	}
+	trusted := func() []string {
+		var names []string
+		for _, cert := range store.certs {
+			names = append(names, cert.Subject.CommonName)
+		}
+		sort.Strings(names)
+		return names
+	}
+	// newBootstrapper returns a new bootstrapper using the store, with the given trust anchors
+	newBootstrapper := func(anchors string) *winNodeBootstrapper {
+		wmcb, err := newWinNodeBootstrapper(wmcb.installDir, "", wmcb.initialKubeletPath, scm.connect)
+		require.NoError(t, err)
+		wmcb.services.waitTime = 10 * time.Millisecond
+		wmcb.certDir = filepath.Join(testDir, "pki")
+		wmcb.trustStore = store
+		files := make(map[string]string)
+		if anchors != "" {
+			files[trustAnchorsIgnitionDir+"openshift-config-user-ca-bundle.crt"] = anchors
+		}
+		wmcb.ignitionFilePath = writeCustomIgnitionFile(t, testDir, files, nil)
+		return wmcb
+	}
+	// run runs a new bootstrapper with the given trust anchors, along with the given added services
+	run := func(anchors string, services ...ServiceSpec) error {
+		wmcb := newBootstrapper(anchors)
+		for _, spec := range services {
+			require.NoError(t, wmcb.AddService(spec))
+		}
+		defer wmcb.Disconnect()
+		return wmcb.Run(context.Background())
+	}
+
+	trust(caA)
+	require.NoError(t, run(caA+caB))
+	assert.Equal(t, []string{"ca-a", "ca-b"}, trusted())
+
+	// A failed run does not stop trusting a certificate it installed which was already trusted
+	trust(caC)
+	require.Error(t, run(caC, testServiceSpec("kube-proxy", "missing-service")))
+	assert.Equal(t, []string{"ca-a", "ca-b", "ca-c"}, trusted())
+
+	// Dropping a certificate which was already trusted keeps trusting it
+	require.NoError(t, run(caB))
+	assert.Equal(t, []string{"ca-a", "ca-b", "ca-c"}, trusted())
+	require.NoError(t, run(caA+caB))
+	assert.Equal(t, []string{"ca-a", "ca-b", "ca-c"}, trusted())
+
+	wmcb = newBootstrapper("")
+	require.NoError(t, wmcb.Uninstall(context.Background(), UninstallOptions{}))
+	require.NoError(t, wmcb.Disconnect())
+	assert.Equal(t, []string{"ca-a", "ca-c"}, trusted())
+}
@@ -0,0 +1,97 @@
+package bootstrapper
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// DefaultNodeTaint is the taint Windows nodes register with, so that only pods which tolerate it, and can
+	// therefore run Windows containers, are scheduled onto them
+	DefaultNodeTaint = "os=Windows:NoSchedule"
+)
+
+// taintRegex matches a taint given as key[=value]:effect
+var taintRegex = regexp.MustCompile(`^([^=:\s]+)(=[^=:\s]*)?:(NoSchedule|PreferNoSchedule|NoExecute)$`)
+
+// SetNodeLabels sets the labels, given as key[=value], the node registers with in addition to the ones set in the
+// ignition file, such as the node role labels. A label given here overrides a label with the same key from the
+// ignition file.
+func (wmcb *winNodeBootstrapper) SetNodeLabels(labels []string) error {
+	for _, label := range labels {
+		if key := strings.SplitN(label, "=", 2)[0]; key == "" || strings.ContainsAny(label, ", ") {
+			return fmt.Errorf("invalid node label %q", label)
+		}
+	}
+	wmcb.nodeLabels = labels
+	return nil
+}
+
+// SetNodeTaints sets the taints, given as key[=value]:effect, the node registers with in addition to the ones set in
+// the ignition file. A taint given here overrides a taint with the same key and effect from the ignition file.
+// Defaults to DefaultNodeTaint.
+func (wmcb *winNodeBootstrapper) SetNodeTaints(taints []string) error {
+	for _, taint := range taints {
+		if !taintRegex.MatchString(taint) {
+			return fmt.Errorf("invalid node taint %q, must be of the form key[=value]:effect", taint)
+		}
+	}
+	wmcb.nodeTaints = taints
+	return nil
+}
+
+// addRegistrationArgs merges the labels and taints found in the ignition file with the configured ones, and sets the
+// kubelet arguments the node registers with accordingly
+func (wmcb *winNodeBootstrapper) addRegistrationArgs() {
+	// Labels are keyed by label key, so that configured labels override the ones from the ignition file
+	labels := make(map[string]string)
+	for _, label := range append(splitNonEmpty(wmcb.kubeletArgs["node-labels"]), wmcb.nodeLabels...) {
+		labels[strings.SplitN(label, "=", 2)[0]] = label
+	}
+	setListArg(wmcb.kubeletArgs, "node-labels", labels)
+
+	// Taints are keyed by key and effect, as a node can have a taint with the same key for each effect
+	taints := make(map[string]string)
+	for _, taint := range append(splitNonEmpty(wmcb.kubeletArgs["register-with-taints"]), wmcb.nodeTaints...) {
+		matches := taintRegex.FindStringSubmatch(taint)
+		if matches == nil {
+			// Taints from the ignition file were not validated, let the kubelet report on them
+			taints[taint] = taint
+			continue
+		}
+		taints[matches[1]+":"+matches[3]] = taint
+	}
+	setListArg(wmcb.kubeletArgs, "register-with-taints", taints)
+}
+
+// setListArg sets the kubelet argument to the comma separated values of the map, sorted by key so that the argument
+// is stable across runs. The argument is removed if the map is empty.
+func setListArg(args map[string]string, name string, values map[string]string) {
+	if len(values) == 0 {
+		delete(args, name)
+		return
+	}
+	var keys []string
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var list []string
+	for _, key := range keys {
+		list = append(list, values[key])
+	}
+	args[name] = strings.Join(list, ",")
+}
+
+// splitNonEmpty splits a comma separated list, ignoring empty elements
+func splitNonEmpty(list string) []string {
+	var elements []string
+	for _, element := range strings.Split(list, ",") {
+		if element != "" {
+			elements = append(elements, element)
+		}
+	}
+	return elements
+}
@@ -0,0 +1,71 @@
+package bootstrapper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestAddRegistrationArgs tests merging the labels and taints from the ignition file with the configured ones
+func TestAddRegistrationArgs(t *testing.T) {
+	tests := []struct {
+		name        string
+		ignitionArg map[string]string
+		labels      []string
+		taints      []string
+		want        map[string]string
+	}{
+		{
+			name:        "Default taint with role labels from the ignition file",
+			ignitionArg: map[string]string{"node-labels": "node-role.kubernetes.io/worker,node.openshift.io/os_id=Windows"},
+			taints:      []string{DefaultNodeTaint},
+			want: map[string]string{
+				"node-labels":          "node-role.kubernetes.io/worker,node.openshift.io/os_id=Windows",
+				"register-with-taints": "os=Windows:NoSchedule",
+			},
+		},
+		{
+			name: "Configured labels and taints override the ignition file",
+			ignitionArg: map[string]string{
+				"node-labels":          "node-role.kubernetes.io/worker,zone=a",
+				"register-with-taints": "dedicated=infra:NoSchedule,dedicated=infra:NoExecute",
+			},
+			labels: []string{"zone=b", "node-role.kubernetes.io/windows"},
+			taints: []string{"os=Windows:NoSchedule", "dedicated=windows:NoSchedule"},
+			want: map[string]string{
+				"node-labels": "node-role.kubernetes.io/windows,node-role.kubernetes.io/worker,zone=b",
+				"register-with-taints": "dedicated=infra:NoExecute,dedicated=windows:NoSchedule," +
+					"os=Windows:NoSchedule",
+			},
+		},
+		{
+			name:        "No taints",
+			ignitionArg: map[string]string{"v": "3"},
+			want:        map[string]string{"v": "3"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wmcb := winNodeBootstrapper{kubeletArgs: tt.ignitionArg}
+			require.NoError(t, wmcb.SetNodeLabels(tt.labels))
+			require.NoError(t, wmcb.SetNodeTaints(tt.taints))
+			wmcb.addRegistrationArgs()
+			assert.Equal(t, tt.want, wmcb.kubeletArgs)
+		})
+	}
+}
+
+// TestSetNodeLabelsAndTaintsValidation tests that malformed labels and taints are rejected
+func TestSetNodeLabelsAndTaintsValidation(t *testing.T) {
+	wmcb := winNodeBootstrapper{}
+	assert.NoError(t, wmcb.SetNodeLabels([]string{"a=b", "node-role.kubernetes.io/worker"}))
+	assert.Error(t, wmcb.SetNodeLabels([]string{"=b"}))
+	assert.Error(t, wmcb.SetNodeLabels([]string{"a=b,c=d"}))
+
+	assert.NoError(t, wmcb.SetNodeTaints([]string{"os=Windows:NoSchedule", "key:NoExecute",
+		"a=:PreferNoSchedule"}))
+	assert.Error(t, wmcb.SetNodeTaints([]string{"os=Windows"}))
+	assert.Error(t, wmcb.SetNodeTaints([]string{"os=Windows:Sometimes"}))
+	assert.Error(t, wmcb.SetNodeTaints([]string{":NoSchedule"}))
+}
@@ -0,0 +1,159 @@
+package bootstrapper
+
+import (
+	"time"
+)
+
+// Phase names of a run, in the order they are run
+const (
+	// PhaseIgnitionParse reads and parses the ignition file
+	PhaseIgnitionParse = "ignition-parse"
+	// PhaseFileTranslation translates the files in the ignition file for Windows and writes them, along with the CNI
+	// configuration
+	PhaseFileTranslation = "file-translation"
+	// PhaseKubeletCopy verifies and installs the kubelet binary, and the hybrid overlay node binary
+	PhaseKubeletCopy = "kubelet-copy"
+	// PhaseServiceCreate creates, reconfigures and removes the services
+	PhaseServiceCreate = "service-create"
+	// PhaseServiceStart starts the services
+	PhaseServiceStart = "service-start"
+)
+
+// phases are the phases of a run, in order
+var phases = []string{PhaseIgnitionParse, PhaseFileTranslation, PhaseKubeletCopy, PhaseServiceCreate,
+	PhaseServiceStart}
+
+// PhaseOutcome is the outcome of a phase of a run
+type PhaseOutcome string
+
+const (
+	// PhaseSucceeded is a phase which completed
+	PhaseSucceeded PhaseOutcome = "succeeded"
+	// PhaseFailed is a phase which failed, failing the run
+	PhaseFailed PhaseOutcome = "failed"
+	// PhaseSkipped is a phase which was not run, as an earlier phase failed
+	PhaseSkipped PhaseOutcome = "skipped"
+)
+
+// FailureCategory classifies the error a run failed with
+type FailureCategory string
+
+const (
+	// FailureConfig is a configuration which could not be applied to the bootstrapper
+	FailureConfig FailureCategory = "config"
+	// FailureIgnition is an ignition file which could not be fetched, read or parsed
+	FailureIgnition FailureCategory = "ignition"
+	// FailureFiles is a file which could not be translated or written
+	FailureFiles FailureCategory = "files"
+	// FailureKubelet is a kubelet binary which could not be verified or installed
+	FailureKubelet FailureCategory = "kubelet"
+	// FailureServices is a service which could not be configured or started
+	FailureServices FailureCategory = "services"
+	// FailureRollback is a failed run whose changes could not be rolled back, leaving the node in an unknown state
+	FailureRollback FailureCategory = "rollback"
+)
+
+// PhaseReport describes a phase of a run
+type PhaseReport struct {
+	Name    string       `json:"name"`
+	Outcome PhaseOutcome `json:"outcome"`
+	// StartTime and DurationMillis are only given if the phase was run
+	StartTime      *time.Time `json:"startTime,omitempty"`
+	DurationMillis int64      `json:"durationMillis"`
+	// Error is the error the phase failed with
+	Error string `json:"error,omitempty"`
+	// ErrorCategory classifies the error the phase failed with
+	ErrorCategory FailureCategory `json:"errorCategory,omitempty"`
+}
+
+// InstalledFile is a file installed by a run
+type InstalledFile struct {
+	Path string `json:"path"`
+	// SHA256 is the hex encoded SHA-256 digest of the file once it was installed
+	SHA256 string `json:"sha256,omitempty"`
+	// Changed is true if the run wrote the file, rather than finding it up to date
+	Changed bool `json:"changed"`
+}
+
+// PhaseError is the error a run fails with, which gives the phase that failed and the class of the failure
+type PhaseError struct {
+	// Phase is the phase which failed, or empty if the run failed before its first phase
+	Phase    string
+	Category FailureCategory
+	Err      error
+}
+
+// Error returns the message of the underlying error
+func (e *PhaseError) Error() string {
+	return e.Err.Error()
+}
+
+// FailureCategoryOf returns the class of the error a run failed with, or an empty category if it is not known
+func FailureCategoryOf(err error) FailureCategory {
+	if phaseErr, ok := err.(*PhaseError); ok {
+		return phaseErr.Category
+	}
+	return ""
+}
+
+// newBootstrapResult returns the result of a run which has not run any of its phases yet
+func newBootstrapResult() *BootstrapResult {
+	result := &BootstrapResult{}
+	for _, name := range phases {
+		result.Phases = append(result.Phases, PhaseReport{Name: name, Outcome: PhaseSkipped})
+	}
+	return result
+}
+
+// runPhase runs the phase, recording its timing and outcome. The error it fails with is returned as a PhaseError of
+// the given category.
+func (r *BootstrapResult) runPhase(name string, category FailureCategory, phase func() error) error {
+	start := time.Now()
+	err := phase()
+	report := PhaseReport{Name: name, Outcome: PhaseSucceeded, DurationMillis: int64(time.Since(start) /
+		time.Millisecond)}
+	startTime := start.UTC()
+	report.StartTime = &startTime
+	if err != nil {
+		report.Outcome, report.Error, report.ErrorCategory = PhaseFailed, err.Error(), category
+		err = &PhaseError{Phase: name, Category: category, Err: err}
+	}
+	for i := range r.Phases {
+		if r.Phases[i].Name == name {
+			r.Phases[i] = report
+			return err
+		}
+	}
+	r.Phases = append(r.Phases, report)
+	return err
+}
+
+// finish records the outcome of the run
+func (r *BootstrapResult) finish(changes *RunChanges, runErr error) {
+	r.Time = time.Now().UTC()
+	r.Succeeded = runErr == nil
+	r.Changes = changes
+	if runErr != nil {
+		r.Error = runErr.Error()
+		r.FailureCategory = FailureCategoryOf(runErr)
+	}
+}
+
+// installedFiles returns the files installed by the stage, with their digests. A file which cannot be hashed is
+// reported without a digest, as the report must not fail the run.
+func installedFiles(stage *fileStage) []InstalledFile {
+	changed := make(map[string]bool)
+	for _, path := range stage.changed() {
+		changed[path] = true
+	}
+	var files []InstalledFile
+	for _, path := range stage.paths {
+		file := InstalledFile{Path: path, Changed: changed[path]}
+		var err error
+		if file.SHA256, err = fileSHA256(stage.fs, path); err != nil {
+			log.Error(err, "could not hash installed file", "path", path)
+		}
+		files = append(files, file)
+	}
+	return files
+}
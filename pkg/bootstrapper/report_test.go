@@ -0,0 +1,186 @@
+package bootstrapper
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// phaseOutcomes returns the outcome of each phase of the report, by name
+func phaseOutcomes(result *BootstrapResult) map[string]PhaseOutcome {
+	outcomes := make(map[string]PhaseOutcome)
+	for _, phase := range result.Phases {
+		outcomes[phase.Name] = phase.Outcome
+	}
+	return outcomes
+}
+
+// TestRunReport tests that a successful run reports its phases, the files it installed and the kubelet arguments, and
+// records the report in the install directory
+func TestRunReport(t *testing.T) {
+	scm := newFakeSCM()
+	wmcb := newTestBootstrapper(t, scm)
+	defer os.RemoveAll(filepath.Dir(wmcb.installDir))
+	require.NoError(t, wmcb.Run(context.Background()))
+	require.NoError(t, wmcb.Disconnect())
+
+	report := wmcb.Report()
+	require.NotNil(t, report)
+	assert.True(t, report.Succeeded)
+	assert.Empty(t, report.FailureCategory)
+	assert.False(t, report.RolledBack)
+	require.Len(t, report.Phases, len(phases))
+	for i, phase := range report.Phases {
+		assert.Equal(t, phases[i], phase.Name)
+		assert.Equal(t, PhaseSucceeded, phase.Outcome, "phase %s", phase.Name)
+		assert.NotNil(t, phase.StartTime, "phase %s", phase.Name)
+		assert.Empty(t, phase.Error)
+	}
+	assert.Equal(t, scm.get(KubeletServiceName).args, report.KubeletArgs)
+	assert.Equal(t, wmcb.Changes(), report.Changes)
+
+	files := make(map[string]InstalledFile)
+	for _, file := range report.Files {
+		assert.True(t, file.Changed, "%s should be changed", file.Path)
+		files[file.Path] = file
+	}
+	for _, name := range []string{"kubelet.exe", "kubelet.conf", "bootstrap-kubeconfig", "kubelet-ca.crt"} {
+		assert.Contains(t, files, filepath.Join(wmcb.installDir, name))
+	}
+	assert.Equal(t, testKubeletDigest, files[filepath.Join(wmcb.installDir, "kubelet.exe")].SHA256)
+
+	contents, err := ioutil.ReadFile(filepath.Join(wmcb.installDir, bootstrapResultFile))
+	require.NoError(t, err)
+	var recorded BootstrapResult
+	require.NoError(t, json.Unmarshal(contents, &recorded))
+	assert.Equal(t, phaseOutcomes(report), phaseOutcomes(&recorded))
+	assert.Equal(t, report.Files, recorded.Files)
+	assert.Equal(t, report.KubeletArgs, recorded.KubeletArgs)
+
+	// Running again finds the files up to date
+	wmcb, err = newWinNodeBootstrapper(wmcb.installDir, wmcb.ignitionFilePath, wmcb.initialKubeletPath, scm.connect)
+	require.NoError(t, err)
+	wmcb.certDir = filepath.Join(filepath.Dir(wmcb.installDir), "pki")
+	require.NoError(t, wmcb.Run(context.Background()))
+	require.NoError(t, wmcb.Disconnect())
+	require.Len(t, wmcb.Report().Files, len(report.Files))
+	for _, file := range wmcb.Report().Files {
+		assert.False(t, file.Changed, "%s should be unchanged", file.Path)
+		assert.Equal(t, files[file.Path].SHA256, file.SHA256)
+	}
+}
+
+// TestRunReportFailure tests that a failed run reports the phase it failed in and the class of the failure, and
+// skips the phases after it
+func TestRunReportFailure(t *testing.T) {
+	tests := []struct {
+		name         string
+		breakRun     func(t *testing.T, wmcb *winNodeBootstrapper)
+		wantCategory FailureCategory
+		wantOutcomes map[string]PhaseOutcome
+		wantError    string
+	}{
+		{
+			name: "Invalid ignition file",
+			breakRun: func(t *testing.T, wmcb *winNodeBootstrapper) {
+				require.NoError(t, ioutil.WriteFile(wmcb.ignitionFilePath, []byte("{"), 0644))
+			},
+			wantCategory: FailureIgnition,
+			wantOutcomes: map[string]PhaseOutcome{PhaseIgnitionParse: PhaseFailed,
+				PhaseFileTranslation: PhaseSkipped, PhaseKubeletCopy: PhaseSkipped, PhaseServiceCreate: PhaseSkipped,
+				PhaseServiceStart: PhaseSkipped},
+			wantError: "could not parse ignition file",
+		},
+		{
+			name: "Missing kubelet",
+			breakRun: func(t *testing.T, wmcb *winNodeBootstrapper) {
+				require.NoError(t, os.Remove(wmcb.initialKubeletPath))
+			},
+			wantCategory: FailureKubelet,
+			wantOutcomes: map[string]PhaseOutcome{PhaseIgnitionParse: PhaseSucceeded,
+				PhaseFileTranslation: PhaseSucceeded, PhaseKubeletCopy: PhaseFailed, PhaseServiceCreate: PhaseSkipped,
+				PhaseServiceStart: PhaseSkipped},
+			wantError: "could not copy kubelet",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scm := newFakeSCM()
+			wmcb := newTestBootstrapper(t, scm)
+			defer os.RemoveAll(filepath.Dir(wmcb.installDir))
+			tt.breakRun(t, wmcb)
+			err := wmcb.Run(context.Background())
+			require.Error(t, err)
+			require.NoError(t, wmcb.Disconnect())
+			assert.Equal(t, tt.wantCategory, FailureCategoryOf(err))
+
+			report := wmcb.Report()
+			require.NotNil(t, report)
+			assert.False(t, report.Succeeded)
+			assert.Equal(t, tt.wantCategory, report.FailureCategory)
+			assert.Contains(t, report.Error, tt.wantError)
+			assert.True(t, report.RolledBack)
+			assert.Empty(t, report.KubeletArgs)
+			assert.Equal(t, tt.wantOutcomes, phaseOutcomes(report))
+			for _, phase := range report.Phases {
+				if phase.Outcome == PhaseFailed {
+					assert.Equal(t, tt.wantCategory, phase.ErrorCategory)
+					assert.Contains(t, phase.Error, tt.wantError)
+				} else if phase.Outcome == PhaseSkipped {
+					assert.Nil(t, phase.StartTime, "phase %s", phase.Name)
+				}
+			}
+			assert.Nil(t, scm.get(KubeletServiceName))
+
+			contents, err := ioutil.ReadFile(filepath.Join(wmcb.installDir, bootstrapResultFile))
+			require.NoError(t, err)
+			var recorded BootstrapResult
+			require.NoError(t, json.Unmarshal(contents, &recorded))
+			assert.Equal(t, tt.wantCategory, recorded.FailureCategory)
+			assert.Equal(t, tt.wantOutcomes, phaseOutcomes(&recorded))
+		})
+	}
+}
+
+// TestRecordFailedRun tests that a run which failed before the bootstrapper could run it is reported as the last
+// bootstrap, replacing the report of the previous run
+func TestRecordFailedRun(t *testing.T) {
+	scm := newFakeSCM()
+	wmcb := newTestBootstrapper(t, scm)
+	defer os.RemoveAll(filepath.Dir(wmcb.installDir))
+	require.NoError(t, wmcb.Run(context.Background()))
+	require.NoError(t, wmcb.Disconnect())
+
+	tests := []struct {
+		name      string
+		category  FailureCategory
+		wantPhase PhaseOutcome
+	}{
+		{name: "Ignition", category: FailureIgnition, wantPhase: PhaseFailed},
+		{name: "Config", category: FailureConfig, wantPhase: PhaseSkipped},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := RecordFailedRun(wmcb.installDir, tt.category, fmt.Errorf("failed before the run"))
+			assert.False(t, result.Succeeded)
+			assert.Equal(t, tt.category, result.FailureCategory)
+			assert.Equal(t, "failed before the run", result.Error)
+			outcomes := phaseOutcomes(result)
+			assert.Equal(t, tt.wantPhase, outcomes[PhaseIgnitionParse])
+			assert.Equal(t, PhaseSkipped, outcomes[PhaseServiceStart])
+
+			status, err := wmcb.Status()
+			require.NoError(t, err)
+			require.NotNil(t, status.LastBootstrap)
+			assert.False(t, status.LastBootstrap.Succeeded)
+			assert.Equal(t, tt.category, status.LastBootstrap.FailureCategory)
+		})
+	}
+}
@@ -0,0 +1,278 @@
+package bootstrapper
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ServiceState describes the execution state of a service. The values mirror the states reported by the Windows
+// service control manager, https://docs.microsoft.com/en-us/windows/win32/api/winsvc/ns-winsvc-service_status
+type ServiceState uint32
+
+const (
+	ServiceStopped         ServiceState = 1
+	ServiceStartPending    ServiceState = 2
+	ServiceStopPending     ServiceState = 3
+	ServiceRunning         ServiceState = 4
+	ServiceContinuePending ServiceState = 5
+	ServicePausePending    ServiceState = 6
+	ServicePaused          ServiceState = 7
+)
+
+// String returns the name of the state
+func (state ServiceState) String() string {
+	switch state {
+	case ServiceStopped:
+		return "Stopped"
+	case ServiceStartPending:
+		return "StartPending"
+	case ServiceStopPending:
+		return "StopPending"
+	case ServiceRunning:
+		return "Running"
+	case ServiceContinuePending:
+		return "ContinuePending"
+	case ServicePausePending:
+		return "PausePending"
+	case ServicePaused:
+		return "Paused"
+	}
+	return fmt.Sprintf("Unknown(%d)", uint32(state))
+}
+
+// ServiceCmd is a control request sent to a running service
+type ServiceCmd uint32
+
+const (
+	ServiceStop     ServiceCmd = 1
+	ServicePause    ServiceCmd = 2
+	ServiceContinue ServiceCmd = 3
+)
+
+// ServiceStatus describes the current status of a service
+type ServiceStatus struct {
+	State ServiceState
+}
+
+// ServiceStartType describes when a service is started
+type ServiceStartType uint32
+
+const (
+	// StartAutomatic will start the service whenever the host boots
+	StartAutomatic ServiceStartType = 2
+	// StartManual requires the service to be started explicitly
+	StartManual ServiceStartType = 3
+	// StartDisabled prevents the service from being started
+	StartDisabled ServiceStartType = 4
+)
+
+// String returns the name of the start type
+func (startType ServiceStartType) String() string {
+	switch startType {
+	case StartAutomatic:
+		return "Automatic"
+	case StartManual:
+		return "Manual"
+	case StartDisabled:
+		return "Disabled"
+	}
+	return fmt.Sprintf("Unknown(%d)", uint32(startType))
+}
+
+// ServiceType describes how the executable of a service is run. The values mirror the service types of the Windows
+// service control manager.
+type ServiceType uint32
+
+const (
+	// ServiceOwnProcess runs the service in its own process
+	ServiceOwnProcess ServiceType = 0x10
+	// ServiceShareProcess runs the service in a process shared with other services
+	ServiceShareProcess ServiceType = 0x20
+)
+
+// ServiceErrorControl describes how the host handles a service which fails to start when the host boots. The values
+// mirror the error control levels of the Windows service control manager.
+type ServiceErrorControl uint32
+
+const (
+	ServiceErrorIgnore   ServiceErrorControl = 0
+	ServiceErrorNormal   ServiceErrorControl = 1
+	ServiceErrorSevere   ServiceErrorControl = 2
+	ServiceErrorCritical ServiceErrorControl = 3
+)
+
+// RecoveryActionType is an action the service manager can take when a service fails
+type RecoveryActionType int
+
+const (
+	NoAction       RecoveryActionType = 0
+	ServiceRestart RecoveryActionType = 1
+	ComputerReboot RecoveryActionType = 2
+	RunCommand     RecoveryActionType = 3
+)
+
+// RecoveryAction is an action the service manager performs, after the given delay, when a service fails
+type RecoveryAction struct {
+	Type  RecoveryActionType
+	Delay time.Duration
+}
+
+// ServiceConfig holds the subset of the service configuration that the bootstrapper manages, along with the settings
+// the service manager requires whenever the configuration is replaced
+type ServiceConfig struct {
+	// ServiceType determines how the service is run. If zero when the service is created, it runs in its own process.
+	// It is not managed by the bootstrapper, but must be kept when the configuration is replaced.
+	ServiceType ServiceType
+	// ErrorControl determines how a failure of the service to start at boot is handled. It is not managed by the
+	// bootstrapper, but must be kept when the configuration is replaced.
+	ErrorControl ServiceErrorControl
+	// StartType determines when the service is started
+	StartType ServiceStartType
+	// BinaryPathName is the fully qualified path to the service binary, it can also include the service arguments
+	BinaryPathName string
+	// Dependencies are the names of the services that must be running before this service starts
+	Dependencies []string
+	// DisplayName is the name of the service shown to users
+	DisplayName string
+	// Description describes the service
+	Description string
+	// Environment are the environment variables, as KEY=VALUE, the service is run with in addition to the system
+	// environment
+	Environment []string
+}
+
+// Service is a handle to a single service installed on the host
+type Service interface {
+	// Name returns the name the service is installed under
+	Name() string
+	// Config returns the current configuration of the service
+	Config() (ServiceConfig, error)
+	// UpdateConfig replaces the configuration of the service. Like the configuration given when the service is
+	// created, it must have a valid service type and error control.
+	UpdateConfig(ServiceConfig) error
+	// Start starts the service, passing it the given arguments in addition to the ones it was created with
+	Start(args ...string) error
+	// Control sends a control request to the service and returns the status reported in response
+	Control(ServiceCmd) (ServiceStatus, error)
+	// Query returns the current status of the service
+	Query() (ServiceStatus, error)
+	// SetRecoveryActions sets the actions taken when the service fails, and the period in seconds after which the
+	// failure count is reset
+	SetRecoveryActions(actions []RecoveryAction, resetPeriod uint32) error
+	// RecoveryActions returns the actions taken when the service fails
+	RecoveryActions() ([]RecoveryAction, error)
+	// ResetPeriod returns the period in seconds after which the failure count of the service is reset
+	ResetPeriod() (uint32, error)
+	// Delete marks the service for deletion. The service is removed once it is stopped and all handles to it are closed
+	Delete() error
+	// Close releases the handle to the service
+	Close() error
+}
+
+// ServiceManager is a connection to the service manager of the host
+type ServiceManager interface {
+	// CreateService installs a new service which runs exepath with the given arguments
+	CreateService(name, exepath string, config ServiceConfig, args ...string) (Service, error)
+	// OpenService returns a handle to an existing service
+	OpenService(name string) (Service, error)
+	// ListServices returns the names of all the services installed on the host
+	ListServices() ([]string, error)
+	// Disconnect closes the connection to the service manager
+	Disconnect() error
+}
+
+// serviceManagerConnector returns a new connection to the service manager of the host
+type serviceManagerConnector func() (ServiceManager, error)
+
+// commandLine returns the command line a service is run with, quoting exepath and args as the Windows service manager
+// does when a service is created
+func commandLine(exepath string, args []string) string {
+	quoted := []string{escapeArg(exepath)}
+	for _, arg := range args {
+		quoted = append(quoted, escapeArg(arg))
+	}
+	return strings.Join(quoted, " ")
+}
+
+// escapeArg quotes an argument following the Windows command line rules, so that it is parsed back as a single
+// argument. It matches syscall.EscapeArg, which is only available on Windows.
+func escapeArg(arg string) string {
+	if arg == "" {
+		return `""`
+	}
+	if !strings.ContainsAny(arg, "\" \t\\") {
+		return arg
+	}
+	hasSpace := strings.ContainsAny(arg, " \t")
+	var b strings.Builder
+	if hasSpace {
+		b.WriteByte('"')
+	}
+	slashes := 0
+	for i := 0; i < len(arg); i++ {
+		switch arg[i] {
+		case '\\':
+			slashes++
+		case '"':
+			// Backslashes preceding a quote are escaped, along with the quote itself
+			b.WriteString(strings.Repeat(`\`, slashes+1))
+			slashes = 0
+		default:
+			slashes = 0
+		}
+		b.WriteByte(arg[i])
+	}
+	if hasSpace {
+		// Backslashes preceding the closing quote are escaped
+		b.WriteString(strings.Repeat(`\`, slashes))
+		b.WriteByte('"')
+	}
+	return b.String()
+}
+
+// splitCommandLine splits a command line, such as the binary path of a service, into the executable path and its
+// arguments following the Windows command line rules. It is the inverse of commandLine.
+func splitCommandLine(cmdLine string) []string {
+	var args []string
+	var arg strings.Builder
+	inArg, inQuotes := false, false
+	for i := 0; i < len(cmdLine); i++ {
+		c := cmdLine[i]
+		switch {
+		case c == '\\':
+			slashes := 1
+			for i+1 < len(cmdLine) && cmdLine[i+1] == '\\' {
+				slashes++
+				i++
+			}
+			if i+1 < len(cmdLine) && cmdLine[i+1] == '"' {
+				// 2n backslashes before a quote are n backslashes, 2n+1 are n backslashes and a literal quote
+				arg.WriteString(strings.Repeat(`\`, slashes/2))
+				if slashes%2 == 1 {
+					arg.WriteByte('"')
+					i++
+				}
+			} else {
+				arg.WriteString(strings.Repeat(`\`, slashes))
+			}
+			inArg = true
+		case c == '"':
+			inQuotes = !inQuotes
+			inArg = true
+		case (c == ' ' || c == '\t') && !inQuotes:
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteByte(c)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+package bootstrapper
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// connectServiceManager returns an error, as the Windows service control manager is only available on Windows
+func connectServiceManager() (ServiceManager, error) {
+	return nil, fmt.Errorf("could not connect to Windows SCM: not supported on %s", runtime.GOOS)
+}
+
+// RunAsService returns an error, as Windows services can only be run on Windows
+func RunAsService(name string, run func(stop <-chan struct{}) error) error {
+	return fmt.Errorf("could not run as service %s: not supported on %s", name, runtime.GOOS)
+}
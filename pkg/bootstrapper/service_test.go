@@ -0,0 +1,43 @@
+package bootstrapper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCommandLine tests that service command lines are quoted as Windows does, and split back into the same arguments
+func TestCommandLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		exepath string
+		args    []string
+		want    string
+	}{
+		{
+			name:    "Plain arguments",
+			exepath: "C:\\k\\kubelet.exe",
+			args:    []string{"--windows-service", "--v=3"},
+			want:    `C:\k\kubelet.exe --windows-service --v=3`,
+		},
+		{
+			name:    "Spaces",
+			exepath: "C:\\Program Files\\kubelet.exe",
+			args:    []string{"--node-labels=a=b", "--hostname-override=win node"},
+			want:    `"C:\Program Files\kubelet.exe" --node-labels=a=b "--hostname-override=win node"`,
+		},
+		{
+			name:    "Quotes and trailing backslashes",
+			exepath: "C:\\k\\kubelet.exe",
+			args:    []string{`--a="b"`, `--dir=C:\my dir\`, ""},
+			want:    `C:\k\kubelet.exe --a=\"b\" "--dir=C:\my dir\\" ""`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := commandLine(tt.exepath, tt.args)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, append([]string{tt.exepath}, tt.args...), splitCommandLine(got))
+		})
+	}
+}
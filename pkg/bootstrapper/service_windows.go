@@ -0,0 +1,239 @@
+//go:build windows
+// +build windows
+
+package bootstrapper
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// serviceEnvironmentValue is the registry value, under the key of a service, holding the environment variables the
+// service is run with
+const serviceEnvironmentValue = "Environment"
+
+// scmServiceManager implements ServiceManager using the Windows service control manager
+type scmServiceManager struct {
+	*mgr.Mgr
+}
+
+// scmService implements Service using a Windows service control manager service handle
+type scmService struct {
+	*mgr.Service
+}
+
+// connectServiceManager connects to the Windows service control manager
+func connectServiceManager() (ServiceManager, error) {
+	m, err := mgr.Connect()
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to Windows SCM: %s", err)
+	}
+	return &scmServiceManager{m}, nil
+}
+
+func (m *scmServiceManager) CreateService(name, exepath string, config ServiceConfig, args ...string) (Service, error) {
+	s, err := m.Mgr.CreateService(name, exepath, toMgrConfig(config), args...)
+	if err != nil {
+		return nil, err
+	}
+	if err = setServiceEnvironment(name, config.Environment); err != nil {
+		return nil, err
+	}
+	return &scmService{s}, nil
+}
+
+func (m *scmServiceManager) OpenService(name string) (Service, error) {
+	s, err := m.Mgr.OpenService(name)
+	if err != nil {
+		return nil, err
+	}
+	return &scmService{s}, nil
+}
+
+func (s *scmService) Name() string {
+	return s.Service.Name
+}
+
+func (s *scmService) Config() (ServiceConfig, error) {
+	c, err := s.Service.Config()
+	if err != nil {
+		return ServiceConfig{}, err
+	}
+	env, err := serviceEnvironment(s.Service.Name)
+	if err != nil {
+		return ServiceConfig{}, err
+	}
+	return ServiceConfig{
+		ServiceType:    ServiceType(c.ServiceType),
+		ErrorControl:   ServiceErrorControl(c.ErrorControl),
+		StartType:      ServiceStartType(c.StartType),
+		BinaryPathName: c.BinaryPathName,
+		Dependencies:   c.Dependencies,
+		DisplayName:    c.DisplayName,
+		Description:    c.Description,
+		Environment:    env,
+	}, nil
+}
+
+func (s *scmService) UpdateConfig(config ServiceConfig) error {
+	if err := s.Service.UpdateConfig(toMgrConfig(config)); err != nil {
+		return err
+	}
+	if len(config.Dependencies) == 0 {
+		// The service API leaves the dependencies unchanged when none are given, they are removed by giving an empty
+		// list instead
+		noDependencies := []uint16{0, 0}
+		err := windows.ChangeServiceConfig(s.Service.Handle, windows.SERVICE_NO_CHANGE, windows.SERVICE_NO_CHANGE,
+			windows.SERVICE_NO_CHANGE, nil, nil, nil, &noDependencies[0], nil, nil, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return setServiceEnvironment(s.Service.Name, config.Environment)
+}
+
+// serviceKeyPath returns the path of the registry key of the service, under HKEY_LOCAL_MACHINE
+func serviceKeyPath(name string) string {
+	return `SYSTEM\CurrentControlSet\Services\` + name
+}
+
+// serviceEnvironment returns the environment variables the service is run with, which the service API does not expose
+func serviceEnvironment(name string) ([]string, error) {
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE, serviceKeyPath(name), registry.QUERY_VALUE)
+	if err != nil {
+		return nil, fmt.Errorf("could not open registry key of service %s: %s", name, err)
+	}
+	defer key.Close()
+	env, _, err := key.GetStringsValue(serviceEnvironmentValue)
+	if err == registry.ErrNotExist {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read environment of service %s: %s", name, err)
+	}
+	return env, nil
+}
+
+// setServiceEnvironment sets the environment variables the service is run with, removing them if env is empty
+func setServiceEnvironment(name string, env []string) error {
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE, serviceKeyPath(name), registry.SET_VALUE)
+	if err != nil {
+		return fmt.Errorf("could not open registry key of service %s: %s", name, err)
+	}
+	defer key.Close()
+	if len(env) == 0 {
+		err = key.DeleteValue(serviceEnvironmentValue)
+		if err == registry.ErrNotExist {
+			err = nil
+		}
+	} else {
+		err = key.SetStringsValue(serviceEnvironmentValue, env)
+	}
+	if err != nil {
+		return fmt.Errorf("could not set environment of service %s: %s", name, err)
+	}
+	return nil
+}
+
+func (s *scmService) Control(cmd ServiceCmd) (ServiceStatus, error) {
+	status, err := s.Service.Control(svc.Cmd(cmd))
+	return ServiceStatus{State: ServiceState(status.State)}, err
+}
+
+func (s *scmService) Query() (ServiceStatus, error) {
+	status, err := s.Service.Query()
+	return ServiceStatus{State: ServiceState(status.State)}, err
+}
+
+func (s *scmService) SetRecoveryActions(actions []RecoveryAction, resetPeriod uint32) error {
+	mgrActions := make([]mgr.RecoveryAction, 0, len(actions))
+	for _, action := range actions {
+		mgrActions = append(mgrActions, mgr.RecoveryAction{Type: int(action.Type), Delay: action.Delay})
+	}
+	return s.Service.SetRecoveryActions(mgrActions, resetPeriod)
+}
+
+func (s *scmService) RecoveryActions() ([]RecoveryAction, error) {
+	mgrActions, err := s.Service.RecoveryActions()
+	if err != nil {
+		return nil, err
+	}
+	actions := make([]RecoveryAction, 0, len(mgrActions))
+	for _, action := range mgrActions {
+		actions = append(actions, RecoveryAction{Type: RecoveryActionType(action.Type), Delay: action.Delay})
+	}
+	return actions, nil
+}
+
+// toMgrConfig converts a ServiceConfig to the configuration used by the Windows service API. The settings left empty
+// are not changed when the configuration of an existing service is replaced.
+func toMgrConfig(config ServiceConfig) mgr.Config {
+	// Mostly default values here
+	return mgr.Config{
+		ServiceType:      uint32(config.ServiceType),
+		StartType:        uint32(config.StartType),
+		ErrorControl:     uint32(config.ErrorControl),
+		BinaryPathName:   config.BinaryPathName,
+		LoadOrderGroup:   "",
+		TagId:            0,
+		Dependencies:     config.Dependencies,
+		ServiceStartName: "",
+		DisplayName:      config.DisplayName,
+		Password:         "",
+		Description:      config.Description,
+	}
+}
+
+// serviceHandler runs a function as a Windows service, until the service control manager stops it
+type serviceHandler struct {
+	run func(stop <-chan struct{}) error
+	// err is the error run returned
+	err error
+}
+
+func (h *serviceHandler) Execute(args []string, requests <-chan svc.ChangeRequest,
+	changes chan<- svc.Status) (bool, uint32) {
+	changes <- svc.Status{State: svc.StartPending}
+	stop := make(chan struct{})
+	done := make(chan error, 1)
+	go func() { done <- h.run(stop) }()
+	changes <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case h.err = <-done:
+			changes <- svc.Status{State: svc.StopPending}
+			if h.err != nil {
+				return true, 1
+			}
+			return false, 0
+		case request := <-requests:
+			switch request.Cmd {
+			case svc.Interrogate:
+				changes <- request.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				changes <- svc.Status{State: svc.StopPending}
+				close(stop)
+				h.err = <-done
+				if h.err != nil {
+					return true, 1
+				}
+				return false, 0
+			}
+		}
+	}
+}
+
+// RunAsService runs the function as the Windows service with the given name, reporting its state to the service
+// control manager. The stop channel given to the function is closed when the service is stopped, and the function
+// must then return.
+func RunAsService(name string, run func(stop <-chan struct{}) error) error {
+	handler := &serviceHandler{run: run}
+	if err := svc.Run(name, handler); err != nil {
+		return fmt.Errorf("could not run as service %s: %s", name, err)
+	}
+	return handler.err
+}
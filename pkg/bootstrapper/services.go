@@ -0,0 +1,716 @@
+package bootstrapper
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultRecoveryResetPeriod is the time in seconds without failures after which the failure count of a service is
+	// reset
+	defaultRecoveryResetPeriod = 600
+	// servicePollInterval is the time to wait before first checking again if a service has changed state, it is
+	// doubled after each check up to servicePollMaxInterval
+	servicePollInterval = 50 * time.Millisecond
+	// servicePollMaxInterval is the longest time to wait between checks of the state of a service
+	servicePollMaxInterval = 2 * time.Second
+)
+
+// defaultRecoveryActions restart a service which fails
+var defaultRecoveryActions = []RecoveryAction{{Type: ServiceRestart, Delay: 5 * time.Second}}
+
+// ServiceSpec declares how a Windows service managed by the bootstrapper is configured
+type ServiceSpec struct {
+	// Name is the name the service is installed under
+	Name string
+	// BinaryPath is the path of the service executable
+	BinaryPath string
+	// Args are the arguments the service executable is run with
+	Args []string
+	// Dependencies are the names of the services which must be running before the service is started. Windows will
+	// not stop a service while a service which depends on it is running.
+	Dependencies []string
+	// After are the names of the services which are started before the service and stopped after it. Unlike
+	// Dependencies, Windows does not know about this order, so the service can run without them.
+	After []string
+	// Description describes the service
+	Description string
+	// StartType determines when the service is started
+	StartType ServiceStartType
+	// RecoveryActions are the actions taken when the service fails. If empty, no action is taken.
+	RecoveryActions []RecoveryAction
+	// RecoveryResetPeriod is the time in seconds without failures after which the failure count of the service is
+	// reset
+	RecoveryResetPeriod uint32
+	// Environment are the environment variables, as KEY=VALUE, the service is run with in addition to the system
+	// environment
+	Environment []string
+}
+
+// config returns the configuration of the service described by the spec
+func (spec *ServiceSpec) config() ServiceConfig {
+	return ServiceConfig{
+		StartType:      spec.StartType,
+		BinaryPathName: commandLine(spec.BinaryPath, spec.Args),
+		Dependencies:   spec.Dependencies,
+		Description:    spec.Description,
+		Environment:    spec.Environment,
+	}
+}
+
+// recoveryActions returns the recovery actions of the spec. The Windows service API requires at least one action, so
+// no action is explicitly given if there are none.
+func (spec *ServiceSpec) recoveryActions() []RecoveryAction {
+	if len(spec.RecoveryActions) == 0 {
+		return []RecoveryAction{{Type: NoAction}}
+	}
+	return spec.RecoveryActions
+}
+
+// differences returns the settings of the installed service, described by current, which differ from the spec. The
+// display name is not compared, as it is not part of the spec.
+func (spec *ServiceSpec) differences(current ServiceSpec) []string {
+	var differences []string
+	if current.BinaryPath != spec.BinaryPath {
+		differences = append(differences, "binary path")
+	}
+	if !equalStrings(current.Args, spec.Args) {
+		differences = append(differences, "args")
+	}
+	if current.StartType != spec.StartType {
+		differences = append(differences, "start type")
+	}
+	if !equalStrings(current.Dependencies, spec.Dependencies) {
+		differences = append(differences, "dependencies")
+	}
+	if current.Description != spec.Description {
+		differences = append(differences, "description")
+	}
+	currentActions, actions := current.recoveryActions(), spec.recoveryActions()
+	if len(currentActions) != len(actions) {
+		differences = append(differences, "recovery actions")
+	} else {
+		for i := range actions {
+			if currentActions[i] != actions[i] {
+				differences = append(differences, "recovery actions")
+				break
+			}
+		}
+	}
+	if current.RecoveryResetPeriod != spec.RecoveryResetPeriod {
+		differences = append(differences, "recovery reset period")
+	}
+	if !equalStrings(current.Environment, spec.Environment) {
+		differences = append(differences, "environment")
+	}
+	return differences
+}
+
+// equalStrings returns true if a and b hold the same strings in the same order, treating nil as empty
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// validate returns an error if the service cannot be created from the spec
+func (spec *ServiceSpec) validate() error {
+	if spec.Name == "" {
+		return fmt.Errorf("service name must be given")
+	}
+	if spec.BinaryPath == "" {
+		return fmt.Errorf("binary path of service %s must be given", spec.Name)
+	}
+	switch spec.StartType {
+	case StartAutomatic, StartManual, StartDisabled:
+	default:
+		return fmt.Errorf("invalid start type %d of service %s", spec.StartType, spec.Name)
+	}
+	for _, variable := range spec.Environment {
+		if strings.Index(variable, "=") < 1 {
+			return fmt.Errorf("invalid environment variable %q of service %s, it must be KEY=VALUE", variable,
+				spec.Name)
+		}
+	}
+	return nil
+}
+
+// orderServices returns the specs ordered so that each service comes after the services it depends on or is started
+// after, otherwise preserving the given order. Services outside of the specs are assumed to be satisfied by the host.
+func orderServices(specs []ServiceSpec) ([]ServiceSpec, error) {
+	byName := make(map[string]ServiceSpec, len(specs))
+	for _, spec := range specs {
+		if _, ok := byName[spec.Name]; ok {
+			return nil, fmt.Errorf("service %s is declared more than once", spec.Name)
+		}
+		byName[spec.Name] = spec
+	}
+	var ordered []ServiceSpec
+	// visiting holds the services whose dependencies are being ordered, to detect cycles
+	visiting := make(map[string]bool)
+	visited := make(map[string]bool)
+	var visit func(spec ServiceSpec) error
+	visit = func(spec ServiceSpec) error {
+		if visited[spec.Name] {
+			return nil
+		}
+		if visiting[spec.Name] {
+			return fmt.Errorf("service %s depends on itself", spec.Name)
+		}
+		visiting[spec.Name] = true
+		for _, name := range append(append([]string{}, spec.Dependencies...), spec.After...) {
+			if dependency, ok := byName[name]; ok {
+				if err := visit(dependency); err != nil {
+					return err
+				}
+			}
+		}
+		visiting[spec.Name] = false
+		visited[spec.Name] = true
+		ordered = append(ordered, spec)
+		return nil
+	}
+	for _, spec := range specs {
+		if err := visit(spec); err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
+
+// serviceSet creates, updates, orders and removes a set of Windows services as a unit. The set holds a handle to each
+// of its installed services until it is closed.
+type serviceSet struct {
+	// svcMgr is used to interact with the Windows service API
+	svcMgr ServiceManager
+	// services are the handles to the installed services of the set, by name
+	services map[string]Service
+	// removed are the handles to the services of the set which were marked for deletion. Windows removes them once
+	// the handles are closed.
+	removed []Service
+	// after are the names of the services each service is started after, as given by the After of its spec
+	after map[string][]string
+	// waitTime is the amount of time to wait for a service to change state or be deleted
+	waitTime time.Duration
+}
+
+// newServiceSet connects to the Windows service API and returns an empty set
+func newServiceSet(connect serviceManagerConnector, waitTime time.Duration) (*serviceSet, error) {
+	svcMgr, err := connect()
+	if err != nil {
+		return nil, err
+	}
+	return &serviceSet{svcMgr: svcMgr, services: make(map[string]Service), after: make(map[string][]string),
+		waitTime: waitTime}, nil
+}
+
+// open adds the services with the given names which are installed on the host to the set
+func (s *serviceSet) open(names ...string) {
+	for _, name := range names {
+		if _, ok := s.services[name]; ok {
+			continue
+		}
+		if service, err := s.svcMgr.OpenService(name); err == nil {
+			s.services[name] = service
+		}
+	}
+}
+
+// args returns the arguments the installed service of the set with the given name is run with, or nil if it is not
+// installed
+func (s *serviceSet) args(name string) ([]string, error) {
+	service, ok := s.services[name]
+	if !ok {
+		return nil, nil
+	}
+	config, err := service.Config()
+	if err != nil {
+		return nil, fmt.Errorf("could not get config of service %s: %s", name, err)
+	}
+	words := splitCommandLine(config.BinaryPathName)
+	if len(words) == 0 {
+		return nil, nil
+	}
+	return words[1:], nil
+}
+
+// installed returns the names of the installed services of the set, ordered so that each service comes after the
+// services it depends on or is started after
+func (s *serviceSet) installed() ([]string, error) {
+	var specs []ServiceSpec
+	for name, service := range s.services {
+		config, err := service.Config()
+		if err != nil {
+			return nil, fmt.Errorf("could not get config of service %s: %s", name, err)
+		}
+		specs = append(specs, ServiceSpec{Name: name, Dependencies: config.Dependencies, After: s.after[name]})
+	}
+	// Sort by name first so that the order is stable
+	sort.Slice(specs, func(i, j int) bool { return specs[i].Name < specs[j].Name })
+	specs, err := orderServices(specs)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, spec := range specs {
+		names = append(names, spec.Name)
+	}
+	return names, nil
+}
+
+// serviceSnapshot is the configuration and state of the installed services of a set at a point in time
+type serviceSnapshot struct {
+	// specs describe the installed services
+	specs []ServiceSpec
+	// running are the names of the services which were not stopped
+	running map[string]bool
+}
+
+// enabled returns true if the service described by the spec is not disabled
+func enabled(spec ServiceSpec) bool {
+	return spec.StartType != StartDisabled
+}
+
+// snapshot returns the configuration and state of the installed services of the set, which can be restored later
+func (s *serviceSet) snapshot() (*serviceSnapshot, error) {
+	snapshot := &serviceSnapshot{running: make(map[string]bool)}
+	for name, service := range s.services {
+		spec, err := serviceSpecOf(service)
+		if err != nil {
+			return nil, err
+		}
+		spec.After = s.after[name]
+		snapshot.specs = append(snapshot.specs, spec)
+		status, err := service.Query()
+		if err != nil {
+			return nil, fmt.Errorf("could not retrieve status of service %s: %s", name, err)
+		}
+		snapshot.running[name] = status.State != ServiceStopped
+	}
+	// Sort by name so that the services are restored in a stable order
+	sort.Slice(snapshot.specs, func(i, j int) bool { return snapshot.specs[i].Name < snapshot.specs[j].Name })
+	return snapshot, nil
+}
+
+// restore makes the set match the snapshot, waiting for removed services to be deleted before they are recreated, and
+// starts the services which were running
+func (s *serviceSet) restore(ctx context.Context, snapshot *serviceSnapshot) error {
+	if err := s.awaitRemoved(ctx); err != nil {
+		return err
+	}
+	wasRunning := func(spec ServiceSpec) bool { return snapshot.running[spec.Name] }
+	ordered, _, err := s.configure(ctx, snapshot.specs, nil, wasRunning)
+	if err != nil {
+		return err
+	}
+	_, err = s.startServices(ctx, ordered, wasRunning)
+	return err
+}
+
+// configure makes the set match the specs, stopping the services it changes or restarts first. It returns the ordered
+// specs and the names of the services which were created, reconfigured or removed.
+func (s *serviceSet) configure(ctx context.Context, specs []ServiceSpec, restart map[string]bool,
+	run func(ServiceSpec) bool) ([]ServiceSpec, []string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, fmt.Errorf("could not configure services: %s", err)
+	}
+	for _, spec := range specs {
+		if err := spec.validate(); err != nil {
+			return nil, nil, err
+		}
+	}
+	ordered, err := orderServices(specs)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, spec := range ordered {
+		s.after[spec.Name] = spec.After
+	}
+	// installed describes the current configuration of the installed services, and running the ones not stopped
+	installed := make(map[string]ServiceSpec)
+	running := make(map[string]bool)
+	for name, service := range s.services {
+		if installed[name], err = serviceSpecOf(service); err != nil {
+			return nil, nil, err
+		}
+		status, err := service.Query()
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not retrieve status of service %s: %s", name, err)
+		}
+		running[name] = status.State != ServiceStopped
+	}
+
+	wanted := make(map[string]bool)
+	// differences are the settings of each installed service which differ from its spec
+	differences := make(map[string][]string)
+	stopping := make(map[string]bool)
+	for _, spec := range ordered {
+		wanted[spec.Name] = true
+		current, ok := installed[spec.Name]
+		if !ok {
+			continue
+		}
+		differences[spec.Name] = spec.differences(current)
+		if len(differences[spec.Name]) > 0 || restart[spec.Name] || !run(spec) {
+			stopping[spec.Name] = true
+		}
+	}
+	for name := range installed {
+		if !wanted[name] {
+			stopping[name] = true
+		}
+	}
+	// A service is restarted along with the services it depends on or is started after, both as Windows will not stop
+	// a service while a service which depends on it is running, and so that the dependent service picks up the change
+	dependents := append(installedSpecs(installed), ordered...)
+	for added := true; added; {
+		added = false
+		for _, spec := range dependents {
+			if stopping[spec.Name] || !running[spec.Name] {
+				continue
+			}
+			for _, dependency := range append(append([]string{}, spec.Dependencies...), s.after[spec.Name]...) {
+				if stopping[dependency] {
+					stopping[spec.Name], added = true, true
+					break
+				}
+			}
+		}
+	}
+	if err = s.stopServices(ctx, stopping); err != nil {
+		return nil, nil, err
+	}
+
+	var changed []string
+	for _, spec := range installedSpecs(installed) {
+		if !wanted[spec.Name] {
+			if err = s.remove(spec.Name); err != nil {
+				return nil, nil, err
+			}
+			changed = append(changed, spec.Name)
+		}
+	}
+	for _, spec := range ordered {
+		if _, ok := installed[spec.Name]; !ok {
+			err = s.create(spec)
+		} else if len(differences[spec.Name]) > 0 {
+			log.Info("reconfiguring service", "service", spec.Name, "changed", differences[spec.Name])
+			err = s.update(spec)
+		} else {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		changed = append(changed, spec.Name)
+	}
+	return ordered, changed, nil
+}
+
+// startServices starts the installed services of the ordered specs for which run returns true, if they are not
+// running, dependencies first. It returns the names of the services which were started.
+func (s *serviceSet) startServices(ctx context.Context, ordered []ServiceSpec, run func(ServiceSpec) bool) ([]string,
+	error) {
+	var started []string
+	for _, spec := range ordered {
+		if !run(spec) {
+			continue
+		}
+		// A service is started along with the services it depends on, so its state must be checked again
+		status, err := s.services[spec.Name].Query()
+		if err != nil {
+			return nil, fmt.Errorf("could not retrieve status of service %s: %s", spec.Name, err)
+		}
+		if status.State != ServiceStopped {
+			continue
+		}
+		if err = s.start(ctx, spec.Name); err != nil {
+			return nil, err
+		}
+		started = append(started, spec.Name)
+	}
+	return started, nil
+}
+
+// verify returns the problems found with the installed services of the specs: services which are missing or differ
+// from their spec, and services which are not running although they are not disabled
+func (s *serviceSet) verify(specs []ServiceSpec) ([]string, error) {
+	var problems []string
+	for _, spec := range specs {
+		service, ok := s.services[spec.Name]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("service %s is not installed", spec.Name))
+			continue
+		}
+		current, err := serviceSpecOf(service)
+		if err != nil {
+			return nil, err
+		}
+		if differences := spec.differences(current); len(differences) > 0 {
+			problems = append(problems, fmt.Sprintf("service %s has a different %s", spec.Name,
+				strings.Join(differences, ", ")))
+		}
+		if !enabled(spec) {
+			continue
+		}
+		status, err := service.Query()
+		if err != nil {
+			return nil, fmt.Errorf("could not retrieve status of service %s: %s", spec.Name, err)
+		}
+		if status.State != ServiceRunning {
+			problems = append(problems, fmt.Sprintf("service %s is not running, it is %s", spec.Name, status.State))
+		}
+	}
+	return problems, nil
+}
+
+// installedSpecs returns the specs of the installed services, sorted by name so that the order is stable
+func installedSpecs(installed map[string]ServiceSpec) []ServiceSpec {
+	var specs []ServiceSpec
+	for _, spec := range installed {
+		specs = append(specs, spec)
+	}
+	sort.Slice(specs, func(i, j int) bool { return specs[i].Name < specs[j].Name })
+	return specs
+}
+
+// serviceSpecOf returns the spec describing the current configuration of the installed service
+func serviceSpecOf(service Service) (ServiceSpec, error) {
+	name := service.Name()
+	config, err := service.Config()
+	if err != nil {
+		return ServiceSpec{}, fmt.Errorf("could not get config of service %s: %s", name, err)
+	}
+	spec := ServiceSpec{
+		Name:         name,
+		Dependencies: config.Dependencies,
+		Description:  config.Description,
+		StartType:    config.StartType,
+		Environment:  config.Environment,
+	}
+	if words := splitCommandLine(config.BinaryPathName); len(words) > 0 {
+		spec.BinaryPath, spec.Args = words[0], words[1:]
+	}
+	if spec.RecoveryActions, err = service.RecoveryActions(); err != nil {
+		return ServiceSpec{}, fmt.Errorf("could not get recovery actions of service %s: %s", name, err)
+	}
+	if spec.RecoveryResetPeriod, err = service.ResetPeriod(); err != nil {
+		return ServiceSpec{}, fmt.Errorf("could not get recovery reset period of service %s: %s", name, err)
+	}
+	return spec, nil
+}
+
+// create installs the service described by the spec
+func (s *serviceSet) create(spec ServiceSpec) error {
+	service, err := s.svcMgr.CreateService(spec.Name, spec.BinaryPath, spec.config(), spec.Args...)
+	if err != nil {
+		return fmt.Errorf("could not create service %s: %s", spec.Name, err)
+	}
+	s.services[spec.Name] = service
+	if err = service.SetRecoveryActions(spec.recoveryActions(), spec.RecoveryResetPeriod); err != nil {
+		return fmt.Errorf("could not set recovery actions of service %s: %s", spec.Name, err)
+	}
+	return nil
+}
+
+// update reconfigures the installed service to match the spec, keeping the settings the spec does not describe
+func (s *serviceSet) update(spec ServiceSpec) error {
+	service := s.services[spec.Name]
+	current, err := service.Config()
+	if err != nil {
+		return fmt.Errorf("could not get config of service %s: %s", spec.Name, err)
+	}
+	config := spec.config()
+	config.ServiceType = current.ServiceType
+	config.ErrorControl = current.ErrorControl
+	config.DisplayName = current.DisplayName
+	if err := service.UpdateConfig(config); err != nil {
+		return fmt.Errorf("could not update config of service %s: %s", spec.Name, err)
+	}
+	if err := service.SetRecoveryActions(spec.recoveryActions(), spec.RecoveryResetPeriod); err != nil {
+		return fmt.Errorf("could not set recovery actions of service %s: %s", spec.Name, err)
+	}
+	return nil
+}
+
+// start starts the installed service, and waits until it is running
+func (s *serviceSet) start(ctx context.Context, name string) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("could not start service %s: %s", name, err)
+	}
+	if err := s.services[name].Start(); err != nil {
+		return fmt.Errorf("could not start service %s: %s", name, err)
+	}
+	return s.waitForState(ctx, name, ServiceRunning)
+}
+
+// stop stops the installed service, if it is not already stopped, and waits until it has stopped
+func (s *serviceSet) stop(ctx context.Context, name string) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("could not stop service %s: %s", name, err)
+	}
+	service := s.services[name]
+	status, err := service.Query()
+	if err != nil {
+		return fmt.Errorf("could not retrieve status of service %s: %s", name, err)
+	}
+	switch status.State {
+	case ServiceStopped:
+		return nil
+	case ServiceStopPending:
+		// A service which is already stopping does not accept another stop control
+	default:
+		if _, err = service.Control(ServiceStop); err != nil {
+			return fmt.Errorf("could not stop service %s: %s", name, err)
+		}
+	}
+	return s.waitForState(ctx, name, ServiceStopped)
+}
+
+// waitForState waits until the installed service is in the wanted state. A service which stops while it is waited on
+// to run failed to start, so the wait fails at once.
+func (s *serviceSet) waitForState(ctx context.Context, name string, want ServiceState) error {
+	service := s.services[name]
+	return s.poll(ctx, fmt.Sprintf("service %s to be %s", name, want), func() (bool, string, error) {
+		status, err := service.Query()
+		if err != nil {
+			return false, "", fmt.Errorf("could not retrieve status of service %s: %s", name, err)
+		}
+		if want == ServiceRunning && status.State == ServiceStopped {
+			return false, "", fmt.Errorf("service %s stopped while starting", name)
+		}
+		return status.State == want, status.State.String(), nil
+	})
+}
+
+// poll checks condition, with an exponential backoff between checks, until it is met. It gives up once the wait time
+// of the set passes or ctx is done, and the error reports the state condition last observed.
+func (s *serviceSet) poll(ctx context.Context, what string, condition func() (bool, string, error)) error {
+	ctx, cancel := context.WithTimeout(ctx, s.waitTime)
+	defer cancel()
+	interval := servicePollInterval
+	for {
+		met, state, err := condition()
+		if err != nil || met {
+			return err
+		}
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("gave up waiting for %s, last observed state %s: %s", what, state, ctx.Err())
+		case <-timer.C:
+		}
+		if interval *= 2; interval > servicePollMaxInterval {
+			interval = servicePollMaxInterval
+		}
+	}
+}
+
+// stopAll stops the installed services of the set, each before the services it depends on
+func (s *serviceSet) stopAll(ctx context.Context) error {
+	names := make(map[string]bool)
+	for name := range s.services {
+		names[name] = true
+	}
+	return s.stopServices(ctx, names)
+}
+
+// stopServices stops the installed services of the set with the given names, each before the services it depends on
+func (s *serviceSet) stopServices(ctx context.Context, names map[string]bool) error {
+	installed, err := s.installed()
+	if err != nil {
+		return err
+	}
+	for i := len(installed) - 1; i >= 0; i-- {
+		if !names[installed[i]] {
+			continue
+		}
+		if err = s.stop(ctx, installed[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remove marks the installed service for deletion and removes it from the set. The service must be stopped.
+func (s *serviceSet) remove(name string) error {
+	service := s.services[name]
+	if err := service.Delete(); err != nil {
+		return fmt.Errorf("could not remove service %s: %s", name, err)
+	}
+	delete(s.services, name)
+	s.removed = append(s.removed, service)
+	return nil
+}
+
+// removeAll stops and removes all the installed services of the set, and waits until Windows has deleted them
+func (s *serviceSet) removeAll(ctx context.Context) error {
+	if err := s.stopAll(ctx); err != nil {
+		return err
+	}
+	for name := range s.services {
+		if err := s.remove(name); err != nil {
+			return err
+		}
+	}
+	return s.awaitRemoved(ctx)
+}
+
+// awaitRemoved releases the handles to the services of the set which were marked for deletion, and waits until
+// Windows has deleted them
+func (s *serviceSet) awaitRemoved(ctx context.Context) error {
+	var names []string
+	for len(s.removed) > 0 {
+		if err := s.removed[0].Close(); err != nil {
+			return fmt.Errorf("could not close service %s: %s", s.removed[0].Name(), err)
+		}
+		names = append(names, s.removed[0].Name())
+		s.removed = s.removed[1:]
+	}
+	for _, name := range names {
+		err := s.poll(ctx, fmt.Sprintf("service %s to be deleted", name), func() (bool, string, error) {
+			installed, err := s.svcMgr.ListServices()
+			if err != nil {
+				return false, "", fmt.Errorf("could not list services: %s", err)
+			}
+			for _, other := range installed {
+				if other == name {
+					return false, "marked for deletion", nil
+				}
+			}
+			return true, "", nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// close releases the handles to the services of the set and disconnects from the Windows service API, which allows
+// removed services to be deleted
+func (s *serviceSet) close() error {
+	for name, service := range s.services {
+		if err := service.Close(); err != nil {
+			return fmt.Errorf("could not close service %s: %s", name, err)
+		}
+		delete(s.services, name)
+	}
+	for len(s.removed) > 0 {
+		if err := s.removed[0].Close(); err != nil {
+			return fmt.Errorf("could not close service %s: %s", s.removed[0].Name(), err)
+		}
+		s.removed = s.removed[1:]
+	}
+	return s.svcMgr.Disconnect()
+}
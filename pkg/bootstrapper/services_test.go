@@ -0,0 +1,388 @@
+package bootstrapper
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestOrderServices tests that services are ordered after the services they depend on
+func TestOrderServices(t *testing.T) {
+	tests := []struct {
+		name    string
+		specs   []ServiceSpec
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:  "No dependencies keeps the given order",
+			specs: []ServiceSpec{{Name: "b"}, {Name: "a"}},
+			want:  []string{"b", "a"},
+		},
+		{
+			name: "Dependencies come first",
+			specs: []ServiceSpec{
+				{Name: "kubelet", Dependencies: []string{"hybrid-overlay-node"}},
+				{Name: "kube-proxy", Dependencies: []string{"kubelet"}},
+				{Name: "hybrid-overlay-node"},
+			},
+			want: []string{"hybrid-overlay-node", "kubelet", "kube-proxy"},
+		},
+		{
+			name:  "Services started after come first",
+			specs: []ServiceSpec{{Name: "kubelet", After: []string{"hybrid-overlay-node"}}, {Name: "hybrid-overlay-node"}},
+			want:  []string{"hybrid-overlay-node", "kubelet"},
+		},
+		{
+			name:  "Dependencies outside of the set are ignored",
+			specs: []ServiceSpec{{Name: "kubelet", Dependencies: []string{"docker"}}},
+			want:  []string{"kubelet"},
+		},
+		{
+			name: "Dependency cycle",
+			specs: []ServiceSpec{
+				{Name: "a", Dependencies: []string{"b"}},
+				{Name: "b", Dependencies: []string{"a"}},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "Duplicate service",
+			specs:   []ServiceSpec{{Name: "a"}, {Name: "a"}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ordered, err := orderServices(tt.specs)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			var got []string
+			for _, spec := range ordered {
+				got = append(got, spec.Name)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+// testServiceSpec returns the spec of a service which runs a test executable
+func testServiceSpec(name string, dependencies ...string) ServiceSpec {
+	return ServiceSpec{
+		Name:                name,
+		BinaryPath:          "C:\\Program Files\\" + name + ".exe",
+		Args:                []string{"--v=2"},
+		Dependencies:        dependencies,
+		StartType:           StartAutomatic,
+		RecoveryActions:     defaultRecoveryActions,
+		RecoveryResetPeriod: defaultRecoveryResetPeriod,
+	}
+}
+
+// newTestServiceSet returns a service set backed by the given fake SCM, containing the services with the given names
+func newTestServiceSet(t *testing.T, scm *fakeSCM, names ...string) *serviceSet {
+	s, err := newServiceSet(scm.connect, 10*time.Millisecond)
+	require.NoError(t, err)
+	s.open(names...)
+	return s
+}
+
+// configureServices configures the set to match the specs and starts the services which are not disabled, as a run of
+// the bootstrapper does. It returns the names of the services which were changed, and the ones which were started.
+func configureServices(s *serviceSet, specs []ServiceSpec, restart map[string]bool) ([]string, []string, error) {
+	ordered, changed, err := s.configure(context.Background(), specs, restart, enabled)
+	if err != nil {
+		return nil, nil, err
+	}
+	started, err := s.startServices(context.Background(), ordered, enabled)
+	return changed, started, err
+}
+
+// TestServiceSetConfigure tests that configuring specs creates, updates and removes the services of the set
+func TestServiceSetConfigure(t *testing.T) {
+	scm := newFakeSCM()
+	scm.install("exporter", ServiceConfig{StartType: StartAutomatic, BinaryPathName: "C:\\exporter.exe"},
+		ServiceRunning)
+	scm.install("kube-proxy", ServiceConfig{StartType: StartManual, BinaryPathName: "C:\\old\\kube-proxy.exe"},
+		ServiceRunning, "--v=10")
+	s := newTestServiceSet(t, scm, "exporter", "kube-proxy", "kubelet")
+
+	kubeProxy := testServiceSpec("kube-proxy", "kubelet")
+	kubeProxy.Args = []string{"--hostname-override=win node", `--cluster-cidr="10.128.0.0/14"`}
+	kubelet := testServiceSpec("kubelet")
+	changed, _, err := configureServices(s, []ServiceSpec{kubeProxy, kubelet}, nil)
+	require.NoError(t, err)
+	require.NoError(t, s.close())
+	assert.Equal(t, []string{"exporter", "kubelet", "kube-proxy"}, changed)
+
+	assert.Nil(t, scm.get("exporter"), "service without a spec should be removed")
+	proxyRecord := scm.get("kube-proxy")
+	require.NotNil(t, proxyRecord)
+	assert.Equal(t, ServiceRunning, proxyRecord.state)
+	assert.Equal(t, StartAutomatic, proxyRecord.config.StartType)
+	assert.Equal(t, kubeProxy.BinaryPath, proxyRecord.exePath())
+	assert.Equal(t, kubeProxy.Args, proxyRecord.args)
+	assert.Equal(t, []string{"kubelet"}, proxyRecord.config.Dependencies)
+	assert.Equal(t, defaultRecoveryActions, proxyRecord.recoveryActions)
+	kubeletRecord := scm.get("kubelet")
+	require.NotNil(t, kubeletRecord)
+	assert.Equal(t, ServiceRunning, kubeletRecord.state)
+	assert.Equal(t, kubelet.Args, kubeletRecord.args)
+	assert.Equal(t, []string{"kubelet", "kube-proxy"}, scm.started)
+	for _, record := range []*fakeServiceRecord{proxyRecord, kubeletRecord} {
+		assert.Equal(t, 0, record.openHandles, "handles to service %s were leaked", record.name)
+	}
+}
+
+// TestServiceSetConfigureRestart tests that configuring specs only restarts the services which changed, or were asked to be
+// restarted, along with the services which depend on them
+func TestServiceSetConfigureRestart(t *testing.T) {
+	scm := newFakeSCM()
+	s := newTestServiceSet(t, scm)
+	defer s.close()
+	kubelet := testServiceSpec("kubelet")
+	kubeProxy := testServiceSpec("kube-proxy", "kubelet")
+	exporter := testServiceSpec("exporter")
+	_, _, err := configureServices(s, []ServiceSpec{kubelet, kubeProxy, exporter}, nil)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		kubeProxy   []string
+		restart     map[string]bool
+		wantChanged []string
+		wantStarted []string
+	}{
+		{name: "Unchanged"},
+		{name: "Restart", restart: map[string]bool{"kubelet": true}, wantStarted: []string{"kubelet", "kube-proxy"}},
+		{name: "Changed args", kubeProxy: []string{"--v=4"}, wantChanged: []string{"kube-proxy"},
+			wantStarted: []string{"kube-proxy"}},
+		{name: "Unchanged args", kubeProxy: []string{"--v=4"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scm.started = nil
+			if tt.kubeProxy != nil {
+				kubeProxy.Args = tt.kubeProxy
+			}
+			changed, started, err := configureServices(s, []ServiceSpec{kubelet, kubeProxy, exporter}, tt.restart)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantChanged, changed)
+			assert.Equal(t, tt.wantStarted, started)
+			assert.Equal(t, tt.wantStarted, scm.started)
+			for _, name := range []string{"kubelet", "kube-proxy", "exporter"} {
+				assert.Equal(t, ServiceRunning, scm.get(name).state)
+			}
+		})
+	}
+}
+
+// TestServiceSetConfigureInPlace tests that a service whose configuration differs from its spec is reconfigured in place
+// with a configuration the service manager accepts, keeping the settings the spec does not describe
+func TestServiceSetConfigureInPlace(t *testing.T) {
+	scm := newFakeSCM()
+	scm.install("hybrid-overlay-node", ServiceConfig{StartType: StartAutomatic, BinaryPathName: "C:\\hybrid.exe"},
+		ServiceRunning)
+	scm.install("kubelet", ServiceConfig{ServiceType: ServiceShareProcess, ErrorControl: ServiceErrorNormal,
+		StartType: StartManual, BinaryPathName: "C:\\k\\kubelet.exe", Dependencies: []string{"hybrid-overlay-node"},
+		DisplayName: "Kubernetes kubelet"}, ServiceRunning, "--v=2")
+	s := newTestServiceSet(t, scm, "kubelet")
+	defer s.close()
+
+	// Like Windows, the service manager rejects a configuration without a service type
+	err := s.services["kubelet"].UpdateConfig(ServiceConfig{StartType: StartAutomatic})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid service type")
+
+	kubelet := ServiceSpec{Name: "kubelet", BinaryPath: "C:\\k\\kubelet.exe", Args: []string{"--v=4"},
+		StartType: StartAutomatic}
+	changed, started, err := configureServices(s, []ServiceSpec{kubelet}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"kubelet"}, changed)
+	assert.Equal(t, []string{"kubelet"}, started)
+
+	record := scm.get("kubelet")
+	assert.Equal(t, []string{"--v=4"}, record.args)
+	assert.Equal(t, StartAutomatic, record.config.StartType)
+	assert.Empty(t, record.config.Dependencies)
+	assert.Equal(t, ServiceShareProcess, record.config.ServiceType)
+	assert.Equal(t, ServiceErrorNormal, record.config.ErrorControl)
+	assert.Equal(t, "Kubernetes kubelet", record.config.DisplayName)
+	assert.Equal(t, ServiceRunning, record.state)
+}
+
+// TestServiceSetConfigureDisabled tests that disabled services are configured but not started
+func TestServiceSetConfigureDisabled(t *testing.T) {
+	scm := newFakeSCM()
+	s := newTestServiceSet(t, scm)
+	spec := testServiceSpec("exporter")
+	spec.StartType = StartDisabled
+	spec.RecoveryActions = nil
+	_, _, err := configureServices(s, []ServiceSpec{spec}, nil)
+	require.NoError(t, err)
+	require.NoError(t, s.close())
+
+	record := scm.get("exporter")
+	require.NotNil(t, record)
+	assert.Equal(t, ServiceStopped, record.state)
+	assert.Equal(t, []RecoveryAction{{Type: NoAction}}, record.recoveryActions)
+}
+
+// TestServiceSetConfigureInvalid tests that invalid specs are rejected before any service is changed
+func TestServiceSetConfigureInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		spec ServiceSpec
+	}{
+		{name: "No name", spec: ServiceSpec{BinaryPath: "C:\\k\\kubelet.exe", StartType: StartAutomatic}},
+		{name: "No binary path", spec: ServiceSpec{Name: "kubelet", StartType: StartAutomatic}},
+		{name: "No start type", spec: ServiceSpec{Name: "kubelet", BinaryPath: "C:\\k\\kubelet.exe"}},
+		{name: "Self dependency", spec: testServiceSpec("kubelet", "kubelet")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scm := newFakeSCM()
+			scm.install("kubelet", ServiceConfig{StartType: StartAutomatic, BinaryPathName: "C:\\kubelet.exe"},
+				ServiceRunning)
+			s := newTestServiceSet(t, scm, "kubelet")
+			_, _, err := configureServices(s, []ServiceSpec{tt.spec}, nil)
+			assert.Error(t, err)
+			require.NoError(t, s.close())
+			assert.Equal(t, ServiceRunning, scm.get("kubelet").state)
+		})
+	}
+}
+
+// TestServiceSetRestoreRemoved tests that a service removed by configuring the set is recreated when the set is
+// restored, although it was only marked for deletion
+func TestServiceSetRestoreRemoved(t *testing.T) {
+	scm := newFakeSCM()
+	s := newTestServiceSet(t, scm)
+	hybridOverlay := testServiceSpec("hybrid-overlay-node")
+	kubelet := testServiceSpec("kubelet", "hybrid-overlay-node")
+	_, _, err := configureServices(s, []ServiceSpec{hybridOverlay, kubelet}, nil)
+	require.NoError(t, err)
+	snapshot, err := s.snapshot()
+	require.NoError(t, err)
+
+	kubelet.Dependencies = nil
+	_, _, err = s.configure(context.Background(), []ServiceSpec{kubelet}, nil, enabled)
+	require.NoError(t, err)
+	require.True(t, scm.get("hybrid-overlay-node").deletePending)
+
+	require.NoError(t, s.restore(context.Background(), snapshot))
+	require.NoError(t, s.close())
+	for _, name := range []string{"hybrid-overlay-node", "kubelet"} {
+		record := scm.get(name)
+		require.NotNil(t, record, "service %s is not installed", name)
+		assert.False(t, record.deletePending)
+		assert.Equal(t, ServiceRunning, record.state)
+	}
+	assert.Equal(t, []string{"hybrid-overlay-node"}, scm.get("kubelet").config.Dependencies)
+}
+
+// TestServiceSetRemoveAll tests that services are stopped before the services they depend on, and have been deleted
+// once they are removed
+func TestServiceSetRemoveAll(t *testing.T) {
+	scm := newFakeSCM()
+	scm.install("hybrid-overlay-node", ServiceConfig{StartType: StartAutomatic}, ServiceRunning)
+	scm.install("kubelet", ServiceConfig{StartType: StartAutomatic, Dependencies: []string{"hybrid-overlay-node"}},
+		ServiceRunning)
+	scm.install("kube-proxy", ServiceConfig{StartType: StartAutomatic, Dependencies: []string{"kubelet"}},
+		ServiceRunning)
+	// The fake SCM refuses to stop a service while a service which depends on it is running
+	s := newTestServiceSet(t, scm, "hybrid-overlay-node", "kubelet", "kube-proxy")
+
+	require.NoError(t, s.removeAll(context.Background()))
+	for _, name := range []string{"hybrid-overlay-node", "kubelet", "kube-proxy"} {
+		assert.Nil(t, scm.get(name))
+	}
+	require.NoError(t, s.close())
+}
+
+// TestServiceSetRemoveAllWait tests that removing a service waits until Windows deletes it, which it only does once
+// other processes release their handles to it
+func TestServiceSetRemoveAllWait(t *testing.T) {
+	scm := newFakeSCM()
+	scm.install("kubelet", ServiceConfig{StartType: StartAutomatic}, ServiceRunning)
+	s := newTestServiceSet(t, scm, "kubelet")
+	s.waitTime = 10 * time.Second
+	release := scm.holdHandle("kubelet")
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		release()
+	}()
+	require.NoError(t, s.removeAll(context.Background()))
+	assert.Nil(t, scm.get("kubelet"))
+	require.NoError(t, s.close())
+
+	scm.install("kubelet", ServiceConfig{StartType: StartAutomatic}, ServiceRunning)
+	s = newTestServiceSet(t, scm, "kubelet")
+	defer scm.holdHandle("kubelet")()
+	err := s.removeAll(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "last observed state marked for deletion")
+	require.NoError(t, s.close())
+}
+
+// TestServiceSetWait tests that stopping and starting a service waits until it is stopped or running, and that a
+// wait which gives up reports the state the service was last in
+func TestServiceSetWait(t *testing.T) {
+	tests := []struct {
+		name string
+		// state is the state the service is in
+		state ServiceState
+		// pendingQueries is the number of queries the service reports it is stopping for, it never stops if negative
+		pendingQueries int
+		// crashes is true if the service exits as soon as it is started
+		crashes bool
+		// cancelled is true if the context is cancelled before the service is waited on
+		cancelled bool
+		// wantErr is part of the error stopping and starting the service fails with, it succeeds if empty
+		wantErr string
+	}{
+		{name: "Slow stop", state: ServiceRunning, pendingQueries: 3},
+		{name: "Already stopping", state: ServiceStopPending, pendingQueries: 3},
+		{name: "Stuck stop", state: ServiceRunning, pendingQueries: -1,
+			wantErr: "gave up waiting for service kubelet to be Stopped, last observed state StopPending: " +
+				"context deadline exceeded"},
+		{name: "Crash on start", state: ServiceStopped, crashes: true, wantErr: "service kubelet stopped while starting"},
+		{name: "Cancelled", state: ServiceRunning, cancelled: true, wantErr: "context canceled"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scm := newFakeSCM()
+			scm.install("kubelet", ServiceConfig{StartType: StartAutomatic}, tt.state)
+			record := scm.get("kubelet")
+			record.pendingQueries, record.remainingQueries, record.crashes = tt.pendingQueries, tt.pendingQueries,
+				tt.crashes
+			s := newTestServiceSet(t, scm, "kubelet")
+			s.waitTime = time.Second
+			defer s.close()
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancelled {
+				cancel()
+			}
+
+			err := s.stop(ctx, "kubelet")
+			if err == nil {
+				assert.Equal(t, ServiceStopped, scm.get("kubelet").state)
+				err = s.start(ctx, "kubelet")
+			}
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, ServiceRunning, scm.get("kubelet").state)
+		})
+	}
+}
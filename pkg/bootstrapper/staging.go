@@ -0,0 +1,297 @@
+package bootstrapper
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// backupDirName is the name of the directory in the install directory the files replaced by the last run are
+	// moved to
+	backupDirName = "backup"
+	// nextBackupDirName is the name of the directory in the install directory the files replaced by the current run
+	// are moved to, until it replaces the backup directory once the run succeeds
+	nextBackupDirName = backupDirName + ".next"
+)
+
+// fileStage writes the files of a run to the install directory, backing up the files it replaces, so that they can be
+// rolled back as a unit
+type fileStage struct {
+	// fs is the filesystem the files are written to
+	fs FileSystem
+	// installDir is the directory the files are written to
+	installDir string
+	// backupDir is the directory holding the backups of the previous generation
+	backupDir string
+	// nextBackupDir is the directory replaced files are moved to, mirroring their paths in the install directory
+	nextBackupDir string
+	// prepared is true once the backups left in the next backup directory by an interrupted run have been removed
+	prepared bool
+	// paths are the files installed through the stage, whether or not they changed, in order
+	paths []string
+	// changes are the files written or removed by the stage, in order
+	changes []stagedChange
+	// undos undo the changes made outside of the install directory along with the files, in order
+	undos []func() error
+}
+
+// stagedChange is a file written by a fileStage
+type stagedChange struct {
+	path string
+	// backup is the path the replaced file was moved to, or empty if the file did not exist
+	backup string
+}
+
+// newFileStage returns a stage which writes to the install directory on the filesystem
+func newFileStage(fs FileSystem, installDir string) *fileStage {
+	return &fileStage{fs: fs, installDir: installDir, backupDir: filepath.Join(installDir, backupDirName),
+		nextBackupDir: filepath.Join(installDir, nextBackupDirName)}
+}
+
+// writeFile writes the contents to the file at path
+func (f *fileStage) writeFile(path string, contents []byte, perm os.FileMode) error {
+	tmpPath := path + ".tmp"
+	if err := writeFile(f.fs, tmpPath, contents, perm); err != nil {
+		f.fs.Remove(tmpPath)
+		return err
+	}
+	return f.install(tmpPath, path)
+}
+
+// copyFile copies src to dest
+func (f *fileStage) copyFile(src, dest string) error {
+	tmpPath := dest + ".tmp"
+	if err := copyFile(f.fs, src, tmpPath); err != nil {
+		f.fs.Remove(tmpPath)
+		return err
+	}
+	return f.install(tmpPath, dest)
+}
+
+// install renames the file at tmpPath into place at path, backing up the file it replaces, unless it has the same
+// contents
+func (f *fileStage) install(tmpPath, path string) error {
+	rel, err := filepath.Rel(f.installDir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		f.fs.Remove(tmpPath)
+		return fmt.Errorf("%s is not in the install directory %s", path, f.installDir)
+	}
+	if !f.installed(path) {
+		f.paths = append(f.paths, path)
+	}
+	if same, err := sameContents(f.fs, tmpPath, path); err != nil || same {
+		f.fs.Remove(tmpPath)
+		return err
+	}
+	if !f.written(path) {
+		if err = f.backupChange(path, rel); err != nil {
+			f.fs.Remove(tmpPath)
+			return err
+		}
+	}
+	if err = f.fs.Rename(tmpPath, path); err != nil {
+		f.fs.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// removeFile removes the file at path, moving it to the backup directory. A file which does not exist is ignored.
+func (f *fileStage) removeFile(path string) error {
+	rel, err := filepath.Rel(f.installDir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("%s is not in the install directory %s", path, f.installDir)
+	}
+	if f.written(path) {
+		// The stage already holds the backup of the original
+		if err = f.fs.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	if _, err = f.fs.Lstat(path); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return f.backupChange(path, rel)
+}
+
+// backupChange moves the file at path, which is rel relative to the install directory, to the backup directory and
+// records the change, so that the file is restored if the stage is rolled back
+func (f *fileStage) backupChange(path, rel string) error {
+	if !f.prepared {
+		if err := f.fs.RemoveAll(f.nextBackupDir); err != nil {
+			return fmt.Errorf("could not remove backup of interrupted run: %s", err)
+		}
+		f.prepared = true
+	}
+	backup, err := f.backup(path, rel)
+	if err != nil {
+		return err
+	}
+	f.changes = append(f.changes, stagedChange{path: path, backup: backup})
+	return nil
+}
+
+// onRollback records undo as the way to undo a change made outside of the install directory along with the files
+func (f *fileStage) onRollback(undo func() error) {
+	f.undos = append(f.undos, undo)
+}
+
+// changed returns the paths of the files written by the stage, in order
+func (f *fileStage) changed() []string {
+	var paths []string
+	for _, change := range f.changes {
+		paths = append(paths, change.path)
+	}
+	return paths
+}
+
+// installed returns true if the file at path was installed through the stage
+func (f *fileStage) installed(path string) bool {
+	for _, installed := range f.paths {
+		if installed == path {
+			return true
+		}
+	}
+	return false
+}
+
+// written returns true if the file at path was written by the stage
+func (f *fileStage) written(path string) bool {
+	for _, change := range f.changes {
+		if change.path == path {
+			return true
+		}
+	}
+	return false
+}
+
+// backup moves the file at path, which is rel relative to the install directory, to the next backup directory, and
+// returns the path it was moved to. An empty path is returned if the file does not exist.
+func (f *fileStage) backup(path, rel string) (string, error) {
+	if _, err := f.fs.Lstat(path); os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	backup := filepath.Join(f.nextBackupDir, rel)
+	if err := f.fs.MkdirAll(filepath.Dir(backup), 0755); err != nil {
+		return "", fmt.Errorf("could not make backup directory: %s", err)
+	}
+	// Renaming works even if the file is the binary of a running service, unlike overwriting it
+	if err := f.fs.Rename(path, backup); err != nil {
+		return "", fmt.Errorf("could not back up %s: %s", path, err)
+	}
+	return backup, nil
+}
+
+// rollback restores the files changed by the stage and undoes the changes made along with them, in reverse order
+func (f *fileStage) rollback() error {
+	var failed []string
+	for i := len(f.changes) - 1; i >= 0; i-- {
+		change := f.changes[i]
+		var err error
+		if change.backup != "" {
+			err = f.fs.Rename(change.backup, change.path)
+		} else {
+			err = f.fs.Remove(change.path)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			failed = append(failed, err.Error())
+		}
+	}
+	f.changes = nil
+	for i := len(f.undos) - 1; i >= 0; i-- {
+		if err := f.undos[i](); err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+	f.undos = nil
+	if len(failed) > 0 {
+		return fmt.Errorf("could not restore files: %s", strings.Join(failed, ", "))
+	}
+	return f.fs.RemoveAll(f.nextBackupDir)
+}
+
+// commit makes the backups of the stage the previous generation, if it changed any files
+func (f *fileStage) commit() error {
+	if len(f.changes) == 0 {
+		return nil
+	}
+	if err := f.fs.RemoveAll(f.backupDir); err != nil {
+		return fmt.Errorf("could not remove previous backup: %s", err)
+	}
+	if _, err := f.fs.Lstat(f.nextBackupDir); os.IsNotExist(err) {
+		// The stage only created files
+		return nil
+	}
+	if err := f.fs.Rename(f.nextBackupDir, f.backupDir); err != nil {
+		return fmt.Errorf("could not keep backup: %s", err)
+	}
+	return nil
+}
+
+// sameContents returns true if the files at a and b on the filesystem have the same contents. It returns false if b
+// does not exist.
+func sameContents(fs FileSystem, a, b string) (bool, error) {
+	bInfo, err := fs.Stat(b)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	aInfo, err := fs.Stat(a)
+	if err != nil {
+		return false, err
+	}
+	if !bInfo.Mode().IsRegular() || aInfo.Size() != bInfo.Size() {
+		return false, nil
+	}
+	aFile, err := fs.Open(a)
+	if err != nil {
+		return false, err
+	}
+	defer aFile.Close()
+	bFile, err := fs.Open(b)
+	if err != nil {
+		return false, err
+	}
+	defer bFile.Close()
+	aBuf, bBuf := make([]byte, 32*1024), make([]byte, 32*1024)
+	for {
+		n, aErr := io.ReadFull(aFile, aBuf)
+		if _, err = io.ReadFull(bFile, bBuf[:n]); err != nil {
+			return false, err
+		}
+		if !bytes.Equal(aBuf[:n], bBuf[:n]) {
+			return false, nil
+		}
+		if aErr == io.EOF || aErr == io.ErrUnexpectedEOF {
+			return true, nil
+		} else if aErr != nil {
+			return false, aErr
+		}
+	}
+}
+
+// writeFile writes the contents to the file at path on the filesystem, replacing it, and flushes it to disk
+func writeFile(fs FileSystem, path string, contents []byte, perm os.FileMode) error {
+	to, err := fs.Create(path, perm)
+	if err != nil {
+		return err
+	}
+	defer to.Close()
+	if _, err = to.Write(contents); err != nil {
+		return err
+	}
+	if err = to.Sync(); err != nil {
+		return err
+	}
+	return to.Close()
+}
@@ -0,0 +1,143 @@
+package bootstrapper
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// assertFileContents checks that the file at path has the given contents
+func assertFileContents(t *testing.T, path, contents string) {
+	actual, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, contents, string(actual))
+}
+
+// TestFileStage tests that a stage replaces files, keeping the replaced ones as a backup, and that rolling it back
+// restores them
+func TestFileStage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wmcb")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	installDir := filepath.Join(dir, "k")
+	require.NoError(t, os.MkdirAll(filepath.Join(installDir, "cni"), 0755))
+	conf := filepath.Join(installDir, "kubelet.conf")
+	require.NoError(t, ioutil.WriteFile(conf, []byte("previous config"), 0644))
+	binary := filepath.Join(installDir, "cni", "win-overlay.exe")
+	require.NoError(t, ioutil.WriteFile(binary, []byte("previous plugin binary"), 0755))
+	src := filepath.Join(dir, "win-overlay.exe")
+	require.NoError(t, ioutil.WriteFile(src, []byte("plugin"), 0755))
+	created := filepath.Join(installDir, "kubelet-ca.crt")
+
+	stage := newFileStage(hostFileSystem{}, installDir)
+	require.NoError(t, stage.writeFile(conf, []byte("config"), 0644))
+	require.NoError(t, stage.writeFile(conf, []byte("final config"), 0644))
+	// The copy is shorter than the file it replaces, none of which must be left behind
+	require.NoError(t, stage.copyFile(src, binary))
+	require.NoError(t, stage.writeFile(created, []byte("ca"), 0644))
+	assert.Error(t, stage.writeFile(filepath.Join(dir, "outside"), []byte("outside"), 0644))
+
+	assertFileContents(t, conf, "final config")
+	assertFileContents(t, binary, "plugin")
+	assertFileContents(t, created, "ca")
+	assertFileContents(t, filepath.Join(installDir, nextBackupDirName, "kubelet.conf"), "previous config")
+	assertFileContents(t, filepath.Join(installDir, nextBackupDirName, "cni", "win-overlay.exe"),
+		"previous plugin binary")
+	for _, path := range []string{conf, binary, created} {
+		_, err = os.Stat(path + ".tmp")
+		assert.True(t, os.IsNotExist(err), "temporary file was left behind")
+	}
+
+	require.NoError(t, stage.rollback())
+	assertFileContents(t, conf, "previous config")
+	assertFileContents(t, binary, "previous plugin binary")
+	_, err = os.Stat(created)
+	assert.True(t, os.IsNotExist(err), "created file was not removed")
+	_, err = os.Stat(filepath.Join(installDir, nextBackupDirName))
+	assert.True(t, os.IsNotExist(err), "backup directory was not removed")
+
+	// Files which are unchanged are neither rewritten nor backed up, so the backups of the last stage which changed
+	// files are kept
+	stage = newFileStage(hostFileSystem{}, installDir)
+	require.NoError(t, stage.writeFile(conf, []byte("config"), 0644))
+	require.NoError(t, stage.commit())
+	require.NoError(t, ioutil.WriteFile(src, []byte("previous plugin binary"), 0755))
+	stage = newFileStage(hostFileSystem{}, installDir)
+	require.NoError(t, stage.writeFile(conf, []byte("config"), 0644))
+	require.NoError(t, stage.copyFile(src, binary))
+	assert.Empty(t, stage.changed())
+	assertFileContents(t, filepath.Join(installDir, backupDirName, "kubelet.conf"), "previous config")
+	require.NoError(t, stage.rollback())
+	assertFileContents(t, filepath.Join(installDir, backupDirName, "kubelet.conf"), "previous config")
+
+	// The backups are replaced by the next stage which changes files once it is committed
+	stage = newFileStage(hostFileSystem{}, installDir)
+	require.NoError(t, stage.writeFile(created, []byte("ca"), 0644))
+	assertFileContents(t, filepath.Join(installDir, backupDirName, "kubelet.conf"), "previous config")
+	require.NoError(t, stage.commit())
+	_, err = os.Stat(filepath.Join(installDir, backupDirName))
+	assert.True(t, os.IsNotExist(err), "previous backup directory was not removed")
+}
+
+// TestFileStageGenerations tests that the backups of a committed stage are kept as the previous generation until
+// the next stage which changes files is committed, and that rolling back a stage leaves them untouched
+func TestFileStageGenerations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wmcb")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	conf := filepath.Join(dir, "kubelet.conf")
+	require.NoError(t, ioutil.WriteFile(conf, []byte("config 1"), 0644))
+	backup := filepath.Join(dir, backupDirName, "kubelet.conf")
+
+	stage := newFileStage(hostFileSystem{}, dir)
+	require.NoError(t, stage.writeFile(conf, []byte("config 2"), 0644))
+	require.NoError(t, stage.commit())
+	assertFileContents(t, backup, "config 1")
+
+	// A stage which fails after replacing the file keeps the backup of the last committed stage
+	stage = newFileStage(hostFileSystem{}, dir)
+	require.NoError(t, stage.writeFile(conf, []byte("config 3"), 0644))
+	assertFileContents(t, backup, "config 1")
+	require.NoError(t, stage.rollback())
+	assertFileContents(t, conf, "config 2")
+	assertFileContents(t, backup, "config 1")
+	_, err = os.Stat(filepath.Join(dir, nextBackupDirName))
+	assert.True(t, os.IsNotExist(err), "backup of the rolled back stage was not removed")
+
+	stage = newFileStage(hostFileSystem{}, dir)
+	require.NoError(t, stage.writeFile(conf, []byte("config 3"), 0644))
+	require.NoError(t, stage.commit())
+	assertFileContents(t, conf, "config 3")
+	assertFileContents(t, backup, "config 2")
+	_, err = os.Stat(filepath.Join(dir, nextBackupDirName))
+	assert.True(t, os.IsNotExist(err), "backup of the committed stage was left behind")
+}
+
+// TestFileStageRemove tests that a stage removes files by backing them up, and that rolling it back restores them and
+// undoes the changes made along with them in reverse order
+func TestFileStageRemove(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wmcb")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	bundle := filepath.Join(dir, "proxy-ca-bundle.crt")
+	require.NoError(t, ioutil.WriteFile(bundle, []byte("previous bundle"), 0644))
+
+	stage := newFileStage(hostFileSystem{}, dir)
+	var undone []string
+	stage.onRollback(func() error { undone = append(undone, "first"); return nil })
+	require.NoError(t, stage.removeFile(bundle))
+	require.NoError(t, stage.removeFile(filepath.Join(dir, "missing")))
+	stage.onRollback(func() error { undone = append(undone, "second"); return nil })
+	assert.Error(t, stage.removeFile(filepath.Join(filepath.Dir(dir), "outside")))
+	_, err = os.Stat(bundle)
+	assert.True(t, os.IsNotExist(err), "file was not removed")
+	assert.Equal(t, []string{bundle}, stage.changed())
+
+	require.NoError(t, stage.rollback())
+	assertFileContents(t, bundle, "previous bundle")
+	assert.Equal(t, []string{"second", "first"}, undone)
+}
@@ -0,0 +1,285 @@
+package bootstrapper
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	// bootstrapResultFile is the name of the file in the install directory the result of the last run is recorded in
+	bootstrapResultFile = "bootstrap-result.json"
+	// kubeletClientCertFile is the name of the file in the kubelet certificate directory holding the current client
+	// certificate the kubelet uses to authenticate with the API server
+	kubeletClientCertFile = "kubelet-client-current.pem"
+)
+
+// NodeStatus describes the bootstrap state of the node
+type NodeStatus struct {
+	// Services are the services managed by the bootstrapper
+	Services []ServiceReport `json:"services"`
+	// KubeletArgs are the arguments the kubelet service was registered with
+	KubeletArgs []string `json:"kubeletArgs,omitempty"`
+	// Files are the files the kubelet needs to run
+	Files []FileReport `json:"files"`
+	// ClientCertificate is the client certificate the kubelet authenticates with. It is nil until the kubelet has been
+	// issued one.
+	ClientCertificate *CertificateReport `json:"clientCertificate,omitempty"`
+	// LastBootstrap is the result of the last run of the bootstrapper. It is nil if it has not been run.
+	LastBootstrap *BootstrapResult `json:"lastBootstrap,omitempty"`
+	// LastReconcile is the result of the last reconcile of the reconcile daemon. It is nil if the daemon has not been
+	// run.
+	LastReconcile *ReconcileResult `json:"lastReconcile,omitempty"`
+}
+
+// ServiceReport describes the state of a service
+type ServiceReport struct {
+	Name      string `json:"name"`
+	Installed bool   `json:"installed"`
+	// State, StartType and BinaryPath are only given if the service is installed
+	State      string `json:"state,omitempty"`
+	StartType  string `json:"startType,omitempty"`
+	BinaryPath string `json:"binaryPath,omitempty"`
+}
+
+// FileReport describes a file
+type FileReport struct {
+	Path    string `json:"path"`
+	Present bool   `json:"present"`
+	// SHA256 is the hex encoded SHA-256 digest of the file, if it is present
+	SHA256 string `json:"sha256,omitempty"`
+	// RecordedSHA256 is the digest recorded when the file was installed, for files which are verified
+	RecordedSHA256 string `json:"recordedSHA256,omitempty"`
+	// Modified is true if the file no longer matches its recorded digest, meaning it was tampered with or replaced
+	Modified bool `json:"modified,omitempty"`
+}
+
+// CertificateReport describes a certificate
+type CertificateReport struct {
+	Path     string    `json:"path"`
+	NotAfter time.Time `json:"notAfter"`
+	Expired  bool      `json:"expired"`
+}
+
+// BootstrapResult is the report of a run of the bootstrapper
+type BootstrapResult struct {
+	// Time is when the run finished
+	Time      time.Time `json:"time"`
+	Succeeded bool      `json:"succeeded"`
+	// Error is the error the run failed with
+	Error string `json:"error,omitempty"`
+	// FailureCategory classifies the error the run failed with
+	FailureCategory FailureCategory `json:"failureCategory,omitempty"`
+	// RolledBack is true if the run failed and its changes were rolled back
+	RolledBack bool `json:"rolledBack,omitempty"`
+	// IgnitionFromCache is true if the ignition config could not be fetched, and the cached copy was used instead
+	IgnitionFromCache bool `json:"ignitionFromCache,omitempty"`
+	// Phases are the phases of the run, in order
+	Phases []PhaseReport `json:"phases,omitempty"`
+	// Files are the files installed by the run
+	Files []InstalledFile `json:"files,omitempty"`
+	// KubeletArgs are the arguments the kubelet service is run with
+	KubeletArgs []string `json:"kubeletArgs,omitempty"`
+	// Changes are the changes made by the run, if it succeeded
+	Changes *RunChanges `json:"changes,omitempty"`
+}
+
+// recordBootstrapResult records the report of a run in the install directory
+func (wmcb *winNodeBootstrapper) recordBootstrapResult(result *BootstrapResult) {
+	writeBootstrapResult(wmcb.installDir, result)
+}
+
+// RecordFailedRun records, in the install directory, the report of a run which failed before the bootstrapper could
+// run it, such as one whose ignition file could not be fetched, and returns it. The run is reported as failing with
+// err, in the given category. A failure of the ignition category is reported as a failure of the ignition-parse phase,
+// otherwise none of the phases were run.
+func RecordFailedRun(installDir string, category FailureCategory, err error) *BootstrapResult {
+	result := newBootstrapResult()
+	runErr := &PhaseError{Category: category, Err: err}
+	if category == FailureIgnition {
+		result.runPhase(PhaseIgnitionParse, category, func() error { return err })
+		runErr.Phase = PhaseIgnitionParse
+	}
+	result.finish(nil, runErr)
+	writeBootstrapResult(installDir, result)
+	return result
+}
+
+// writeBootstrapResult writes the report of a run to the install directory. Failing to write it is logged, as it must
+// not mask the result itself.
+func writeBootstrapResult(installDir string, result *BootstrapResult) {
+	contents, err := json.MarshalIndent(result, "", "  ")
+	if err == nil {
+		// The install directory is not made yet if the run failed early
+		err = os.MkdirAll(installDir, 0755)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(installDir, bootstrapResultFile), contents, 0644)
+	}
+	if err != nil {
+		log.Error(err, "could not record bootstrap result")
+	}
+}
+
+// Status reports the bootstrap state of the node
+func (wmcb *winNodeBootstrapper) Status() (*NodeStatus, error) {
+	status := &NodeStatus{}
+	names, err := wmcb.statusServiceNames()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		report, err := wmcb.services.report(name)
+		if err != nil {
+			return nil, err
+		}
+		status.Services = append(status.Services, report)
+	}
+	if status.KubeletArgs, err = wmcb.services.args(KubeletServiceName); err != nil {
+		return nil, err
+	}
+
+	for _, path := range []string{wmcb.kubeletConfPath, filepath.Join(wmcb.installDir, "bootstrap-kubeconfig"),
+		filepath.Join(wmcb.installDir, "kubelet-ca.crt"), filepath.Join(wmcb.installDir, "kubelet.exe")} {
+		report := FileReport{Path: path}
+		report.SHA256, err = fileSHA256(wmcb.fs, path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("could not hash %s: %s", path, err)
+		}
+		report.Present = err == nil
+		status.Files = append(status.Files, report)
+	}
+	kubelet := &status.Files[len(status.Files)-1]
+	if kubelet.RecordedSHA256, err = wmcb.recordedKubeletDigest(); err != nil {
+		return nil, fmt.Errorf("could not read recorded kubelet digest: %s", err)
+	}
+	kubelet.Modified = kubelet.RecordedSHA256 != "" && kubelet.SHA256 != kubelet.RecordedSHA256
+
+	certPath := filepath.Join(wmcb.certDir, kubeletClientCertFile)
+	cert, err := readCertificate(certPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("could not read client certificate: %s", err)
+	}
+	if cert != nil {
+		status.ClientCertificate = &CertificateReport{
+			Path:     certPath,
+			NotAfter: cert.NotAfter,
+			Expired:  time.Now().After(cert.NotAfter),
+		}
+	}
+
+	contents, err := ioutil.ReadFile(filepath.Join(wmcb.installDir, bootstrapResultFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("could not read last bootstrap result: %s", err)
+	}
+	if err == nil {
+		status.LastBootstrap = &BootstrapResult{}
+		if err = json.Unmarshal(contents, status.LastBootstrap); err != nil {
+			return nil, fmt.Errorf("could not parse last bootstrap result: %s", err)
+		}
+	}
+	contents, err = ioutil.ReadFile(filepath.Join(wmcb.installDir, reconcileResultFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("could not read last reconcile result: %s", err)
+	}
+	if err == nil {
+		status.LastReconcile = &ReconcileResult{}
+		if err = json.Unmarshal(contents, status.LastReconcile); err != nil {
+			return nil, fmt.Errorf("could not parse last reconcile result: %s", err)
+		}
+	}
+	return status, nil
+}
+
+// statusServiceNames returns the names of the services reported by Status: the services the bootstrapper runs, the
+// services it manages which were installed by a run configured differently, and the reconcile daemon
+func (wmcb *winNodeBootstrapper) statusServiceNames() ([]string, error) {
+	specs, err := wmcb.serviceSpecs()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	reported := make(map[string]bool)
+	for _, spec := range specs {
+		names = append(names, spec.Name)
+		reported[spec.Name] = true
+	}
+	var installed []string
+	for name := range wmcb.services.services {
+		if !reported[name] && name != DaemonServiceName {
+			installed = append(installed, name)
+		}
+	}
+	sort.Strings(installed)
+	return append(append(names, installed...), DaemonServiceName), nil
+}
+
+// report returns the state of the installed service with the given name. A service which is not in the set is only
+// opened while it is reported, so that it is not managed along with the set.
+func (s *serviceSet) report(name string) (ServiceReport, error) {
+	report := ServiceReport{Name: name}
+	service, ok := s.services[name]
+	if !ok {
+		var err error
+		if service, err = s.svcMgr.OpenService(name); err != nil {
+			return report, nil
+		}
+		defer service.Close()
+	}
+	report.Installed = true
+	config, err := service.Config()
+	if err != nil {
+		return report, fmt.Errorf("could not get config of service %s: %s", name, err)
+	}
+	status, err := service.Query()
+	if err != nil {
+		return report, fmt.Errorf("could not retrieve status of service %s: %s", name, err)
+	}
+	report.State = status.State.String()
+	report.StartType = config.StartType.String()
+	if words := splitCommandLine(config.BinaryPathName); len(words) > 0 {
+		report.BinaryPath = words[0]
+	}
+	return report, nil
+}
+
+// fileSHA256 returns the hex encoded SHA-256 digest of the file on the filesystem
+func fileSHA256(fs FileSystem, path string) (string, error) {
+	f, err := fs.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// readCertificate returns the first certificate in the PEM file, which can also hold the private key
+func readCertificate(path string) (*x509.Certificate, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var block *pem.Block
+		block, contents = pem.Decode(contents)
+		if block == nil {
+			return nil, fmt.Errorf("no certificate found in %s", path)
+		}
+		if block.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(block.Bytes)
+		}
+	}
+}
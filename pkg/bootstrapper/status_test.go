@@ -0,0 +1,140 @@
+package bootstrapper
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTestClientCert writes a kubelet client certificate and key, as the kubelet does, which expires at notAfter
+func writeTestClientCert(t *testing.T, path string, notAfter time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "system:node:winnode"},
+		NotBefore:    notAfter.Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	contents := append(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, ioutil.WriteFile(path, contents, 0600))
+}
+
+// TestStatusNotBootstrapped tests the status of a node which has not been bootstrapped
+func TestStatusNotBootstrapped(t *testing.T) {
+	scm := newFakeSCM()
+	wmcb := newTestBootstrapper(t, scm)
+	defer os.RemoveAll(filepath.Dir(wmcb.installDir))
+
+	status, err := wmcb.Status()
+	require.NoError(t, err)
+	require.NoError(t, wmcb.Disconnect())
+	assert.Equal(t, []ServiceReport{{Name: KubeletServiceName}, {Name: DaemonServiceName}}, status.Services)
+	assert.Empty(t, status.KubeletArgs)
+	for _, file := range status.Files {
+		assert.False(t, file.Present, "%s should be missing", file.Path)
+		assert.Empty(t, file.SHA256)
+	}
+	assert.Nil(t, status.ClientCertificate)
+	assert.Nil(t, status.LastBootstrap)
+}
+
+// TestStatus tests the status of a bootstrapped node
+func TestStatus(t *testing.T) {
+	scm := newFakeSCM()
+	wmcb := newTestBootstrapper(t, scm)
+	defer os.RemoveAll(filepath.Dir(wmcb.installDir))
+	require.NoError(t, wmcb.Run(context.Background()))
+	notAfter := time.Now().Add(24 * time.Hour).Truncate(time.Second).UTC()
+	writeTestClientCert(t, filepath.Join(wmcb.certDir, kubeletClientCertFile), notAfter)
+
+	status, err := wmcb.Status()
+	require.NoError(t, err)
+	require.NoError(t, wmcb.Disconnect())
+	assert.Equal(t, []ServiceReport{
+		{Name: KubeletServiceName, Installed: true, State: "Running", StartType: "Automatic",
+			BinaryPath: filepath.Join(wmcb.installDir, "kubelet.exe")},
+		{Name: DaemonServiceName},
+	}, status.Services)
+	assert.Equal(t, scm.get(KubeletServiceName).args, status.KubeletArgs)
+	require.Len(t, status.Files, 4)
+	for _, file := range status.Files {
+		assert.True(t, file.Present, "%s should be present", file.Path)
+	}
+	assert.Equal(t, filepath.Join(wmcb.installDir, "kubelet.exe"), status.Files[3].Path)
+	assert.Equal(t, testKubeletDigest, status.Files[3].SHA256)
+	require.NotNil(t, status.ClientCertificate)
+	assert.Equal(t, notAfter, status.ClientCertificate.NotAfter.UTC())
+	assert.False(t, status.ClientCertificate.Expired)
+	require.NotNil(t, status.LastBootstrap)
+	assert.True(t, status.LastBootstrap.Succeeded)
+	assert.Empty(t, status.LastBootstrap.Error)
+}
+
+// TestStatusServices tests that the added services, the managed services installed by a run configured differently
+// and the reconcile daemon are reported along with the kubelet
+func TestStatusServices(t *testing.T) {
+	scm := newFakeSCM()
+	scm.install(HybridOverlayServiceName, ServiceConfig{StartType: StartAutomatic,
+		BinaryPathName: "C:\\k\\hybrid-overlay-node.exe"}, ServiceStopped)
+	scm.install(DaemonServiceName, ServiceConfig{StartType: StartAutomatic, BinaryPathName: "C:\\wmcb.exe"},
+		ServiceRunning, "daemon")
+	wmcb := newTestBootstrapper(t, scm)
+	defer os.RemoveAll(filepath.Dir(wmcb.installDir))
+	require.NoError(t, wmcb.AddService(testServiceSpec("kube-proxy", KubeletServiceName)))
+
+	status, err := wmcb.Status()
+	require.NoError(t, err)
+	var names []string
+	for _, report := range status.Services {
+		names = append(names, report.Name)
+	}
+	assert.Equal(t, []string{KubeletServiceName, "kube-proxy", HybridOverlayServiceName, DaemonServiceName}, names)
+	assert.Equal(t, ServiceReport{Name: DaemonServiceName, Installed: true, State: "Running", StartType: "Automatic",
+		BinaryPath: "C:\\wmcb.exe"}, status.Services[3])
+	assert.True(t, status.Services[2].Installed)
+
+	// The daemon is not managed along with the services of the bootstrapper, so running it leaves the daemon alone
+	require.NoError(t, wmcb.Run(context.Background()))
+	require.NoError(t, wmcb.Disconnect())
+	require.NotNil(t, scm.get(DaemonServiceName))
+	assert.Equal(t, 0, scm.get(DaemonServiceName).openHandles)
+}
+
+// TestStatusFailedBootstrap tests that a failed bootstrap and an expired client certificate are reported
+func TestStatusFailedBootstrap(t *testing.T) {
+	scm := newFakeSCM()
+	wmcb := newTestBootstrapper(t, scm)
+	defer os.RemoveAll(filepath.Dir(wmcb.installDir))
+	require.NoError(t, os.Remove(wmcb.initialKubeletPath))
+	require.Error(t, wmcb.Run(context.Background()))
+	writeTestClientCert(t, filepath.Join(wmcb.certDir, kubeletClientCertFile), time.Now().Add(-time.Hour))
+
+	status, err := wmcb.Status()
+	require.NoError(t, err)
+	require.NoError(t, wmcb.Disconnect())
+	require.NotNil(t, status.LastBootstrap)
+	assert.False(t, status.LastBootstrap.Succeeded)
+	assert.Contains(t, status.LastBootstrap.Error, "could not copy kubelet")
+	require.NotNil(t, status.ClientCertificate)
+	assert.True(t, status.ClientCertificate.Expired)
+}
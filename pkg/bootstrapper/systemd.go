@@ -0,0 +1,227 @@
+package bootstrapper
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+/*
+	The kubelet arguments the MCO configures are spread across the kubelet systemd unit, its drop-ins and the
+	environment files they reference. The functions here implement the subset of systemd unit file semantics needed to
+	recover the command line the kubelet would have been started with on a Linux worker.
+	https://www.freedesktop.org/software/systemd/man/systemd.unit.html
+	https://www.freedesktop.org/software/systemd/man/systemd.exec.html
+*/
+
+// systemdUnit is a parsed systemd unit file, with its drop-ins applied. It maps section names to the values assigned
+// to each key in that section, in the order they were assigned.
+type systemdUnit map[string]map[string][]string
+
+// parseSystemdUnit parses the unit file contents, and then each of the drop-ins in the order systemd applies them,
+// which is the lexicographic order of their names
+func parseSystemdUnit(contents string, dropins []ignitionDropin) (systemdUnit, error) {
+	unit := make(systemdUnit)
+	if err := unit.parse(contents); err != nil {
+		return nil, err
+	}
+	sorted := make([]ignitionDropin, len(dropins))
+	copy(sorted, dropins)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].name < sorted[j].name })
+	for _, dropin := range sorted {
+		if err := unit.parse(dropin.contents); err != nil {
+			return nil, fmt.Errorf("could not parse drop-in %s: %s", dropin.name, err)
+		}
+	}
+	return unit, nil
+}
+
+// parse adds the assignments in contents to the unit. An empty assignment resets the list of values of the key.
+func (u systemdUnit) parse(contents string) error {
+	section := ""
+	scanner := bufio.NewScanner(strings.NewReader(contents))
+	lineNumber := 0
+	var line string
+	for scanner.Scan() {
+		lineNumber++
+		raw := strings.TrimSpace(scanner.Text())
+		// Comments are only recognised at the start of a line, including within continued lines
+		if strings.HasPrefix(raw, "#") || strings.HasPrefix(raw, ";") {
+			continue
+		}
+		// A trailing backslash continues the line, the backslash is replaced by a space
+		if strings.HasSuffix(raw, `\`) {
+			line += strings.TrimSuffix(raw, `\`) + " "
+			continue
+		}
+		line += raw
+		if err := u.parseLine(&section, line); err != nil {
+			return fmt.Errorf("line %d: %s", lineNumber, err)
+		}
+		line = ""
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	// The last line may end with a continuation
+	return u.parseLine(&section, line)
+}
+
+// parseLine parses a single logical line of a unit file, updating the current section if the line is a section header
+func (u systemdUnit) parseLine(section *string, line string) error {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return nil
+	}
+	if strings.HasPrefix(line, "[") {
+		if !strings.HasSuffix(line, "]") {
+			return fmt.Errorf("invalid section header %q", line)
+		}
+		*section = line[1 : len(line)-1]
+		return nil
+	}
+	if *section == "" {
+		return fmt.Errorf("assignment outside of a section")
+	}
+	parts := strings.SplitN(line, "=", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid assignment %q", line)
+	}
+	key := strings.TrimSpace(parts[0])
+	value := strings.TrimSpace(parts[1])
+	if u[*section] == nil {
+		u[*section] = make(map[string][]string)
+	}
+	if value == "" {
+		u[*section][key] = nil
+		return nil
+	}
+	u[*section][key] = append(u[*section][key], value)
+	return nil
+}
+
+// values returns the values assigned to the key in the section
+func (u systemdUnit) values(section, key string) []string {
+	return u[section][key]
+}
+
+// environment returns the environment variables the service would be started with. Environment files are looked up
+// in files, as the Linux node's file system is not available. Files which are not found are ignored. As in systemd,
+// variables set in environment files override those set with Environment=.
+func (u systemdUnit) environment(files map[string]ignitionFile) (map[string]string, error) {
+	env := make(map[string]string)
+	for _, value := range u.values("Service", "Environment") {
+		for _, assignment := range splitUnitWords(value) {
+			parts := strings.SplitN(assignment, "=", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("invalid environment assignment %q", assignment)
+			}
+			env[parts[0]] = parts[1]
+		}
+	}
+	for _, path := range u.values("Service", "EnvironmentFile") {
+		// A leading "-" means the file is optional. As the files are read from the ignition config, and not the
+		// node, every environment file is treated as optional
+		path = strings.TrimPrefix(path, "-")
+		file, ok := files[path]
+		if !ok {
+			continue
+		}
+		contents, err := file.contents()
+		if err != nil {
+			return nil, fmt.Errorf("could not read environment file %s: %s", path, err)
+		}
+		if err = parseEnvironmentFile(string(contents), env); err != nil {
+			return nil, fmt.Errorf("could not parse environment file %s: %s", path, err)
+		}
+	}
+	return env, nil
+}
+
+// execStart returns the words of the command line the service would be started with, with environment variables
+// expanded. Only a single command is supported, as used by Type=simple and Type=notify services.
+func (u systemdUnit) execStart(env map[string]string) ([]string, error) {
+	commands := u.values("Service", "ExecStart")
+	if len(commands) == 0 {
+		return nil, fmt.Errorf("no ExecStart")
+	}
+	if len(commands) > 1 {
+		return nil, fmt.Errorf("multiple ExecStart commands are not supported")
+	}
+	// Prefixes such as "-" and "@" change how systemd runs the command, but not its arguments
+	command := strings.TrimLeft(commands[0], "-@:+!")
+	var words []string
+	for _, word := range splitUnitWords(command) {
+		// $VAR on its own is split into words, ${VAR} is substituted as part of the word it is in
+		if strings.HasPrefix(word, "$") && !strings.HasPrefix(word, "${") {
+			words = append(words, strings.Fields(env[word[1:]])...)
+			continue
+		}
+		words = append(words, expandUnitVariables(word, env))
+	}
+	return words, nil
+}
+
+// parseEnvironmentFile adds the KEY=VALUE assignments in an environment file to env
+func parseEnvironmentFile(contents string, env map[string]string) error {
+	scanner := bufio.NewScanner(strings.NewReader(contents))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid assignment %q", line)
+		}
+		value := strings.TrimSpace(parts[1])
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		env[strings.TrimSpace(parts[0])] = value
+	}
+	return scanner.Err()
+}
+
+// splitUnitWords splits a unit file value into words separated by whitespace. Double or single quotes group words,
+// and are removed.
+func splitUnitWords(value string) []string {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	for _, c := range value {
+		switch {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			word.WriteRune(c)
+		case c == '"' || c == '\'':
+			quote = c
+			inWord = true
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words
+}
+
+// expandUnitVariables replaces ${VAR} references in word with their values in env. Unset variables expand to an
+// empty string, as they do in systemd.
+func expandUnitVariables(word string, env map[string]string) string {
+	return os.Expand(word, func(name string) string {
+		return env[name]
+	})
+}
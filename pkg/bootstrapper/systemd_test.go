@@ -0,0 +1,84 @@
+package bootstrapper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestParseSystemdUnit tests parsing units and applying their drop-ins
+func TestParseSystemdUnit(t *testing.T) {
+	contents := `# The kubelet unit
+[Unit]
+Description=Kubernetes Kubelet
+
+[Service]
+Environment=A=1
+ExecStart=/usr/bin/hyperkube \
+    kubelet \
+      --config=/etc/kubernetes/kubelet.conf \
+      \
+      --v=3 \
+
+Restart=always
+`
+	dropins := []ignitionDropin{
+		{name: "20-override.conf", contents: "[Service]\nExecStart=\nExecStart=/usr/bin/kubelet --v=4\n"},
+		{name: "10-env.conf", contents: "[Service]\n; another comment\nEnvironment=\"B=2 3\" C=4\n"},
+	}
+	unit, err := parseSystemdUnit(contents, dropins)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Kubernetes Kubelet"}, unit.values("Unit", "Description"))
+	assert.Equal(t, []string{"always"}, unit.values("Service", "Restart"))
+	// Drop-ins are applied in name order, and an empty assignment resets the previous values
+	assert.Equal(t, []string{"A=1", `"B=2 3" C=4`}, unit.values("Service", "Environment"))
+	assert.Equal(t, []string{"/usr/bin/kubelet --v=4"}, unit.values("Service", "ExecStart"))
+
+	unit, err = parseSystemdUnit(contents, nil)
+	require.NoError(t, err)
+	command, err := unit.execStart(nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/usr/bin/hyperkube", "kubelet", "--config=/etc/kubernetes/kubelet.conf", "--v=3"},
+		command)
+}
+
+// TestParseSystemdUnitErrors tests that malformed units are rejected
+func TestParseSystemdUnitErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+	}{
+		{name: "Assignment outside of a section", contents: "ExecStart=/usr/bin/kubelet\n"},
+		{name: "Unterminated section header", contents: "[Service\nExecStart=/usr/bin/kubelet\n"},
+		{name: "Not an assignment", contents: "[Service]\nExecStart\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseSystemdUnit(tt.contents, nil)
+			assert.Error(t, err)
+		})
+	}
+}
+
+// TestSystemdUnitEnvironment tests expanding the service environment into its command line
+func TestSystemdUnitEnvironment(t *testing.T) {
+	files := map[string]ignitionFile{
+		"/etc/kubernetes/kubelet-env": {source: "data:,%23%20comment%0AB%3D%22from%20file%22%0AC%3D5%0A"},
+	}
+	contents := `[Service]
+Environment="A=--a=1 --b=2" B=from-unit
+EnvironmentFile=/etc/os-release
+EnvironmentFile=-/etc/kubernetes/kubelet-env
+ExecStart=/usr/bin/kubelet $A --c=${C} "--d=${B}" --e=${UNSET}
+`
+	unit, err := parseSystemdUnit(contents, nil)
+	require.NoError(t, err)
+	env, err := unit.environment(files)
+	require.NoError(t, err)
+	// Environment files override Environment=, and missing environment files are ignored
+	assert.Equal(t, map[string]string{"A": "--a=1 --b=2", "B": "from file", "C": "5"}, env)
+	command, err := unit.execStart(env)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/usr/bin/kubelet", "--a=1", "--b=2", "--c=5", "--d=from file", "--e="}, command)
+}
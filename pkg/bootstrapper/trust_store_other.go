@@ -0,0 +1,31 @@
+//go:build !windows
+// +build !windows
+
+package bootstrapper
+
+import (
+	"crypto/x509"
+	"fmt"
+	"runtime"
+)
+
+// unsupportedCertificateStore is a certificateStore which fails, as the certificate stores are only available on
+// Windows
+type unsupportedCertificateStore struct{}
+
+// systemTrustStore returns the store of the trusted root certificates of the host
+func systemTrustStore() certificateStore {
+	return unsupportedCertificateStore{}
+}
+
+func (unsupportedCertificateStore) add([]*x509.Certificate) error {
+	return fmt.Errorf("certificate stores are not supported on %s", runtime.GOOS)
+}
+
+func (unsupportedCertificateStore) remove([]*x509.Certificate) error {
+	return fmt.Errorf("certificate stores are not supported on %s", runtime.GOOS)
+}
+
+func (unsupportedCertificateStore) contains(*x509.Certificate) (bool, error) {
+	return false, fmt.Errorf("certificate stores are not supported on %s", runtime.GOOS)
+}
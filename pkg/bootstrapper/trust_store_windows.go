@@ -0,0 +1,112 @@
+//go:build windows
+// +build windows
+
+package bootstrapper
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	crypt32 = windows.NewLazySystemDLL("crypt32.dll")
+	// The functions used to remove certificates are not wrapped by golang.org/x/sys/windows
+	procCertDuplicateCertificateContext = crypt32.NewProc("CertDuplicateCertificateContext")
+	procCertDeleteCertificateFromStore  = crypt32.NewProc("CertDeleteCertificateFromStore")
+)
+
+// rootCertificateStore is the certificateStore of the trusted root certificates of the local machine, which Windows
+// verifies TLS connections against for every service
+type rootCertificateStore struct{}
+
+// systemTrustStore returns the store of the trusted root certificates of the host
+func systemTrustStore() certificateStore {
+	return rootCertificateStore{}
+}
+
+// open opens the store. It must be closed with windows.CertCloseStore.
+func (rootCertificateStore) open() (windows.Handle, error) {
+	name, err := windows.UTF16PtrFromString("ROOT")
+	if err != nil {
+		return 0, err
+	}
+	store, err := windows.CertOpenStore(windows.CERT_STORE_PROV_SYSTEM, 0, 0,
+		windows.CERT_SYSTEM_STORE_LOCAL_MACHINE, uintptr(unsafe.Pointer(name)))
+	if err != nil {
+		return 0, fmt.Errorf("could not open the trusted root certificate store: %s", err)
+	}
+	return store, nil
+}
+
+func (s rootCertificateStore) add(certs []*x509.Certificate) error {
+	store, err := s.open()
+	if err != nil {
+		return err
+	}
+	defer windows.CertCloseStore(store, 0)
+	for _, cert := range certs {
+		context, err := windows.CertCreateCertificateContext(windows.X509_ASN_ENCODING|windows.PKCS_7_ASN_ENCODING,
+			&cert.Raw[0], uint32(len(cert.Raw)))
+		if err != nil {
+			return fmt.Errorf("could not decode certificate %s: %s", cert.Subject, err)
+		}
+		err = windows.CertAddCertificateContextToStore(store, context, windows.CERT_STORE_ADD_USE_EXISTING, nil)
+		windows.CertFreeCertificateContext(context)
+		if err != nil {
+			return fmt.Errorf("could not add certificate %s: %s", cert.Subject, err)
+		}
+	}
+	return nil
+}
+
+func (s rootCertificateStore) remove(certs []*x509.Certificate) error {
+	store, err := s.open()
+	if err != nil {
+		return err
+	}
+	defer windows.CertCloseStore(store, 0)
+	var context *windows.CertContext
+	for {
+		// The enumeration ends with an error once there are no more certificates
+		if context, _ = windows.CertEnumCertificatesInStore(store, context); context == nil {
+			return nil
+		}
+		encoded := (*[1 << 20]byte)(unsafe.Pointer(context.EncodedCert))[:context.Length:context.Length]
+		for _, cert := range certs {
+			if !bytes.Equal(encoded, cert.Raw) {
+				continue
+			}
+			// Deleting frees the context, so a duplicate is deleted to carry on enumerating
+			duplicate, _, _ := procCertDuplicateCertificateContext.Call(uintptr(unsafe.Pointer(context)))
+			if ok, _, err := procCertDeleteCertificateFromStore.Call(duplicate); ok == 0 {
+				windows.CertFreeCertificateContext(context)
+				return fmt.Errorf("could not remove certificate %s: %s", cert.Subject, err)
+			}
+			break
+		}
+	}
+}
+
+func (s rootCertificateStore) contains(cert *x509.Certificate) (bool, error) {
+	store, err := s.open()
+	if err != nil {
+		return false, err
+	}
+	defer windows.CertCloseStore(store, 0)
+	var context *windows.CertContext
+	for {
+		// The enumeration ends with an error once there are no more certificates
+		if context, _ = windows.CertEnumCertificatesInStore(store, context); context == nil {
+			return false, nil
+		}
+		encoded := (*[1 << 20]byte)(unsafe.Pointer(context.EncodedCert))[:context.Length:context.Length]
+		if bytes.Equal(encoded, cert.Raw) {
+			windows.CertFreeCertificateContext(context)
+			return true, nil
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package bootstrapper
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// UninstallOptions configures how Uninstall deconfigures the node
+type UninstallOptions struct {
+	// DeleteNode deletes the Node object of the node from the cluster
+	DeleteNode bool
+	// Kubeconfig is the kubeconfig used to delete the Node object. Defaults to the kubeconfig of the node.
+	Kubeconfig string
+	// ArchivePath is the path of a new zip file the generated files are archived to before they are deleted, which
+	// only its owner can read. The files are not archived if empty.
+	ArchivePath string
+	// ArchiveCredentials archives the generated files which may hold credentials of the node, including the files
+	// written by the file mapping rules, along with the other files. They are left out of the archive unless it is set.
+	ArchiveCredentials bool
+}
+
+// kubeClientFactory returns a Kubernetes client which uses the given kubeconfig
+type kubeClientFactory func(kubeconfig string) (kubernetes.Interface, error)
+
+// newKubeClient returns a Kubernetes client which uses the given kubeconfig
+func newKubeClient(kubeconfig string) (kubernetes.Interface, error) {
+	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("could not load kubeconfig %s: %s", kubeconfig, err)
+	}
+	return kubernetes.NewForConfig(config)
+}
+
+// generatedPaths returns the files and directories the bootstrapper and the services it runs create
+func (wmcb *winNodeBootstrapper) generatedPaths() []string {
+	paths := []string{wmcb.kubeletConfPath, wmcb.kubeconfigPath}
+	for _, name := range []string{"kubelet.exe", "kubelet.exe.tmp", kubeletDigestFile, "bootstrap-kubeconfig",
+		"kubelet-ca.crt", hybridOverlayBinary, "cni", ignitionCacheFile, ignitionCacheFile + ".tmp",
+		bootstrapResultFile, reconcileResultFile, backupDirName, nextBackupDirName, wmcbBinary, wmcbBinary + ".tmp",
+		proxyCABundleFile, trustedProxyCAsFile, cloudConfigFile} {
+		paths = append(paths, filepath.Join(wmcb.installDir, name))
+	}
+	paths = append(paths, wmcb.mappedPaths()...)
+	for _, path := range wmcb.serviceLogs() {
+		paths = append(paths, path)
+		// The rotated copies of the log are found from what is on disk, as they are named after when they were made
+		logs, err := rotatedLogs(path)
+		if err != nil {
+			log.Error(err, "could not find rotated logs", "path", path)
+		}
+		for _, rotated := range logs {
+			paths = append(paths, rotated.path)
+		}
+	}
+	return append(paths, wmcb.certDir)
+}
+
+// credentialPaths returns the generated files and directories which may hold credentials of the node, including the
+// files written by the file mapping rules
+func (wmcb *winNodeBootstrapper) credentialPaths() []string {
+	paths := []string{wmcb.certDir, wmcb.kubeconfigPath}
+	for _, name := range []string{"bootstrap-kubeconfig", cloudConfigFile, ignitionCacheFile, ignitionCacheFile + ".tmp",
+		backupDirName, nextBackupDirName} {
+		paths = append(paths, filepath.Join(wmcb.installDir, name))
+	}
+	if _, dest, ok := wmcb.mapIgnitionFile(cloudConfigIgnitionPath); ok {
+		paths = append(paths, dest)
+	}
+	// The mapped files can be anything in the ignition config, such as the pull secret
+	return append(paths, wmcb.mappedPaths()...)
+}
+
+// installedNodeName returns the name the node was registered with, according to the installed kubelet service
+func (wmcb *winNodeBootstrapper) installedNodeName() (string, error) {
+	args, err := wmcb.services.args(KubeletServiceName)
+	if err != nil {
+		return "", err
+	}
+	for _, arg := range parseKubeletCommandLine(args) {
+		if arg.name == "hostname-override" {
+			return arg.value, nil
+		}
+	}
+	return wmcb.nodeName()
+}
+
+// Uninstall removes the services, generated files and proxy CA certificates of the bootstrapper, so that the host can
+// be bootstrapped into another cluster
+func (wmcb *winNodeBootstrapper) Uninstall(ctx context.Context, options UninstallOptions) error {
+	// The node name has to be found before the kubelet service is removed
+	nodeName, err := wmcb.installedNodeName()
+	if err != nil {
+		return fmt.Errorf("could not find node name: %s", err)
+	}
+	// Stop the kubelet first, so that it does not register the node again once the Node object is deleted, along with
+	// the reconcile daemon, so that it does not bootstrap the node again
+	wmcb.services.open(DaemonServiceName)
+	if err = wmcb.StopAndRemoveServices(ctx); err != nil {
+		return fmt.Errorf("could not remove services: %s", err)
+	}
+	if options.DeleteNode {
+		kubeconfig := options.Kubeconfig
+		if kubeconfig == "" {
+			kubeconfig = wmcb.kubeconfigPath
+		}
+		if err = wmcb.deleteNode(kubeconfig, nodeName); err != nil {
+			return err
+		}
+	}
+	// The bundle records which certificates were trusted, so they are removed before it is deleted
+	if err = wmcb.removeProxyCABundle(); err != nil {
+		return fmt.Errorf("could not remove proxy CA certificates from the trusted root certificates: %s", err)
+	}
+	var paths []string
+	for _, path := range wmcb.generatedPaths() {
+		if _, err = os.Stat(path); err == nil {
+			paths = append(paths, path)
+		}
+	}
+	if options.ArchivePath != "" {
+		archived := paths
+		if !options.ArchiveCredentials {
+			archived = withoutPaths(paths, wmcb.credentialPaths())
+			log.Info("not archiving the files holding credentials of the node", "paths", wmcb.credentialPaths())
+		}
+		if err = archiveFiles(options.ArchivePath, archived); err != nil {
+			return fmt.Errorf("could not archive files to %s: %s", options.ArchivePath, err)
+		}
+		log.Info("archived generated files", "path", options.ArchivePath)
+	}
+	for _, path := range paths {
+		if err = os.RemoveAll(path); err != nil {
+			return fmt.Errorf("could not remove %s: %s", path, err)
+		}
+	}
+	// Removing a directory fails if it is not empty, in which case it holds files wmcb did not create. The lock file of
+	// the install directory is left to its holder, which removes the directory once it releases it.
+	if err = os.Remove(wmcb.installDir); err != nil && !os.IsNotExist(err) {
+		if remaining, _ := filepath.Glob(filepath.Join(wmcb.installDir, "*")); len(remaining) != 1 ||
+			filepath.Base(remaining[0]) != lockFileName {
+			log.Info("not removing install directory as it is not empty", "path", wmcb.installDir)
+		}
+	}
+	return nil
+}
+
+// deleteNode deletes the Node object with the given name using the kubeconfig. It is not an error if the Node object
+// does not exist.
+func (wmcb *winNodeBootstrapper) deleteNode(kubeconfig, nodeName string) error {
+	client, err := wmcb.newKubeClient(kubeconfig)
+	if err != nil {
+		return fmt.Errorf("could not create Kubernetes client: %s", err)
+	}
+	err = client.CoreV1().Nodes().Delete(nodeName, &metav1.DeleteOptions{})
+	if errors.IsNotFound(err) {
+		log.Info("node does not exist", "node", nodeName)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not delete node %s: %s", nodeName, err)
+	}
+	log.Info("deleted node", "node", nodeName)
+	return nil
+}
+
+// archiveFiles writes the given files and directories to a zip file at archivePath. Each path is stored under its
+// base name.
+func archiveFiles(archivePath string, paths []string) error {
+	// The archive is created only readable by its owner, and an existing file is not reused, as it could be readable
+	// by others
+	archive, err := os.OpenFile(archivePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+	w := zip.NewWriter(archive)
+	for _, root := range paths {
+		err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil || !info.Mode().IsRegular() {
+				return err
+			}
+			rel, err := filepath.Rel(filepath.Dir(root), path)
+			if err != nil {
+				return err
+			}
+			return addToArchive(w, path, filepath.ToSlash(rel), info)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+// withoutPaths returns the paths which are not in excluded
+func withoutPaths(paths, excluded []string) []string {
+	exclude := make(map[string]bool, len(excluded))
+	for _, path := range excluded {
+		exclude[filepath.Clean(path)] = true
+	}
+	var kept []string
+	for _, path := range paths {
+		if !exclude[filepath.Clean(path)] {
+			kept = append(kept, path)
+		}
+	}
+	return kept
+}
+
+// addToArchive adds the file at path to the zip archive under the given name
+func addToArchive(w *zip.Writer, path, name string, info os.FileInfo) error {
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	header.Method = zip.Deflate
+	to, err := w.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	from, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer from.Close()
+	_, err = io.Copy(to, from)
+	return err
+}
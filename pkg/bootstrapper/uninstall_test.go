@@ -0,0 +1,209 @@
+package bootstrapper
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// newUninstallTestBootstrapper returns a test bootstrapper for a node which was bootstrapped as winnode. The kubelet
+// service is installed, and the Kubernetes client used to delete the node is backed by client.
+func newUninstallTestBootstrapper(t *testing.T, scm *fakeSCM, client kubernetes.Interface) *winNodeBootstrapper {
+	wmcb := newTestBootstrapper(t, scm)
+	require.NoError(t, wmcb.Run(context.Background()))
+	require.NoError(t, wmcb.Disconnect())
+
+	// Fill in what the kubelet writes once it runs
+	require.NoError(t, os.MkdirAll(wmcb.certDir, 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(wmcb.certDir, kubeletClientCertFile), []byte("cert"),
+		0600))
+	require.NoError(t, ioutil.WriteFile(wmcb.kubeconfigPath, []byte("kubeconfig"), 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(wmcb.installDir, "kubelet.log"), []byte("log"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(wmcb.installDir, "kubelet-20200601T120000.000.log.gz"),
+		[]byte("rotated log"), 0644))
+
+	wmcb, err := newWinNodeBootstrapper(wmcb.installDir, "", "", scm.connect)
+	require.NoError(t, err)
+	wmcb.services.waitTime = 10 * time.Millisecond
+	wmcb.certDir = filepath.Join(filepath.Dir(wmcb.installDir), "pki")
+	wmcb.newKubeClient = func(kubeconfig string) (kubernetes.Interface, error) {
+		assert.Equal(t, wmcb.kubeconfigPath, kubeconfig)
+		return client, nil
+	}
+	// The node name comes from the kubelet service
+	kubelet := scm.get(KubeletServiceName)
+	kubelet.config.BinaryPathName = commandLine(kubelet.exePath(), append(kubelet.args, "--hostname-override=winnode"))
+	return wmcb
+}
+
+// TestUninstall tests that uninstalling removes the services, including the reconcile daemon, the Node object and the
+// generated files
+func TestUninstall(t *testing.T) {
+	scm := newFakeSCM()
+	client := fake.NewSimpleClientset(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "winnode"}})
+	wmcb := newUninstallTestBootstrapper(t, scm, client)
+	testDir := filepath.Dir(wmcb.installDir)
+	defer os.RemoveAll(testDir)
+	archivePath := filepath.Join(testDir, "uninstall.zip")
+	scm.install(DaemonServiceName, ServiceConfig{StartType: StartAutomatic}, ServiceRunning)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(wmcb.installDir, reconcileResultFile), []byte("{}"), 0644))
+
+	require.NoError(t, wmcb.Uninstall(context.Background(), UninstallOptions{DeleteNode: true, ArchivePath: archivePath}))
+	require.NoError(t, wmcb.Disconnect())
+
+	assert.Nil(t, scm.get(KubeletServiceName), "kubelet service was not removed")
+	assert.Nil(t, scm.get(DaemonServiceName), "reconcile daemon service was not removed")
+	_, err := client.CoreV1().Nodes().Get("winnode", metav1.GetOptions{})
+	assert.True(t, errors.IsNotFound(err), "node was not deleted")
+	for _, path := range []string{wmcb.installDir, wmcb.certDir} {
+		_, err = os.Stat(path)
+		assert.True(t, os.IsNotExist(err), "%s was not removed", path)
+	}
+
+	// The files holding credentials of the node are left out of the archive
+	assert.Equal(t, []string{"bootstrap-result.json", "kubelet-20200601T120000.000.log.gz", "kubelet-ca.crt",
+		"kubelet.conf", "kubelet.exe", "kubelet.exe.sha256", "kubelet.log", "wmcb-reconcile.json"},
+		archivedFiles(t, archivePath))
+	info, err := os.Stat(archivePath)
+	require.NoError(t, err)
+	if runtime.GOOS != "windows" {
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm(), "archive should only be readable by its owner")
+	}
+}
+
+// archivedFiles returns the sorted names of the files in the zip archive
+func archivedFiles(t *testing.T, archivePath string) []string {
+	archive, err := zip.OpenReader(archivePath)
+	require.NoError(t, err)
+	defer archive.Close()
+	var archived []string
+	for _, file := range archive.File {
+		archived = append(archived, file.Name)
+	}
+	sort.Strings(archived)
+	return archived
+}
+
+// TestUninstallArchiveCredentials tests that the files holding credentials of the node are only archived if asked to,
+// and that an existing file is not overwritten by the archive
+func TestUninstallArchiveCredentials(t *testing.T) {
+	scm := newFakeSCM()
+	wmcb := newUninstallTestBootstrapper(t, scm, fake.NewSimpleClientset())
+	testDir := filepath.Dir(wmcb.installDir)
+	defer os.RemoveAll(testDir)
+	existingPath := filepath.Join(testDir, "existing.zip")
+	require.NoError(t, ioutil.WriteFile(existingPath, []byte("existing"), 0644))
+
+	err := wmcb.Uninstall(context.Background(), UninstallOptions{ArchivePath: existingPath})
+	require.Error(t, err)
+	contents, err := ioutil.ReadFile(existingPath)
+	require.NoError(t, err)
+	assert.Equal(t, "existing", string(contents))
+
+	archivePath := filepath.Join(testDir, "uninstall.zip")
+	require.NoError(t, wmcb.Uninstall(context.Background(), UninstallOptions{ArchivePath: archivePath,
+		ArchiveCredentials: true}))
+	require.NoError(t, wmcb.Disconnect())
+	archived := archivedFiles(t, archivePath)
+	for _, name := range []string{"bootstrap-kubeconfig", "kubeconfig", "pki/kubelet-client-current.pem"} {
+		assert.Contains(t, archived, name)
+	}
+}
+
+// TestUninstallArchiveMappedFiles tests that the files written by the file mapping rules, such as the pull secret, are
+// left out of the archive unless the credentials are archived
+func TestUninstallArchiveMappedFiles(t *testing.T) {
+	scm := newFakeSCM()
+	wmcb := newTestBootstrapper(t, scm)
+	testDir := filepath.Dir(wmcb.installDir)
+	defer os.RemoveAll(testDir)
+	wmcb.ignitionFilePath = writeCustomIgnitionFile(t, testDir, map[string]string{
+		"/var/lib/kubelet/config.json": `{"auths":{"quay.io":{"auth":"c2VjcmV0"}}}`,
+	}, nil)
+	rulesPath := filepath.Join(testDir, "file-mappings.yaml")
+	require.NoError(t, ioutil.WriteFile(rulesPath, []byte(testFileMappingsHeader+"rules:\n"+
+		"- source: /var/lib/kubelet/config.json\n  destination: pull-secret.json\n"), 0644))
+	require.NoError(t, wmcb.SetFileMappings(rulesPath))
+	require.NoError(t, wmcb.Run(context.Background()))
+	require.NoError(t, wmcb.Disconnect())
+
+	for _, archiveCredentials := range []bool{false, true} {
+		wmcb, err := newWinNodeBootstrapper(wmcb.installDir, "", "", scm.connect)
+		require.NoError(t, err)
+		wmcb.services.waitTime = 10 * time.Millisecond
+		wmcb.certDir = filepath.Join(testDir, "pki")
+		require.NoError(t, wmcb.SetFileMappings(rulesPath))
+		// The first uninstall removes the install directory, so the pull secret is written again for the second
+		require.NoError(t, os.MkdirAll(wmcb.installDir, 0755))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(wmcb.installDir, "pull-secret.json"), []byte("{}"), 0600))
+		archivePath := filepath.Join(testDir, fmt.Sprintf("uninstall-%t.zip", archiveCredentials))
+		require.NoError(t, wmcb.Uninstall(context.Background(), UninstallOptions{ArchivePath: archivePath,
+			ArchiveCredentials: archiveCredentials}))
+		require.NoError(t, wmcb.Disconnect())
+		if archiveCredentials {
+			assert.Contains(t, archivedFiles(t, archivePath), "pull-secret.json")
+		} else {
+			assert.NotContains(t, archivedFiles(t, archivePath), "pull-secret.json")
+		}
+		_, err = os.Stat(filepath.Join(wmcb.installDir, "pull-secret.json"))
+		assert.True(t, os.IsNotExist(err), "pull secret was not removed")
+	}
+}
+
+// TestUninstallKeepsNode tests that uninstalling leaves the Node object and files wmcb did not create alone
+func TestUninstallKeepsNode(t *testing.T) {
+	tests := []struct {
+		name       string
+		deleteNode bool
+		nodes      []corev1.Node
+	}{
+		{
+			name:  "Node not deleted",
+			nodes: []corev1.Node{{ObjectMeta: metav1.ObjectMeta{Name: "winnode"}}},
+		},
+		{
+			name:       "Node already deleted",
+			deleteNode: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scm := newFakeSCM()
+			client := fake.NewSimpleClientset()
+			for i := range tt.nodes {
+				_, err := client.CoreV1().Nodes().Create(&tt.nodes[i])
+				require.NoError(t, err)
+			}
+			wmcb := newUninstallTestBootstrapper(t, scm, client)
+			defer os.RemoveAll(filepath.Dir(wmcb.installDir))
+			userFile := filepath.Join(wmcb.installDir, "notes.txt")
+			require.NoError(t, ioutil.WriteFile(userFile, []byte("notes"), 0644))
+
+			require.NoError(t, wmcb.Uninstall(context.Background(), UninstallOptions{DeleteNode: tt.deleteNode}))
+			require.NoError(t, wmcb.Disconnect())
+
+			assert.Nil(t, scm.get(KubeletServiceName), "kubelet service was not removed")
+			nodes, err := client.CoreV1().Nodes().List(metav1.ListOptions{})
+			require.NoError(t, err)
+			assert.Len(t, nodes.Items, len(tt.nodes))
+			assert.FileExists(t, userFile)
+			_, err = os.Stat(filepath.Join(wmcb.installDir, "kubelet.exe"))
+			assert.True(t, os.IsNotExist(err), "kubelet was not removed")
+		})
+	}
+}
@@ -1,6 +1,10 @@
+//go:build windows
+// +build windows
+
 package e2e
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"testing"
@@ -38,7 +42,7 @@ func TestBootstrapper(t *testing.T) {
 	wmcb, err := bootstrapper.NewWinNodeBootstrapper(installDir, ignitionFilePath, kubeletPath)
 	assert.Nilf(t, err, "Could not create WinNodeBootstrapper: %s", err)
 	// Run the bootstrapper, which will start the kubelet service
-	err = wmcb.Run()
+	err = wmcb.Run(context.Background())
 	assert.Nilf(t, err, "Could not run bootstrapper: %s", err)
 	err = wmcb.Disconnect()
 	assert.Nilf(t, err, "Could not disconnect from windows svc API: %s", err)
@@ -48,7 +52,7 @@ func TestBootstrapper(t *testing.T) {
 	time.Sleep(5 * time.Second)
 	wmcb, err = bootstrapper.NewWinNodeBootstrapper(installDir, ignitionFilePath, kubeletPath)
 	assert.Nilf(t, err, "Could not create WinNodeBootstrapper: %s", err)
-	err = wmcb.Run()
+	err = wmcb.Run(context.Background())
 	assert.Nilf(t, err, "Could not run bootstrapper: %s", err)
 	err = wmcb.Disconnect()
 	assert.Nilf(t, err, "Could not disconnect from windows svc API: %s", err)